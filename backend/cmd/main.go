@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -12,10 +13,21 @@ import (
 	"github.com/rs/cors"
 	"trading-app/internal/ai"
 	"trading-app/internal/auth"
+	"trading-app/internal/autoorder"
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
+	"trading-app/internal/calendar"
 	"trading-app/internal/database"
 	"trading-app/internal/email"
+	"trading-app/internal/emergency"
 	"trading-app/internal/handlers"
+	"trading-app/internal/ledger"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/models"
 	"trading-app/internal/openalgo"
+	"trading-app/internal/orders"
+	"trading-app/internal/risk"
+	"trading-app/internal/squareoff"
 	"trading-app/internal/websocket"
 )
 
@@ -33,6 +45,51 @@ func main() {
 	openalgoURL := getEnv("OPENALGO_URL", "https://openalgo.mywire.org")
 	openalgoAPIKey := getEnv("OPENALGO_API_KEY", "")
 	geminiAPIKey := getEnv("GEMINI_API_KEY", "")
+	candleCachePersist := getEnv("CANDLE_CACHE_PERSIST", "false") == "true"
+
+	// Broker configuration
+	defaultBroker := getEnv("BROKER", broker.OpenAlgo)
+	paperInitialCash, _ := strconv.ParseFloat(getEnv("PAPER_INITIAL_CASH", "1000000"), 64)
+	paperSlippagePercent, _ := strconv.ParseFloat(getEnv("PAPER_SLIPPAGE_PERCENT", "0.05"), 64)
+	paperSlippageFixed, _ := strconv.ParseFloat(getEnv("PAPER_SLIPPAGE_FIXED", "0"), 64)
+
+	// Position ledger reconciliation against the broker's position book
+	reconcileMinutes, _ := strconv.Atoi(getEnv("POSITION_RECONCILE_MINUTES", "5"))
+	if reconcileMinutes <= 0 {
+		reconcileMinutes = 5
+	}
+
+	// How often pending broker orders are checked until they are filled, rejected or cancelled
+	orderTrackSeconds, _ := strconv.Atoi(getEnv("ORDER_TRACK_SECONDS", "10"))
+	if orderTrackSeconds <= 0 {
+		orderTrackSeconds = 10
+	}
+
+	// How often the stop-loss and target of open brackets are checked against the price
+	bracketCheckSeconds, _ := strconv.Atoi(getEnv("BRACKET_CHECK_SECONDS", "5"))
+	if bracketCheckSeconds <= 0 {
+		bracketCheckSeconds = 5
+	}
+
+	// Default pre-trade risk limits of users without limits of their own; 0 disables a limit
+	riskMaxOrderValue, _ := strconv.ParseFloat(getEnv("RISK_MAX_ORDER_VALUE", "0"), 64)
+	riskMaxSymbolQuantity, _ := strconv.Atoi(getEnv("RISK_MAX_SYMBOL_QUANTITY", "0"))
+	riskMaxOpenPositions, _ := strconv.Atoi(getEnv("RISK_MAX_OPEN_POSITIONS", "0"))
+	riskMaxDailyLoss, _ := strconv.ParseFloat(getEnv("RISK_MAX_DAILY_LOSS", "0"), 64)
+	riskMaxOrdersPerMinute, _ := strconv.Atoi(getEnv("RISK_MAX_ORDERS_PER_MINUTE", "0"))
+	riskBlockedSymbols := strings.Split(getEnv("RISK_BLOCKED_SYMBOLS", ""), ",")
+	// Usernames allowed to engage and release the kill switch
+	riskAdmins := strings.Split(getEnv("RISK_ADMINS", "admin"), ",")
+
+	// Time (IST) at which intraday MIS positions of users who set no time of their own are squared off; "off" disables it
+	squareOffTime := getEnv("MIS_SQUARE_OFF_TIME", squareoff.DefaultTime)
+	squareOffCheckSeconds, _ := strconv.Atoi(getEnv("SQUARE_OFF_CHECK_SECONDS", "30"))
+	if squareOffCheckSeconds <= 0 {
+		squareOffCheckSeconds = 30
+	}
+
+	// Exchange sessions and holidays; empty uses the calendar bundled with the binary
+	calendarFile := getEnv("MARKET_CALENDAR_FILE", "")
 
 	// Email configuration
 	smtpHost := getEnv("SMTP_HOST", "")
@@ -70,21 +127,67 @@ func main() {
 		}
 	}()
 
+	marketCalendar, err := calendar.Load(calendarFile)
+	if err != nil {
+		log.Fatalf("Failed to load market calendar: %v", err)
+	}
+
 	openalgoClient := openalgo.NewOpenAlgoClient(openalgoURL, openalgoAPIKey)
+	// Candles are kept in memory, and also in SQLite when persistence is enabled
+	candleDB := db
+	if !candleCachePersist {
+		candleDB = nil
+	}
+	candleStore := marketdata.NewStore(openalgoClient, candleDB, marketCalendar)
+	brokers := broker.NewSelector(db, openalgoClient, candleStore, defaultBroker, broker.PaperConfig{
+		InitialCash: paperInitialCash,
+		Slippage:    broker.Slippage{Percent: paperSlippagePercent, Fixed: paperSlippageFixed},
+	})
+	positionLedger := ledger.NewLedger(db, brokers)
+	positionLedger.Start(time.Duration(reconcileMinutes) * time.Minute)
 	emailService := email.NewEmailService(smtpHost, smtpPort, smtpUsername, smtpPassword, emailSender)
 	aiClient := ai.NewAIClient(geminiAPIKey)
 	hub := websocket.NewHub()
 	go hub.Run()
 
+	riskEngine := risk.NewEngine(db, models.RiskLimits{
+		MaxOrderValue:      riskMaxOrderValue,
+		MaxSymbolQuantity:  riskMaxSymbolQuantity,
+		MaxOpenPositions:   riskMaxOpenPositions,
+		MaxDailyLoss:       riskMaxDailyLoss,
+		MaxOrdersPerMinute: riskMaxOrdersPerMinute,
+		BlockedSymbols:     riskBlockedSymbols,
+	}, riskAdmins)
+	bracketManager := bracket.NewManager(db, brokers, candleStore, hub)
+	autoOrderEngine := autoorder.NewEngine(db, brokers, candleStore, hub, emailService, emailRecipient, bracketManager, riskEngine)
+	if err := autoOrderEngine.Start(); err != nil {
+		log.Printf("Warning: Failed to resume auto orders: %v", err)
+	}
+	orderTracker := orders.NewTracker(db, brokers, positionLedger, riskEngine, hub)
+	orderTracker.Subscribe(autoOrderEngine.OrderUpdated)
+	orderTracker.Subscribe(bracketManager.OrderUpdated)
+	orderTracker.Start(time.Duration(orderTrackSeconds) * time.Second)
+	bracketManager.Start(time.Duration(bracketCheckSeconds) * time.Second)
+	liquidator := emergency.NewLiquidator(db, brokers, autoOrderEngine, orderTracker, bracketManager)
+	squareOffScheduler := squareoff.NewScheduler(db, marketCalendar, liquidator, hub, emailService, emailRecipient, squareOffTime)
+	squareOffScheduler.Start(time.Duration(squareOffCheckSeconds) * time.Second)
+
 	authHandler := handlers.NewAuthHandler(db)
 	middleware := handlers.NewMiddleware(db)
 	chatHandler := handlers.NewChatHandler(db)
 	fileHandler := handlers.NewFileHandler(db, uploadDir)
 	strategyHandler := handlers.NewStrategyHandler(db)
-	tradeHandler := handlers.NewTradeHandler(db, openalgoClient)
-	portfolioHandler := handlers.NewPortfolioHandler(db, openalgoClient)
-	backtestHandler := handlers.NewBacktestHandler(db, openalgoClient)
-	wsHandler := handlers.NewWebSocketHandler(hub, db, aiClient, openalgoURL, openalgoAPIKey, emailService, emailRecipient)
+	tradeHandler := handlers.NewTradeHandler(db, candleStore)
+	portfolioHandler := handlers.NewPortfolioHandler(db, brokers, candleStore, positionLedger, bracketManager, riskEngine)
+	backtestHandler := handlers.NewBacktestHandler(db, candleStore)
+	autoOrderHandler := handlers.NewAutoOrderHandler(db, autoOrderEngine)
+	brokerHandler := handlers.NewBrokerHandler(db, brokers)
+	orderHandler := handlers.NewOrderHandler(db, orderTracker)
+	bracketHandler := handlers.NewBracketHandler(bracketManager)
+	riskHandler := handlers.NewRiskHandler(riskEngine)
+	panicHandler := handlers.NewPanicHandler(liquidator)
+	squareOffHandler := handlers.NewSquareOffHandler(squareOffScheduler)
+	wsHandler := handlers.NewWebSocketHandler(hub, db, aiClient, brokers, candleStore, autoOrderEngine, orderTracker, bracketManager, riskEngine, liquidator, squareOffScheduler)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/api/signal", tradeHandler.HandleSignal).Methods("GET")
@@ -109,6 +212,28 @@ func main() {
 	r.HandleFunc("/api/portfolio/holdings", middleware.AuthMiddleware(portfolioHandler.GetHoldings)).Methods("GET")
 	r.HandleFunc("/api/portfolio/order", middleware.AuthMiddleware(portfolioHandler.PlaceOrder)).Methods("POST")
 	r.HandleFunc("/api/portfolio/quote", middleware.AuthMiddleware(portfolioHandler.GetQuote)).Methods("GET")
+	r.HandleFunc("/api/portfolio/value", middleware.AuthMiddleware(portfolioHandler.HandlePortfolioValue)).Methods("GET")
+	r.HandleFunc("/api/portfolio/signal", middleware.AuthMiddleware(portfolioHandler.HandlePortfolioSignal)).Methods("GET")
+	r.HandleFunc("/api/portfolio/ledger", middleware.AuthMiddleware(portfolioHandler.GetLedger)).Methods("GET")
+	r.HandleFunc("/api/portfolio/reconcile", middleware.AuthMiddleware(portfolioHandler.ReconcilePositions)).Methods("POST")
+	r.HandleFunc("/api/auto-orders", middleware.AuthMiddleware(autoOrderHandler.GetAutoOrders)).Methods("GET")
+	r.HandleFunc("/api/auto-orders", middleware.AuthMiddleware(autoOrderHandler.CreateAutoOrder)).Methods("POST")
+	r.HandleFunc("/api/auto-orders", middleware.AuthMiddleware(autoOrderHandler.CancelAutoOrder)).Methods("DELETE")
+	r.HandleFunc("/api/orders", middleware.AuthMiddleware(orderHandler.GetOpenOrders)).Methods("GET")
+	r.HandleFunc("/api/orders", middleware.AuthMiddleware(orderHandler.ModifyOrder)).Methods("PUT")
+	r.HandleFunc("/api/orders", middleware.AuthMiddleware(orderHandler.CancelOrder)).Methods("DELETE")
+	r.HandleFunc("/api/brackets", middleware.AuthMiddleware(bracketHandler.GetBrackets)).Methods("GET")
+	r.HandleFunc("/api/brackets", middleware.AuthMiddleware(bracketHandler.CancelBracket)).Methods("DELETE")
+	r.HandleFunc("/api/risk/limits", middleware.AuthMiddleware(riskHandler.GetLimits)).Methods("GET")
+	r.HandleFunc("/api/risk/limits", middleware.AuthMiddleware(riskHandler.UpdateLimits)).Methods("PUT")
+	r.HandleFunc("/api/risk/rejections", middleware.AuthMiddleware(riskHandler.GetRejections)).Methods("GET")
+	r.HandleFunc("/api/risk/kill-switch", middleware.AuthMiddleware(riskHandler.GetKillSwitch)).Methods("GET")
+	r.HandleFunc("/api/risk/kill-switch", middleware.AuthMiddleware(riskHandler.SetKillSwitch)).Methods("PUT")
+	r.HandleFunc("/api/panic", middleware.AuthMiddleware(panicHandler.Panic)).Methods("POST")
+	r.HandleFunc("/api/square-off", middleware.AuthMiddleware(squareOffHandler.GetSetting)).Methods("GET")
+	r.HandleFunc("/api/square-off", middleware.AuthMiddleware(squareOffHandler.UpdateSetting)).Methods("PUT")
+	r.HandleFunc("/api/broker", middleware.AuthMiddleware(brokerHandler.GetBroker)).Methods("GET")
+	r.HandleFunc("/api/broker", middleware.AuthMiddleware(brokerHandler.SetBroker)).Methods("PUT")
 	r.HandleFunc("/ws", wsHandler.HandleWebSocket)
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -126,6 +251,7 @@ func main() {
 	addr := ":" + port
 	log.Printf("Server starting on %s", addr)
 	log.Printf("OpenAlgo URL: %s", openalgoURL)
+	log.Printf("Default broker: %s", brokers.Default())
 	log.Printf("Database: %s", dbPath)
 	log.Printf("Upload directory: %s", uploadDir)
 
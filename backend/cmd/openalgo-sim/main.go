@@ -0,0 +1,385 @@
+// Command openalgo-sim is a stand-in for an OpenAlgo server, for developing
+// and testing the trading app offline. It serves /api/v1/quotes,
+// /api/v1/placesmartorder, /api/v1/orderstatus, /api/v1/history and the
+// positionbook, holdings and funds endpoints with the same JSON as OpenAlgo,
+// from a seeded random walk or prices replayed from a CSV file, and can
+// inject rejections, latency and HTML error pages.
+//
+// Point the app at it with OPENALGO_URL=http://localhost:5000 and any
+// OPENALGO_API_KEY (or the one given with -apikey).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/openalgo"
+)
+
+// faults are the failures injected into responses
+type faults struct {
+	latency       time.Duration
+	jitter        time.Duration
+	htmlRate      float64         // Share of requests answered with an HTML error page
+	errorRate     float64         // Share of orders refused when placed
+	rejectRate    float64         // Share of orders accepted, then rejected
+	stuckRate     float64         // Share of orders that stay open forever
+	rejectSymbols map[string]bool // Symbols whose orders are always rejected
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// chance reports true with the given probability
+func (f *faults) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < rate
+}
+
+func (f *faults) delay() time.Duration {
+	if f.jitter <= 0 {
+		return f.latency
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.latency + time.Duration(f.rng.Int63n(int64(f.jitter)))
+}
+
+type server struct {
+	feed        feed
+	book        *book
+	faults      *faults
+	apiKey      string
+	fillDelay   time.Duration
+	marketHours bool
+}
+
+func main() {
+	addr := flag.String("addr", ":5000", "address to listen on")
+	apiKey := flag.String("apikey", "", "API key clients must send (any key is accepted if empty)")
+	csvPath := flag.String("csv", "", "CSV file of candles to replay instead of a random walk")
+	warmup := flag.Int("warmup", 500, "CSV candles of each symbol that are history when the simulator starts")
+	seed := flag.Int64("seed", 1, "seed of the random walk and of the injected faults")
+	days := flag.Int("days", 30, "days of random walk history before the simulator starts")
+	volatility := flag.Float64("volatility", 0.002, "standard deviation of the random walk's one minute return")
+	prices := flag.String("prices", "", "start prices of the random walk, e.g. RELIANCE=2900,INFY=1500")
+	marketHours := flag.Bool("market-hours", false, "only trade in the NSE session (09:15-15:30 IST on weekdays)")
+	cash := flag.Float64("cash", 1000000, "cash of the simulated account")
+	fillDelay := flag.Duration("fill-delay", 2*time.Second, "time before an order fills or is rejected")
+	latency := flag.Duration("latency", 0, "delay added to every response")
+	jitter := flag.Duration("jitter", 0, "random delay of up to this much added to the latency")
+	htmlRate := flag.Float64("html-rate", 0, "share of requests (0-1) answered with an HTML 502 page")
+	errorRate := flag.Float64("error-rate", 0, "share of orders (0-1) refused when placed")
+	rejectRate := flag.Float64("reject-rate", 0, "share of orders (0-1) accepted and then rejected")
+	stuckRate := flag.Float64("stuck-rate", 0, "share of orders (0-1) that never leave the open status")
+	rejectSymbols := flag.String("reject-symbols", "", "comma separated symbols whose orders are always rejected")
+	flag.Parse()
+
+	start := time.Now()
+	var source feed
+	if *csvPath != "" {
+		replayed, err := loadReplay(*csvPath, *warmup, start)
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *csvPath, err)
+		}
+		source = replayed
+		log.Printf("Replaying %d series from %s", len(replayed.series), *csvPath)
+	} else {
+		startPrices, err := parsePrices(*prices)
+		if err != nil {
+			log.Fatalf("Invalid -prices: %v", err)
+		}
+		source = newWalk(*seed, start.AddDate(0, 0, -*days), *volatility, startPrices, *marketHours)
+		log.Printf("Random walk with seed %d and %d days of history", *seed, *days)
+	}
+
+	s := &server{
+		feed: source,
+		faults: &faults{
+			latency:       *latency,
+			jitter:        *jitter,
+			htmlRate:      *htmlRate,
+			errorRate:     *errorRate,
+			rejectRate:    *rejectRate,
+			stuckRate:     *stuckRate,
+			rejectSymbols: make(map[string]bool),
+			rng:           rand.New(rand.NewSource(*seed)),
+		},
+		apiKey:      *apiKey,
+		fillDelay:   *fillDelay,
+		marketHours: *marketHours,
+	}
+	for _, symbol := range strings.Split(*rejectSymbols, ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			s.faults.rejectSymbols[symbol] = true
+		}
+	}
+	s.book = newBook(s.ltp, *cash)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/quotes", s.handle(s.quotes))
+	mux.HandleFunc("POST /api/v1/placesmartorder", s.handle(s.placeSmartOrder))
+	mux.HandleFunc("POST /api/v1/modifyorder", s.handle(s.modifyOrder))
+	mux.HandleFunc("POST /api/v1/cancelorder", s.handle(s.cancelOrder))
+	mux.HandleFunc("POST /api/v1/cancelallorder", s.handle(s.cancelAllOrders))
+	mux.HandleFunc("POST /api/v1/orderstatus", s.handle(s.orderStatus))
+	mux.HandleFunc("POST /api/v1/history", s.handle(s.history))
+	mux.HandleFunc("POST /api/v1/positionbook", s.handle(s.positionBook))
+	mux.HandleFunc("POST /api/v1/holdings", s.handle(s.holdings))
+	mux.HandleFunc("POST /api/v1/funds", s.handle(s.funds))
+
+	log.Printf("OpenAlgo simulator listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+func parsePrices(text string) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	for _, entry := range strings.Split(text, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		symbol, value, ok := strings.Cut(entry, "=")
+		price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || price <= 0 {
+			return nil, fmt.Errorf("invalid entry '%s' (use SYMBOL=PRICE)", entry)
+		}
+		prices[strings.ToUpper(strings.TrimSpace(symbol))] = price
+	}
+	return prices, nil
+}
+
+// handle wraps an endpoint with the injected latency and HTML errors and
+// with the API key check
+func (s *server) handle(endpoint func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		if d := s.faults.delay(); d > 0 {
+			time.Sleep(d)
+		}
+		if s.faults.chance(s.faults.htmlRate) {
+			log.Printf("Injected HTML error page for %s", r.URL.Path)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<html><head><title>502 Bad Gateway</title></head><body><center><h1>502 Bad Gateway</h1></center><hr><center>nginx</center></body></html>")
+			return
+		}
+		endpoint(w, r)
+	}
+}
+
+// decode reads a request body and checks its API key
+func (s *server) decode(w http.ResponseWriter, r *http.Request, req interface{}, apiKey *string) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return false
+	}
+	if s.apiKey != "" && *apiKey != s.apiKey {
+		writeError(w, http.StatusForbidden, "Invalid openalgo apikey")
+		return false
+	}
+	return true
+}
+
+func (s *server) ltp(symbol, exchange string) (float64, bool) {
+	candles := s.feed.candles(symbol, exchange, time.Now())
+	if len(candles) == 0 {
+		return 0, false
+	}
+	return candles[len(candles)-1].Close, true
+}
+
+func (s *server) quotes(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoQuoteRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	symbol, exchange := strings.ToUpper(req.Symbol), strings.ToUpper(req.Exchange)
+	data, ok := quote(s.feed.candles(symbol, exchange, time.Now()))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No data found for symbol %s on exchange %s", symbol, exchange))
+		return
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoQuoteResponse{Status: "success", Data: data})
+}
+
+func (s *server) placeSmartOrder(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoSmartOrderRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	if s.faults.chance(s.faults.errorRate) {
+		log.Printf("Injected order error for %s %s", req.Action, req.Symbol)
+		writeError(w, http.StatusBadRequest, "Order placement failed: simulated broker error")
+		return
+	}
+	reject := s.faults.rejectSymbols[strings.ToUpper(req.Symbol)] || s.faults.chance(s.faults.rejectRate)
+	stuck := !reject && s.faults.chance(s.faults.stuckRate)
+
+	o, err := s.book.placeSmartOrder(&req, time.Now().Add(s.fillDelay), reject, stuck)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if o == nil {
+		writeJSON(w, http.StatusOK, openalgo.OpenAlgoSmartOrderResponse{Status: "success", Message: "Positions Already Matched. No Action needed."})
+		return
+	}
+
+	log.Printf("Order %s: %s %d %s:%s (%s, %s)", o.id, o.action, o.quantity, o.exchange, o.symbol, o.priceType, o.product)
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoSmartOrderResponse{
+		Status: "success",
+		Data:   openalgo.OpenAlgoSmartOrderData{OrderID: o.id},
+	})
+}
+
+func (s *server) modifyOrder(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoModifyOrderRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	if err := s.book.modify(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Order %s modified: %d at %.2f (%s)", req.OrderID, req.Quantity, req.Price, req.Pricetype)
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoOrderResponse{Status: "success", OrderID: req.OrderID})
+}
+
+func (s *server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoCancelOrderRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	if err := s.book.cancel(req.OrderID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Order %s cancelled", req.OrderID)
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoOrderResponse{Status: "success", OrderID: req.OrderID})
+}
+
+func (s *server) cancelAllOrders(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoCancelAllOrdersRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	cancelled := s.book.cancelAll()
+	log.Printf("Cancelled %d open orders", len(cancelled))
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoCancelAllOrdersResponse{
+		Status:              "success",
+		Message:             fmt.Sprintf("Canceled %d orders. Failed to cancel 0 orders.", len(cancelled)),
+		CanceledOrders:      cancelled,
+		FailedCancellations: []openalgo.OpenAlgoFailedCancellation{},
+	})
+}
+
+func (s *server) orderStatus(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoOrderStatusRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	data, ok := s.book.status(req.OrderID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Order not found: "+req.OrderID)
+		return
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoOrderStatusResponse{Status: "success", Data: *data})
+}
+
+func (s *server) history(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoHistoryRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", req.StartDate, ist)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid start_date: "+req.StartDate)
+		return
+	}
+	end, err := time.ParseInLocation("2006-01-02", req.EndDate, ist)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid end_date: "+req.EndDate)
+		return
+	}
+	end = end.AddDate(0, 0, 1)
+
+	candles := s.feed.candles(strings.ToUpper(req.Symbol), strings.ToUpper(req.Exchange), time.Now())
+	aggregated, err := aggregate(candles, req.Interval, s.marketHours)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid interval: "+req.Interval)
+		return
+	}
+
+	data := []openalgo.OpenAlgoCandle{}
+	for _, c := range aggregated {
+		if c.Timestamp >= start.Unix() && c.Timestamp < end.Unix() {
+			data = append(data, c)
+		}
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoHistoryResponse{Status: "success", Data: data})
+}
+
+func (s *server) positionBook(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoAccountRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoPositionBookResponse{Status: "success", Data: s.book.positionBook()})
+}
+
+// holdings is always empty: simulated positions never settle into delivery
+func (s *server) holdings(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoAccountRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoHoldingsResponse{
+		Status: "success",
+		Data:   openalgo.OpenAlgoHoldingsData{Holdings: []openalgo.OpenAlgoHolding{}},
+	})
+}
+
+func (s *server) funds(w http.ResponseWriter, r *http.Request) {
+	var req openalgo.OpenAlgoAccountRequest
+	if !s.decode(w, r, &req, &req.Apikey) {
+		return
+	}
+	writeJSON(w, http.StatusOK, openalgo.OpenAlgoFundsResponse{Status: "success", Data: s.book.funds()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError answers like OpenAlgo, which reports errors in "message"; the
+// message is repeated in "error" where the client looks for it
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{
+		"status":  "error",
+		"message": message,
+		"error":   message,
+	})
+}
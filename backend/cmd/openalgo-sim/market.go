@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/condition"
+	"trading-app/internal/openalgo"
+)
+
+// ist is the exchange time zone: daily bars and sessions start at IST times
+var ist = time.FixedZone("IST", 5*3600+1800)
+
+// Session of the NSE cash market, used when the feed only trades in market hours
+const (
+	sessionOpen  = 9*time.Hour + 15*time.Minute
+	sessionClose = 15*time.Hour + 30*time.Minute
+)
+
+// feed provides the base candles of a symbol, oldest first. Candles of other
+// intervals are aggregated from them.
+type feed interface {
+	candles(symbol, exchange string, now time.Time) []openalgo.OpenAlgoCandle
+}
+
+// walk is a feed of one minute candles following a seeded random walk. The
+// same seed always produces the same prices for a symbol.
+type walk struct {
+	seed        int64
+	start       time.Time
+	volatility  float64 // Standard deviation of the log return of one minute
+	prices      map[string]float64
+	marketHours bool
+
+	mu     sync.Mutex
+	series map[string]*walkSeries
+}
+
+type walkSeries struct {
+	rng     *rand.Rand
+	next    time.Time
+	last    float64
+	candles []openalgo.OpenAlgoCandle
+}
+
+func newWalk(seed int64, start time.Time, volatility float64, prices map[string]float64, marketHours bool) *walk {
+	return &walk{
+		seed:        seed,
+		start:       start.Truncate(time.Minute),
+		volatility:  volatility,
+		prices:      prices,
+		marketHours: marketHours,
+		series:      make(map[string]*walkSeries),
+	}
+}
+
+func (w *walk) candles(symbol, exchange string, now time.Time) []openalgo.OpenAlgoCandle {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := exchange + ":" + symbol
+	s, ok := w.series[k]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		s = &walkSeries{
+			rng:  rand.New(rand.NewSource(w.seed ^ int64(h.Sum64()))),
+			next: w.start,
+		}
+		s.last = w.startPrice(symbol, s.rng)
+		w.series[k] = s
+	}
+
+	for ; !s.next.After(now); s.next = s.next.Add(time.Minute) {
+		if w.marketHours && !inSession(s.next) {
+			continue
+		}
+		s.candles = append(s.candles, w.step(s, s.next))
+	}
+	return s.candles
+}
+
+// startPrice returns the configured price of a symbol, or a random one
+func (w *walk) startPrice(symbol string, rng *rand.Rand) float64 {
+	if price, ok := w.prices[symbol]; ok {
+		return price
+	}
+	return roundTick(100 + rng.Float64()*2900)
+}
+
+// step generates the candle of one minute from four random ticks
+func (w *walk) step(s *walkSeries, at time.Time) openalgo.OpenAlgoCandle {
+	candle := openalgo.OpenAlgoCandle{Timestamp: at.Unix(), Open: s.last, High: s.last, Low: s.last}
+	price := s.last
+	for i := 0; i < 4; i++ {
+		price = roundTick(price * math.Exp(s.rng.NormFloat64()*w.volatility/2))
+		candle.High = math.Max(candle.High, price)
+		candle.Low = math.Min(candle.Low, price)
+	}
+	candle.Close = price
+	candle.Volume = 100 + s.rng.Int63n(10000)
+	s.last = price
+	return candle
+}
+
+// replay is a feed of candles read from a CSV file. Each series is shifted in
+// time so that its warmup-th candle is the current one when the simulator
+// starts; later candles appear as time passes at their original spacing.
+type replay struct {
+	series map[string][]openalgo.OpenAlgoCandle // By symbol; "" holds candles for any symbol
+}
+
+// loadReplay reads a CSV file with a header row. The columns are timestamp
+// (or date, time, datetime), open, high, low, close and optionally volume,
+// oi and symbol. Timestamps are unix seconds or IST dates and times.
+func loadReplay(path string, warmup int, start time.Time) (*replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "date", "time", "datetime":
+			name = "timestamp"
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"timestamp", "open", "high", "low", "close"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV has no '%s' column", required)
+		}
+	}
+
+	series := make(map[string][]openalgo.OpenAlgoCandle)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		candle, err := parseCandle(field)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		symbol := strings.ToUpper(field("symbol"))
+		series[symbol] = append(series[symbol], candle)
+	}
+	if len(series) == 0 {
+		return nil, fmt.Errorf("CSV has no candles")
+	}
+
+	for symbol, candles := range series {
+		sort.SliceStable(candles, func(i, j int) bool { return candles[i].Timestamp < candles[j].Timestamp })
+		anchor := min(warmup, len(candles)-1)
+		offset := start.Truncate(spacing(candles)).Unix() - candles[anchor].Timestamp
+		for i := range candles {
+			candles[i].Timestamp += offset
+		}
+		series[symbol] = candles
+	}
+	return &replay{series: series}, nil
+}
+
+func parseCandle(field func(string) string) (openalgo.OpenAlgoCandle, error) {
+	var candle openalgo.OpenAlgoCandle
+	timestamp, err := parseTimestamp(field("timestamp"))
+	if err != nil {
+		return candle, err
+	}
+	candle.Timestamp = timestamp
+
+	prices := []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close}
+	for i, name := range []string{"open", "high", "low", "close"} {
+		value, err := strconv.ParseFloat(field(name), 64)
+		if err != nil {
+			return candle, fmt.Errorf("invalid %s '%s'", name, field(name))
+		}
+		*prices[i] = value
+	}
+	for name, value := range map[string]*int64{"volume": &candle.Volume, "oi": &candle.OI} {
+		if text := field(name); text != "" {
+			number, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return candle, fmt.Errorf("invalid %s '%s'", name, text)
+			}
+			*value = int64(number)
+		}
+	}
+	return candle, nil
+}
+
+func parseTimestamp(text string) (int64, error) {
+	if seconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if seconds > 1e12 {
+			seconds /= 1000 // Milliseconds
+		}
+		return seconds, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t.Unix(), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02", "02-01-2006"} {
+		if t, err := time.ParseInLocation(layout, text, ist); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timestamp '%s'", text)
+}
+
+// spacing returns the smallest distance between two candles
+func spacing(candles []openalgo.OpenAlgoCandle) time.Duration {
+	smallest := int64(0)
+	for i := 1; i < len(candles); i++ {
+		if d := candles[i].Timestamp - candles[i-1].Timestamp; d > 0 && (smallest == 0 || d < smallest) {
+			smallest = d
+		}
+	}
+	if smallest == 0 {
+		return time.Minute
+	}
+	return time.Duration(smallest) * time.Second
+}
+
+func (r *replay) candles(symbol, exchange string, now time.Time) []openalgo.OpenAlgoCandle {
+	candles, ok := r.series[symbol]
+	if !ok {
+		candles = r.series[""]
+	}
+	visible := sort.Search(len(candles), func(i int) bool { return candles[i].Timestamp > now.Unix() })
+	return candles[:visible]
+}
+
+// aggregate combines base candles into candles of an interval. Intraday
+// buckets are aligned to IST midnight, or to the session open in market hours.
+func aggregate(candles []openalgo.OpenAlgoCandle, interval string, marketHours bool) ([]openalgo.OpenAlgoCandle, error) {
+	length, err := condition.IntervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	normalized, _ := condition.NormalizeInterval(interval)
+
+	bucket := func(timestamp int64) int64 {
+		t := time.Unix(timestamp, 0).In(ist)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ist)
+		switch normalized {
+		case "D":
+			return day.Unix()
+		case "W":
+			return day.AddDate(0, 0, -(int(day.Weekday())+6)%7).Unix()
+		}
+		if marketHours {
+			day = day.Add(sessionOpen)
+		}
+		return day.Add(t.Sub(day) / length * length).Unix()
+	}
+
+	aggregated := []openalgo.OpenAlgoCandle{}
+	for _, c := range candles {
+		b := bucket(c.Timestamp)
+		n := len(aggregated)
+		if n == 0 || aggregated[n-1].Timestamp != b {
+			c.Timestamp = b
+			aggregated = append(aggregated, c)
+			continue
+		}
+		last := &aggregated[n-1]
+		last.High = math.Max(last.High, c.High)
+		last.Low = math.Min(last.Low, c.Low)
+		last.Close = c.Close
+		last.Volume += c.Volume
+		last.OI = c.OI
+	}
+	return aggregated, nil
+}
+
+// quote summarizes the current IST day of a series
+func quote(candles []openalgo.OpenAlgoCandle) (openalgo.OpenAlgoQuoteData, bool) {
+	if len(candles) == 0 {
+		return openalgo.OpenAlgoQuoteData{}, false
+	}
+	last := candles[len(candles)-1]
+	t := time.Unix(last.Timestamp, 0).In(ist)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ist).Unix()
+
+	first := sort.Search(len(candles), func(i int) bool { return candles[i].Timestamp >= today })
+	data := openalgo.OpenAlgoQuoteData{
+		LTP:           last.Close,
+		Open:          candles[first].Open,
+		High:          candles[first].High,
+		Low:           candles[first].Low,
+		PreviousClose: candles[first].Open,
+	}
+	if first > 0 {
+		data.PreviousClose = candles[first-1].Close
+	}
+	for _, c := range candles[first:] {
+		data.High = math.Max(data.High, c.High)
+		data.Low = math.Min(data.Low, c.Low)
+	}
+	data.Change = roundTick(data.LTP - data.PreviousClose)
+	if data.PreviousClose != 0 {
+		data.ChangePercent = math.Round(data.Change/data.PreviousClose*10000) / 100
+	}
+	return data, true
+}
+
+func inSession(t time.Time) bool {
+	t = t.In(ist)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return sinceMidnight >= sessionOpen && sinceMidnight < sessionClose
+}
+
+// roundTick rounds a price to the 0.05 tick size
+func roundTick(price float64) float64 {
+	return math.Round(price*20) / 20
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/openalgo"
+)
+
+// Order statuses reported by /api/v1/orderstatus
+const (
+	statusOpen      = "open"
+	statusComplete  = "complete"
+	statusRejected  = "rejected"
+	statusCancelled = "cancelled"
+)
+
+type order struct {
+	id        string
+	strategy  string
+	symbol    string
+	exchange  string
+	action    string
+	priceType string
+	product   string
+	quantity  int
+	price     float64
+	average   float64
+	status    string
+	placed    time.Time
+	fillAt    time.Time // Orders stay open at least until then
+	reject    bool      // Rejected by the exchange once fillAt has passed
+	stuck     bool      // Never leaves the open status
+}
+
+// position is the net position built by the filled orders of a symbol and product
+type position struct {
+	symbol   string
+	exchange string
+	product  string
+	quantity int // Negative when short
+	average  float64
+	realized float64
+}
+
+// book holds the simulated orders, the positions they build and the cash of
+// the account. Orders are settled lazily when the account is queried.
+type book struct {
+	price func(symbol, exchange string) (float64, bool)
+
+	mu        sync.Mutex
+	next      int
+	cash      float64
+	orders    map[string]*order
+	positions map[string]*position // By exchange:symbol:product
+}
+
+func newBook(price func(symbol, exchange string) (float64, bool), cash float64) *book {
+	return &book{
+		price:     price,
+		next:      1,
+		cash:      cash,
+		orders:    make(map[string]*order),
+		positions: make(map[string]*position),
+	}
+}
+
+// placeSmartOrder follows OpenAlgo's smart order semantics: with a position
+// size the order trades the difference to the current position, otherwise it
+// trades the requested quantity. It returns nil if nothing needs to be traded.
+func (b *book) placeSmartOrder(req *openalgo.OpenAlgoSmartOrderRequest, fillAt time.Time, reject, stuck bool) (*order, error) {
+	o := &order{
+		strategy:  req.Strategy,
+		symbol:    strings.ToUpper(req.Symbol),
+		exchange:  strings.ToUpper(req.Exchange),
+		action:    strings.ToUpper(req.Action),
+		priceType: strings.ToUpper(req.Pricetype),
+		product:   strings.ToUpper(req.Product),
+		quantity:  req.Quantity,
+		price:     req.Price,
+		status:    statusOpen,
+		placed:    time.Now(),
+		fillAt:    fillAt,
+		reject:    reject,
+		stuck:     stuck,
+	}
+	if o.priceType == "" {
+		o.priceType = "MARKET"
+	}
+	if o.product == "" {
+		o.product = "MIS"
+	}
+
+	switch {
+	case o.symbol == "" || o.exchange == "":
+		return nil, fmt.Errorf("symbol and exchange are required")
+	case o.action != "BUY" && o.action != "SELL":
+		return nil, fmt.Errorf("invalid action '%s'", req.Action)
+	case o.priceType != "MARKET" && o.priceType != "LIMIT":
+		return nil, fmt.Errorf("invalid pricetype '%s'", req.Pricetype)
+	case o.priceType == "LIMIT" && o.price <= 0:
+		return nil, fmt.Errorf("price is required for LIMIT orders")
+	}
+	if _, ok := b.price(o.symbol, o.exchange); !ok {
+		return nil, fmt.Errorf("no data found for symbol %s on exchange %s", o.symbol, o.exchange)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.settleAll()
+	current := b.position(o.exchange, o.symbol, o.product).quantity
+	switch target := req.PositionSize; {
+	case target == 0 && current == 0:
+		if o.quantity <= 0 {
+			return nil, fmt.Errorf("quantity must be positive")
+		}
+	case target == current:
+		return nil, nil
+	default:
+		o.quantity, o.action = target-current, "BUY"
+		if o.quantity < 0 {
+			o.quantity, o.action = -o.quantity, "SELL"
+		}
+	}
+
+	o.id = fmt.Sprintf("%s%07d", o.placed.In(ist).Format("060102"), b.next)
+	b.next++
+	b.orders[o.id] = o
+	b.settle(o)
+	return o, nil
+}
+
+// status settles an order and returns its state as OpenAlgo reports it
+func (b *book) status(orderID string) (*openalgo.OpenAlgoOrderStatusData, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.orders[orderID]
+	if !ok {
+		return nil, false
+	}
+	b.settle(o)
+	return &openalgo.OpenAlgoOrderStatusData{
+		Action:       o.action,
+		AveragePrice: o.average,
+		Exchange:     o.exchange,
+		OrderStatus:  o.status,
+		OrderID:      o.id,
+		Price:        o.price,
+		PriceType:    o.priceType,
+		Product:      o.product,
+		Quantity:     strconv.Itoa(o.quantity),
+		Symbol:       o.symbol,
+		Timestamp:    o.placed.In(ist).Format("02-Jan-2006 15:04:05"),
+	}, true
+}
+
+// modify changes the price, quantity or type of an open order
+func (b *book) modify(req *openalgo.OpenAlgoModifyOrderRequest) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err := b.open(req.OrderID)
+	if err != nil {
+		return err
+	}
+	priceType := strings.ToUpper(req.Pricetype)
+	if priceType == "" {
+		priceType = o.priceType
+	}
+	switch {
+	case priceType != "MARKET" && priceType != "LIMIT":
+		return fmt.Errorf("invalid pricetype '%s'", req.Pricetype)
+	case priceType == "LIMIT" && req.Price <= 0:
+		return fmt.Errorf("price is required for LIMIT orders")
+	}
+
+	o.priceType = priceType
+	o.price = req.Price
+	if req.Quantity > 0 {
+		o.quantity = req.Quantity
+	}
+	b.settle(o)
+	return nil
+}
+
+// cancel cancels an open order
+func (b *book) cancel(orderID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err := b.open(orderID)
+	if err != nil {
+		return err
+	}
+	o.status = statusCancelled
+	return nil
+}
+
+// cancelAll cancels every order that is still open and returns their IDs
+func (b *book) cancelAll() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settleAll()
+
+	cancelled := []string{}
+	for _, o := range b.orders {
+		if o.status == statusOpen {
+			o.status = statusCancelled
+			cancelled = append(cancelled, o.id)
+		}
+	}
+	sort.Strings(cancelled)
+	return cancelled
+}
+
+// open settles an order and returns it if it can still be changed
+func (b *book) open(orderID string) (*order, error) {
+	o, ok := b.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+	b.settle(o)
+	if o.status != statusOpen {
+		return nil, fmt.Errorf("order %s is %s and can no longer be changed", orderID, o.status)
+	}
+	return o, nil
+}
+
+// settle fills or rejects an open order once its fill delay has passed.
+// Limit orders only fill when the price reaches the limit.
+func (b *book) settle(o *order) {
+	if o.status != statusOpen || o.stuck || time.Now().Before(o.fillAt) {
+		return
+	}
+	if o.reject {
+		o.status = statusRejected
+		return
+	}
+
+	ltp, ok := b.price(o.symbol, o.exchange)
+	if !ok {
+		return
+	}
+	buy := o.action == "BUY"
+	if o.priceType == "LIMIT" {
+		if (buy && ltp > o.price) || (!buy && ltp < o.price) {
+			return
+		}
+		if buy {
+			ltp = math.Min(ltp, o.price)
+		} else {
+			ltp = math.Max(ltp, o.price)
+		}
+	}
+
+	o.status = statusComplete
+	o.average = ltp
+	quantity := o.quantity
+	if !buy {
+		quantity = -quantity
+	}
+	b.cash -= ltp * float64(quantity)
+
+	pos := b.position(o.exchange, o.symbol, o.product)
+	switch {
+	case pos.quantity == 0 || (pos.quantity > 0) == (quantity > 0):
+		total := pos.quantity + quantity
+		pos.average = (pos.average*math.Abs(float64(pos.quantity)) + ltp*math.Abs(float64(quantity))) / math.Abs(float64(total))
+		pos.quantity = total
+	default:
+		closed := min(abs(quantity), abs(pos.quantity))
+		if pos.quantity > 0 {
+			pos.realized += (ltp - pos.average) * float64(closed)
+		} else {
+			pos.realized += (pos.average - ltp) * float64(closed)
+		}
+		pos.quantity += quantity
+		if abs(quantity) > closed {
+			pos.average = ltp // The position flipped
+		}
+	}
+}
+
+// settleAll settles every open order, as positions only change when orders settle
+func (b *book) settleAll() {
+	for _, o := range b.orders {
+		b.settle(o)
+	}
+}
+
+func (b *book) position(exchange, symbol, product string) *position {
+	k := exchange + ":" + symbol + ":" + product
+	pos, ok := b.positions[k]
+	if !ok {
+		pos = &position{symbol: symbol, exchange: exchange, product: product}
+		b.positions[k] = pos
+	}
+	return pos
+}
+
+// positionBook returns the positions of the account valued at the current price
+func (b *book) positionBook() []openalgo.OpenAlgoPosition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settleAll()
+
+	entries := []openalgo.OpenAlgoPosition{}
+	for _, pos := range b.positions {
+		ltp, _ := b.price(pos.symbol, pos.exchange)
+		entries = append(entries, openalgo.OpenAlgoPosition{
+			Symbol:       pos.symbol,
+			Exchange:     pos.exchange,
+			Product:      pos.product,
+			Quantity:     openalgo.Number(pos.quantity),
+			AveragePrice: openalgo.Number(pos.average),
+			LTP:          openalgo.Number(ltp),
+			PnL:          openalgo.Number(pos.realized + (ltp-pos.average)*float64(pos.quantity)),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Symbol < entries[j].Symbol })
+	return entries
+}
+
+// funds returns the cash of the account and its realized and unrealized P&L
+func (b *book) funds() openalgo.OpenAlgoFunds {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settleAll()
+
+	funds := openalgo.OpenAlgoFunds{AvailableCash: openalgo.Number(b.cash)}
+	for _, pos := range b.positions {
+		funds.M2MRealized += openalgo.Number(pos.realized)
+		if pos.quantity == 0 {
+			continue
+		}
+		ltp, _ := b.price(pos.symbol, pos.exchange)
+		funds.M2MUnrealized += openalgo.Number((ltp - pos.average) * float64(pos.quantity))
+		funds.UtilisedDebits += openalgo.Number(math.Abs(float64(pos.quantity)) * pos.average)
+	}
+	return funds
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
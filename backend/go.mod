@@ -5,7 +5,6 @@ go 1.24.0
 toolchain go1.24.9
 
 require (
-	github.com/Knetic/govaluate v3.0.0+incompatible
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/google/generative-ai-go v0.20.1
 	github.com/gorilla/mux v1.8.1
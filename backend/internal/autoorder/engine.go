@@ -0,0 +1,779 @@
+package autoorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
+	"trading-app/internal/calendar"
+	"trading-app/internal/condition"
+	"trading-app/internal/database"
+	"trading-app/internal/email"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+	"trading-app/internal/risk"
+)
+
+// Event types published to the user while an auto order is running
+const (
+	EventStarted    = "started"
+	EventTriggered  = "triggered"
+	EventExecuted   = "executed"
+	EventFilled     = "filled"
+	EventRejected   = "rejected"
+	EventExpired    = "expired"
+	EventCancelled  = "cancelled"
+	EventUnresolved = "unresolved"
+	EventCrashed    = "crashed"
+	EventSkipped    = "skipped"
+)
+
+// maxCrashes is the number of panics after which an order is no longer restarted
+const maxCrashes = 3
+
+// ErrOrderNotFound is returned when an auto order does not exist or belongs to another user
+var ErrOrderNotFound = errors.New("auto order not found")
+
+// Notifier delivers a message to every open session of a user.
+// It is implemented by websocket.Hub.
+type Notifier interface {
+	SendToUser(userID int, message []byte)
+}
+
+// Event describes a change in the lifecycle of an auto order
+type Event struct {
+	Type          string             `json:"type"`
+	OrderID       string             `json:"order_id"`
+	Symbol        string             `json:"symbol"`
+	Exchange      string             `json:"exchange"`
+	Action        string             `json:"action"`
+	BrokerOrderID string             `json:"broker_order_id,omitempty"`
+	Values        map[string]float64 `json:"values,omitempty"`
+	Message       string             `json:"message"`
+	Timestamp     time.Time          `json:"timestamp"`
+}
+
+// message mirrors the websocket message envelope understood by the frontend
+type message struct {
+	Type    string      `json:"type"`
+	Content string      `json:"content,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Engine owns the monitoring goroutines of every running auto order,
+// independently of any websocket connection.
+type Engine struct {
+	db             *database.DB
+	brokers        *broker.Selector
+	candles        *marketdata.Store
+	notifier       Notifier
+	emailService   *email.EmailService
+	emailRecipient string
+	brackets       *bracket.Manager
+	risk           *risk.Engine
+
+	mu           sync.Mutex
+	orders       map[string]*models.AutoOrder
+	cancellation map[string]chan struct{}
+
+	// Serializes picking an ID and saving the order, so that two orders
+	// submitted in the same second do not pick the same ID
+	createMu sync.Mutex
+}
+
+// NewEngine creates a new auto order engine
+func NewEngine(db *database.DB, brokers *broker.Selector, candles *marketdata.Store, notifier Notifier, emailService *email.EmailService, emailRecipient string, brackets *bracket.Manager, riskEngine *risk.Engine) *Engine {
+	return &Engine{
+		db:             db,
+		brokers:        brokers,
+		candles:        candles,
+		notifier:       notifier,
+		emailService:   emailService,
+		emailRecipient: emailRecipient,
+		brackets:       brackets,
+		risk:           riskEngine,
+		orders:         make(map[string]*models.AutoOrder),
+		cancellation:   make(map[string]chan struct{}),
+	}
+}
+
+// Start rehydrates running auto orders from the database and resumes monitoring.
+// Orders that expired while the server was down are closed instead of resumed.
+func (e *Engine) Start() error {
+	orders, err := e.db.GetRunningAutoOrders()
+	if err != nil {
+		return fmt.Errorf("failed to load auto orders: %w", err)
+	}
+
+	resumed := 0
+	for _, order := range orders {
+		if time.Now().After(order.ExpiresAt) {
+			log.Printf("AUTO-ORDER: %s expired while the server was down", order.ID)
+			e.notify(order, EventExpired, fmt.Sprintf("🕒 Auto-Order %s for %s expired while the server was offline.", order.ID, order.Symbol), "", nil)
+			e.setStatus(order, models.StateExpired, "expired")
+			continue
+		}
+
+		// Scheduled orders have no condition
+		var program *condition.Program
+		if order.ScheduleAt == "" {
+			program, err = condition.Compile(order.Condition)
+			if err != nil {
+				log.Printf("AUTO-ORDER: Not resuming %s, invalid condition: %v", order.ID, err)
+				order.LastError = err.Error()
+				e.notify(order, EventRejected, fmt.Sprintf("❌ Auto-Order %s for %s was stopped: invalid condition (%v).", order.ID, order.Symbol, err), "", nil)
+				e.setStatus(order, models.StateFailed, "failed")
+				continue
+			}
+		}
+
+		log.Printf("AUTO-ORDER: Resuming %s for %s on %s (fired %d times)", order.ID, order.Symbol, order.Exchange, order.FireCount)
+		e.launch(order, program)
+		resumed++
+	}
+
+	log.Printf("AUTO-ORDER: Resumed %d auto orders", resumed)
+	return nil
+}
+
+// Submit validates a request, persists the new auto order and starts monitoring it
+func (e *Engine) Submit(userID int, req Request) (*models.AutoOrder, error) {
+	expiresAt, err := req.Validate(e.Calendar())
+	if err != nil {
+		return nil, err
+	}
+	var program *condition.Program
+	state := models.StateScheduled
+	if req.ScheduleAt == "" {
+		if program, err = condition.Compile(req.Condition); err != nil {
+			return nil, err
+		}
+		state = models.StateMonitoring
+	}
+
+	now := time.Now()
+	order := &models.AutoOrder{
+		UserID:       userID,
+		Symbol:       req.Symbol,
+		Exchange:     req.Exchange,
+		Product:      req.Product,
+		Quantity:     req.Quantity,
+		Action:       req.Action,
+		Interval:     req.Interval,
+		Condition:    req.Condition,
+		ScheduleAt:   req.ScheduleAt,
+		Recurring:    req.Recurring,
+		StopLoss:     req.StopLoss,
+		Target:       req.Target,
+		TrailingStop: req.TrailingStop,
+		TrailOn:      req.TrailOn,
+		Status:       "running",
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+		State:        state,
+	}
+
+	if err := e.create(order); err != nil {
+		return nil, err
+	}
+
+	e.launch(order, program)
+	return order, nil
+}
+
+// create gives a new auto order an ID that is not taken yet and saves it
+func (e *Engine) create(order *models.AutoOrder) error {
+	e.createMu.Lock()
+	defer e.createMu.Unlock()
+
+	id, err := e.newID(order.CreatedAt)
+	if err != nil {
+		return err
+	}
+	order.ID = id
+	if err := e.db.CreateAutoOrder(order); err != nil {
+		return fmt.Errorf("failed to save auto order: %w", err)
+	}
+	return nil
+}
+
+// newID returns an auto order ID that is not taken yet, starting from one
+// based on the time
+func (e *Engine) newID(now time.Time) (string, error) {
+	const ids = 100000
+	start := now.Unix() % ids
+	for i := int64(0); i < ids; i++ {
+		id := fmt.Sprintf("SO-%d", (start+i)%ids)
+		stored, err := e.db.GetAutoOrderByID(id)
+		if err != nil {
+			return "", fmt.Errorf("failed to check auto order ID %s: %w", id, err)
+		}
+		if stored == nil {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no free auto order ID")
+}
+
+// Calendar returns the exchange calendar that orders are monitored by
+func (e *Engine) Calendar() *calendar.Calendar {
+	return e.candles.Calendar()
+}
+
+// List returns the running auto orders of a user, oldest first
+func (e *Engine) List(userID int) []*models.AutoOrder {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	orders := []*models.AutoOrder{}
+	for _, order := range e.orders {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	return orders
+}
+
+// Get returns a running auto order owned by the user
+func (e *Engine) Get(userID int, orderID string) (*models.AutoOrder, error) {
+	order := e.lookup(orderID)
+	if order == nil || order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// Cancel stops a running auto order owned by the user. The cancellation is
+// persisted before returning and published to all of the user's sessions.
+func (e *Engine) Cancel(userID int, orderID string) (*models.AutoOrder, error) {
+	order := e.lookup(orderID)
+	if order == nil {
+		// Not monitored in memory; close it if the database still thinks it is running
+		stored, err := e.db.GetAutoOrderByID(orderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load auto order: %w", err)
+		}
+		if stored == nil || stored.UserID != userID || stored.Status != "running" {
+			return nil, ErrOrderNotFound
+		}
+		e.setStatus(stored, models.StateCancelled, "cancelled")
+		return stored, nil
+	}
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+
+	// The order may have stopped on its own since it was looked up
+	if !e.finish(order, models.StateCancelled, "cancelled") {
+		return nil, ErrOrderNotFound
+	}
+	e.notify(order, EventCancelled, fmt.Sprintf("❌ Auto-Order %s for %s was CANCELLED.", order.ID, order.Symbol), "", nil)
+	return order, nil
+}
+
+// CancelAll stops every running auto order of the user and returns the cancelled orders
+func (e *Engine) CancelAll(userID int) []*models.AutoOrder {
+	cancelled := []*models.AutoOrder{}
+	for _, order := range e.List(userID) {
+		if _, err := e.Cancel(userID, order.ID); err != nil {
+			log.Printf("AUTO-ORDER: Failed to cancel %s: %v", order.ID, err)
+			continue
+		}
+		cancelled = append(cancelled, order)
+	}
+	return cancelled
+}
+
+// launch registers an order and starts its monitoring goroutine
+func (e *Engine) launch(order *models.AutoOrder, program *condition.Program) {
+	cancelChan := make(chan struct{})
+
+	e.mu.Lock()
+	e.orders[order.ID] = order
+	e.cancellation[order.ID] = cancelChan
+	e.mu.Unlock()
+
+	go e.run(order, program, cancelChan, 0)
+}
+
+// run monitors an order until it reaches a final status, restarting it after a panic
+// as long as it has not expired. After maxCrashes panics the order fails instead.
+func (e *Engine) run(order *models.AutoOrder, program *condition.Program, cancelChan chan struct{}, crashes int) {
+	defer func() {
+		if r := recover(); r != nil {
+			crashes++
+			log.Printf("🚨 PANIC in auto order monitor for %s (crash %d of %d): %v", order.Symbol, crashes, maxCrashes, r)
+			e.notify(order, EventCrashed, fmt.Sprintf("❌ Auto-Order %s crashed: %v.", order.ID, r), "", nil)
+			e.emailService.SendEmail(e.emailRecipient, "Auto-Order Process crashed", fmt.Sprintf("Auto-Order %s crashed (%d of %d): %v", order.ID, crashes, maxCrashes, r))
+			switch {
+			case crashes >= maxCrashes:
+				order.StateMux.Lock()
+				order.LastError = fmt.Sprintf("crashed %d times: %v", crashes, r)
+				order.StateMux.Unlock()
+				e.notify(order, EventCrashed, fmt.Sprintf(" order %s crashed %d times and will not be restarted.", order.ID, crashes), "", nil)
+				e.finish(order, models.StateFailed, "failed")
+			case time.Now().Before(order.ExpiresAt):
+				e.notify(order, EventStarted, fmt.Sprintf(" restarting monitoring for order %s.", order.ID), "", nil)
+				go e.run(order, program, cancelChan, crashes)
+			default:
+				e.notify(order, EventExpired, fmt.Sprintf(" order %s has expired and will not be restarted.", order.ID), "", nil)
+				e.finish(order, models.StateExpired, "expired")
+			}
+		}
+	}()
+
+	var state models.OrderState
+	var status string
+	if order.ScheduleAt != "" {
+		state, status = e.schedule(order, cancelChan)
+	} else {
+		state, status = e.monitor(order, program, cancelChan)
+	}
+	e.finish(order, state, status)
+}
+
+// monitor evaluates the order condition on every interval tick and places the order
+// each time the condition becomes true. It returns the final state and status.
+func (e *Engine) monitor(order *models.AutoOrder, program *condition.Program, cancelChan chan struct{}) (models.OrderState, string) {
+	log.Printf("AUTO-ORDER: Monitoring started for %s on %s. Interval: %s. Condition: %s",
+		order.Symbol, order.Exchange, order.Interval, order.Condition)
+
+	checkDelay, _ := ParseIntervalDuration(order.Interval)
+	if checkDelay < 5*time.Second {
+		checkDelay = 5 * time.Second
+	}
+	ticker := time.NewTicker(checkDelay)
+	defer ticker.Stop()
+
+	expiryDuration := time.Until(order.ExpiresAt)
+	if expiryDuration <= 0 {
+		e.notify(order, EventExpired, fmt.Sprintf("⚠️ Auto-Order %s already expired. Stopping.", order.ID), "", nil)
+		return models.StateExpired, "expired"
+	}
+	if expiryDuration > 30*24*time.Hour {
+		expiryDuration = 30 * 24 * time.Hour
+	}
+	expiryTimer := time.NewTimer(expiryDuration)
+	defer expiryTimer.Stop()
+
+	for {
+		select {
+		case <-cancelChan:
+			// Cancel has already persisted and announced the cancellation
+			return models.StateCancelled, "cancelled"
+		case <-expiryTimer.C:
+			e.notify(order, EventExpired, fmt.Sprintf("🕒 Auto-Order %s for %s has EXPIRED. Monitoring stopped.", order.ID, order.Symbol), "", nil)
+			return models.StateExpired, "expired"
+		case <-ticker.C:
+			if time.Now().After(order.ExpiresAt) {
+				e.notify(order, EventExpired, fmt.Sprintf("🕒 Auto-Order %s for %s has EXPIRED. Monitoring stopped.", order.ID, order.Symbol), "", nil)
+				return models.StateExpired, "expired"
+			}
+
+			if !e.marketReady(order) {
+				continue
+			}
+			if stopped := e.tick(order, program); stopped {
+				return models.StateFailed, "failed"
+			}
+		}
+	}
+}
+
+// marketReady reports whether the exchange of an order is in session and the
+// first candle of the session has closed, so that conditions are never
+// evaluated on the stale candles of the previous session. Until then the
+// order waits in the market closed state without evaluating.
+func (e *Engine) marketReady(order *models.AutoOrder) bool {
+	now := time.Now()
+	length, _ := ParseIntervalDuration(order.Interval)
+	session, ok := e.Calendar().Next(order.Exchange, now)
+	if ok && session.Contains(now) && !now.Before(session.Open.Add(length)) {
+		return true
+	}
+
+	order.StateMux.Lock()
+	waiting := order.State == models.StateMarketClosed
+	order.State = models.StateMarketClosed
+	order.StateMux.Unlock()
+	if !waiting {
+		if ok {
+			log.Printf("AUTO-ORDER: %s waits for %s to open; evaluation resumes after the first %s candle from %s",
+				order.ID, order.Exchange, order.Interval, session.Open.Format("02 Jan 15:04 MST"))
+		} else {
+			log.Printf("AUTO-ORDER: %s waits for %s to open; no session within 30 days", order.ID, order.Exchange)
+		}
+	}
+	return false
+}
+
+// tick runs a single evaluation of an order. It reports whether monitoring must stop.
+func (e *Engine) tick(order *models.AutoOrder, program *condition.Program) bool {
+	e.setState(order, models.StateEvaluating)
+	isMet, valuesMap, err := e.candles.Evaluate(order.Interval, program, order.Symbol, order.Exchange)
+	evaluatedAt := time.Now()
+	if err != nil {
+		log.Printf("AUTO-ORDER: Evaluation error for %s: %v", order.ID, err)
+		order.StateMux.Lock()
+		order.State = models.StateMonitoring
+		order.LastError = err.Error()
+		order.LastEvaluatedAt = &evaluatedAt
+		order.StateMux.Unlock()
+		return false
+	}
+
+	order.StateMux.Lock()
+	order.LastValues = finiteValues(valuesMap)
+	order.LastResult = isMet
+	order.LastEvaluatedAt = &evaluatedAt
+	order.LastError = ""
+	order.StateMux.Unlock()
+
+	// State transition logic: only fire when the condition *becomes* true
+	if isMet && !order.ConditionState {
+		order.StateMux.Lock()
+		order.ConditionState = true
+		order.StateMux.Unlock()
+
+		indicatorSummary := FormatValues(valuesMap)
+		e.notify(order, EventTriggered, fmt.Sprintf("🎯 Auto-Order %s condition met for %s:%s", order.ID, order.Symbol, indicatorSummary), "", valuesMap)
+
+		log.Printf("AUTO-ORDER: Condition met for %s. Placing order.", order.ID)
+		return e.place(order, valuesMap, "### Trigger Values:\n"+indicatorSummary, "Monitoring continues.")
+	}
+
+	if !isMet && order.ConditionState {
+		// Condition is no longer met, reset the state so it can fire again
+		log.Printf("AUTO-ORDER: Condition for %s is no longer met. Resetting state.", order.ID)
+		order.StateMux.Lock()
+		order.ConditionState = false
+		order.StateMux.Unlock()
+		e.saveState(order)
+	}
+
+	e.setState(order, models.StateMonitoring)
+	return false
+}
+
+// place sends the order of an auto order that fired to the broker, records
+// the fire and reports it with trigger, the markdown section that explains
+// why it fired, and next, what happens to the auto order now. It reports
+// whether the auto order must stop because the order could not be placed.
+func (e *Engine) place(order *models.AutoOrder, values map[string]float64, trigger, next string) bool {
+	firedAt := time.Now()
+	order.StateMux.Lock()
+	order.FireCount++
+	order.LastFiredAt = &firedAt
+	order.State = models.StateExecuting
+	order.StateMux.Unlock()
+
+	orderReq := &openalgo.OpenAlgoSmartOrderRequest{
+		Strategy:  "auto_chat",
+		Symbol:    order.Symbol,
+		Exchange:  order.Exchange,
+		Action:    order.Action,
+		Pricetype: "MARKET",
+		Product:   order.Product,
+		Quantity:  order.Quantity,
+	}
+
+	b, err := e.brokers.For(order.UserID, 0)
+	var orderResponse *openalgo.OpenAlgoSmartOrderResponse
+	if err == nil {
+		// A smart order moves the position to its position size. Aim for the
+		// position held now plus this order, so that every fire, such as each
+		// run of a recurring schedule, adds to it instead of closing it.
+		var current int
+		if current, err = broker.Held(b, order.Symbol, order.Exchange, order.Product); err != nil {
+			err = fmt.Errorf("failed to read the position: %w", err)
+		} else if strings.EqualFold(order.Action, "SELL") {
+			orderReq.PositionSize = current - order.Quantity
+		} else {
+			orderReq.PositionSize = current + order.Quantity
+		}
+	}
+	if err == nil {
+		err = e.risk.Check(order.UserID, b, orderReq, risk.SourceAutoOrder)
+	}
+	if err == nil {
+		orderResponse, err = b.PlaceSmartOrder(orderReq)
+	}
+	if err != nil {
+		order.StateMux.Lock()
+		order.LastError = err.Error()
+		order.StateMux.Unlock()
+		e.recordFire(order, values, "", err)
+		e.saveState(order)
+
+		// On failure, cancel the auto-order immediately
+		errMsg := fmt.Sprintf("❌ Auto-Order %s FAILED to place order: %v. The auto-order has been CANCELLED.", order.ID, err)
+		e.notify(order, EventRejected, errMsg, "", values)
+		e.emailService.SendEmail(e.emailRecipient, "Auto-Order CANCELLED Due to Failure", errMsg)
+		return true
+	}
+
+	// Safely access the broker ID from the already parsed response
+	brokerID := ""
+	if orderResponse != nil && orderResponse.Data.OrderID != "" {
+		brokerID = orderResponse.Data.OrderID
+	}
+
+	if brokerID == "" {
+		log.Printf("CRITICAL: Broker Order ID is empty for auto-order %s. Its status cannot be tracked.", order.ID)
+		// Even if brokerID is empty, we must send a success message so the user knows the trigger fired.
+		// The user will see that the Broker ID is missing.
+	}
+
+	order.StateMux.Lock()
+	order.State = models.StateMonitoring
+	order.StateMux.Unlock()
+	e.recordFire(order, values, brokerID, nil)
+	e.saveState(order)
+
+	e.notify(order, EventExecuted, fmt.Sprintf("✅ **AUTO ORDER EXECUTED** for %s on %s!\n\n%s\n**Broker**: %s\n**Broker ID**: %s\n\n%s",
+		order.Symbol, order.Exchange, trigger, b.Name(), brokerID, next), brokerID, values)
+	e.emailService.SendEmail(e.emailRecipient, "Auto-Order Executed", fmt.Sprintf("Auto-Order %s executed for %s on %s.", order.ID, order.Symbol, order.Exchange))
+
+	// Record the trade so the order tracker follows it until it is filled, rejected or cancelled
+	if brokerID != "" {
+		trade := &models.Trade{
+			UserID:      order.UserID,
+			AutoOrderID: order.ID,
+			Symbol:      strings.ToUpper(order.Symbol),
+			Exchange:    strings.ToUpper(order.Exchange),
+			Product:     strings.ToUpper(order.Product),
+			Broker:      b.Name(),
+			Action:      order.Action,
+			Quantity:    order.Quantity,
+			OrderType:   "MARKET",
+			Status:      models.TradePending,
+			OrderID:     brokerID,
+		}
+		savedTrade, err := e.db.CreateTrade(trade)
+		if err != nil {
+			log.Printf("AUTO-ORDER: Failed to record trade for %s (broker ID %s): %v", order.ID, brokerID, err)
+		} else if legs := exitLegs(order); !legs.IsZero() {
+			if _, err := e.brackets.Attach(savedTrade, legs); err != nil {
+				log.Printf("AUTO-ORDER: Failed to attach the bracket of %s to broker ID %s: %v", order.ID, brokerID, err)
+				e.notify(order, EventUnresolved, fmt.Sprintf("⚠️ The protective exits of Auto-Order %s could not be set for broker ID **%s**: %v. The position is not protected.",
+					order.ID, brokerID, err), brokerID, nil)
+			}
+		}
+	}
+	return false
+}
+
+// OrderUpdated reports the outcome of a broker order placed by an auto order.
+// It is called by the order tracker whenever the status of a trade changes.
+func (e *Engine) OrderUpdated(trade *models.Trade) {
+	if trade.AutoOrderID == "" {
+		return
+	}
+	autoOrder, err := e.db.GetAutoOrderByID(trade.AutoOrderID)
+	if err != nil || autoOrder == nil {
+		log.Printf("AUTO-ORDER: Order %s status update for unknown auto-order %s", trade.OrderID, trade.AutoOrderID)
+		return
+	}
+
+	log.Printf("Order %s status for %s (%s): %s", trade.OrderID, autoOrder.Symbol, autoOrder.Action, trade.BrokerStatus)
+
+	switch trade.Status {
+	case models.TradeExecuted:
+		values := map[string]float64{"filled_quantity": float64(trade.FilledQuantity), "average_price": trade.AveragePrice}
+		e.notify(autoOrder, EventFilled, fmt.Sprintf("💰 Auto-Order %s for %s (%s) was filled: %d at %.2f (broker ID **%s**).",
+			autoOrder.ID, autoOrder.Symbol, autoOrder.Action, trade.FilledQuantity, trade.AveragePrice, trade.OrderID), trade.OrderID, values)
+	case models.TradeRejected, models.TradeCancelled:
+		failureMsg := fmt.Sprintf(
+			"⚠️ **Order Failure Notice** ⚠️\n\nYour auto-order for **%s** (%s) with broker ID **%s** was **%s**.",
+			autoOrder.Symbol, autoOrder.Action, trade.OrderID, strings.ToUpper(trade.Status),
+		)
+		e.notify(autoOrder, EventRejected, failureMsg, trade.OrderID, nil)
+		e.emailService.SendEmail(
+			e.emailRecipient,
+			fmt.Sprintf("Auto-Order %s for %s was %s", autoOrder.ID, autoOrder.Symbol, strings.ToUpper(trade.Status)),
+			failureMsg,
+		)
+	case models.TradeUnresolved:
+		unresolvedMsg := fmt.Sprintf(
+			"⚠️ **Order Status Unresolved** ⚠️\n\nYour auto-order for **%s** (%s) with broker ID **%s** could not be confirmed as 'complete' (%s). Please verify its status manually.",
+			autoOrder.Symbol, autoOrder.Action, trade.OrderID, trade.StatusMessage,
+		)
+		e.notify(autoOrder, EventUnresolved, unresolvedMsg, trade.OrderID, nil)
+		e.emailService.SendEmail(
+			e.emailRecipient,
+			fmt.Sprintf("Auto-Order %s for %s - Status Unresolved", autoOrder.ID, autoOrder.Symbol),
+			unresolvedMsg,
+		)
+	}
+}
+
+// exitLegs returns the exits an order attaches to each of its fills
+func exitLegs(order *models.AutoOrder) bracket.Legs {
+	return bracket.Legs{
+		StopLoss:     order.StopLoss,
+		Target:       order.Target,
+		TrailingStop: order.TrailingStop,
+		TrailOn:      order.TrailOn,
+		Interval:     order.Interval,
+	}
+}
+
+// lookup returns a running order by ID, or nil if it is no longer running
+func (e *Engine) lookup(orderID string) *models.AutoOrder {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.orders[orderID]
+}
+
+// finish records the final status of an order and releases its resources.
+// The first final status wins, so a cancellation is not overwritten by the
+// monitor goroutine winding down. It reports whether this status was recorded.
+func (e *Engine) finish(order *models.AutoOrder, state models.OrderState, status string) bool {
+	recorded := e.setStatus(order, state, status)
+
+	order.CleanupOnce.Do(func() {
+		e.mu.Lock()
+		delete(e.orders, order.ID)
+		if ch, ok := e.cancellation[order.ID]; ok {
+			select {
+			case <-ch:
+			default:
+				close(ch)
+			}
+			delete(e.cancellation, order.ID)
+		}
+		e.mu.Unlock()
+		log.Printf("AUTO-ORDER: Monitoring for %s (ID: %s) stopped and cleaned up.", order.Symbol, order.ID)
+	})
+	return recorded
+}
+
+func (e *Engine) setState(order *models.AutoOrder, state models.OrderState) {
+	order.StateMux.Lock()
+	order.State = state
+	order.StateMux.Unlock()
+}
+
+// setStatus records the final state and status of a running order in memory and
+// in the database. The check and the update share one lock, so of two final
+// statuses only the first is recorded; it reports whether this one was.
+func (e *Engine) setStatus(order *models.AutoOrder, state models.OrderState, status string) bool {
+	order.StateMux.Lock()
+	if order.Status != "running" {
+		order.StateMux.Unlock()
+		return false
+	}
+	order.State = state
+	order.Status = status
+	order.StateMux.Unlock()
+
+	e.saveState(order)
+	if err := e.db.UpdateAutoOrderStatus(order.ID, status); err != nil {
+		log.Printf("AUTO-ORDER: Failed to persist status '%s' for %s: %v", status, order.ID, err)
+	}
+	return true
+}
+
+// saveState persists the condition and fire state of an order
+func (e *Engine) saveState(order *models.AutoOrder) {
+	if err := e.db.UpdateAutoOrderState(order); err != nil {
+		log.Printf("AUTO-ORDER: Failed to persist state for %s: %v", order.ID, err)
+	}
+}
+
+// recordFire stores one trigger of an order in its fire history
+func (e *Engine) recordFire(order *models.AutoOrder, values map[string]float64, brokerID string, placeErr error) {
+	fire := &models.AutoOrderFire{
+		AutoOrderID:   order.ID,
+		BrokerOrderID: brokerID,
+		TriggerValues: "{}",
+		Status:        "placed",
+	}
+	if placeErr != nil {
+		fire.Status = "failed"
+		fire.Error = placeErr.Error()
+	}
+
+	if valuesJSON, err := json.Marshal(finiteValues(values)); err == nil {
+		fire.TriggerValues = string(valuesJSON)
+	}
+
+	if err := e.db.CreateAutoOrderFire(fire); err != nil {
+		log.Printf("AUTO-ORDER: Failed to record fire for %s: %v", order.ID, err)
+	}
+}
+
+// notify publishes an event to every open session of the order owner, both as a
+// system chat message and as a structured auto_order event.
+func (e *Engine) notify(order *models.AutoOrder, eventType, content, brokerID string, values map[string]float64) {
+	now := time.Now()
+	event := Event{
+		Type:          eventType,
+		OrderID:       order.ID,
+		Symbol:        order.Symbol,
+		Exchange:      order.Exchange,
+		Action:        order.Action,
+		BrokerOrderID: brokerID,
+		Values:        finiteValues(values),
+		Message:       content,
+		Timestamp:     now,
+	}
+
+	chatMsg := message{
+		Type:    "chat",
+		Content: content,
+		Data: map[string]interface{}{
+			"role":       "system",
+			"created_at": now,
+		},
+	}
+	if msgBytes, err := json.Marshal(chatMsg); err == nil {
+		e.notifier.SendToUser(order.UserID, msgBytes)
+	}
+
+	eventMsg := message{Type: "auto_order", Data: event}
+	if msgBytes, err := json.Marshal(eventMsg); err == nil {
+		e.notifier.SendToUser(order.UserID, msgBytes)
+	} else {
+		log.Printf("AUTO-ORDER: Failed to marshal %s event for %s: %v", eventType, order.ID, err)
+	}
+}
+
+// FormatValues renders indicator values as a compact markdown summary
+func FormatValues(values map[string]float64) string {
+	var summary strings.Builder
+	for name, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			summary.WriteString(fmt.Sprintf(" **%s**: N/A |", name))
+		} else {
+			summary.WriteString(fmt.Sprintf(" **%s**: %.2f |", name, value))
+		}
+	}
+	return summary.String()
+}
+
+// finiteValues drops NaN and infinite values, which cannot be encoded as JSON
+func finiteValues(values map[string]float64) map[string]float64 {
+	if values == nil {
+		return nil
+	}
+	finite := make(map[string]float64, len(values))
+	for name, value := range values {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			finite[name] = value
+		}
+	}
+	return finite
+}
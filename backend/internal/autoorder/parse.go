@@ -0,0 +1,57 @@
+package autoorder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"trading-app/internal/calendar"
+)
+
+// ValidityClose is the validity of an auto order that ends with the trading session
+const ValidityClose = "close"
+
+// ParseIntervalDuration converts a candle interval such as "5m" or "1h" into a duration
+func ParseIntervalDuration(interval string) (time.Duration, error) {
+	switch strings.ToLower(interval) {
+	case "5m":
+		return 5 * time.Minute, nil
+	case "15m":
+		return 15 * time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	default:
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return 0, fmt.Errorf("invalid or unsupported interval format: %s", interval)
+		}
+		return d, nil
+	}
+}
+
+// ParseValidity converts a validity such as "2h", "close" or "forever" into
+// an expiry time. "close" ends with the session of the exchange that is open,
+// or with its next session while it is closed.
+func ParseValidity(validityStr, exchange string, cal *calendar.Calendar) (time.Time, error) {
+	switch strings.ToLower(validityStr) {
+	case "forever":
+		return time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	case ValidityClose:
+		session, ok := cal.Next(exchange, time.Now())
+		if !ok {
+			return time.Time{}, fmt.Errorf("%s has no trading session in the next 30 days", exchange)
+		}
+		return session.Close, nil
+	}
+
+	duration, err := time.ParseDuration(validityStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid duration format")
+	}
+
+	if duration > 30*24*time.Hour {
+		return time.Time{}, fmt.Errorf("maximum validity period is 30 days")
+	}
+
+	return time.Now().Add(duration), nil
+}
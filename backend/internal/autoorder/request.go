@@ -0,0 +1,204 @@
+package autoorder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"trading-app/internal/bracket"
+	"trading-app/internal/calendar"
+	"trading-app/internal/condition"
+	"trading-app/internal/models"
+)
+
+// Request describes a new auto order, as submitted through chat or the REST API
+type Request struct {
+	Symbol    string `json:"symbol"`
+	Exchange  string `json:"exchange"`
+	Product   string `json:"product"`   // MIS, NRML, CNC
+	Interval  string `json:"interval"`  // 5m, 15m, 1h
+	Validity  string `json:"validity"`  // Go duration such as "2h", "close" or "forever"
+	Condition string `json:"condition"` // Pine-like condition, e.g. "RSI14 < 30"
+	Action    string `json:"action"`    // BUY, SELL
+	Quantity  int    `json:"quantity"`
+	StopLoss  string `json:"stop_loss"` // Optional: "1450", "2%" or "1.5atr"
+	Target    string `json:"target"`
+	// Optional trailing stop, by "20" points, "1%" or "2atr", following the
+	// price on every check ("tick") or on every closed Interval candle ("interval")
+	TrailingStop string `json:"trailing_stop"`
+	TrailOn      string `json:"trail_on"`
+	// Optional time of day, as "09:20" IST, at which the order is placed
+	// instead of on a condition. It runs once, or on every trading day when
+	// recurring, until its validity ("forever" by default) ends.
+	ScheduleAt string `json:"schedule_at"`
+	Recurring  bool   `json:"recurring"`
+}
+
+// Validate normalizes the request and checks it against the auto order rules.
+// It returns the expiry time derived from the validity, using the calendar
+// for a validity of "close" and for the runs of scheduled orders.
+func (r *Request) Validate(cal *calendar.Calendar) (time.Time, error) {
+	r.Symbol = strings.ToUpper(strings.TrimSpace(r.Symbol))
+	r.Exchange = strings.ToUpper(strings.TrimSpace(r.Exchange))
+	r.Product = strings.ToUpper(strings.TrimSpace(r.Product))
+	r.Interval = strings.ToLower(strings.TrimSpace(r.Interval))
+	r.Validity = strings.ToLower(strings.TrimSpace(r.Validity))
+	r.Condition = strings.Trim(strings.TrimSpace(r.Condition), "\"")
+	r.Action = strings.ToUpper(strings.TrimSpace(r.Action))
+	r.ScheduleAt = strings.TrimSpace(r.ScheduleAt)
+	if r.ScheduleAt != "" && r.Interval == "" {
+		r.Interval = DefaultScheduleInterval
+	}
+
+	if r.Symbol == "" || r.Exchange == "" {
+		return time.Time{}, fmt.Errorf("symbol and exchange are required")
+	}
+	if r.Action != "BUY" && r.Action != "SELL" {
+		return time.Time{}, fmt.Errorf("invalid action %q (use BUY or SELL)", r.Action)
+	}
+	if r.Product != "MIS" && r.Product != "NRML" && r.Product != "CNC" {
+		return time.Time{}, fmt.Errorf("invalid product type %q (use MIS, NRML, or CNC)", r.Product)
+	}
+	if r.Quantity <= 0 {
+		return time.Time{}, fmt.Errorf("quantity must be greater than zero")
+	}
+	if r.Interval != "5m" && r.Interval != "15m" && r.Interval != "1h" {
+		return time.Time{}, fmt.Errorf("unsupported interval %q (use 5m, 15m, or 1h)", r.Interval)
+	}
+	var expiresAt time.Time
+	var err error
+	if r.ScheduleAt != "" {
+		if expiresAt, err = r.validateSchedule(cal, time.Now()); err != nil {
+			return time.Time{}, err
+		}
+	} else {
+		if r.Recurring {
+			return time.Time{}, fmt.Errorf("only scheduled orders recur")
+		}
+		if r.Condition == "" {
+			return time.Time{}, fmt.Errorf("a condition is required")
+		}
+		if _, err := condition.Compile(r.Condition); err != nil {
+			return time.Time{}, fmt.Errorf("invalid condition: %v", err)
+		}
+		if expiresAt, err = ParseValidity(r.Validity, r.Exchange, cal); err != nil {
+			return time.Time{}, fmt.Errorf("invalid validity %q: %v", r.Validity, err)
+		}
+	}
+	// Absolute levels are checked against the fill once the order fires
+	legs := bracket.Legs{StopLoss: r.StopLoss, Target: r.Target, TrailingStop: r.TrailingStop, TrailOn: r.TrailOn}
+	if err := legs.Validate(r.Action, 0); err != nil {
+		return time.Time{}, fmt.Errorf("invalid %v", err)
+	}
+	r.StopLoss, r.Target, r.TrailingStop, r.TrailOn = legs.StopLoss, legs.Target, legs.TrailingStop, legs.TrailOn
+
+	return expiresAt, nil
+}
+
+// validateSchedule checks the time of a scheduled order against the trading
+// sessions of its exchange and returns its expiry. An order that does not
+// recur expires shortly after its first run after now.
+func (r *Request) validateSchedule(cal *calendar.Calendar, now time.Time) (time.Time, error) {
+	if r.Condition != "" {
+		return time.Time{}, fmt.Errorf("a scheduled order takes no condition")
+	}
+	at, err := ParseScheduleTime(r.ScheduleAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	r.ScheduleAt = at
+	first, ok := NextRun(cal, r.Exchange, r.ScheduleAt, now)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s IST falls in no trading session of %s in the next 30 days", r.ScheduleAt, r.Exchange)
+	}
+
+	if !r.Recurring {
+		if r.Validity != "" {
+			return time.Time{}, fmt.Errorf("a one-off scheduled order takes no validity; it ends after its run")
+		}
+		return first.Add(missedRunGrace), nil
+	}
+	if r.Validity == "" {
+		r.Validity = "forever"
+	}
+	expiresAt, err := ParseValidity(r.Validity, r.Exchange, cal)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid validity %q: %v", r.Validity, err)
+	}
+	if !first.Before(expiresAt) {
+		return time.Time{}, fmt.Errorf("the order would expire before its first run on %s", first.Format(runLayout))
+	}
+	return expiresAt, nil
+}
+
+// Status is a point-in-time view of an auto order that is safe to serialize
+// while its monitor is running.
+type Status struct {
+	ID              string                  `json:"id"`
+	Symbol          string                  `json:"symbol"`
+	Exchange        string                  `json:"exchange"`
+	Product         string                  `json:"product"`
+	Quantity        int                     `json:"quantity"`
+	Action          string                  `json:"action"`
+	Interval        string                  `json:"interval"`
+	Condition       string                  `json:"condition"`
+	ScheduleAt      string                  `json:"schedule_at,omitempty"`
+	Recurring       bool                    `json:"recurring,omitempty"`
+	NextRunAt       *time.Time              `json:"next_run_at,omitempty"`
+	StopLoss        string                  `json:"stop_loss,omitempty"`
+	Target          string                  `json:"target,omitempty"`
+	TrailingStop    string                  `json:"trailing_stop,omitempty"`
+	TrailOn         string                  `json:"trail_on,omitempty"`
+	Status          string                  `json:"status"`
+	State           models.OrderState       `json:"state"`
+	ConditionState  bool                    `json:"condition_state"`
+	FireCount       int                     `json:"fire_count"`
+	LastFiredAt     *time.Time              `json:"last_fired_at,omitempty"`
+	LastError       string                  `json:"last_error,omitempty"`
+	LastResult      bool                    `json:"last_result"`
+	LastValues      map[string]float64      `json:"last_values,omitempty"`
+	LastEvaluatedAt *time.Time              `json:"last_evaluated_at,omitempty"`
+	CreatedAt       time.Time               `json:"created_at"`
+	ExpiresAt       time.Time               `json:"expires_at"`
+	Fires           []*models.AutoOrderFire `json:"fires,omitempty"`
+}
+
+// StatusOf takes a consistent snapshot of an auto order
+func StatusOf(order *models.AutoOrder) Status {
+	order.StateMux.RLock()
+	defer order.StateMux.RUnlock()
+
+	values := make(map[string]float64, len(order.LastValues))
+	for name, value := range order.LastValues {
+		values[name] = value
+	}
+
+	return Status{
+		ID:              order.ID,
+		Symbol:          order.Symbol,
+		Exchange:        order.Exchange,
+		Product:         order.Product,
+		Quantity:        order.Quantity,
+		Action:          order.Action,
+		Interval:        order.Interval,
+		Condition:       order.Condition,
+		ScheduleAt:      order.ScheduleAt,
+		Recurring:       order.Recurring,
+		NextRunAt:       order.NextRunAt,
+		StopLoss:        order.StopLoss,
+		Target:          order.Target,
+		TrailingStop:    order.TrailingStop,
+		TrailOn:         order.TrailOn,
+		Status:          order.Status,
+		State:           order.State,
+		ConditionState:  order.ConditionState,
+		FireCount:       order.FireCount,
+		LastFiredAt:     order.LastFiredAt,
+		LastError:       order.LastError,
+		LastResult:      order.LastResult,
+		LastValues:      values,
+		LastEvaluatedAt: order.LastEvaluatedAt,
+		CreatedAt:       order.CreatedAt,
+		ExpiresAt:       order.ExpiresAt,
+	}
+}
@@ -0,0 +1,146 @@
+package autoorder
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"trading-app/internal/calendar"
+	"trading-app/internal/models"
+)
+
+// DefaultScheduleInterval is the candle interval of scheduled orders that set
+// none, used for the ATR of their brackets
+const DefaultScheduleInterval = "5m"
+
+// missedRunGrace is how late a scheduled order still fires, as after a restart
+const missedRunGrace = 5 * time.Minute
+
+// runLayout formats the run times of scheduled orders
+const runLayout = "Mon 02 Jan 15:04 MST"
+
+// ParseScheduleTime checks a time of day such as "09:20" and returns it as HH:MM
+func ParseScheduleTime(text string) (string, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return "", fmt.Errorf("invalid time %q (use HH:MM, IST)", text)
+	}
+	return t.Format("15:04"), nil
+}
+
+// NextRun returns the first run after t of an order scheduled at a time of
+// day on an exchange: that time on the next trading day whose session
+// includes it. ok is false if there is none within 30 days.
+func NextRun(cal *calendar.Calendar, exchange, at string, t time.Time) (time.Time, bool) {
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.In(calendar.IST)
+	for day := 0; day <= 30; day++ {
+		date := t.AddDate(0, 0, day)
+		run := time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), 0, 0, calendar.IST)
+		if session, ok := cal.Session(exchange, run); ok && session.Contains(run) && run.After(t) {
+			return run, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dueRun returns the run to wait for of an order scheduled at a time of day,
+// the first after the given time that was not missed by more than
+// missedRunGrace at now, and the runs it skipped
+func dueRun(cal *calendar.Calendar, exchange, at string, after, now time.Time) (time.Time, []time.Time, bool) {
+	var missed []time.Time
+	for {
+		run, ok := NextRun(cal, exchange, at, after)
+		if !ok || now.Sub(run) <= missedRunGrace {
+			return run, missed, ok
+		}
+		missed = append(missed, run)
+		after = run
+	}
+}
+
+// schedule places a scheduled order at each of its runs until it expires, or
+// once if it does not recur. Runs missed by more than a few minutes, as while
+// the server was down, are skipped. It returns the final state and status.
+func (e *Engine) schedule(order *models.AutoOrder, cancelChan chan struct{}) (models.OrderState, string) {
+	log.Printf("AUTO-ORDER: Schedule started for %s on %s at %s IST (recurring: %t)",
+		order.Symbol, order.Exchange, order.ScheduleAt, order.Recurring)
+
+	order.StateMux.RLock()
+	after := order.CreatedAt
+	if order.LastFiredAt != nil && order.LastFiredAt.After(after) {
+		after = *order.LastFiredAt
+	}
+	order.StateMux.RUnlock()
+
+	for {
+		run, missed, ok := dueRun(e.Calendar(), order.Exchange, order.ScheduleAt, after, time.Now())
+		for _, skipped := range missed {
+			e.notify(order, EventSkipped, fmt.Sprintf("⏭️ Auto-Order %s for %s missed its run of %s while the server was offline.", order.ID, order.Symbol, skipped.Format(runLayout)), "", nil)
+			after = skipped
+		}
+
+		// Without a session in the next 30 days, look again tomorrow
+		wake := run
+		if !ok {
+			wake = time.Now().Add(24 * time.Hour)
+		}
+		if wake.After(order.ExpiresAt) {
+			wake = order.ExpiresAt
+		}
+		order.StateMux.Lock()
+		order.State = models.StateScheduled
+		order.NextRunAt = nil
+		if ok && wake.Equal(run) {
+			order.NextRunAt = &run
+		}
+		order.StateMux.Unlock()
+
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-cancelChan:
+			// Cancel has already persisted and announced the cancellation
+			timer.Stop()
+			return models.StateCancelled, "cancelled"
+		case <-timer.C:
+		}
+
+		if !time.Now().Before(order.ExpiresAt) {
+			e.notify(order, EventExpired, fmt.Sprintf("🕒 Auto-Order %s for %s has EXPIRED. Its schedule stopped.", order.ID, order.Symbol), "", nil)
+			return models.StateExpired, "expired"
+		}
+		if !ok || !wake.Equal(run) {
+			continue
+		}
+		after = run
+
+		next := "The schedule is complete."
+		if order.Recurring {
+			if following, ok := NextRun(e.Calendar(), order.Exchange, order.ScheduleAt, run); ok && following.Before(order.ExpiresAt) {
+				next = fmt.Sprintf("Next run: %s.", following.Format(runLayout))
+			} else {
+				next = "This was the last run before the order expires."
+			}
+		}
+		e.notify(order, EventTriggered, fmt.Sprintf("⏰ Auto-Order %s scheduled time %s IST reached for %s.", order.ID, order.ScheduleAt, order.Symbol), "", nil)
+		log.Printf("AUTO-ORDER: Scheduled time of %s reached. Placing order.", order.ID)
+		if stopped := e.place(order, map[string]float64{}, "### Schedule:\n"+describeSchedule(order, run), next); stopped {
+			return models.StateFailed, "failed"
+		}
+		if !order.Recurring {
+			return models.StateCompleted, "executed"
+		}
+	}
+}
+
+// describeSchedule renders when a scheduled order runs, for a run that is due
+func describeSchedule(order *models.AutoOrder, run time.Time) string {
+	if order.Recurring {
+		return fmt.Sprintf("%s IST on every trading day of %s (this run: %s)", order.ScheduleAt, order.Exchange, run.Format(runLayout))
+	}
+	return fmt.Sprintf("%s IST on %s", order.ScheduleAt, run.Format("Mon 02 Jan"))
+}
@@ -0,0 +1,226 @@
+package autoorder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"trading-app/internal/calendar"
+)
+
+// testCalendar has a holiday on Monday 26 Jan 2026, a short session on
+// Wednesday 4 Mar and Muhurat trading on Sunday 8 Nov. MCX trades late.
+const testCalendar = `{
+	"exchanges": {
+		"NSE": {
+			"open": "09:15",
+			"close": "15:30",
+			"holidays": [{"date": "2026-01-26", "name": "Republic Day"}],
+			"special_sessions": [
+				{"date": "2026-03-04", "open": "09:15", "close": "13:00", "name": "Short session"},
+				{"date": "2026-11-08", "open": "18:00", "close": "19:00", "name": "Muhurat Trading"}
+			]
+		},
+		"MCX": {"open": "09:00", "close": "23:30"}
+	}
+}`
+
+func loadTestCalendar(t *testing.T) *calendar.Calendar {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "exchanges.json")
+	if err := os.WriteFile(path, []byte(testCalendar), 0o644); err != nil {
+		t.Fatalf("failed to write calendar: %v", err)
+	}
+	cal, err := calendar.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return cal
+}
+
+// ist parses a time such as "2026-01-23 09:20" in IST
+func ist(t *testing.T, text string) time.Time {
+	t.Helper()
+	at, err := time.ParseInLocation("2006-01-02 15:04", text, calendar.IST)
+	if err != nil {
+		t.Fatalf("invalid time %q: %v", text, err)
+	}
+	return at
+}
+
+func TestNextRun(t *testing.T) {
+	cal := loadTestCalendar(t)
+	tests := []struct {
+		name     string
+		exchange string
+		at       string
+		after    string
+		want     string // Empty when there is no run
+	}{
+		{"later the same day", "NSE", "09:20", "2026-01-23 08:00", "2026-01-23 09:20"},
+		{"at the run itself", "NSE", "09:20", "2026-01-23 09:20", "2026-01-27 09:20"},
+		{"over a weekend and a holiday", "NSE", "09:20", "2026-01-23 10:00", "2026-01-27 09:20"},
+		{"from a weekend", "NSE", "09:20", "2026-01-31 12:00", "2026-02-02 09:20"},
+		{"at the open", "NSE", "09:15", "2026-01-23 08:00", "2026-01-23 09:15"},
+		{"before the open", "NSE", "09:00", "2026-01-23 08:00", ""},
+		{"at the close", "NSE", "15:30", "2026-01-23 08:00", ""},
+		{"at night", "NSE", "20:00", "2026-01-23 08:00", ""},
+		{"within a short session", "NSE", "10:00", "2026-03-03 15:00", "2026-03-04 10:00"},
+		{"after a short session closed", "NSE", "14:00", "2026-03-03 15:00", "2026-03-05 14:00"},
+		{"in a special session on a Sunday", "NSE", "18:15", "2026-11-06 12:00", "2026-11-08 18:15"},
+		{"in the sessions of another exchange", "MCX", "20:00", "2026-01-23 12:00", "2026-01-23 20:00"},
+		{"on another exchange's holiday", "MCX", "10:00", "2026-01-23 12:00", "2026-01-26 10:00"},
+		{"on an exchange the calendar does not list", "BSE", "09:20", "2026-01-23 10:00", "2026-01-27 09:20"},
+		{"invalid time", "NSE", "9am", "2026-01-23 08:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run, ok := NextRun(cal, tt.exchange, tt.at, ist(t, tt.after))
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("NextRun = %s, want none", run.Format(runLayout))
+			case tt.want != "" && !ok:
+				t.Errorf("NextRun found no run, want %s", tt.want)
+			case tt.want != "" && !run.Equal(ist(t, tt.want)):
+				t.Errorf("NextRun = %s, want %s", run.Format(runLayout), ist(t, tt.want).Format(runLayout))
+			}
+		})
+	}
+
+	// The time is read in IST whatever its zone
+	after := time.Date(2026, time.January, 23, 2, 0, 0, 0, time.UTC) // 07:30 IST
+	if run, ok := NextRun(cal, "NSE", "09:20", after); !ok || !run.Equal(ist(t, "2026-01-23 09:20")) {
+		t.Errorf("NextRun from 02:00 UTC = %s, %t, want Fri 23 Jan 09:20 IST", run.Format(runLayout), ok)
+	}
+}
+
+func TestDueRun(t *testing.T) {
+	cal := loadTestCalendar(t)
+	tests := []struct {
+		name   string
+		at     string
+		after  string // Creation or last run
+		now    string
+		want   string // Empty when there is no run
+		missed []string
+	}{
+		{"before the run", "09:20", "2026-01-22 18:00", "2026-01-23 09:00", "2026-01-23 09:20", nil},
+		{"late within the grace", "09:20", "2026-01-22 18:00", "2026-01-23 09:24", "2026-01-23 09:20", nil},
+		{"late by the grace", "09:20", "2026-01-22 18:00", "2026-01-23 09:25", "2026-01-23 09:20", nil},
+		{"late beyond the grace", "09:20", "2026-01-22 18:00", "2026-01-23 09:26", "2026-01-27 09:20",
+			[]string{"2026-01-23 09:20"}},
+		{"restart after days offline", "09:20", "2026-01-22 18:00", "2026-01-28 12:00", "2026-01-29 09:20",
+			[]string{"2026-01-23 09:20", "2026-01-27 09:20", "2026-01-28 09:20"}},
+		{"restart just after a run", "09:20", "2026-01-23 09:20", "2026-01-23 09:21", "2026-01-27 09:20", nil},
+		{"no session", "20:00", "2026-01-22 18:00", "2026-01-23 09:00", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run, missed, ok := dueRun(cal, "NSE", tt.at, ist(t, tt.after), ist(t, tt.now))
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("dueRun = %s, want none", run.Format(runLayout))
+			case tt.want != "" && !ok:
+				t.Errorf("dueRun found no run, want %s", tt.want)
+			case tt.want != "" && !run.Equal(ist(t, tt.want)):
+				t.Errorf("dueRun = %s, want %s", run.Format(runLayout), ist(t, tt.want).Format(runLayout))
+			}
+			if len(missed) != len(tt.missed) {
+				t.Fatalf("dueRun missed %v, want %v", missed, tt.missed)
+			}
+			for i, m := range missed {
+				if !m.Equal(ist(t, tt.missed[i])) {
+					t.Errorf("dueRun missed %v, want %v", missed, tt.missed)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleExpiry(t *testing.T) {
+	cal := loadTestCalendar(t)
+	forever := time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		request   Request
+		now       string
+		want      time.Time
+		wantError string
+	}{
+		{
+			name:    "one-off later today",
+			request: Request{Exchange: "NSE", ScheduleAt: "09:20"},
+			now:     "2026-01-23 08:00",
+			want:    ist(t, "2026-01-23 09:25"),
+		},
+		{
+			name:    "one-off after the weekend and the holiday",
+			request: Request{Exchange: "NSE", ScheduleAt: "9:20"},
+			now:     "2026-01-23 10:00",
+			want:    ist(t, "2026-01-27 09:25"),
+		},
+		{
+			name:    "one-off in a special session",
+			request: Request{Exchange: "NSE", ScheduleAt: "18:15"},
+			now:     "2026-11-06 12:00",
+			want:    ist(t, "2026-11-08 18:20"),
+		},
+		{
+			name:    "recurring runs forever by default",
+			request: Request{Exchange: "NSE", ScheduleAt: "09:20", Recurring: true},
+			now:     "2026-01-23 10:00",
+			want:    forever,
+		},
+		{
+			name:      "one-off with a validity",
+			request:   Request{Exchange: "NSE", ScheduleAt: "09:20", Validity: "2h"},
+			now:       "2026-01-23 08:00",
+			wantError: "takes no validity",
+		},
+		{
+			name:      "outside every session",
+			request:   Request{Exchange: "NSE", ScheduleAt: "16:00"},
+			now:       "2026-01-23 08:00",
+			wantError: "falls in no trading session",
+		},
+		{
+			name:      "with a condition",
+			request:   Request{Exchange: "NSE", ScheduleAt: "09:20", Condition: "close > 0"},
+			now:       "2026-01-23 08:00",
+			wantError: "takes no condition",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt, err := tt.request.validateSchedule(cal, ist(t, tt.now))
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("validateSchedule error = %v, want one containing %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateSchedule failed: %v", err)
+			}
+			if !expiresAt.Equal(tt.want) {
+				t.Errorf("validateSchedule expires at %s, want %s", expiresAt.Format(runLayout), tt.want.Format(runLayout))
+			}
+		})
+	}
+
+	// A one-off order expires once its run can no longer fire
+	request := Request{Exchange: "NSE", ScheduleAt: "09:20"}
+	created := ist(t, "2026-01-23 08:00")
+	expiresAt, err := request.validateSchedule(cal, created)
+	if err != nil {
+		t.Fatalf("validateSchedule failed: %v", err)
+	}
+	if _, _, ok := dueRun(cal, "NSE", request.ScheduleAt, created, expiresAt); !ok {
+		t.Fatal("dueRun found no run at the expiry")
+	}
+	if run, _, _ := dueRun(cal, "NSE", request.ScheduleAt, created, expiresAt.Add(time.Minute)); run.Before(expiresAt) {
+		t.Errorf("dueRun after the expiry = %s, want a run after it", run.Format(runLayout))
+	}
+}
@@ -0,0 +1,74 @@
+package bracket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// When a trailing stop follows the price
+const (
+	TrailOnTick     = "tick"     // On every price check
+	TrailOnInterval = "interval" // When a candle of the bracket's interval closes
+)
+
+// Legs are the exits requested with an entry order. Levels are given as
+// ParseSpec reads them; a trailing stop given as a plain number trails by
+// that many points.
+type Legs struct {
+	StopLoss     string `json:"stop_loss"`
+	Target       string `json:"target"`
+	TrailingStop string `json:"trailing_stop"`
+	TrailOn      string `json:"trail_on"` // tick (default) or interval
+	Interval     string `json:"interval"` // Candles of the ATR and of interval trailing, 5m by default
+}
+
+// IsZero reports whether no exit was requested
+func (l Legs) IsZero() bool {
+	return strings.TrimSpace(l.StopLoss) == "" && strings.TrimSpace(l.Target) == "" && strings.TrimSpace(l.TrailingStop) == ""
+}
+
+// Validate normalizes the legs of an order to action and checks them. Levels
+// given as prices are checked against the current price when it is known
+// (price above 0).
+func (l *Legs) Validate(action string, price float64) error {
+	stopSpec, err := ParseSpec(l.StopLoss)
+	if err != nil {
+		return fmt.Errorf("stop-loss: %w", err)
+	}
+	targetSpec, err := ParseSpec(l.Target)
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	trailSpec, err := ParseSpec(l.TrailingStop)
+	if err != nil {
+		return fmt.Errorf("trailing stop: %w", err)
+	}
+	l.StopLoss, l.Target, l.TrailingStop = stopSpec.String(), targetSpec.String(), trailSpec.String()
+
+	l.TrailOn = strings.ToLower(strings.TrimSpace(l.TrailOn))
+	switch {
+	case l.TrailOn == "" && !trailSpec.IsZero():
+		l.TrailOn = TrailOnTick
+	case l.TrailOn != "" && trailSpec.IsZero():
+		return fmt.Errorf("trail_on needs a trailing stop")
+	case l.TrailOn != "" && l.TrailOn != TrailOnTick && l.TrailOn != TrailOnInterval:
+		return fmt.Errorf("invalid trail_on %q (use tick or interval)", l.TrailOn)
+	}
+
+	l.Interval = strings.ToLower(strings.TrimSpace(l.Interval))
+	if l.Interval != "" && l.Interval != "5m" && l.Interval != "15m" && l.Interval != "1h" {
+		return fmt.Errorf("unsupported interval %q (use 5m, 15m, or 1h)", l.Interval)
+	}
+
+	if price <= 0 {
+		return nil
+	}
+	var stopPrice, targetPrice float64
+	if stopSpec.Kind == Price {
+		stopPrice = stopSpec.Value
+	}
+	if targetSpec.Kind == Price {
+		targetPrice = targetSpec.Value
+	}
+	return Check(price, stopPrice, targetPrice, strings.ToUpper(action) == "BUY")
+}
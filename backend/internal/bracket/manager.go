@@ -0,0 +1,699 @@
+// Package bracket manages stop-loss and target exits of filled orders on the
+// server. Both legs are watched against the live price; when one is reached
+// the position is closed with a market order and the other leg is cancelled.
+package bracket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markcheno/go-talib"
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+// Strategy is the strategy name of exit orders
+const Strategy = "bracket"
+
+// DefaultInterval is the ATR interval of brackets on orders without one
+const DefaultInterval = "5m"
+
+// ErrBracketNotFound is returned when a bracket does not exist or belongs to another user
+var ErrBracketNotFound = errors.New("bracket not found")
+
+// unrecordedExit is the error of a bracket whose exit order ID was not recorded
+const unrecordedExit = "the exit order ID was not recorded, so it is unknown whether the exit was placed"
+
+// maxExitAttempts is the number of exits that may fail before a bracket stops
+// retrying, so that an exit the broker keeps rejecting is not placed forever
+const maxExitAttempts = 5
+
+// ErrBracketExiting is returned when a bracket cannot be cancelled because its exit order is working
+var ErrBracketExiting = errors.New("bracket is exiting")
+
+// Notifier delivers a message to every open session of a user.
+// It is implemented by websocket.Hub.
+type Notifier interface {
+	SendToUser(userID int, message []byte)
+}
+
+// message mirrors the websocket message envelope understood by the frontend
+type message struct {
+	Type    string      `json:"type"`
+	Content string      `json:"content,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Manager watches the open brackets. Their state is kept in the database, so
+// a restart resumes watching, and exits that were triggered but not placed
+// are placed again.
+type Manager struct {
+	db       *database.DB
+	brokers  *broker.Selector
+	candles  *marketdata.Store
+	notifier Notifier
+
+	// Serializes bracket updates
+	mu sync.Mutex
+}
+
+// NewManager creates a bracket manager
+func NewManager(db *database.DB, brokers *broker.Selector, candles *marketdata.Store, notifier Notifier) *Manager {
+	return &Manager{
+		db:       db,
+		brokers:  brokers,
+		candles:  candles,
+		notifier: notifier,
+	}
+}
+
+// Attach adds the requested exits to the entry order of a trade. The levels
+// are set once the entry fills. It does nothing when no exit is requested.
+func (m *Manager) Attach(trade *models.Trade, legs Legs) (*models.Bracket, error) {
+	if legs.IsZero() {
+		return nil, nil
+	}
+	if err := legs.Validate(trade.Action, 0); err != nil {
+		return nil, err
+	}
+	if legs.Interval == "" {
+		legs.Interval = DefaultInterval
+	}
+
+	b := &models.Bracket{
+		UserID:       trade.UserID,
+		AutoOrderID:  trade.AutoOrderID,
+		Broker:       trade.Broker,
+		Symbol:       strings.ToUpper(trade.Symbol),
+		Exchange:     strings.ToUpper(trade.Exchange),
+		Product:      strings.ToUpper(trade.Product),
+		Action:       strings.ToUpper(trade.Action),
+		Quantity:     trade.Quantity,
+		Interval:     legs.Interval,
+		StopLossSpec: legs.StopLoss,
+		TargetSpec:   legs.Target,
+		TrailingStop: legs.TrailingStop,
+		TrailOn:      legs.TrailOn,
+		EntryTradeID: trade.ID,
+		Status:       models.BracketPending,
+	}
+	if b.Product == "" {
+		b.Product = "MIS"
+	}
+	if err := m.db.CreateBracket(b); err != nil {
+		return nil, fmt.Errorf("failed to save bracket: %w", err)
+	}
+	log.Printf("BRACKET: %d attached to trade %d (%s %d %s:%s), stop-loss %q, target %q, trailing stop %q",
+		b.ID, trade.ID, b.Action, b.Quantity, b.Exchange, b.Symbol, b.StopLossSpec, b.TargetSpec, b.TrailingStop)
+	return b, nil
+}
+
+// List returns the latest brackets of a user, newest first
+func (m *Manager) List(userID, limit int) ([]*models.Bracket, error) {
+	return m.db.GetBracketsByUserID(userID, limit)
+}
+
+// Cancel stops watching a bracket that is pending or active. The position
+// itself is left open. A bracket whose exit order is working cannot be
+// cancelled; cancel the exit order instead. One whose exit order ID was not
+// recorded can, once the user has checked the exit with the broker.
+func (m *Manager) Cancel(userID, id int) (*models.Bracket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.db.GetBracket(userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bracket: %w", err)
+	}
+	if b == nil || (b.Status != models.BracketPending && b.Status != models.BracketActive && b.Status != models.BracketExiting) {
+		return nil, ErrBracketNotFound
+	}
+	if b.Status == models.BracketExiting && !m.unrecorded(b) {
+		return b, ErrBracketExiting
+	}
+
+	m.close(b, models.BracketCancelled)
+	log.Printf("BRACKET: %d cancelled by user %d", b.ID, userID)
+	return b, nil
+}
+
+// Start checks the open brackets now and then at the given interval
+func (m *Manager) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Check()
+			<-ticker.C
+		}
+	}()
+}
+
+// Check brings every open bracket up to date: it activates the brackets whose
+// entry filled, exits the ones whose stop-loss or target was reached, and
+// closes the ones whose exit filled
+func (m *Manager) Check() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	brackets, err := m.db.GetOpenBrackets()
+	if err != nil {
+		log.Printf("BRACKET: Failed to load open brackets: %v", err)
+		return
+	}
+	quotes := make(map[string]float64)
+	for _, b := range brackets {
+		m.update(b, quotes)
+	}
+}
+
+// OrderUpdated brings the brackets of a trade up to date as soon as its
+// order changes. It is called by the order tracker.
+func (m *Manager) OrderUpdated(trade *models.Trade) {
+	if trade.Status == models.TradePending {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	brackets, err := m.db.GetBracketsByTradeID(trade.ID)
+	if err != nil {
+		log.Printf("BRACKET: Failed to load brackets of trade %d: %v", trade.ID, err)
+		return
+	}
+	for _, b := range brackets {
+		// Only the order matters here; price triggers wait for the next check
+		if b.Status != models.BracketActive {
+			m.update(b, nil)
+		}
+	}
+}
+
+// update moves a bracket forward. quotes caches the prices of this check;
+// without it, active brackets are not checked against the price.
+func (m *Manager) update(b *models.Bracket, quotes map[string]float64) {
+	switch b.Status {
+	case models.BracketPending:
+		m.updateEntry(b)
+	case models.BracketActive:
+		if quotes != nil {
+			m.watch(b, quotes)
+		}
+	case models.BracketExiting:
+		m.updateExit(b)
+	}
+}
+
+// updateEntry activates a bracket once its entry order filled, or cancels it
+// if the entry did not fill
+func (m *Manager) updateEntry(b *models.Bracket) {
+	trade, err := m.db.GetTradeByID(b.EntryTradeID)
+	if err != nil {
+		log.Printf("BRACKET: Failed to load entry trade %d of bracket %d: %v", b.EntryTradeID, b.ID, err)
+		return
+	}
+	switch {
+	case trade == nil:
+		b.LastError = "the entry trade no longer exists"
+		m.close(b, models.BracketCancelled)
+		return
+	case trade.Status == models.TradePending:
+		return
+	case trade.Status != models.TradeExecuted:
+		b.LastError = "the entry order was " + trade.Status
+		m.close(b, models.BracketCancelled)
+		log.Printf("BRACKET: %d cancelled, its entry order %s was %s", b.ID, trade.OrderID, trade.Status)
+		return
+	}
+
+	if err := m.activate(b, trade); err != nil {
+		if b.LastError != err.Error() {
+			log.Printf("BRACKET: Failed to activate %d: %v", b.ID, err)
+			b.LastError = err.Error()
+			m.save(b)
+		}
+		return
+	}
+}
+
+// activate sets the levels of a bracket from the fill of its entry
+func (m *Manager) activate(b *models.Bracket, entry *models.Trade) error {
+	price := entry.AveragePrice
+	if price <= 0 {
+		price = entry.Price
+	}
+	if price <= 0 {
+		return fmt.Errorf("the entry trade %d has no fill price", entry.ID)
+	}
+	stopSpec, err := ParseSpec(b.StopLossSpec)
+	if err != nil {
+		return err
+	}
+	targetSpec, err := ParseSpec(b.TargetSpec)
+	if err != nil {
+		return err
+	}
+	trailSpec, err := ParseSpec(b.TrailingStop)
+	if err != nil {
+		return err
+	}
+
+	var atr float64
+	if stopSpec.Kind == ATR || targetSpec.Kind == ATR || trailSpec.Kind == ATR {
+		if atr, err = m.atr(b.Symbol, b.Exchange, b.Interval); err != nil {
+			return fmt.Errorf("failed to calculate the ATR: %w", err)
+		}
+	}
+
+	long := b.Action == "BUY"
+	b.EntryPrice = price
+	if entry.FilledQuantity > 0 {
+		b.Quantity = entry.FilledQuantity
+	}
+	b.ATR = atr
+	b.StopLoss = stopSpec.Level(price, atr, long, true)
+	b.Target = targetSpec.Level(price, atr, long, false)
+	stopLabel := b.StopLossSpec
+	if !trailSpec.IsZero() {
+		// The trailing stop starts from the fill, unless the fixed stop-loss is tighter
+		filledAt := time.Now()
+		if entry.ExecutedAt != nil {
+			filledAt = *entry.ExecutedAt
+		}
+		b.ExtremePrice, b.TrailedAt = price, &filledAt
+		if stop := tighter(b.StopLoss, trailSpec.Trail(price, atr, long), long); stop != b.StopLoss {
+			b.StopLoss, stopLabel = stop, "trailing by "+formatTrail(b.TrailingStop)
+		}
+	}
+	b.LastError = ""
+
+	// A price level on the wrong side of the fill would exit straight away
+	var dropped []string
+	if err := Check(price, b.StopLoss, 0, long); err != nil {
+		dropped = append(dropped, err.Error())
+		b.StopLoss = 0
+	}
+	if err := Check(price, 0, b.Target, long); err != nil {
+		dropped = append(dropped, err.Error())
+		b.Target = 0
+	}
+	if len(dropped) > 0 {
+		b.LastError = strings.Join(dropped, "; ")
+	}
+	if b.StopLoss == 0 && b.Target == 0 {
+		m.close(b, models.BracketCancelled)
+		m.notify(b, fmt.Sprintf("⚠️ **Bracket Cancelled** for %s: %s.", describe(b), b.LastError))
+		return nil
+	}
+
+	b.Status = models.BracketActive
+	m.save(b)
+	log.Printf("BRACKET: %d active for %s %d %s:%s at %.2f, stop-loss %.2f, target %.2f",
+		b.ID, b.Action, b.Quantity, b.Exchange, b.Symbol, b.EntryPrice, b.StopLoss, b.Target)
+
+	content := fmt.Sprintf("🛡️ **Bracket Active** for %s, entered at %.2f.\n- **Stop-loss**: %s\n- **Target**: %s",
+		describe(b), b.EntryPrice, formatLevel(b.StopLoss, stopLabel), formatLevel(b.Target, b.TargetSpec))
+	if b.TrailingStop != "" {
+		content += fmt.Sprintf("\n- **Trailing**: by %s, on every %s", formatTrail(b.TrailingStop), trailCadence(b))
+	}
+	if b.LastError != "" {
+		content += fmt.Sprintf("\n\n⚠️ A leg was dropped: %s.", b.LastError)
+	}
+	m.notify(b, content)
+	return nil
+}
+
+// atr returns the latest ATR of a symbol
+func (m *Manager) atr(symbol, exchange, interval string) (float64, error) {
+	// Wilder smoothing needs a long history for a stable value
+	candles, err := m.candles.Latest(symbol, exchange, interval, ATRLength*10)
+	if err != nil {
+		return 0, err
+	}
+	if len(candles) <= ATRLength {
+		return 0, fmt.Errorf("not enough %s candles (need more than %d, got %d)", interval, ATRLength, len(candles))
+	}
+
+	high := make([]float64, len(candles))
+	low := make([]float64, len(candles))
+	closes := make([]float64, len(candles))
+	for i, c := range candles {
+		high[i], low[i], closes[i] = c.High, c.Low, c.Close
+	}
+	atr := talib.Atr(high, low, closes, ATRLength)
+	value := atr[len(atr)-1]
+	if math.IsNaN(value) || value <= 0 {
+		return 0, fmt.Errorf("no ATR value")
+	}
+	return value, nil
+}
+
+// watch exits a bracket whose stop-loss or target was reached
+func (m *Manager) watch(b *models.Bracket, quotes map[string]float64) {
+	k := b.Broker + ":" + b.Exchange + ":" + b.Symbol
+	ltp, ok := quotes[k]
+	if !ok {
+		br, err := m.brokers.ByName(b.UserID, b.Broker)
+		if err != nil {
+			log.Printf("BRACKET: No broker for %d: %v", b.ID, err)
+			return
+		}
+		quote, err := br.Quote(b.Symbol, b.Exchange)
+		if err != nil {
+			log.Printf("BRACKET: Failed to fetch quote for %s on %s: %v", b.Symbol, b.Exchange, err)
+			return
+		}
+		ltp = quote.LTP
+		quotes[k] = ltp
+	}
+	if ltp <= 0 {
+		return
+	}
+
+	if b.TrailingStop != "" {
+		m.trail(b, ltp)
+	}
+	reason := triggered(b, ltp)
+	if reason == "" {
+		return
+	}
+
+	// Record the trigger before placing the exit, so that a restart places it
+	// again rather than leaving the position without its exit
+	b.Status = models.BracketExiting
+	b.ExitReason = reason
+	b.TriggerPrice = ltp
+	m.save(b)
+	log.Printf("BRACKET: %d %s reached at %.2f", b.ID, legName(reason), ltp)
+	m.updateExit(b)
+}
+
+// triggered returns the leg of a bracket that a price reached, if any
+func triggered(b *models.Bracket, ltp float64) string {
+	long := b.Action == "BUY"
+	switch {
+	case b.StopLoss > 0 && ((long && ltp <= b.StopLoss) || (!long && ltp >= b.StopLoss)):
+		if b.TrailingStop != "" {
+			return models.ExitTrailingStop
+		}
+		return models.ExitStopLoss
+	case b.Target > 0 && ((long && ltp >= b.Target) || (!long && ltp <= b.Target)):
+		return models.ExitTarget
+	}
+	return ""
+}
+
+// updateExit places the exit order of a triggered bracket, and closes the
+// bracket once it filled. If the exit fails the bracket is watched again,
+// until maxExitAttempts exits failed.
+func (m *Manager) updateExit(b *models.Bracket) {
+	if b.ExitTradeID == nil {
+		if err := m.placeExit(b); err != nil {
+			log.Printf("BRACKET: Failed to place the exit of %d: %v", b.ID, err)
+			m.reopen(b, err.Error())
+		}
+		return
+	}
+
+	trade, err := m.db.GetTradeByID(*b.ExitTradeID)
+	if err != nil {
+		log.Printf("BRACKET: Failed to load exit trade %d of bracket %d: %v", *b.ExitTradeID, b.ID, err)
+		return
+	}
+	switch {
+	case trade == nil:
+		m.reopen(b, "the exit trade no longer exists")
+	case trade.Status == models.TradePending && trade.OrderID == "":
+		// The exit may have been placed without its order ID being recorded,
+		// so it is neither tracked nor placed again
+		if b.LastError == "" {
+			b.LastError = unrecordedExit
+			m.save(b)
+			m.notify(b, fmt.Sprintf("⚠️ **Bracket Exit Unknown** for %s: %s. Please verify it with the broker, then cancel the bracket.", describe(b), unrecordedExit))
+		}
+	case trade.Status == models.TradePending:
+	case trade.Status == models.TradeExecuted:
+		m.closeExit(b, trade)
+	default:
+		// The order ID is left out, so that the same failure is reported once
+		log.Printf("BRACKET: Exit order %s of %d was %s", trade.OrderID, b.ID, trade.Status)
+		m.reopen(b, "the exit order was "+trade.Status)
+	}
+}
+
+// unrecorded reports whether the exit of a bracket may have been placed
+// without its order ID being recorded. Its trade stays pending, so that a
+// panic still counts it as an exit on its way.
+func (m *Manager) unrecorded(b *models.Bracket) bool {
+	if b.ExitTradeID == nil {
+		return false
+	}
+	trade, err := m.db.GetTradeByID(*b.ExitTradeID)
+	return err == nil && trade != nil && trade.Status == models.TradePending && trade.OrderID == ""
+}
+
+// placeExit sends the market order that closes the bracketed quantity
+func (m *Manager) placeExit(b *models.Bracket) error {
+	br, err := m.brokers.ByName(b.UserID, b.Broker)
+	if err != nil {
+		return err
+	}
+	current, err := broker.Held(br, b.Symbol, b.Exchange, b.Product)
+	if err != nil {
+		return fmt.Errorf("failed to read the position: %w", err)
+	}
+
+	quantity := b.Quantity
+	if b.Action == "SELL" {
+		quantity = -quantity
+	}
+	if current == 0 || (current > 0) != (quantity > 0) {
+		// Closed outside the bracket; there is nothing left to protect
+		b.LastError = "the position was already closed"
+		m.close(b, models.BracketCancelled)
+		m.notify(b, fmt.Sprintf("ℹ️ **Bracket Closed** for %s: the position was already closed, no exit order was placed.", describe(b)))
+		return nil
+	}
+	// Never exit more than is held, nor flip the position
+	target := current - quantity
+	if (target > 0) != (current > 0) {
+		target = 0
+	}
+	action := "SELL"
+	if b.Action == "SELL" {
+		action = "BUY"
+	}
+
+	// Exits only reduce a position, so they are not held back by the risk
+	// checks, not even by the kill switch
+	orderReq := &openalgo.OpenAlgoSmartOrderRequest{
+		Strategy:     Strategy,
+		Symbol:       b.Symbol,
+		Exchange:     b.Exchange,
+		Action:       action,
+		Pricetype:    "MARKET",
+		Product:      b.Product,
+		Quantity:     abs(current - target),
+		PositionSize: target,
+	}
+	// The exit trade is saved and linked before the order is placed, so that
+	// an order placed without its ID being recorded is never placed twice.
+	// It is not linked to the auto-order, whose fill it would report again.
+	trade, err := m.db.CreateTrade(&models.Trade{
+		UserID:    b.UserID,
+		Symbol:    b.Symbol,
+		Exchange:  b.Exchange,
+		Product:   b.Product,
+		Broker:    b.Broker,
+		Action:    action,
+		Quantity:  orderReq.Quantity,
+		OrderType: "MARKET",
+		Status:    models.TradePending,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save the exit trade: %w", err)
+	}
+	b.ExitTradeID = &trade.ID
+	if err := m.db.UpdateBracket(b); err != nil {
+		m.failTrade(trade, err.Error())
+		return fmt.Errorf("failed to save the exit trade of the bracket: %w", err)
+	}
+
+	response, err := br.PlaceSmartOrder(orderReq)
+	if err == nil && (response == nil || response.Data.OrderID == "") {
+		err = fmt.Errorf("the broker returned no order ID")
+	}
+	if err != nil {
+		m.failTrade(trade, err.Error())
+		return err
+	}
+
+	if err := m.db.SetTradeOrderID(trade.ID, response.Data.OrderID); err != nil {
+		// The order is working but cannot be tracked; keep exiting so it is not placed twice
+		b.LastError = fmt.Sprintf("exit order %s placed but not recorded: %v", response.Data.OrderID, err)
+		m.save(b)
+		m.notify(b, fmt.Sprintf("⚠️ **%s Hit** for %s at %.2f. Exit order **%s** was placed but could not be recorded, so its fill is not tracked. Please verify it with the broker.",
+			legTitle(b.ExitReason), describe(b), b.TriggerPrice, response.Data.OrderID))
+		return nil
+	}
+	trade.OrderID = response.Data.OrderID
+
+	b.LastError = ""
+	m.save(b)
+	m.notify(b, fmt.Sprintf("🚨 **%s Hit** for %s at %.2f. Exit order **%s** (%s %d) placed.",
+		legTitle(b.ExitReason), describe(b), b.TriggerPrice, trade.OrderID, action, trade.Quantity))
+	return nil
+}
+
+// failTrade marks an exit trade whose order was not placed as failed
+func (m *Manager) failTrade(trade *models.Trade, reason string) {
+	now := time.Now()
+	trade.Status = models.TradeFailed
+	trade.StatusMessage = reason
+	trade.UpdatedAt = &now
+	if err := m.db.UpdateTradeOrder(trade); err != nil {
+		log.Printf("BRACKET: Failed to mark exit trade %d as failed: %v", trade.ID, err)
+	}
+}
+
+// closeExit closes a bracket whose exit filled, cancelling the other leg
+func (m *Manager) closeExit(b *models.Bracket, exit *models.Trade) {
+	b.ExitPrice = exit.AveragePrice
+	quantity := exit.FilledQuantity
+	if quantity <= 0 {
+		quantity = exit.Quantity
+	}
+	side := 1.0
+	if b.Action == "SELL" {
+		side = -1
+	}
+	if b.ExitPrice > 0 {
+		b.RealizedPnL = (b.ExitPrice - b.EntryPrice) * float64(quantity) * side
+	}
+	m.close(b, models.BracketClosed)
+	log.Printf("BRACKET: %d closed by its %s at %.2f, P&L %.2f", b.ID, legName(b.ExitReason), b.ExitPrice, b.RealizedPnL)
+
+	// The other leg is cancelled
+	other, otherLevel := models.ExitTarget, b.Target
+	if b.ExitReason == models.ExitTarget {
+		other, otherLevel = models.ExitStopLoss, b.StopLoss
+		if b.TrailingStop != "" {
+			other = models.ExitTrailingStop
+		}
+	}
+	content := fmt.Sprintf("✅ **Bracket Closed** for %s: the %s filled %d at %.2f (P&L %.2f).",
+		describe(b), legName(b.ExitReason), quantity, b.ExitPrice, b.RealizedPnL)
+	if otherLevel > 0 {
+		content += fmt.Sprintf(" The %s was cancelled.", legName(other))
+	}
+	m.notify(b, content)
+}
+
+// reopen watches a bracket again after its exit could not be placed or did not
+// fill. After maxExitAttempts failed exits the bracket is cancelled instead.
+func (m *Manager) reopen(b *models.Bracket, reason string) {
+	notify := b.LastError != reason
+	b.ExitTradeID = nil
+	b.ExitAttempts++
+	b.LastError = reason
+	if b.ExitAttempts >= maxExitAttempts {
+		m.close(b, models.BracketCancelled)
+		log.Printf("BRACKET: %d cancelled after %d failed exits: %s", b.ID, b.ExitAttempts, reason)
+		m.notify(b, fmt.Sprintf("🛑 **Bracket Stopped** for %s: the exit failed %d times (last: %s). The position is no longer protected; please close it with the broker.",
+			describe(b), b.ExitAttempts, reason))
+		return
+	}
+	b.Status = models.BracketActive
+	m.save(b)
+	if notify {
+		m.notify(b, fmt.Sprintf("⚠️ **Bracket Exit Failed** for %s: %s. The stop-loss and target are watched again and the exit will be retried.", describe(b), reason))
+	}
+}
+
+// close ends a bracket with a final status
+func (m *Manager) close(b *models.Bracket, status string) {
+	now := time.Now()
+	b.Status = status
+	b.ClosedAt = &now
+	m.save(b)
+}
+
+func (m *Manager) save(b *models.Bracket) {
+	if err := m.db.UpdateBracket(b); err != nil {
+		log.Printf("BRACKET: Failed to save %d: %v", b.ID, err)
+	}
+}
+
+// notify sends a chat message and a bracket event to the user
+func (m *Manager) notify(b *models.Bracket, content string) {
+	chatMsg := message{
+		Type:    "chat",
+		Content: content,
+		Data: map[string]interface{}{
+			"role":       "system",
+			"created_at": time.Now(),
+		},
+	}
+	if msgBytes, err := json.Marshal(chatMsg); err == nil {
+		m.notifier.SendToUser(b.UserID, msgBytes)
+	}
+
+	if msgBytes, err := json.Marshal(message{Type: "bracket", Data: b}); err == nil {
+		m.notifier.SendToUser(b.UserID, msgBytes)
+	} else {
+		log.Printf("BRACKET: Failed to marshal event for %d: %v", b.ID, err)
+	}
+}
+
+// describe names the position of a bracket
+func describe(b *models.Bracket) string {
+	return fmt.Sprintf("%s %d %s:%s (bracket %d)", b.Action, b.Quantity, b.Exchange, b.Symbol, b.ID)
+}
+
+// formatLevel renders a level with the specification or rule it came from
+func formatLevel(level float64, spec string) string {
+	if level <= 0 {
+		return "none"
+	}
+	if s, err := ParseSpec(spec); spec == "" || (err == nil && s.Kind == Price) {
+		return fmt.Sprintf("%.2f", level)
+	}
+	return fmt.Sprintf("%.2f (%s)", level, spec)
+}
+
+func legName(reason string) string {
+	switch reason {
+	case models.ExitTarget:
+		return "target"
+	case models.ExitTrailingStop:
+		return "trailing stop"
+	}
+	return "stop-loss"
+}
+
+func legTitle(reason string) string {
+	switch reason {
+	case models.ExitTarget:
+		return "Target"
+	case models.ExitTrailingStop:
+		return "Trailing Stop"
+	}
+	return "Stop-Loss"
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,143 @@
+package bracket
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kinds of level specifications
+const (
+	Price   = "price"   // An absolute price, as in "1450"
+	Percent = "percent" // A distance from the entry price in percent, as in "2%"
+	ATR     = "atr"     // A distance from the entry price in ATRs, as in "1.5atr"
+)
+
+// ATRLength is the length of the ATR that ATR based levels are measured in
+const ATRLength = 14
+
+// tickSize is the price step that levels are rounded to
+const tickSize = 0.05
+
+// Spec describes where a stop-loss or target lies. The zero Spec means no level.
+type Spec struct {
+	Kind  string
+	Value float64
+}
+
+// ParseSpec reads a level given as an absolute price ("1450"), a percentage
+// of the entry price ("2%") or a multiple of the ATR ("1.5atr"). An empty
+// text is the zero Spec.
+func ParseSpec(text string) (Spec, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return Spec{}, nil
+	}
+
+	spec := Spec{Kind: Price}
+	number := text
+	switch {
+	case strings.HasSuffix(text, "%"):
+		spec.Kind, number = Percent, strings.TrimSuffix(text, "%")
+	case strings.HasSuffix(text, "atr"):
+		spec.Kind, number = ATR, strings.TrimRight(strings.TrimSuffix(text, "atr"), "x* ")
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value <= 0 || math.IsInf(value, 0) {
+		return Spec{}, fmt.Errorf("invalid level %q (use a price such as 1450, a percentage such as 2%% or an ATR multiple such as 1.5atr)", text)
+	}
+	if spec.Kind == Percent && value >= 100 {
+		return Spec{}, fmt.Errorf("invalid level %q (a percentage must be below 100%%)", text)
+	}
+	spec.Value = value
+	return spec, nil
+}
+
+// IsZero reports whether the Spec sets no level
+func (s Spec) IsZero() bool {
+	return s.Kind == ""
+}
+
+// String returns the Spec in the form ParseSpec reads
+func (s Spec) String() string {
+	value := strconv.FormatFloat(s.Value, 'f', -1, 64)
+	switch s.Kind {
+	case Percent:
+		return value + "%"
+	case ATR:
+		return value + "atr"
+	case Price:
+		return value
+	}
+	return ""
+}
+
+// Level returns the price of a stop-loss (stop true) or target for a
+// position entered at entry, long or short. atr is only used by ATR based
+// levels. It returns 0 for the zero Spec.
+func (s Spec) Level(entry, atr float64, long, stop bool) float64 {
+	var distance float64
+	switch s.Kind {
+	case Price:
+		return roundTick(s.Value)
+	case Percent:
+		distance = entry * s.Value / 100
+	case ATR:
+		distance = atr * s.Value
+	default:
+		return 0
+	}
+	// A stop-loss lies below a long entry and above a short one, a target the other way
+	if long == stop {
+		distance = -distance
+	}
+	return roundTick(entry + distance)
+}
+
+// Trail returns the price of a trailing stop that follows extreme, the
+// highest price since a long entry or the lowest since a short one. A
+// trailing stop given as a price trails by that many points.
+func (s Spec) Trail(extreme, atr float64, long bool) float64 {
+	var distance float64
+	switch s.Kind {
+	case Price:
+		distance = s.Value
+	case Percent:
+		distance = extreme * s.Value / 100
+	case ATR:
+		distance = atr * s.Value
+	default:
+		return 0
+	}
+	if long {
+		distance = -distance
+	}
+	return roundTick(extreme + distance)
+}
+
+// Check reports an error if a stop-loss and target, as prices, are not on
+// the loss and profit side of a price for a long or short position
+func Check(price, stopLoss, target float64, long bool) error {
+	side := "below"
+	if !long {
+		side = "above"
+	}
+	if stopLoss > 0 && (stopLoss >= price) == long {
+		return fmt.Errorf("the stop-loss %.2f must be %s the price %.2f", stopLoss, side, price)
+	}
+	side = "above"
+	if !long {
+		side = "below"
+	}
+	if target > 0 && (target <= price) == long {
+		return fmt.Errorf("the target %.2f must be %s the price %.2f", target, side, price)
+	}
+	return nil
+}
+
+func roundTick(price float64) float64 {
+	// Dividing by the exact number of ticks per rupee avoids results such as 171.10000000000002
+	const ticks = 1 / tickSize
+	return math.Round(price*ticks) / ticks
+}
@@ -0,0 +1,144 @@
+package bracket
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"trading-app/internal/condition"
+	"trading-app/internal/models"
+)
+
+// trail moves the stop of a bracket with a trailing stop after the highest
+// price since a long entry, or the lowest since a short one. On ticks it
+// follows the last price; on intervals the highs or lows of the candles that
+// closed since the last move. The stop only ever moves in the position's favor.
+func (m *Manager) trail(b *models.Bracket, ltp float64) {
+	spec, err := ParseSpec(b.TrailingStop)
+	if err != nil || spec.IsZero() {
+		return
+	}
+	long := b.Action == "BUY"
+
+	extreme := b.ExtremePrice
+	if b.TrailOn == TrailOnInterval {
+		high, low, ok := m.closedRange(b)
+		if !ok {
+			return
+		}
+		extreme = further(extreme, high, low, long)
+	} else {
+		extreme = further(extreme, ltp, ltp, long)
+	}
+	if extreme == b.ExtremePrice {
+		return
+	}
+
+	previous := b.StopLoss
+	b.ExtremePrice = extreme
+	b.StopLoss = tighter(b.StopLoss, spec.Trail(extreme, b.ATR, long), long)
+	m.save(b)
+	if b.StopLoss == previous {
+		return
+	}
+
+	side := "high"
+	if !long {
+		side = "low"
+	}
+	log.Printf("BRACKET: %d trailing stop moved from %.2f to %.2f (%s %.2f)", b.ID, previous, b.StopLoss, side, extreme)
+	m.notify(b, fmt.Sprintf("📈 **Trailing Stop Moved** for %s: %.2f → %.2f, %s by %s from the %s of %.2f since entry.",
+		describe(b), previous, b.StopLoss, trailDirection(long), formatTrail(b.TrailingStop), side, extreme))
+}
+
+// closedRange returns the highest high and lowest low of the candles of a
+// bracket's interval that closed since it last trailed, and records them as
+// followed. ok is false when no candle closed since.
+func (m *Manager) closedRange(b *models.Bracket) (high, low float64, ok bool) {
+	duration, err := condition.IntervalDuration(b.Interval)
+	if err != nil {
+		return 0, 0, false
+	}
+	since := b.CreatedAt
+	if b.TrailedAt != nil {
+		since = *b.TrailedAt
+	}
+	now := time.Now()
+	if now.Sub(since) < duration {
+		return 0, 0, false
+	}
+
+	candles, err := m.candles.Candles(b.Symbol, b.Exchange, b.Interval, since)
+	if err != nil {
+		log.Printf("BRACKET: Failed to fetch %s candles of %s for %d: %v", b.Interval, b.Symbol, b.ID, err)
+		return 0, 0, false
+	}
+	var last time.Time
+	for _, c := range candles {
+		start := time.Unix(c.Timestamp, 0)
+		// Only candles that started after the last one followed and have closed
+		if !start.After(since) || start.Add(duration).After(now) {
+			continue
+		}
+		if !ok || c.High > high {
+			high = c.High
+		}
+		if !ok || c.Low < low {
+			low = c.Low
+		}
+		last, ok = start, true
+	}
+	if ok {
+		b.TrailedAt = &last
+	}
+	return high, low, ok
+}
+
+// further returns the more favorable extreme of a position: the higher of
+// extreme and high when long, the lower of extreme and low when short
+func further(extreme, high, low float64, long bool) float64 {
+	if long {
+		return max(extreme, high)
+	}
+	if extreme <= 0 {
+		return low
+	}
+	return min(extreme, low)
+}
+
+// tighter returns the stop closer to the price of a position: the higher one
+// when long, the lower one when short. A stop of 0 is no stop.
+func tighter(stop, other float64, long bool) float64 {
+	switch {
+	case stop <= 0:
+		return other
+	case other <= 0:
+		return stop
+	case long:
+		return max(stop, other)
+	}
+	return min(stop, other)
+}
+
+func trailDirection(long bool) string {
+	if long {
+		return "trailing below"
+	}
+	return "trailing above"
+}
+
+// formatTrail renders the distance of a trailing stop
+func formatTrail(spec string) string {
+	if s, err := ParseSpec(spec); err == nil && s.Kind == Price {
+		return spec + " points"
+	}
+	return spec
+}
+
+// trailCadence describes when the trailing stop of a bracket moves
+func trailCadence(b *models.Bracket) string {
+	if b.TrailOn == TrailOnInterval {
+		return b.Interval + " candle"
+	}
+	return "price check"
+}
@@ -0,0 +1,214 @@
+// Package broker abstracts where orders are placed, so that the engine and the
+// handlers trade the same way through OpenAlgo or on a paper account.
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"trading-app/internal/database"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+// Broker names that can be selected per user or per strategy
+const (
+	OpenAlgo = "openalgo"
+	Paper    = "paper"
+)
+
+// Broker is a trading account: market data, orders, positions, holdings and funds.
+// It is implemented by openalgo.OpenAlgoClient and PaperBroker.
+type Broker interface {
+	Name() string
+	Quote(symbol, exchange string) (*openalgo.OpenAlgoQuoteData, error)
+	History(symbol, exchange, interval, startDate, endDate string) ([]openalgo.OpenAlgoCandle, error)
+	PlaceSmartOrder(orderReq *openalgo.OpenAlgoSmartOrderRequest) (*openalgo.OpenAlgoSmartOrderResponse, error)
+	ModifyOrder(orderReq *openalgo.OpenAlgoModifyOrderRequest) (*openalgo.OpenAlgoOrderResponse, error)
+	CancelOrder(orderID, strategy string) (*openalgo.OpenAlgoOrderResponse, error)
+	OrderStatus(orderID, strategy string) (*openalgo.OpenAlgoOrderStatusData, error)
+	Positions() ([]openalgo.OpenAlgoPosition, error)
+	Holdings() (*openalgo.OpenAlgoHoldingsData, error)
+	Funds() (*openalgo.OpenAlgoFunds, error)
+}
+
+var _ Broker = (*openalgo.OpenAlgoClient)(nil)
+
+// ValidName reports whether a broker name can be selected
+func ValidName(name string) bool {
+	return name == OpenAlgo || name == Paper
+}
+
+// Selector returns the broker a user, or one of their strategies, trades with.
+// A strategy setting overrides the user's, which overrides the default.
+type Selector struct {
+	db            *database.DB
+	live          Broker
+	candles       *marketdata.Store
+	defaultBroker string
+	paperConfig   PaperConfig
+
+	mu    sync.Mutex
+	paper map[int]*PaperBroker
+}
+
+// NewSelector creates a broker selector. Paper accounts get their market data
+// from the live broker, falling back to the candle store.
+func NewSelector(db *database.DB, live Broker, candles *marketdata.Store, defaultBroker string, paperConfig PaperConfig) *Selector {
+	defaultBroker = strings.ToLower(defaultBroker)
+	if !ValidName(defaultBroker) {
+		defaultBroker = OpenAlgo
+	}
+	return &Selector{
+		db:            db,
+		live:          live,
+		candles:       candles,
+		defaultBroker: defaultBroker,
+		paperConfig:   paperConfig,
+		paper:         make(map[int]*PaperBroker),
+	}
+}
+
+// Default returns the name of the broker used when nothing was selected
+func (s *Selector) Default() string {
+	return s.defaultBroker
+}
+
+// Live returns the live OpenAlgo account, for market data that does not depend on the user
+func (s *Selector) Live() Broker {
+	return s.live
+}
+
+// Resolve returns the name of the broker for a user's strategy, or for the
+// user's other orders when strategyID is 0
+func (s *Selector) Resolve(userID, strategyID int) (string, error) {
+	if strategyID != 0 {
+		setting, err := s.db.GetBrokerSetting(userID, strategyID)
+		if err != nil {
+			return "", fmt.Errorf("failed to load broker setting: %w", err)
+		}
+		if setting != nil {
+			return setting.Broker, nil
+		}
+	}
+
+	setting, err := s.db.GetBrokerSetting(userID, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to load broker setting: %w", err)
+	}
+	if setting != nil {
+		return setting.Broker, nil
+	}
+	return s.defaultBroker, nil
+}
+
+// For returns the broker for a user's strategy, or for the user's other orders when strategyID is 0
+func (s *Selector) For(userID, strategyID int) (Broker, error) {
+	name, err := s.Resolve(userID, strategyID)
+	if err != nil {
+		return nil, err
+	}
+	return s.named(userID, name)
+}
+
+// Set selects the broker of a user's strategy, or of all their orders when strategyID is 0
+func (s *Selector) Set(userID, strategyID int, name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !ValidName(name) {
+		return fmt.Errorf("unknown broker '%s' (use %s or %s)", name, OpenAlgo, Paper)
+	}
+	return s.db.SetBrokerSetting(&models.BrokerSetting{UserID: userID, StrategyID: strategyID, Broker: name})
+}
+
+// PaperAccount returns the paper broker of a user
+func (s *Selector) PaperAccount(userID int) *PaperBroker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paper, ok := s.paper[userID]
+	if !ok {
+		paper = NewPaperBroker(userID, s.db, s.live, s.candles, s.paperConfig)
+		s.paper[userID] = paper
+	}
+	return paper
+}
+
+// ByName returns a user's account with the named broker, whatever they selected
+func (s *Selector) ByName(userID int, name string) (Broker, error) {
+	return s.named(userID, name)
+}
+
+func (s *Selector) named(userID int, name string) (Broker, error) {
+	switch name {
+	case OpenAlgo:
+		return s.live, nil
+	case Paper:
+		return s.PaperAccount(userID), nil
+	}
+	return nil, fmt.Errorf("unknown broker '%s'", name)
+}
+
+// Held returns the net quantity an account holds, including delivery
+// holdings for CNC positions
+func Held(b Broker, symbol, exchange, product string) (int, error) {
+	positions, err := b.Positions()
+	if err != nil {
+		return 0, err
+	}
+	held := 0
+	for _, pos := range positions {
+		if strings.EqualFold(pos.Symbol, symbol) && strings.EqualFold(pos.Exchange, exchange) && strings.EqualFold(pos.Product, product) {
+			held += int(pos.Quantity)
+		}
+	}
+	if product != "CNC" {
+		return held, nil
+	}
+
+	holdings, err := b.Holdings()
+	if err != nil {
+		return 0, err
+	}
+	for _, holding := range holdings.Holdings {
+		if strings.EqualFold(holding.Symbol, symbol) && strings.EqualFold(holding.Exchange, exchange) {
+			held += int(holding.Quantity)
+		}
+	}
+	return held, nil
+}
+
+// PositionKey identifies a position within one broker account
+type PositionKey struct {
+	Symbol   string
+	Exchange string
+	Product  string
+}
+
+// Book returns the net quantities an account holds, from its position book
+// and its delivery holdings
+func Book(b Broker) (map[PositionKey]int, error) {
+	positions, err := b.Positions()
+	if err != nil {
+		return nil, err
+	}
+	holdings, err := b.Holdings()
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[PositionKey]int)
+	for _, pos := range positions {
+		k := PositionKey{strings.ToUpper(pos.Symbol), strings.ToUpper(pos.Exchange), strings.ToUpper(pos.Product)}
+		held[k] += int(pos.Quantity)
+	}
+	for _, holding := range holdings.Holdings {
+		product := strings.ToUpper(holding.Product)
+		if product == "" {
+			product = "CNC"
+		}
+		k := PositionKey{strings.ToUpper(holding.Symbol), strings.ToUpper(holding.Exchange), product}
+		held[k] += int(holding.Quantity)
+	}
+	return held, nil
+}
@@ -0,0 +1,577 @@
+package broker
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/database"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+// Paper order statuses, named like OpenAlgo's
+const (
+	StatusOpen      = "open"
+	StatusComplete  = "complete"
+	StatusCancelled = "cancelled"
+	StatusRejected  = "rejected"
+)
+
+// Slippage moves paper fills against the order: buys fill higher and sells lower
+type Slippage struct {
+	Percent float64 // Of the quoted price
+	Fixed   float64 // Absolute amount added on top
+}
+
+// Apply returns the fill price of an order for the given action at a quoted price
+func (s Slippage) Apply(action string, price float64) float64 {
+	move := price*s.Percent/100 + s.Fixed
+	if action == "SELL" {
+		move = -move
+	}
+	return math.Round((price+move)*100) / 100
+}
+
+// PaperConfig configures new paper trading accounts
+type PaperConfig struct {
+	InitialCash float64
+	Slippage    Slippage
+}
+
+// PaperBroker simulates a trading account. Orders fill against the live quote,
+// or the last cached candle when no quote is available, and the account keeps
+// its own cash, positions and order book in the database.
+type PaperBroker struct {
+	userID  int
+	db      *database.DB
+	market  Broker
+	candles *marketdata.Store
+	config  PaperConfig
+
+	// Serializes ledger updates of the account
+	mu sync.Mutex
+}
+
+var _ Broker = (*PaperBroker)(nil)
+
+// NewPaperBroker creates the paper broker of a user
+func NewPaperBroker(userID int, db *database.DB, market Broker, candles *marketdata.Store, config PaperConfig) *PaperBroker {
+	return &PaperBroker{
+		userID:  userID,
+		db:      db,
+		market:  market,
+		candles: candles,
+		config:  config,
+	}
+}
+
+func (p *PaperBroker) Name() string {
+	return Paper
+}
+
+// Quote returns the live quote, falling back to the last cached candle
+func (p *PaperBroker) Quote(symbol, exchange string) (*openalgo.OpenAlgoQuoteData, error) {
+	quote, err := p.market.Quote(symbol, exchange)
+	if err == nil {
+		return quote, nil
+	}
+	if p.candles != nil {
+		if price, at, ok := p.candles.LastPrice(symbol, exchange); ok {
+			log.Printf("PAPER: No live quote for %s:%s (%v), using cached price %.2f from %s", exchange, symbol, err, price, at.Format(time.RFC3339))
+			return &openalgo.OpenAlgoQuoteData{LTP: price}, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *PaperBroker) History(symbol, exchange, interval, startDate, endDate string) ([]openalgo.OpenAlgoCandle, error) {
+	return p.market.History(symbol, exchange, interval, startDate, endDate)
+}
+
+// PlaceSmartOrder follows OpenAlgo's smart order rules: the order moves the
+// current position to position_size, and a position_size of 0 with no
+// position open places the order as given
+func (p *PaperBroker) PlaceSmartOrder(orderReq *openalgo.OpenAlgoSmartOrderRequest) (*openalgo.OpenAlgoSmartOrderResponse, error) {
+	symbol := strings.ToUpper(orderReq.Symbol)
+	exchange := strings.ToUpper(orderReq.Exchange)
+	action := strings.ToUpper(orderReq.Action)
+	product := strings.ToUpper(orderReq.Product)
+	if product == "" {
+		product = "MIS"
+	}
+	priceType := strings.ToUpper(orderReq.Pricetype)
+	if priceType == "" {
+		priceType = "MARKET"
+	}
+
+	if symbol == "" || exchange == "" {
+		return nil, fmt.Errorf("symbol and exchange are required")
+	}
+	if action != "BUY" && action != "SELL" {
+		return nil, fmt.Errorf("invalid action '%s'", orderReq.Action)
+	}
+	if priceType != "MARKET" && priceType != "LIMIT" {
+		return nil, fmt.Errorf("invalid pricetype '%s' for a smart order", orderReq.Pricetype)
+	}
+	if priceType == "LIMIT" && orderReq.Price <= 0 {
+		return nil, fmt.Errorf("a LIMIT order needs a price")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.openAccount(); err != nil {
+		return nil, err
+	}
+	position, err := p.position(symbol, exchange, product)
+	if err != nil {
+		return nil, err
+	}
+
+	current := position.Quantity
+	quantity := orderReq.Quantity
+	switch target := orderReq.PositionSize; {
+	case target == 0 && current == 0:
+		if quantity <= 0 {
+			return nil, fmt.Errorf("quantity must be positive")
+		}
+	case target == current:
+		return &openalgo.OpenAlgoSmartOrderResponse{Status: "success", Message: "Positions Already Matched. No Action needed."}, nil
+	default:
+		quantity = target - current
+		action = "BUY"
+		if quantity < 0 {
+			action, quantity = "SELL", -quantity
+		}
+	}
+
+	order := &models.PaperOrder{
+		ID:        fmt.Sprintf("PAPER-%d", time.Now().UnixNano()),
+		UserID:    p.userID,
+		Strategy:  orderReq.Strategy,
+		Symbol:    symbol,
+		Exchange:  exchange,
+		Action:    action,
+		Product:   product,
+		PriceType: priceType,
+		Quantity:  quantity,
+		Price:     orderReq.Price,
+		Status:    StatusOpen,
+	}
+	if err := p.db.CreatePaperOrder(order); err != nil {
+		return nil, fmt.Errorf("failed to save paper order: %w", err)
+	}
+	log.Printf("PAPER: User %d placed %s %s %d %s:%s (%s)", p.userID, order.ID, action, quantity, exchange, symbol, priceType)
+
+	p.match(order)
+	return &openalgo.OpenAlgoSmartOrderResponse{
+		Status: "success",
+		Data:   openalgo.OpenAlgoSmartOrderData{OrderID: order.ID},
+	}, nil
+}
+
+// ModifyOrder changes an open order and matches it again
+func (p *PaperBroker) ModifyOrder(orderReq *openalgo.OpenAlgoModifyOrderRequest) (*openalgo.OpenAlgoOrderResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order, err := p.openOrder(orderReq.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	priceType := strings.ToUpper(orderReq.Pricetype)
+	switch priceType {
+	case "":
+		priceType = order.PriceType
+	case "MARKET", "LIMIT", "SL", "SL-M":
+	default:
+		return nil, fmt.Errorf("invalid pricetype '%s'", orderReq.Pricetype)
+	}
+	if orderReq.Quantity > 0 {
+		order.Quantity = orderReq.Quantity
+	}
+	order.PriceType = priceType
+	order.Price = orderReq.Price
+	order.TriggerPrice = orderReq.TriggerPrice
+	if (priceType == "LIMIT" || priceType == "SL") && order.Price <= 0 {
+		return nil, fmt.Errorf("a %s order needs a price", priceType)
+	}
+	if (priceType == "SL" || priceType == "SL-M") && order.TriggerPrice <= 0 {
+		return nil, fmt.Errorf("a %s order needs a trigger price", priceType)
+	}
+
+	if err := p.db.UpdatePaperOrder(order); err != nil {
+		return nil, fmt.Errorf("failed to save paper order: %w", err)
+	}
+	p.match(order)
+	return &openalgo.OpenAlgoOrderResponse{Status: "success", OrderID: order.ID}, nil
+}
+
+// CancelOrder cancels an open order
+func (p *PaperBroker) CancelOrder(orderID, strategy string) (*openalgo.OpenAlgoOrderResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order, err := p.openOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Status = StatusCancelled
+	if err := p.db.UpdatePaperOrder(order); err != nil {
+		return nil, fmt.Errorf("failed to save paper order: %w", err)
+	}
+	return &openalgo.OpenAlgoOrderResponse{Status: "success", OrderID: order.ID}, nil
+}
+
+// OrderStatus reports an order, matching it first if it is still open
+func (p *PaperBroker) OrderStatus(orderID, strategy string) (*openalgo.OpenAlgoOrderStatusData, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order, err := p.db.GetPaperOrder(p.userID, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper order: %w", err)
+	}
+	if order == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if order.Status == StatusOpen {
+		p.match(order)
+	}
+
+	return &openalgo.OpenAlgoOrderStatusData{
+		Action:       order.Action,
+		AveragePrice: order.AveragePrice,
+		Exchange:     order.Exchange,
+		OrderStatus:  order.Status,
+		OrderID:      order.ID,
+		Price:        order.Price,
+		PriceType:    order.PriceType,
+		Product:      order.Product,
+		Quantity:     strconv.Itoa(order.Quantity),
+		Symbol:       order.Symbol,
+		Timestamp:    order.UpdatedAt.Format("02-Jan-2006 15:04:05"),
+		TriggerPrice: order.TriggerPrice,
+	}, nil
+}
+
+// Positions returns the position book, valued at the latest quotes
+func (p *PaperBroker) Positions() ([]openalgo.OpenAlgoPosition, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.matchOpenOrders()
+
+	positions, err := p.db.GetPaperPositions(p.userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper positions: %w", err)
+	}
+
+	book := make([]openalgo.OpenAlgoPosition, 0, len(positions))
+	for _, pos := range positions {
+		if settled(pos) {
+			continue
+		}
+		entry := openalgo.OpenAlgoPosition{
+			Symbol:       pos.Symbol,
+			Exchange:     pos.Exchange,
+			Product:      pos.Product,
+			Quantity:     openalgo.Number(pos.Quantity),
+			AveragePrice: openalgo.Number(pos.AveragePrice),
+			PnL:          openalgo.Number(pos.RealizedPnL),
+		}
+		if pos.Quantity != 0 {
+			if quote, err := p.Quote(pos.Symbol, pos.Exchange); err == nil {
+				entry.LTP = openalgo.Number(quote.LTP)
+				entry.PnL += openalgo.Number((quote.LTP - pos.AveragePrice) * float64(pos.Quantity))
+			}
+		}
+		book = append(book, entry)
+	}
+	return book, nil
+}
+
+// Holdings returns the delivery positions bought before today, which have
+// settled into the demat account like they would with a real broker
+func (p *PaperBroker) Holdings() (*openalgo.OpenAlgoHoldingsData, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.matchOpenOrders()
+
+	positions, err := p.db.GetPaperPositions(p.userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper positions: %w", err)
+	}
+
+	data := &openalgo.OpenAlgoHoldingsData{Holdings: []openalgo.OpenAlgoHolding{}}
+	stats := &data.Statistics
+	for _, pos := range positions {
+		if !settled(pos) {
+			continue
+		}
+		invested := float64(pos.Quantity) * pos.AveragePrice
+		holding := openalgo.OpenAlgoHolding{
+			Symbol:       pos.Symbol,
+			Exchange:     pos.Exchange,
+			Product:      pos.Product,
+			Quantity:     openalgo.Number(pos.Quantity),
+			AveragePrice: openalgo.Number(pos.AveragePrice),
+			LTP:          openalgo.Number(pos.AveragePrice),
+		}
+		if quote, err := p.Quote(pos.Symbol, pos.Exchange); err == nil {
+			holding.LTP = openalgo.Number(quote.LTP)
+		}
+		value := float64(holding.LTP) * float64(pos.Quantity)
+		holding.PnL = openalgo.Number(value - invested)
+		if invested > 0 {
+			holding.PnLPercent = openalgo.Number(math.Round((value-invested)/invested*10000) / 100)
+		}
+		data.Holdings = append(data.Holdings, holding)
+
+		stats.TotalHoldingValue += openalgo.Number(value)
+		stats.TotalInvValue += openalgo.Number(invested)
+		stats.TotalProfitAndLoss += holding.PnL
+	}
+	if stats.TotalInvValue > 0 {
+		stats.TotalPnLPercentage = openalgo.Number(math.Round(float64(stats.TotalProfitAndLoss/stats.TotalInvValue)*10000) / 100)
+	}
+	return data, nil
+}
+
+// Funds returns the cash of the account and its realized and unrealized P&L
+func (p *PaperBroker) Funds() (*openalgo.OpenAlgoFunds, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.openAccount(); err != nil {
+		return nil, err
+	}
+	p.matchOpenOrders()
+
+	account, err := p.db.GetPaperAccount(p.userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper account: %w", err)
+	}
+	positions, err := p.db.GetPaperPositions(p.userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper positions: %w", err)
+	}
+
+	funds := &openalgo.OpenAlgoFunds{AvailableCash: openalgo.Number(account.Cash)}
+	for _, pos := range positions {
+		funds.M2MRealized += openalgo.Number(pos.RealizedPnL)
+		if pos.Quantity == 0 {
+			continue
+		}
+		funds.UtilisedDebits += openalgo.Number(math.Abs(float64(pos.Quantity)) * pos.AveragePrice)
+		if quote, err := p.Quote(pos.Symbol, pos.Exchange); err == nil {
+			funds.M2MUnrealized += openalgo.Number((quote.LTP - pos.AveragePrice) * float64(pos.Quantity))
+		}
+	}
+	return funds, nil
+}
+
+// openAccount creates the paper account with its initial cash on first use
+func (p *PaperBroker) openAccount() error {
+	account, err := p.db.GetPaperAccount(p.userID)
+	if err != nil {
+		return fmt.Errorf("failed to load paper account: %w", err)
+	}
+	if account != nil {
+		return nil
+	}
+	log.Printf("PAPER: Opening paper account for user %d with %.2f", p.userID, p.config.InitialCash)
+	return p.db.CreatePaperAccount(&models.PaperAccount{
+		UserID:      p.userID,
+		Cash:        p.config.InitialCash,
+		InitialCash: p.config.InitialCash,
+	})
+}
+
+func (p *PaperBroker) position(symbol, exchange, product string) (*models.PaperPosition, error) {
+	position, err := p.db.GetPaperPosition(p.userID, symbol, exchange, product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper position: %w", err)
+	}
+	if position == nil {
+		position = &models.PaperPosition{UserID: p.userID, Symbol: symbol, Exchange: exchange, Product: product}
+	}
+	return position, nil
+}
+
+func (p *PaperBroker) openOrder(orderID string) (*models.PaperOrder, error) {
+	order, err := p.db.GetPaperOrder(p.userID, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper order: %w", err)
+	}
+	if order == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if order.Status != StatusOpen {
+		return nil, fmt.Errorf("order %s is %s", orderID, order.Status)
+	}
+	return order, nil
+}
+
+// matchOpenOrders tries to fill every open order of the account
+func (p *PaperBroker) matchOpenOrders() {
+	orders, err := p.db.GetOpenPaperOrders(p.userID)
+	if err != nil {
+		log.Printf("PAPER: Failed to load open orders of user %d: %v", p.userID, err)
+		return
+	}
+	for _, order := range orders {
+		p.match(order)
+	}
+}
+
+// match fills an open order if the market allows it. Market orders that
+// cannot be priced, and fills the account cannot afford, are rejected.
+func (p *PaperBroker) match(order *models.PaperOrder) {
+	quote, err := p.Quote(order.Symbol, order.Exchange)
+	if err != nil {
+		if order.PriceType == "MARKET" {
+			p.reject(order, fmt.Sprintf("no price available: %v", err))
+		}
+		return
+	}
+
+	price, ok := fillPrice(order, quote.LTP, p.config.Slippage)
+	if !ok {
+		return
+	}
+
+	position, err := p.position(order.Symbol, order.Exchange, order.Product)
+	if err != nil {
+		log.Printf("PAPER: %v", err)
+		return
+	}
+	if order.Action == "SELL" && order.Product == "CNC" && order.Quantity > position.Quantity {
+		p.reject(order, fmt.Sprintf("cannot sell %d %s for delivery with %d held", order.Quantity, order.Symbol, position.Quantity))
+		return
+	}
+
+	cashChange := applyFill(position, order.Action, order.Quantity, price)
+	account, err := p.db.GetPaperAccount(p.userID)
+	if err != nil || account == nil {
+		log.Printf("PAPER: Failed to load paper account of user %d: %v", p.userID, err)
+		return
+	}
+	if cashChange < 0 && account.Cash+cashChange < 0 {
+		p.reject(order, fmt.Sprintf("insufficient funds: need %.2f, available %.2f", -cashChange, account.Cash))
+		return
+	}
+
+	order.Status = StatusComplete
+	order.FilledQuantity = order.Quantity
+	order.AveragePrice = price
+	if err := p.db.RecordPaperFill(order, position, cashChange); err != nil {
+		log.Printf("PAPER: Failed to record fill of %s: %v", order.ID, err)
+		order.Status = StatusOpen
+		order.FilledQuantity = 0
+		order.AveragePrice = 0
+		return
+	}
+	log.Printf("PAPER: Filled %s %s %d %s at %.2f (quote %.2f)", order.ID, order.Action, order.Quantity, order.Symbol, price, quote.LTP)
+}
+
+func (p *PaperBroker) reject(order *models.PaperOrder, reason string) {
+	log.Printf("PAPER: Rejected %s: %s", order.ID, reason)
+	order.Status = StatusRejected
+	order.Message = reason
+	if err := p.db.UpdatePaperOrder(order); err != nil {
+		log.Printf("PAPER: Failed to save rejection of %s: %v", order.ID, err)
+	}
+}
+
+// fillPrice returns the price an order fills at given the last traded price,
+// or false if the order cannot fill yet
+func fillPrice(order *models.PaperOrder, ltp float64, slippage Slippage) (float64, bool) {
+	buy := order.Action == "BUY"
+	switch order.PriceType {
+	case "MARKET":
+		return slippage.Apply(order.Action, ltp), true
+	case "SL-M":
+		if !triggered(buy, ltp, order.TriggerPrice) {
+			return 0, false
+		}
+		return slippage.Apply(order.Action, ltp), true
+	case "SL":
+		if !triggered(buy, ltp, order.TriggerPrice) {
+			return 0, false
+		}
+	}
+
+	// Limit prices fill at the market price or better, never worse than the limit
+	price := slippage.Apply(order.Action, ltp)
+	if buy {
+		if ltp > order.Price {
+			return 0, false
+		}
+		return math.Min(price, order.Price), true
+	}
+	if ltp < order.Price {
+		return 0, false
+	}
+	return math.Max(price, order.Price), true
+}
+
+// settled reports whether a position is a delivery holding: a long CNC
+// position last traded before today
+func settled(pos *models.PaperPosition) bool {
+	return pos.Product == "CNC" && pos.Quantity > 0 && pos.UpdatedAt.Before(startOfToday())
+}
+
+func startOfToday() time.Time {
+	year, month, day := time.Now().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// triggered reports whether a stop order's trigger price has been reached
+func triggered(buy bool, ltp, trigger float64) bool {
+	if buy {
+		return ltp >= trigger
+	}
+	return ltp <= trigger
+}
+
+// applyFill updates a position with a fill and returns the change in cash.
+// Adding to a position averages its price; reducing it realizes P&L.
+func applyFill(position *models.PaperPosition, action string, quantity int, price float64) float64 {
+	signed := quantity
+	if action == "SELL" {
+		signed = -quantity
+	}
+	current := position.Quantity
+	next := current + signed
+
+	switch {
+	case current == 0 || (current > 0) == (signed > 0):
+		position.AveragePrice = (position.AveragePrice*math.Abs(float64(current)) + price*float64(quantity)) / math.Abs(float64(next))
+	case abs(signed) <= abs(current):
+		position.RealizedPnL += (price - position.AveragePrice) * float64(-signed)
+		if next == 0 {
+			position.AveragePrice = 0
+		}
+	default:
+		// The fill closes the position and opens one the other way
+		position.RealizedPnL += (price - position.AveragePrice) * float64(current)
+		position.AveragePrice = price
+	}
+	position.Quantity = next
+
+	return -float64(signed) * price
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
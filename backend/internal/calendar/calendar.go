@@ -0,0 +1,258 @@
+// Package calendar knows when the exchanges trade: their regular session
+// hours, their holidays and their special sessions, such as Muhurat trading.
+// The calendar is read from a JSON file; a copy is bundled with the binary.
+package calendar
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed exchanges.json
+var bundled []byte
+
+// DefaultExchange is the exchange whose sessions apply to exchanges the calendar does not list
+const DefaultExchange = "NSE"
+
+// searchDays is how far ahead and back sessions are looked for
+const searchDays = 30
+
+// IST is the time zone of the Indian exchanges
+var IST = time.FixedZone("IST", 5*60*60+30*60)
+
+// Session is a trading session of an exchange
+type Session struct {
+	Open  time.Time `json:"open"`
+	Close time.Time `json:"close"`
+	Name  string    `json:"name,omitempty"` // Set for special sessions
+}
+
+// Contains reports whether the session is open at t
+func (s Session) Contains(t time.Time) bool {
+	return !t.Before(s.Open) && t.Before(s.Close)
+}
+
+// Minutes returns the length of the session
+func (s Session) Minutes() int {
+	return int(s.Close.Sub(s.Open).Minutes())
+}
+
+// Calendar holds the sessions of every exchange. It is safe for concurrent use.
+type Calendar struct {
+	exchanges map[string]*exchange
+	aliases   map[string]string
+}
+
+type exchange struct {
+	open, close time.Duration // Since midnight IST
+	holidays    map[string]string
+	special     map[string]special
+}
+
+type special struct {
+	open, close time.Duration
+	name        string
+}
+
+// file is the JSON layout of a calendar file
+type file struct {
+	Exchanges map[string]struct {
+		Open     string `json:"open"` // "09:15", IST
+		Close    string `json:"close"`
+		Holidays []struct {
+			Date string `json:"date"` // "2026-01-26"
+			Name string `json:"name"`
+		} `json:"holidays"`
+		// Special sessions replace the regular session of their date, also on weekends and holidays
+		SpecialSessions []struct {
+			Date  string `json:"date"`
+			Open  string `json:"open"`
+			Close string `json:"close"`
+			Name  string `json:"name"`
+		} `json:"special_sessions"`
+	} `json:"exchanges"`
+	Aliases map[string]string `json:"aliases"` // Exchanges that trade in the sessions of another, as "BFO": "NFO"
+}
+
+// Load reads a calendar file, or the bundled calendar when path is empty
+func Load(path string) (*Calendar, error) {
+	data := bundled
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read calendar: %w", err)
+		}
+	}
+	return parse(data)
+}
+
+// Bundled returns the calendar bundled with the binary
+func Bundled() *Calendar {
+	c, err := parse(bundled)
+	if err != nil {
+		panic("calendar: invalid bundled calendar: " + err.Error())
+	}
+	return c
+}
+
+func parse(data []byte) (*Calendar, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid calendar: %w", err)
+	}
+
+	c := &Calendar{exchanges: make(map[string]*exchange), aliases: make(map[string]string)}
+	for name, def := range f.Exchanges {
+		name = strings.ToUpper(name)
+		open, close, err := hours(def.Open, def.Close)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar of %s: %w", name, err)
+		}
+		ex := &exchange{open: open, close: close, holidays: make(map[string]string), special: make(map[string]special)}
+		for _, holiday := range def.Holidays {
+			if _, err := time.Parse(time.DateOnly, holiday.Date); err != nil {
+				return nil, fmt.Errorf("invalid holiday of %s: %q", name, holiday.Date)
+			}
+			ex.holidays[holiday.Date] = holiday.Name
+		}
+		for _, session := range def.SpecialSessions {
+			if _, err := time.Parse(time.DateOnly, session.Date); err != nil {
+				return nil, fmt.Errorf("invalid special session of %s: %q", name, session.Date)
+			}
+			open, close, err := hours(session.Open, session.Close)
+			if err != nil {
+				return nil, fmt.Errorf("invalid special session of %s on %s: %w", name, session.Date, err)
+			}
+			ex.special[session.Date] = special{open: open, close: close, name: session.Name}
+		}
+		c.exchanges[name] = ex
+	}
+	if c.exchanges[DefaultExchange] == nil {
+		return nil, fmt.Errorf("invalid calendar: %s is missing", DefaultExchange)
+	}
+	for alias, target := range f.Aliases {
+		target = strings.ToUpper(target)
+		if c.exchanges[target] == nil {
+			return nil, fmt.Errorf("invalid calendar: alias %s of unknown exchange %s", alias, target)
+		}
+		c.aliases[strings.ToUpper(alias)] = target
+	}
+	return c, nil
+}
+
+// hours parses the opening and closing time of a session
+func hours(open, close string) (time.Duration, time.Duration, error) {
+	o, err := clock(open)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, err := clock(close)
+	if err != nil {
+		return 0, 0, err
+	}
+	if c <= o {
+		return 0, 0, fmt.Errorf("session closes at %s, before it opens at %s", close, open)
+	}
+	return o, c, nil
+}
+
+// clock parses a time of day such as "09:15" into the time since midnight
+func clock(text string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use HH:MM)", text)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// lookup returns the sessions of an exchange, of the exchange it is an alias
+// of, or of the default exchange
+func (c *Calendar) lookup(name string) *exchange {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if target, ok := c.aliases[name]; ok {
+		name = target
+	}
+	if ex, ok := c.exchanges[name]; ok {
+		return ex
+	}
+	return c.exchanges[DefaultExchange]
+}
+
+// Session returns the session of an exchange on the day of t, in IST. ok is
+// false on weekends and holidays without a special session.
+func (c *Calendar) Session(exchangeName string, t time.Time) (session Session, ok bool) {
+	ex := c.lookup(exchangeName)
+	t = t.In(IST)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, IST)
+	date := midnight.Format(time.DateOnly)
+
+	if s, ok := ex.special[date]; ok {
+		return Session{Open: midnight.Add(s.open), Close: midnight.Add(s.close), Name: s.name}, true
+	}
+	if _, holiday := ex.holidays[date]; holiday || t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return Session{}, false
+	}
+	return Session{Open: midnight.Add(ex.open), Close: midnight.Add(ex.close)}, true
+}
+
+// Holiday returns the name of the holiday of an exchange on the day of t, if it is one
+func (c *Calendar) Holiday(exchangeName string, t time.Time) (string, bool) {
+	name, ok := c.lookup(exchangeName).holidays[t.In(IST).Format(time.DateOnly)]
+	return name, ok
+}
+
+// IsOpen reports whether an exchange is in session at t
+func (c *Calendar) IsOpen(exchangeName string, t time.Time) bool {
+	session, ok := c.Session(exchangeName, t)
+	return ok && session.Contains(t)
+}
+
+// Next returns the session of an exchange that is open at t, or else the
+// next one to open. ok is false if none opens within 30 days.
+func (c *Calendar) Next(exchangeName string, t time.Time) (session Session, ok bool) {
+	for day := 0; day <= searchDays; day++ {
+		if session, ok := c.Session(exchangeName, t.AddDate(0, 0, day)); ok && t.Before(session.Close) {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+// Previous returns the last session of an exchange that closed at or before t
+func (c *Calendar) Previous(exchangeName string, t time.Time) (session Session, ok bool) {
+	for day := 0; day <= searchDays; day++ {
+		if session, ok := c.Session(exchangeName, t.AddDate(0, 0, -day)); ok && !t.Before(session.Close) {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+// SessionMinutes returns the length of the regular session of an exchange
+func (c *Calendar) SessionMinutes(exchangeName string) int {
+	ex := c.lookup(exchangeName)
+	return int((ex.close - ex.open).Minutes())
+}
+
+// DaysBack returns midnight IST of the trading day of an exchange that lies
+// the given number of trading days back, counting the day of t if the
+// exchange trades that day
+func (c *Calendar) DaysBack(exchangeName string, days int, t time.Time) time.Time {
+	t = t.In(IST)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, IST)
+	for counted := 0; ; day = day.AddDate(0, 0, -1) {
+		if _, ok := c.Session(exchangeName, day); ok {
+			if counted++; counted >= days {
+				return day
+			}
+		}
+		// Calendars are never empty for long, but a bad file must not hang the caller
+		if t.Sub(day) > time.Duration(days+searchDays)*7*24*time.Hour {
+			return day
+		}
+	}
+}
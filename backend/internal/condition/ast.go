@@ -0,0 +1,128 @@
+package condition
+
+import (
+	"strings"
+)
+
+// Node is an element of a parsed condition
+type Node interface {
+	// Pos returns the byte offset of the node in the source
+	Pos() int
+	// String returns the canonical source form of the node
+	String() string
+}
+
+// NumberLit is a numeric literal such as 30 or 1.5
+type NumberLit struct {
+	At    int
+	Value float64
+	Text  string
+}
+
+// BoolLit is the literal true or false
+type BoolLit struct {
+	At    int
+	Value bool
+}
+
+// Ident is a named value such as close, or a legacy indicator shorthand such as RSI14
+type Ident struct {
+	At   int
+	Name string
+}
+
+// Call is a function call such as ema(close, 20)
+type Call struct {
+	At   int
+	Name string // Lower case
+	Args []Node
+}
+
+// Index refers to a past value of a series: close[1] is the previous close
+type Index struct {
+	At     int // Position of the opening bracket
+	X      Node
+	Offset Node
+}
+
+// Member selects one output of a multi-output indicator, as in bb(close, 20, 2).upper
+type Member struct {
+	At   int // Position of the dot
+	X    Node
+	Name string // Lower case
+}
+
+// Timeframe evaluates an expression on the bars of another interval, as in close@1h
+type Timeframe struct {
+	At       int // Position of the @
+	X        Node
+	Interval string
+}
+
+// Unary is a prefix operation: -x or not x
+type Unary struct {
+	At int
+	Op tokenKind
+	X  Node
+}
+
+// Binary is an infix operation such as x > y or x and y
+type Binary struct {
+	At int // Position of the operator
+	Op tokenKind
+	X  Node
+	Y  Node
+}
+
+func (n *NumberLit) Pos() int { return n.At }
+func (n *BoolLit) Pos() int   { return n.At }
+func (n *Ident) Pos() int     { return n.At }
+func (n *Call) Pos() int      { return n.At }
+func (n *Index) Pos() int     { return n.X.Pos() }
+func (n *Member) Pos() int    { return n.X.Pos() }
+func (n *Timeframe) Pos() int { return n.X.Pos() }
+func (n *Unary) Pos() int     { return n.At }
+func (n *Binary) Pos() int    { return n.X.Pos() }
+
+func (n *NumberLit) String() string { return n.Text }
+
+func (n *BoolLit) String() string {
+	if n.Value {
+		return "true"
+	}
+	return "false"
+}
+
+func (n *Ident) String() string { return n.Name }
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + "(" + strings.Join(args, ",") + ")"
+}
+
+func (n *Index) String() string {
+	return n.X.String() + "[" + n.Offset.String() + "]"
+}
+
+func (n *Member) String() string {
+	return n.X.String() + "." + n.Name
+}
+
+func (n *Timeframe) String() string {
+	return n.X.String() + "@" + n.Interval
+}
+
+func (n *Unary) String() string {
+	if n.Op == tokNot {
+		return "not " + n.X.String()
+	}
+	return "-" + n.X.String()
+}
+
+func (n *Binary) String() string {
+	op := strings.Trim(n.Op.String(), "'")
+	return "(" + n.X.String() + " " + op + " " + n.Y.String() + ")"
+}
@@ -0,0 +1,575 @@
+package condition
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of an expression
+type Type int
+
+const (
+	TypeNumber Type = iota
+	TypeBool
+)
+
+func (t Type) String() string {
+	if t == TypeBool {
+		return "true/false"
+	}
+	return "number"
+}
+
+// expr is a type-checked expression, evaluated over every bar at once
+type expr struct {
+	typ      Type
+	lookback int // Bars before the first valid value
+	warmup   int // Further bars until smoothed values no longer depend on their seed
+	num      func(c *evalContext) []float64
+	cond     func(c *evalContext) []bool
+}
+
+// watch is a named value reported alongside the result of an evaluation
+type watch struct {
+	name     string
+	interval string // Timeframe the lookback is counted in; empty for the condition's own
+	lookback int
+	num      func(c *evalContext) []float64
+}
+
+// maxBars is the most bars a condition can look back, the candle window
+// kept per series by the market data store
+const maxBars = 5000
+
+// legacyIndicator matches the shorthand indicator form such as RSI14 or EMA20
+var legacyIndicator = regexp.MustCompile(`^(?i)(rsi|ema|sma|roc|lrs)(\d+)$`)
+
+type compiler struct {
+	src       string
+	watches   []watch
+	watched   map[string]bool
+	interval  string // Timeframe being compiled; empty for the condition's own
+	intervals []string
+	history   map[string]int // Bars needed of each timeframe referenced with @
+	lookbacks map[string]int // Bars before the first value of each timeframe referenced with @
+}
+
+func (c *compiler) errorf(pos int, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...), Source: c.src}
+}
+
+func (c *compiler) watch(name string, e *expr) {
+	num := e.num
+	if interval := c.interval; interval != "" {
+		// Report values from another timeframe as seen from the condition's own bars
+		name += "@" + interval
+		num = func(ctx *evalContext) []float64 {
+			sub := ctx.timeframe(interval)
+			return ctx.align(sub, e.num(sub))
+		}
+	}
+	if c.watched[name] {
+		return
+	}
+	c.watched[name] = true
+	c.watches = append(c.watches, watch{name: name, interval: c.interval, lookback: e.lookback, num: num})
+}
+
+func (c *compiler) compile(node Node) (*expr, error) {
+	switch n := node.(type) {
+	case *NumberLit:
+		value := n.Value
+		return &expr{typ: TypeNumber, num: func(ctx *evalContext) []float64 {
+			return ctx.constant(value)
+		}}, nil
+	case *BoolLit:
+		value := n.Value
+		return &expr{typ: TypeBool, cond: func(ctx *evalContext) []bool {
+			out := make([]bool, ctx.n)
+			for i := range out {
+				out[i] = value
+			}
+			return out
+		}}, nil
+	case *Ident:
+		return c.compileIdent(n)
+	case *Call:
+		e, err := c.compileCall(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if e.typ == TypeNumber {
+			c.watch(n.String(), e)
+		}
+		return e, nil
+	case *Index:
+		e, err := c.compileIndex(n)
+		if err != nil {
+			return nil, err
+		}
+		if e.typ == TypeNumber {
+			c.watch(n.String(), e)
+		}
+		return e, nil
+	case *Member:
+		call, ok := n.X.(*Call)
+		if !ok {
+			return nil, c.errorf(n.At, "only indicator results have fields, e.g. bb(close, 20, 2).upper")
+		}
+		e, err := c.compileCall(call, n)
+		if err != nil {
+			return nil, err
+		}
+		c.watch(n.String(), e)
+		return e, nil
+	case *Timeframe:
+		return c.compileTimeframe(n)
+	case *Unary:
+		return c.compileUnary(n)
+	case *Binary:
+		return c.compileBinary(n)
+	}
+	return nil, c.errorf(node.Pos(), "unsupported expression")
+}
+
+func (c *compiler) compileIdent(n *Ident) (*expr, error) {
+	name := strings.ToLower(n.Name)
+
+	if field, ok := sources[name]; ok {
+		e := &expr{typ: TypeNumber, num: func(ctx *evalContext) []float64 { return ctx.source(name, field) }}
+		c.watch(n.Name, e)
+		return e, nil
+	}
+
+	// Legacy shorthand: RSI14 is rsi(close, 14) and MACD is macd(close)
+	if m := legacyIndicator.FindStringSubmatch(n.Name); m != nil {
+		call := &Call{At: n.At, Name: strings.ToLower(m[1]), Args: []Node{
+			&Ident{At: n.At, Name: "close"},
+			&NumberLit{At: n.At + len(m[1]), Value: mustAtof(m[2]), Text: m[2]},
+		}}
+		e, err := c.compileCall(call, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.watch(n.Name, e)
+		return e, nil
+	}
+	if name == "macd" {
+		e, err := c.compileCall(&Call{At: n.At, Name: "macd", Args: []Node{&Ident{At: n.At, Name: "close"}}}, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.watch(n.Name, e)
+		return e, nil
+	}
+
+	// Legacy shorthand without its period, such as RSI
+	if legacyIndicator.MatchString(n.Name + "14") {
+		return nil, c.errorf(n.At, "indicator '%s' needs a period, e.g. %s14 or %s(close, 14)", n.Name, strings.ToUpper(name), name)
+	}
+	if fn, ok := functions[name]; ok {
+		return nil, c.errorf(n.At, "'%s' is an indicator, use %s", n.Name, signature(name, fn))
+	}
+	if sf, ok := seriesFunctions[name]; ok {
+		return nil, c.errorf(n.At, "'%s' is a function, use %s", n.Name, sf.usage)
+	}
+	return nil, c.errorf(n.At, "unknown identifier '%s'", n.Name)
+}
+
+// compileCall compiles a function call. For indicators with several outputs,
+// field selects the output and defaults to the first one.
+func (c *compiler) compileCall(n *Call, field *Member) (*expr, error) {
+	if sf, ok := seriesFunctions[n.Name]; ok {
+		if field != nil {
+			return nil, c.errorf(field.At, "%s has no fields", n.Name)
+		}
+		return c.compileSeriesCall(n, sf)
+	}
+
+	fn, ok := functions[n.Name]
+	if !ok {
+		return nil, c.errorf(n.At, "unknown function '%s'", n.Name)
+	}
+
+	output := 0
+	if field != nil {
+		if len(fn.outputs) == 0 {
+			return nil, c.errorf(field.At, "%s has no fields", n.Name)
+		}
+		if output = fn.output(field.Name); output < 0 {
+			return nil, c.errorf(field.At, "%s has no field '%s' (use %s)", n.Name, field.Name, strings.Join(fn.outputs, ", "))
+		}
+	}
+
+	args := n.Args
+	if len(args) == 0 && fn.defaultSource != "" {
+		args = []Node{&Ident{At: n.At, Name: fn.defaultSource}}
+	}
+
+	required := 0
+	if fn.source {
+		required++
+	}
+	for _, p := range fn.params {
+		if !p.optional {
+			required++
+		}
+	}
+	maxArgs := len(fn.params)
+	if fn.source {
+		maxArgs++
+	}
+	if len(args) < required || len(args) > maxArgs {
+		return nil, c.errorf(n.At, "%s expects %s", n.Name, signature(n.Name, fn))
+	}
+
+	inputs := []func(ctx *evalContext) []float64{}
+	lookback, warmup := 0, 0
+	if fn.source {
+		src, err := c.compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if src.typ != TypeNumber {
+			return nil, c.errorf(args[0].Pos(), "source of %s must be a number series, not %s", n.Name, src.typ)
+		}
+		inputs = append(inputs, src.num)
+		lookback, warmup = src.lookback, src.warmup
+		args = args[1:]
+	}
+	for _, name := range fn.inputs {
+		name, input := name, inputField(name)
+		inputs = append(inputs, func(ctx *evalContext) []float64 { return ctx.source(name, input) })
+	}
+
+	params := make([]float64, len(fn.params))
+	for i, p := range fn.params {
+		if i >= len(args) {
+			params[i] = p.def
+			continue
+		}
+		lit, ok := args[i].(*NumberLit)
+		switch {
+		case p.fraction && !ok:
+			return nil, c.errorf(args[i].Pos(), "%s of %s must be a constant number", p.name, n.Name)
+		case p.fraction && lit.Value <= p.min:
+			return nil, c.errorf(args[i].Pos(), "%s of %s must be greater than %g", p.name, n.Name, p.min)
+		case !p.fraction && (!ok || lit.Value != math.Trunc(lit.Value)):
+			return nil, c.errorf(args[i].Pos(), "%s of %s must be a whole number", p.name, n.Name)
+		case !p.fraction && lit.Value < p.min:
+			return nil, c.errorf(args[i].Pos(), "%s of %s must be at least %g", p.name, n.Name, p.min)
+		case p.max > 0 && lit.Value > p.max:
+			return nil, c.errorf(args[i].Pos(), "%s of %s must be at most %g", p.name, n.Name, p.max)
+		}
+		params[i] = lit.Value
+	}
+
+	key := n.String()
+	return &expr{
+		typ:      TypeNumber,
+		lookback: lookback + fn.lookback(params),
+		warmup:   warmup + fn.warmupBars(params),
+		num: func(ctx *evalContext) []float64 {
+			return ctx.cachedOutputs(key, func() [][]float64 {
+				in := make([][]float64, len(inputs))
+				for i, input := range inputs {
+					in[i] = input(ctx)
+				}
+				return fn.apply(in, params)
+			})[output]
+		},
+	}, nil
+}
+
+func (c *compiler) compileSeriesCall(n *Call, sf *seriesFunction) (*expr, error) {
+	want := sf.series
+	if sf.length {
+		want++
+	}
+	if len(n.Args) != want {
+		return nil, c.errorf(n.At, "%s expects %s", n.Name, sf.usage)
+	}
+
+	args := make([]func(ctx *evalContext) []float64, sf.series)
+	lookback, warmup := 0, 0
+	for i := 0; i < sf.series; i++ {
+		arg, err := c.compile(n.Args[i])
+		if err != nil {
+			return nil, err
+		}
+		if arg.typ != TypeNumber {
+			return nil, c.errorf(n.Args[i].Pos(), "argument %d of %s must be a number series, not %s", i+1, n.Name, arg.typ)
+		}
+		args[i] = arg.num
+		lookback = max(lookback, arg.lookback)
+		warmup = max(warmup, arg.warmup)
+	}
+
+	length := 1
+	if sf.length {
+		arg := n.Args[sf.series]
+		lit, ok := arg.(*NumberLit)
+		if !ok || lit.Value != math.Trunc(lit.Value) || lit.Value < 1 {
+			return nil, c.errorf(arg.Pos(), "length of %s must be a whole number of at least 1", n.Name)
+		}
+		if lit.Value > maxBars {
+			return nil, c.errorf(arg.Pos(), "length of %s must be at most %d", n.Name, maxBars)
+		}
+		length = int(lit.Value)
+	}
+
+	return &expr{
+		typ:      TypeBool,
+		lookback: lookback + length,
+		warmup:   warmup,
+		cond: func(ctx *evalContext) []bool {
+			values := make([][]float64, len(args))
+			for i, arg := range args {
+				values[i] = arg(ctx)
+			}
+			return sf.evaluate(values, length)
+		},
+	}, nil
+}
+
+func (c *compiler) compileIndex(n *Index) (*expr, error) {
+	x, err := c.compile(n.X)
+	if err != nil {
+		return nil, err
+	}
+
+	lit, ok := n.Offset.(*NumberLit)
+	if !ok || lit.Value != math.Trunc(lit.Value) || lit.Value < 0 {
+		return nil, c.errorf(n.Offset.Pos(), "history index must be a whole number of bars back, e.g. close[1]")
+	}
+	if lit.Value > maxBars {
+		return nil, c.errorf(n.Offset.Pos(), "history index must be at most %d bars back", maxBars)
+	}
+	offset := int(lit.Value)
+
+	if x.typ == TypeBool {
+		return &expr{typ: TypeBool, lookback: x.lookback + offset, warmup: x.warmup, cond: func(ctx *evalContext) []bool {
+			return shiftBool(x.cond(ctx), offset)
+		}}, nil
+	}
+	return &expr{typ: TypeNumber, lookback: x.lookback + offset, warmup: x.warmup, num: func(ctx *evalContext) []float64 {
+		return shift(x.num(ctx), offset)
+	}}, nil
+}
+
+func (c *compiler) compileTimeframe(n *Timeframe) (*expr, error) {
+	if c.interval != "" {
+		return nil, c.errorf(n.At, "timeframes cannot be nested")
+	}
+	interval, ok := NormalizeInterval(n.Interval)
+	if !ok {
+		return nil, c.errorf(n.At+1, "unsupported interval '%s' (use 1m, 3m, 5m, 10m, 15m, 30m, 1h, 2h, 4h, D or W)", n.Interval)
+	}
+
+	c.interval = interval
+	x, err := c.compile(n.X)
+	c.interval = ""
+	if err != nil {
+		return nil, err
+	}
+
+	known := false
+	for _, i := range c.intervals {
+		known = known || i == interval
+	}
+	if !known {
+		c.intervals = append(c.intervals, interval)
+	}
+
+	// The lookback of x counts bars of the other timeframe, not of ours
+	c.history[interval] = max(c.history[interval], x.lookback+x.warmup+1)
+	c.lookbacks[interval] = max(c.lookbacks[interval], x.lookback)
+	if x.typ == TypeBool {
+		return &expr{typ: TypeBool, cond: func(ctx *evalContext) []bool {
+			sub := ctx.timeframe(interval)
+			return ctx.alignBool(sub, x.cond(sub))
+		}}, nil
+	}
+	return &expr{typ: TypeNumber, num: func(ctx *evalContext) []float64 {
+		sub := ctx.timeframe(interval)
+		return ctx.align(sub, x.num(sub))
+	}}, nil
+}
+
+func (c *compiler) compileUnary(n *Unary) (*expr, error) {
+	x, err := c.compile(n.X)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Op == tokNot {
+		if x.typ != TypeBool {
+			return nil, c.errorf(n.X.Pos(), "'not' needs a true/false value, not a %s", x.typ)
+		}
+		return &expr{typ: TypeBool, lookback: x.lookback, warmup: x.warmup, cond: func(ctx *evalContext) []bool {
+			values := x.cond(ctx)
+			out := make([]bool, len(values))
+			for i, v := range values {
+				out[i] = !v
+			}
+			return out
+		}}, nil
+	}
+
+	if x.typ != TypeNumber {
+		return nil, c.errorf(n.X.Pos(), "'-' needs a number, not a %s value", x.typ)
+	}
+	return &expr{typ: TypeNumber, lookback: x.lookback, warmup: x.warmup, num: func(ctx *evalContext) []float64 {
+		values := x.num(ctx)
+		out := make([]float64, len(values))
+		for i, v := range values {
+			out[i] = -v
+		}
+		return out
+	}}, nil
+}
+
+func (c *compiler) compileBinary(n *Binary) (*expr, error) {
+	x, err := c.compile(n.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := c.compile(n.Y)
+	if err != nil {
+		return nil, err
+	}
+	lookback := max(x.lookback, y.lookback)
+	warmup := max(x.warmup, y.warmup)
+
+	switch n.Op {
+	case tokAnd, tokOr:
+		if x.typ != TypeBool {
+			return nil, c.errorf(n.X.Pos(), "%s needs true/false values, but the left side is a %s", n.Op, x.typ)
+		}
+		if y.typ != TypeBool {
+			return nil, c.errorf(n.Y.Pos(), "%s needs true/false values, but the right side is a %s", n.Op, y.typ)
+		}
+		and := n.Op == tokAnd
+		return &expr{typ: TypeBool, lookback: lookback, warmup: warmup, cond: func(ctx *evalContext) []bool {
+			xs, ys := x.cond(ctx), y.cond(ctx)
+			out := make([]bool, len(xs))
+			for i := range out {
+				if and {
+					out[i] = xs[i] && ys[i]
+				} else {
+					out[i] = xs[i] || ys[i]
+				}
+			}
+			return out
+		}}, nil
+
+	case tokEQ, tokNE:
+		if x.typ != y.typ {
+			return nil, c.errorf(n.At, "cannot compare a %s with a %s", x.typ, y.typ)
+		}
+		equal := n.Op == tokEQ
+		if x.typ == TypeBool {
+			return &expr{typ: TypeBool, lookback: lookback, warmup: warmup, cond: func(ctx *evalContext) []bool {
+				xs, ys := x.cond(ctx), y.cond(ctx)
+				out := make([]bool, len(xs))
+				for i := range out {
+					out[i] = (xs[i] == ys[i]) == equal
+				}
+				return out
+			}}, nil
+		}
+		return c.comparison(n.Op, x, y, lookback, warmup), nil
+
+	case tokLT, tokLE, tokGT, tokGE:
+		if x.typ != TypeNumber || y.typ != TypeNumber {
+			return nil, c.errorf(n.At, "%s needs numbers on both sides", n.Op)
+		}
+		return c.comparison(n.Op, x, y, lookback, warmup), nil
+
+	case tokPlus, tokMinus, tokStar, tokSlash:
+		if x.typ != TypeNumber {
+			return nil, c.errorf(n.X.Pos(), "%s needs numbers, but the left side is a %s value", n.Op, x.typ)
+		}
+		if y.typ != TypeNumber {
+			return nil, c.errorf(n.Y.Pos(), "%s needs numbers, but the right side is a %s value", n.Op, y.typ)
+		}
+		op := n.Op
+		return &expr{typ: TypeNumber, lookback: lookback, warmup: warmup, num: func(ctx *evalContext) []float64 {
+			xs, ys := x.num(ctx), y.num(ctx)
+			out := make([]float64, len(xs))
+			for i := range out {
+				switch op {
+				case tokPlus:
+					out[i] = xs[i] + ys[i]
+				case tokMinus:
+					out[i] = xs[i] - ys[i]
+				case tokStar:
+					out[i] = xs[i] * ys[i]
+				case tokSlash:
+					out[i] = xs[i] / ys[i]
+				}
+			}
+			return out
+		}}, nil
+	}
+	return nil, c.errorf(n.At, "unsupported operator %s", n.Op)
+}
+
+// comparison compares two number series. Comparisons involving a value that
+// is not available yet (NaN) are false, except for != which is true.
+func (c *compiler) comparison(op tokenKind, x, y *expr, lookback, warmup int) *expr {
+	return &expr{typ: TypeBool, lookback: lookback, warmup: warmup, cond: func(ctx *evalContext) []bool {
+		xs, ys := x.num(ctx), y.num(ctx)
+		out := make([]bool, len(xs))
+		for i := range out {
+			switch op {
+			case tokLT:
+				out[i] = xs[i] < ys[i]
+			case tokLE:
+				out[i] = xs[i] <= ys[i]
+			case tokGT:
+				out[i] = xs[i] > ys[i]
+			case tokGE:
+				out[i] = xs[i] >= ys[i]
+			case tokEQ:
+				out[i] = xs[i] == ys[i]
+			case tokNE:
+				out[i] = xs[i] != ys[i]
+			}
+		}
+		return out
+	}}
+}
+
+// signature describes the arguments of a function for error messages
+func signature(name string, fn *function) string {
+	args := []string{}
+	if fn.defaultSource != "" {
+		args = append(args, "source="+fn.defaultSource)
+	} else if fn.source {
+		args = append(args, "source")
+	}
+	for _, p := range fn.params {
+		if p.optional {
+			args = append(args, fmt.Sprintf("%s=%g", p.name, p.def))
+		} else {
+			args = append(args, p.name)
+		}
+	}
+	usage := name + "(" + strings.Join(args, ", ") + ")"
+	if len(fn.outputs) > 0 {
+		usage += " with ." + strings.Join(fn.outputs, ", .")
+	}
+	return usage
+}
+
+func mustAtof(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,370 @@
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tree renders a parsed condition fully parenthesized, so that tests see how
+// it was grouped
+func tree(n Node) string {
+	switch n := n.(type) {
+	case *Unary:
+		op := "-"
+		if n.Op == tokNot {
+			op = "not"
+		}
+		return "(" + op + " " + tree(n.X) + ")"
+	case *Binary:
+		return "(" + strings.Trim(n.Op.String(), "'") + " " + tree(n.X) + " " + tree(n.Y) + ")"
+	case *Index:
+		return "(index " + tree(n.X) + " " + tree(n.Offset) + ")"
+	case *Timeframe:
+		return "(@" + n.Interval + " " + tree(n.X) + ")"
+	case *Member:
+		return "(." + n.Name + " " + tree(n.X) + ")"
+	case *Call:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = tree(arg)
+		}
+		return "(" + n.Name + " " + strings.Join(args, " ") + ")"
+	}
+	return n.String()
+}
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"not a and b or c", "(or (and (not a) b) c)"},
+		{"a or b and c", "(or a (and b c))"},
+		{"not not a", "(not (not a))"},
+		{"not a > b", "(not (> a b))"},
+		{"a > b and c < d", "(and (> a b) (< c d))"},
+		{"1 - 2 - 3", "(- (- 1 2) 3)"},
+		{"8 / 4 / 2", "(/ (/ 8 4) 2)"},
+		{"2 + 3 * 4", "(+ 2 (* 3 4))"},
+		{"(2 + 3) * 4", "(* (+ 2 3) 4)"},
+		{"-x[1]", "(- (index x 1))"},
+		{"-x * y", "(* (- x) y)"},
+		{"-2", "-2"},
+		{"a@1h[1]", "(index (@1h a) 1)"},
+		{"a[1]@1h", "(@1h (index a 1))"},
+		{"bb(close, 20, 2).upper@D", "(@D (.upper (bb close 20 2)))"},
+		{"a && b || !c", "(or (and a b) (not c))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			node, err := Parse(tt.src)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.src, err)
+			}
+			if got := tree(node); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		pos  int
+		want string
+	}{
+		{"", 0, "condition is empty"},
+		{"ema(close, 20)", 0, "must evaluate to true or false"},
+		{"close and true", 0, "the left side is a number"},
+		{"rsi(close, 14) > 30 and close", 24, "the right side is a number"},
+		{"not close", 4, "'not' needs a true/false value"},
+		{"close > true", 6, "needs numbers on both sides"},
+		{"close == true", 6, "cannot compare a number with a true/false"},
+		{"close + (close > 1) > 2", 9, "the right side is a true/false value"},
+		{"sma(close, 2.5) > 1", 11, "must be a whole number"},
+		{"sma(close) > 1", 0, "sma expects sma(source, length)"},
+		{"close@7m > 1", 6, "unsupported interval '7m'"},
+		{"(close@1h)@D > 1", 6, "timeframes cannot be nested"},
+		{"close[1.5] > 1", 6, "history index must be a whole number"},
+		{"close = 1", 6, "use '==' to compare"},
+		{"close > ", 8, "unexpected end of condition"},
+		{"foo > 1", 0, "unknown identifier 'foo'"},
+		{"bb(close, 20).width > 1", 13, "bb has no field 'width'"},
+		{"crossover(close) and true", 0, "crossover expects crossover(a, b)"},
+		{"sma(close, 100000000000000000000) > 1", 11, "length of sma must be at most 5000"},
+		{"macd(close, 12, 26, 5001).signal > 0", 20, "signal of macd must be at most 5000"},
+		{"close[100000000000000000000] > 1", 6, "at most 5000 bars back"},
+		{"close[5001] > 1", 6, "at most 5000 bars back"},
+		{"rising(close, 1000000000000000000000)", 14, "length of rising must be at most 5000"},
+		{"falling(close, 5001)", 15, "length of falling must be at most 5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			var cerr *Error
+			if !errors.As(err, &cerr) {
+				t.Fatalf("Compile(%q) error = %v, want an *Error", tt.src, err)
+			}
+			if cerr.Pos != tt.pos || !strings.Contains(cerr.Msg, tt.want) {
+				t.Errorf("Compile(%q) error at %d %q, want at %d containing %q", tt.src, cerr.Pos, cerr.Msg, tt.pos, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyShorthand(t *testing.T) {
+	bars := closes(44, 44.5, 43.8, 44.6, 45.2, 45.9, 46.3, 45.8, 46.1, 46.8, 47.2, 46.9, 47.5, 48.1, 47.7, 48.3, 48.9, 48.2, 48.6, 49.1)
+	legacy := series(t, "RSI14", Input{Bars: bars})
+	explicit := series(t, "rsi(close, 14)", Input{Bars: bars})
+	for i := range explicit {
+		if !same(legacy[i], explicit[i]) {
+			t.Fatalf("RSI14[%d] = %v, want rsi(close, 14) = %v", i, legacy[i], explicit[i])
+		}
+	}
+
+	program, err := Compile("RSI14 < 70 and ema20 > 0")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if got := program.History("5m")["5m"]; got <= 20 {
+		t.Errorf("History of RSI14 and ema20 = %d bars, want more than 20", got)
+	}
+
+	_, err = Compile("RSI < 30")
+	var cerr *Error
+	if !errors.As(err, &cerr) || cerr.Pos != 0 || !strings.Contains(cerr.Msg, "RSI14 or rsi(close, 14)") {
+		t.Errorf("Compile(\"RSI < 30\") error = %v, want a hint to give a period", err)
+	}
+}
+
+func TestCrossOnEqualBars(t *testing.T) {
+	// close is a, open is b
+	bars := []Bar{
+		{Open: 2, Close: 1}, // below
+		{Open: 2, Close: 2}, // touches
+		{Open: 2, Close: 3}, // crosses above from equal
+		{Open: 2, Close: 2}, // touches from above
+		{Open: 2, Close: 2}, // stays equal
+		{Open: 2, Close: 1}, // crosses below from equal
+		{Open: 2, Close: 3}, // crosses above from below
+		{Open: 3, Close: 3}, // equal after being above
+	}
+	tests := []struct {
+		src  string
+		want []bool
+	}{
+		{"crossover(close, open)", []bool{false, false, true, false, false, false, true, false}},
+		{"crossunder(close, open)", []bool{false, false, false, false, false, true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			assertSignals(t, tt.src, Input{Bars: bars}, tt.want)
+		})
+	}
+}
+
+func TestWarmupNeverSignals(t *testing.T) {
+	bars := closes(10, 11, 12, 13, 14, 15)
+	tests := []struct {
+		src  string
+		want []bool
+	}{
+		// Comparisons with a missing value are false anyway
+		{"close > sma(close, 3)", []bool{false, false, true, true, true, true}},
+		// but would be true once negated
+		{"not (close < sma(close, 3))", []bool{false, false, true, true, true, true}},
+		{"sma(close, 3) != 0", []bool{false, false, true, true, true, true}},
+		{"close[2] != 0", []bool{false, false, true, true, true, true}},
+		{"not falling(close, 2)", []bool{false, false, true, true, true, true}},
+		// crossover also needs the bar before
+		{"crossover(close, sma(close, 3)) or true", []bool{false, false, false, true, true, true}},
+		{"rsi(close, 2) != 50 or close > 0", []bool{false, false, true, true, true, true}},
+		{"true", []bool{true, true, true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			assertSignals(t, tt.src, Input{Bars: bars}, tt.want)
+		})
+	}
+
+	program, err := Compile("rsi(close, 14) > 0")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, _, err := program.Eval(Input{Bars: bars}); err == nil || !strings.Contains(err.Error(), "not enough history") {
+		t.Errorf("Eval on %d bars error = %v, want not enough history", len(bars), err)
+	}
+}
+
+func TestTimeframeAlignment(t *testing.T) {
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	quarters := make([]Bar, 12) // 10:00 to 12:45
+	for i := range quarters {
+		quarters[i] = Bar{Time: start.Add(time.Duration(i) * 15 * time.Minute), Close: float64(i)}
+	}
+	hours := []Bar{
+		{Time: start, Close: 100},
+		{Time: start.Add(time.Hour), Close: 200},
+		{Time: start.Add(2 * time.Hour), Close: 300}, // Closes at 13:00, with the last quarter
+	}
+	in := Input{Interval: "15m", Bars: quarters, Timeframes: map[string][]Bar{"1h": hours}}
+	nan := math.NaN()
+
+	tests := []struct {
+		src  string
+		want []float64
+	}{
+		// An hour is only seen from the quarter that closes with it
+		{"close@1h", []float64{nan, nan, nan, 100, 100, 100, 100, 200, 200, 200, 200, 300}},
+		// The previous quarter's view of the hour
+		{"close@1h[1]", []float64{nan, nan, nan, nan, 100, 100, 100, 100, 200, 200, 200, 200}},
+		// The hour before the last closed one
+		{"close[1]@1h", []float64{nan, nan, nan, nan, nan, nan, nan, 100, 100, 100, 100, 200}},
+		{"close@15m", []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			assertSeries(t, tt.src, in, tt.want, 0)
+		})
+	}
+
+	assertSignals(t, "close@1h > 150", in, []bool{false, false, false, false, false, false, false, true, true, true, true, true})
+	assertSignals(t, "close@1h != 0", in, []bool{false, false, false, true, true, true, true, true, true, true, true, true})
+
+	program, err := Compile("ema(close, 5)@1h > close")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	history := program.History("15m")
+	if history["15m"] != 1 || history["1h"] <= 5 {
+		t.Errorf("History = %v, want 1 bar of 15m and more than 5 of 1h", history)
+	}
+}
+
+// ohlc are bars with their true range worked out by hand: 2, 2, 4 and 2 from the second bar on
+var ohlc = []Bar{
+	{High: 10, Low: 8, Close: 9},
+	{High: 11, Low: 9, Close: 10},
+	{High: 12, Low: 10, Close: 11},
+	{High: 15, Low: 11, Close: 14},
+	{High: 14, Low: 12, Close: 13},
+}
+
+func TestIndicatorValues(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		src  string
+		bars []Bar
+		want []float64
+	}{
+		{"sma(close, 3)", closes(1, 2, 3, 4, 5, 9), []float64{nan, nan, 2, 3, 4, 6}},
+		// Seeded with the SMA of the first 3 closes, then smoothed by 2/(3+1)
+		{"ema(close, 3)", closes(1, 2, 3, 4, 5, 9), []float64{nan, nan, 2, 3, 4, 6.5}},
+		// Average gain and loss seeded over 2 changes, then smoothed by 1/2
+		{"rsi(close, 2)", closes(1, 2, 3, 2, 3), []float64{nan, nan, 100, 50, 75}},
+		// ATR seeded with the mean true range of bars 1 and 2, then Wilder smoothed
+		{"atr(2)", ohlc, []float64{nan, nan, 2, 3, 2.5}},
+		// Lower band mid-ATR, tightened while the close stays above it
+		{"supertrend(1, 2)", ohlc, []float64{nan, nan, 9, 10, 10.5}},
+		{"supertrend(1, 2).direction", ohlc, []float64{nan, nan, 1, 1, 1}},
+		{"hlc3", ohlc[:2], []float64{9, 10}},
+		{"close - close[1]", closes(1, 3, 6), []float64{nan, 2, 3}},
+		// Nested indicators start after the warm-up of their source
+		{"sma(sma(close, 2), 2)", closes(1, 3, 5, 7), []float64{nan, nan, 3, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			assertSeries(t, tt.src, Input{Bars: tt.bars}, tt.want, 1e-9)
+		})
+	}
+}
+
+func TestSupertrendFlips(t *testing.T) {
+	bars := append(append([]Bar{}, ohlc...),
+		Bar{High: 11, Low: 7, Close: 8},  // Closes below the lower band
+		Bar{High: 9, Low: 6, Close: 7},   // Stays down
+		Bar{High: 20, Low: 9, Close: 19}, // Closes above the upper band
+	)
+	got := series(t, "supertrend(1, 2).direction", Input{Bars: bars})
+	want := []float64{1, 1, 1, -1, -1, 1}
+	for i, w := range want {
+		if got[i+2] != w {
+			t.Fatalf("direction = %v, want %v from bar 2", got[2:], want)
+		}
+	}
+}
+
+// series compiles a numeric expression and evaluates it over every bar
+func series(t *testing.T, src string, in Input) []float64 {
+	t.Helper()
+	node, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", src, err)
+	}
+	c := &compiler{src: src, watched: map[string]bool{}, history: map[string]int{}, lookbacks: map[string]int{}}
+	e, err := c.compile(node)
+	if err != nil {
+		t.Fatalf("compile(%q) failed: %v", src, err)
+	}
+	if e.typ != TypeNumber {
+		t.Fatalf("%q is a %s, want a number", src, e.typ)
+	}
+	ctx, err := (&Program{intervals: c.intervals}).newContext(in)
+	if err != nil {
+		t.Fatalf("newContext failed: %v", err)
+	}
+	return e.num(ctx)
+}
+
+func assertSeries(t *testing.T, src string, in Input, want []float64, tolerance float64) {
+	t.Helper()
+	got := series(t, src, in)
+	if len(got) != len(want) {
+		t.Fatalf("%s has %d values, want %d", src, len(got), len(want))
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) || math.Abs(got[i]-want[i]) > tolerance {
+			t.Fatalf("%s = %v, want %v (first difference at bar %d)", src, format(got), format(want), i)
+		}
+	}
+}
+
+func assertSignals(t *testing.T, src string, in Input, want []bool) {
+	t.Helper()
+	program, err := Compile(src)
+	if err != nil {
+		t.Fatalf("Compile(%q) failed: %v", src, err)
+	}
+	got, err := program.EvalSeries(in)
+	if err != nil {
+		t.Fatalf("EvalSeries(%q) failed: %v", src, err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("EvalSeries(%q) = %v, want %v", src, got, want)
+	}
+}
+
+func closes(values ...float64) []Bar {
+	bars := make([]Bar, len(values))
+	for i, v := range values {
+		bars[i] = Bar{Open: v, High: v, Low: v, Close: v}
+	}
+	return bars
+}
+
+func same(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+func format(values []float64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%.4g", v)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
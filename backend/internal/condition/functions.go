@@ -0,0 +1,349 @@
+package condition
+
+import (
+	"math"
+
+	"github.com/markcheno/go-talib"
+)
+
+// function describes a built-in indicator. An indicator takes an optional
+// source series as its first argument followed by constant parameters, and
+// may read further bar fields (inputs) implicitly, such as high and low for ATR.
+type function struct {
+	source        bool     // Takes a source series as first argument
+	defaultSource string   // Source used when the call has no arguments at all
+	inputs        []string // Bar fields passed after the source
+	params        []param
+	outputs       []string // Named results; the first is used when no field is selected
+	lookback      func(p []float64) int
+	warmup        func(p []float64) int // Bars after the lookback for smoothing to settle; nil if none
+	calculate     func(in [][]float64, p []float64) [][]float64
+}
+
+type param struct {
+	name     string
+	min      float64
+	max      float64 // Largest value allowed; 0 for no limit
+	def      float64 // Default when optional
+	optional bool    // Optional parameters may be omitted from the end
+	fraction bool    // Whether non-integer values are allowed
+}
+
+func length(name string, min float64) param {
+	return param{name: name, min: min, max: maxBars}
+}
+
+func optionalLength(name string, min, def float64) param {
+	return param{name: name, min: min, max: maxBars, def: def, optional: true}
+}
+
+var hlc = []string{"high", "low", "close"}
+
+var functions = map[string]*function{
+	"sma": {
+		source:    true,
+		params:    []param{length("length", 1)},
+		lookback:  func(p []float64) int { return int(p[0]) - 1 },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.Sma(in[0], int(p[0])) }),
+	},
+	"ema": {
+		source:    true,
+		params:    []param{length("length", 1)},
+		lookback:  func(p []float64) int { return int(p[0]) - 1 },
+		warmup:    func(p []float64) int { return emaWarmup(p[0]) },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.Ema(in[0], int(p[0])) }),
+	},
+	"rsi": {
+		source:    true,
+		params:    []param{length("length", 2)},
+		lookback:  func(p []float64) int { return int(p[0]) },
+		warmup:    func(p []float64) int { return wilderWarmup(p[0]) },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.Rsi(in[0], int(p[0])) }),
+	},
+	"roc": {
+		source:    true,
+		params:    []param{length("length", 1)},
+		lookback:  func(p []float64) int { return int(p[0]) },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.Roc(in[0], int(p[0])) }),
+	},
+	"lrs": {
+		source:    true,
+		params:    []param{length("length", 2)},
+		lookback:  func(p []float64) int { return int(p[0]) - 1 },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.LinearRegSlope(in[0], int(p[0])) }),
+	},
+	// macd defaults to the histogram (MACD line minus signal line)
+	"macd": {
+		source: true,
+		params: []param{
+			optionalLength("fast", 2, 12),
+			optionalLength("slow", 2, 26),
+			optionalLength("signal", 1, 9),
+		},
+		outputs:  []string{"hist", "macd", "signal"},
+		lookback: func(p []float64) int { return int(max(p[0], p[1])) - 1 + int(p[2]) - 1 },
+		warmup:   func(p []float64) int { return emaWarmup(max(p[0], p[1])) + emaWarmup(p[2]) },
+		calculate: func(in [][]float64, p []float64) [][]float64 {
+			line, signal, hist := talib.Macd(in[0], int(p[0]), int(p[1]), int(p[2]))
+			return [][]float64{hist, line, signal}
+		},
+	},
+	// bb is a Bollinger band of mult standard deviations around an SMA
+	"bb": {
+		source:   true,
+		params:   []param{length("length", 2), {name: "mult", min: 0, def: 2, optional: true, fraction: true}},
+		outputs:  []string{"middle", "upper", "lower"},
+		lookback: func(p []float64) int { return int(p[0]) - 1 },
+		calculate: func(in [][]float64, p []float64) [][]float64 {
+			upper, middle, lower := talib.BBands(in[0], int(p[0]), p[1], p[1], talib.SMA)
+			return [][]float64{middle, upper, lower}
+		},
+	},
+	"atr": {
+		inputs:    hlc,
+		params:    []param{length("length", 1)},
+		lookback:  func(p []float64) int { return int(p[0]) },
+		warmup:    func(p []float64) int { return wilderWarmup(p[0]) },
+		calculate: single(func(in [][]float64, p []float64) []float64 { return talib.Atr(in[0], in[1], in[2], int(p[0])) }),
+	},
+	// stoch is the slow stochastic: %K smoothed over smoothK bars, and its SMA %D
+	"stoch": {
+		inputs: hlc,
+		params: []param{
+			optionalLength("length", 1, 14),
+			optionalLength("smoothK", 1, 3),
+			optionalLength("smoothD", 1, 3),
+		},
+		outputs:  []string{"k", "d"},
+		lookback: func(p []float64) int { return int(p[0]) - 1 + int(p[1]) - 1 + int(p[2]) - 1 },
+		calculate: func(in [][]float64, p []float64) [][]float64 {
+			k, d := talib.Stoch(in[0], in[1], in[2], int(p[0]), int(p[1]), talib.SMA, int(p[2]), talib.SMA)
+			return [][]float64{k, d}
+		},
+	},
+	// adx is the average directional index with the +DI and -DI lines
+	"adx": {
+		inputs:   hlc,
+		params:   []param{optionalLength("length", 2, 14)},
+		outputs:  []string{"adx", "plus", "minus"},
+		lookback: func(p []float64) int { return 2*int(p[0]) - 1 },
+		warmup:   func(p []float64) int { return 2 * wilderWarmup(p[0]) },
+		calculate: func(in [][]float64, p []float64) [][]float64 {
+			n := int(p[0])
+			return [][]float64{
+				talib.Adx(in[0], in[1], in[2], n),
+				talib.PlusDI(in[0], in[1], in[2], n),
+				talib.MinusDI(in[0], in[1], in[2], n),
+			}
+		},
+	},
+	"cci": {
+		source:   true,
+		params:   []param{length("length", 2)},
+		lookback: func(p []float64) int { return int(p[0]) - 1 },
+		calculate: single(func(in [][]float64, p []float64) []float64 {
+			return talib.Cci(in[0], in[0], in[0], int(p[0]))
+		}),
+	},
+	"mfi": {
+		source:   true,
+		inputs:   []string{"volume"},
+		params:   []param{length("length", 2)},
+		lookback: func(p []float64) int { return int(p[0]) },
+		calculate: single(func(in [][]float64, p []float64) []float64 {
+			return talib.Mfi(in[0], in[0], in[0], in[1], int(p[0]))
+		}),
+	},
+	"obv": {
+		source:        true,
+		defaultSource: "close",
+		inputs:        []string{"volume"},
+		lookback:      func(p []float64) int { return 0 },
+		calculate:     single(func(in [][]float64, p []float64) []float64 { return talib.Obv(in[0], in[1]) }),
+	},
+	// vwap restarts at the first bar of every trading session
+	"vwap": {
+		source:        true,
+		defaultSource: "hlc3",
+		inputs:        []string{"volume", sessionInput},
+		lookback:      func(p []float64) int { return 0 },
+		calculate:     single(func(in [][]float64, p []float64) []float64 { return sessionVWAP(in[0], in[1], in[2]) }),
+	},
+	// supertrend follows price with an ATR band; direction is 1 in an uptrend and -1 in a downtrend
+	"supertrend": {
+		inputs: hlc,
+		params: []param{
+			{name: "factor", min: 0, fraction: true},
+			length("atrLength", 1),
+		},
+		outputs:  []string{"value", "direction"},
+		lookback: func(p []float64) int { return int(p[1]) },
+		warmup:   func(p []float64) int { return wilderWarmup(p[1]) },
+		calculate: func(in [][]float64, p []float64) [][]float64 {
+			return superTrend(in[0], in[1], in[2], p[0], int(p[1]))
+		},
+	},
+}
+
+// single adapts an indicator with a single output
+func single(calculate func(in [][]float64, p []float64) []float64) func(in [][]float64, p []float64) [][]float64 {
+	return func(in [][]float64, p []float64) [][]float64 {
+		return [][]float64{calculate(in, p)}
+	}
+}
+
+// warmupBars returns the bars an indicator needs after its lookback before
+// its values no longer depend noticeably on how its smoothing was seeded
+func (f *function) warmupBars(p []float64) int {
+	if f.warmup == nil {
+		return 0
+	}
+	return f.warmup(p)
+}
+
+// seedWeight is the influence of the initial value below which an
+// exponentially smoothed series is considered settled
+const seedWeight = 0.01
+
+// emaWarmup is the warm-up of an EMA with smoothing factor 2/(length+1)
+func emaWarmup(length float64) int {
+	return settle(2 / (length + 1))
+}
+
+// wilderWarmup is the warm-up of Wilder's smoothing (RMA) with factor 1/length,
+// used by RSI, ATR and ADX
+func wilderWarmup(length float64) int {
+	return settle(1 / length)
+}
+
+// settle returns the bars after which the seed of an exponential smoothing
+// with factor alpha weighs less than seedWeight
+func settle(alpha float64) int {
+	if alpha >= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log(seedWeight) / math.Log(1-alpha)))
+}
+
+// output returns the index of a named output, or -1
+func (f *function) output(name string) int {
+	if name == "" {
+		return 0
+	}
+	for i, output := range f.outputs {
+		if output == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply runs an indicator over its input series. Leading NaN values of the
+// inputs (the warm-up of a nested indicator) are skipped, and every output is
+// NaN until the indicator itself has warmed up.
+func (f *function) apply(in [][]float64, p []float64) [][]float64 {
+	n := len(in[0])
+	outputs := max(1, len(f.outputs))
+	out := make([][]float64, outputs)
+	for i := range out {
+		out[i] = nanSeries(n)
+	}
+
+	start := 0
+	for start < n && anyNaN(in, start) {
+		start++
+	}
+	lookback := f.lookback(p)
+	if n-start <= lookback {
+		return out
+	}
+
+	suffix := make([][]float64, len(in))
+	for i, series := range in {
+		suffix[i] = series[start:]
+	}
+	for o, values := range f.calculate(suffix, p) {
+		for i := lookback; i < len(values); i++ {
+			out[o][start+i] = values[i]
+		}
+	}
+	return out
+}
+
+func anyNaN(in [][]float64, i int) bool {
+	for _, series := range in {
+		if math.IsNaN(series[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func nanSeries(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
+
+// sessionVWAP is the volume weighted average price, reset at each new session
+func sessionVWAP(price, volume, session []float64) []float64 {
+	out := nanSeries(len(price))
+	var sumPV, sumV float64
+	for i := range price {
+		if i == 0 || session[i] != session[i-1] {
+			sumPV, sumV = 0, 0
+		}
+		sumPV += price[i] * volume[i]
+		sumV += volume[i]
+		if sumV > 0 {
+			out[i] = sumPV / sumV
+		}
+	}
+	return out
+}
+
+// superTrend computes the SuperTrend line and direction using Wilder's ATR
+func superTrend(high, low, close []float64, factor float64, atrLength int) [][]float64 {
+	n := len(close)
+	value := nanSeries(n)
+	direction := nanSeries(n)
+	atr := talib.Atr(high, low, close, atrLength)
+
+	var upper, lower float64
+	for i := atrLength; i < n; i++ {
+		mid := (high[i] + low[i]) / 2
+		basicUpper := mid + factor*atr[i]
+		basicLower := mid - factor*atr[i]
+
+		if i == atrLength {
+			upper, lower = basicUpper, basicLower
+			direction[i] = 1
+			if close[i] < mid {
+				direction[i] = -1
+			}
+		} else {
+			// Bands only tighten while price stays on the same side of them
+			if basicUpper < upper || close[i-1] > upper {
+				upper = basicUpper
+			}
+			if basicLower > lower || close[i-1] < lower {
+				lower = basicLower
+			}
+			direction[i] = direction[i-1]
+			if direction[i] < 0 && close[i] > upper {
+				direction[i] = 1
+			} else if direction[i] > 0 && close[i] < lower {
+				direction[i] = -1
+			}
+		}
+
+		if direction[i] > 0 {
+			value[i] = lower
+		} else {
+			value[i] = upper
+		}
+	}
+	return [][]float64{value, direction}
+}
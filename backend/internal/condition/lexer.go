@@ -0,0 +1,236 @@
+package condition
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a compile error at a position in the condition source
+type Error struct {
+	Pos    int // Byte offset into the source, zero based
+	Msg    string
+	Source string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Context renders the source line with a caret under the error position
+func (e *Error) Context() string {
+	pos := e.Pos
+	if pos > len(e.Source) {
+		pos = len(e.Source)
+	}
+	return e.Source + "\n" + strings.Repeat(" ", pos) + "^"
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokLParen
+	tokRParen
+	tokComma
+	tokLBracket
+	tokRBracket
+	tokDot
+	tokAt
+	tokPlus
+	tokMinus
+	tokStar
+	tokSlash
+	tokLT
+	tokLE
+	tokGT
+	tokGE
+	tokEQ
+	tokNE
+	tokAnd
+	tokOr
+	tokNot
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of condition",
+	tokNumber:   "number",
+	tokIdent:    "identifier",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokComma:    "','",
+	tokLBracket: "'['",
+	tokRBracket: "']'",
+	tokDot:      "'.'",
+	tokAt:       "'@'",
+	tokPlus:     "'+'",
+	tokMinus:    "'-'",
+	tokStar:     "'*'",
+	tokSlash:    "'/'",
+	tokLT:       "'<'",
+	tokLE:       "'<='",
+	tokGT:       "'>'",
+	tokGE:       "'>='",
+	tokEQ:       "'=='",
+	tokNE:       "'!='",
+	tokAnd:      "'and'",
+	tokOr:       "'or'",
+	tokNot:      "'not'",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+type token struct {
+	kind tokenKind
+	pos  int
+	text string
+}
+
+// describe names a token for use in error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of condition"
+	case tokNumber, tokIdent:
+		return fmt.Sprintf("'%s'", t.text)
+	default:
+		return t.kind.String()
+	}
+}
+
+// lex splits a condition into tokens. Keywords are case-insensitive and the
+// symbolic forms &&, || and ! are accepted for and, or and not.
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			seenDot := false
+			for i < len(src) && (isDigit(src[i]) || (src[i] == '.' && !seenDot)) {
+				if src[i] == '.' {
+					seenDot = true
+				}
+				i++
+			}
+			if i < len(src) && isIdentStart(src[i]) {
+				return nil, &Error{Pos: start, Msg: fmt.Sprintf("invalid number '%s'", src[start:i+1]), Source: src}
+			}
+			tokens = append(tokens, token{kind: tokNumber, pos: start, text: src[start:i]})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			text := src[start:i]
+			kind := tokIdent
+			switch strings.ToLower(text) {
+			case "and":
+				kind = tokAnd
+			case "or":
+				kind = tokOr
+			case "not":
+				kind = tokNot
+			}
+			tokens = append(tokens, token{kind: kind, pos: start, text: text})
+		case c == '@':
+			// The interval after @ is a single word such as 15m, 1h or D
+			start := i
+			i++
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			if i == start+1 {
+				return nil, &Error{Pos: start, Msg: "expected an interval after '@', e.g. @15m", Source: src}
+			}
+			tokens = append(tokens, token{kind: tokAt, pos: start, text: src[start+1 : i]})
+		default:
+			kind, width, err := lexOperator(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: kind, pos: i, text: src[i : i+width]})
+			i += width
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(src)})
+	return tokens, nil
+}
+
+func lexOperator(src string, i int) (tokenKind, int, error) {
+	next := byte(0)
+	if i+1 < len(src) {
+		next = src[i+1]
+	}
+
+	switch src[i] {
+	case '(':
+		return tokLParen, 1, nil
+	case ')':
+		return tokRParen, 1, nil
+	case ',':
+		return tokComma, 1, nil
+	case '[':
+		return tokLBracket, 1, nil
+	case ']':
+		return tokRBracket, 1, nil
+	case '.':
+		return tokDot, 1, nil
+	case '+':
+		return tokPlus, 1, nil
+	case '-':
+		return tokMinus, 1, nil
+	case '*':
+		return tokStar, 1, nil
+	case '/':
+		return tokSlash, 1, nil
+	case '<':
+		if next == '=' {
+			return tokLE, 2, nil
+		}
+		return tokLT, 1, nil
+	case '>':
+		if next == '=' {
+			return tokGE, 2, nil
+		}
+		return tokGT, 1, nil
+	case '=':
+		if next == '=' {
+			return tokEQ, 2, nil
+		}
+		return 0, 0, &Error{Pos: i, Msg: "unexpected '=' (use '==' to compare values)", Source: src}
+	case '!':
+		if next == '=' {
+			return tokNE, 2, nil
+		}
+		return tokNot, 1, nil
+	case '&':
+		if next == '&' {
+			return tokAnd, 2, nil
+		}
+		return 0, 0, &Error{Pos: i, Msg: "unexpected '&' (use 'and' or '&&')", Source: src}
+	case '|':
+		if next == '|' {
+			return tokOr, 2, nil
+		}
+		return 0, 0, &Error{Pos: i, Msg: "unexpected '|' (use 'or' or '||')", Source: src}
+	}
+	r, _ := utf8.DecodeRuneInString(src[i:])
+	return 0, 0, &Error{Pos: i, Msg: fmt.Sprintf("unexpected character '%c'", r), Source: src}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,286 @@
+package condition
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses a condition into its syntax tree without type checking it
+func Parse(src string) (Node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: src, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(0, "condition is empty")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok.pos, "unexpected %s", tok.describe())
+	}
+	return node, nil
+}
+
+// parser is a recursive descent parser. Precedence from lowest to highest:
+// or, and, not, comparison, + -, * /, unary minus, then call, field,
+// history index and timeframe.
+type parser struct {
+	src    string
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.peek()
+	if tok.kind != kind {
+		return tok, p.errorf(tok.pos, "expected %s but found %s", kind, tok.describe())
+	}
+	return p.advance(), nil
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...), Source: p.src}
+}
+
+func (p *parser) parseOr() (Node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		op := p.advance()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{At: op.pos, Op: tokOr, X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		op := p.advance()
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{At: op.pos, Op: tokAnd, X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if p.peek().kind == tokNot {
+		op := p.advance()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{At: op.pos, Op: tokNot, X: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Node, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch kind := p.peek().kind; kind {
+		case tokLT, tokLE, tokGT, tokGE, tokEQ, tokNE:
+			op := p.advance()
+			y, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			x = &Binary{At: op.pos, Op: kind, X: x, Y: y}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parseAdditive() (Node, error) {
+	x, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch kind := p.peek().kind; kind {
+		case tokPlus, tokMinus:
+			op := p.advance()
+			y, err := p.parseMultiplicative()
+			if err != nil {
+				return nil, err
+			}
+			x = &Binary{At: op.pos, Op: kind, X: x, Y: y}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parseMultiplicative() (Node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch kind := p.peek().kind; kind {
+		case tokStar, tokSlash:
+			op := p.advance()
+			y, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			x = &Binary{At: op.pos, Op: kind, X: x, Y: y}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	switch p.peek().kind {
+	case tokMinus:
+		op := p.advance()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold negative literals so that they stay constant arguments
+		if lit, ok := x.(*NumberLit); ok {
+			return &NumberLit{At: op.pos, Value: -lit.Value, Text: "-" + lit.Text}, nil
+		}
+		return &Unary{At: op.pos, Op: tokMinus, X: x}, nil
+	case tokPlus:
+		p.advance()
+		return p.parseUnary()
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a value followed by any number of fields, history
+// indexes and timeframes
+func (p *parser) parsePostfix() (Node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokLBracket:
+			open := p.advance()
+			offset, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokRBracket); err != nil {
+				return nil, err
+			}
+			x = &Index{At: open.pos, X: x, Offset: offset}
+		case tokDot:
+			dot := p.advance()
+			name, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			x = &Member{At: dot.pos, X: x, Name: strings.ToLower(name.text)}
+		case tokAt:
+			at := p.advance()
+			x = &Timeframe{At: at.pos, X: x, Interval: at.text}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.advance()
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "invalid number '%s'", tok.text)
+		}
+		return &NumberLit{At: tok.pos, Value: value, Text: tok.text}, nil
+	case tokIdent:
+		p.advance()
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &BoolLit{At: tok.pos, Value: true}, nil
+		case "false":
+			return &BoolLit{At: tok.pos, Value: false}, nil
+		}
+		if p.peek().kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		return &Ident{At: tok.pos, Name: tok.text}, nil
+	case tokLParen:
+		p.advance()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokEOF:
+		return nil, p.errorf(tok.pos, "unexpected end of condition, expected a value")
+	}
+	return nil, p.errorf(tok.pos, "unexpected %s, expected a value", tok.describe())
+}
+
+func (p *parser) parseCall(name token) (Node, error) {
+	p.advance() // (
+	call := &Call{At: name.pos, Name: strings.ToLower(name.text)}
+	if p.peek().kind == tokRParen {
+		p.advance()
+		return call, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		tok := p.peek()
+		if tok.kind == tokComma {
+			p.advance()
+			continue
+		}
+		if tok.kind == tokRParen {
+			p.advance()
+			return call, nil
+		}
+		return nil, p.errorf(tok.pos, "expected ',' or ')' in call to %s but found %s", call.Name, tok.describe())
+	}
+}
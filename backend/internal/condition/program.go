@@ -0,0 +1,278 @@
+// Package condition compiles and evaluates Pine-like trading conditions such
+// as "rsi(close, 14) < 30 and close > ema(close, 20)" over a series of bars.
+// Every sub-expression is a full series, so conditions can look back in time
+// with close[1] or crossover(ema(close, 9), ema(close, 21)). The price series
+// open, high, low, close, volume, oi, hl2, hlc3 and ohlc4 can be used directly
+// or as the source of any indicator, as in sma(volume, 20). Indicators with
+// several results expose them as fields, as in bb(close, 20, 2).upper.
+//
+// Any expression can be evaluated on another timeframe with @, as in
+// ema(close, 200)@1h. Such values are aligned to the condition's own bars using
+// only the bars of the other timeframe that had already closed.
+package condition
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Bar is a single OHLCV candle
+type Bar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+	OI     float64
+}
+
+// sources are the bar fields and derived prices available as series by name
+var sources = map[string]func(b Bar) float64{
+	"open":   func(b Bar) float64 { return b.Open },
+	"high":   func(b Bar) float64 { return b.High },
+	"low":    func(b Bar) float64 { return b.Low },
+	"close":  func(b Bar) float64 { return b.Close },
+	"volume": func(b Bar) float64 { return b.Volume },
+	"oi":     func(b Bar) float64 { return b.OI },
+	"hl2":    func(b Bar) float64 { return (b.High + b.Low) / 2 },
+	"hlc3":   func(b Bar) float64 { return (b.High + b.Low + b.Close) / 3 },
+	"ohlc4":  func(b Bar) float64 { return (b.Open + b.High + b.Low + b.Close) / 4 },
+}
+
+// sessionInput is the internal input that numbers trading sessions, used to
+// restart session-anchored indicators such as vwap
+const sessionInput = "session"
+
+// sessionLocation is the exchange time zone that session boundaries follow
+var sessionLocation = time.FixedZone("IST", 5*60*60+30*60)
+
+// inputField returns the bar field behind a named input
+func inputField(name string) func(b Bar) float64 {
+	if name == sessionInput {
+		return func(b Bar) float64 {
+			year, month, day := b.Time.In(sessionLocation).Date()
+			return float64(year*10000 + int(month)*100 + day)
+		}
+	}
+	return sources[name]
+}
+
+// Program is a compiled condition. It is immutable and safe for concurrent use.
+type Program struct {
+	source    string
+	root      *expr
+	watches   []watch
+	intervals []string
+	bars      int            // Bars of the condition's own timeframe needed
+	history   map[string]int // Bars needed of each timeframe referenced with @
+	lookbacks map[string]int // Bars before the first value of each timeframe referenced with @
+}
+
+// Compile parses and type checks a condition. Errors are of type *Error and
+// carry the position of the problem in the source.
+func Compile(src string) (*Program, error) {
+	node, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{src: src, watched: map[string]bool{}, history: map[string]int{}, lookbacks: map[string]int{}}
+	root, err := c.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	if root.typ != TypeBool {
+		return nil, c.errorf(0, "condition must evaluate to true or false, but it is a %s (did you forget a comparison such as > or <?)", root.typ)
+	}
+
+	return &Program{
+		source:    src,
+		root:      root,
+		watches:   c.watches,
+		intervals: c.intervals,
+		bars:      root.lookback + root.warmup + 1,
+		history:   c.history,
+		lookbacks: c.lookbacks,
+	}, nil
+}
+
+// Source returns the condition the program was compiled from
+func (p *Program) Source() string {
+	return p.source
+}
+
+// Intervals returns the intervals the condition refers to with @, in the
+// canonical form returned by NormalizeInterval
+func (p *Program) Intervals() []string {
+	return append([]string(nil), p.intervals...)
+}
+
+// History returns how many of the latest bars of each interval the condition
+// needs when evaluated on bars of the given interval. Besides the bars before
+// an indicator has a value, this includes the warm-up of smoothed indicators
+// such as EMA and RSI, so that their values match a long price history.
+func (p *Program) History(interval string) map[string]int {
+	if normalized, ok := NormalizeInterval(interval); ok {
+		interval = normalized
+	}
+	history := map[string]int{interval: p.bars}
+	for other, bars := range p.history {
+		history[other] = max(history[other], bars)
+	}
+	return history
+}
+
+// Eval evaluates the condition on the last bar. It also returns the latest
+// value of every indicator and price referenced by the condition.
+func (p *Program) Eval(in Input) (bool, map[string]float64, error) {
+	ctx, err := p.newContext(in)
+	if err != nil {
+		return false, nil, err
+	}
+
+	values := make(map[string]float64, len(p.watches))
+	for _, w := range p.watches {
+		series := w.num(ctx)
+		value := series[len(series)-1]
+		if math.IsNaN(value) {
+			bars := ctx.n
+			if w.interval != "" {
+				bars = ctx.timeframe(w.interval).n
+			}
+			if bars <= w.lookback {
+				return false, nil, fmt.Errorf("not enough history data to calculate %s (need at least %d bars, got %d)", w.name, w.lookback+1, bars)
+			}
+		}
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			values[w.name] = value
+		}
+	}
+
+	result := p.root.cond(ctx)
+	return result[len(result)-1], values, nil
+}
+
+// EvalSeries evaluates the condition on every bar, using only the bars up to
+// and including it. Bars without enough history for the condition are false.
+func (p *Program) EvalSeries(in Input) ([]bool, error) {
+	ctx, err := p.newContext(in)
+	if err != nil {
+		return nil, err
+	}
+	result := p.root.cond(ctx)
+	for i, ready := range p.ready(ctx) {
+		result[i] = result[i] && ready
+	}
+	return result, nil
+}
+
+// ready reports, for every bar, whether the condition has enough history on
+// it, in its own timeframe and in every timeframe referenced with @.
+// Comparisons with a missing value are false, but "not" and != would turn
+// them into signals.
+func (p *Program) ready(ctx *evalContext) []bool {
+	ready := make([]bool, ctx.n)
+	for i := range ready {
+		ready[i] = i >= p.root.lookback
+	}
+	for interval, lookback := range p.lookbacks {
+		if interval == ctx.interval {
+			for i := range ready {
+				ready[i] = ready[i] && i >= lookback
+			}
+			continue
+		}
+		for i, j := range ctx.alignment(ctx.timeframe(interval)) {
+			ready[i] = ready[i] && j >= lookback
+		}
+	}
+	return ready
+}
+
+func (p *Program) newContext(in Input) (*evalContext, error) {
+	if len(in.Bars) == 0 {
+		return nil, fmt.Errorf("no historical data available to evaluate condition")
+	}
+
+	ctx := newEvalContext(in.Bars)
+	ctx.input = in
+	if len(p.intervals) == 0 {
+		return ctx, nil
+	}
+
+	interval, ok := NormalizeInterval(in.Interval)
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval '%s' for a condition with timeframes", in.Interval)
+	}
+	ctx.interval = interval
+	for _, other := range p.intervals {
+		if other != interval && len(in.Timeframes[other]) == 0 {
+			return nil, fmt.Errorf("no %s historical data available to evaluate condition", other)
+		}
+	}
+	return ctx, nil
+}
+
+// evalContext holds the bars of one timeframe and memoizes series shared
+// between sub-expressions during a single evaluation
+type evalContext struct {
+	bars     []Bar
+	n        int
+	interval string
+	cache    map[string][]float64
+	outputs  map[string][][]float64
+
+	// Only set on the context of the condition's own timeframe
+	input      Input
+	timeframes map[string]*evalContext
+	alignments map[string][]int
+}
+
+func newEvalContext(bars []Bar) *evalContext {
+	return &evalContext{
+		bars:       bars,
+		n:          len(bars),
+		cache:      map[string][]float64{},
+		outputs:    map[string][][]float64{},
+		timeframes: map[string]*evalContext{},
+		alignments: map[string][]int{},
+	}
+}
+
+func (c *evalContext) cachedOutputs(key string, compute func() [][]float64) [][]float64 {
+	if outputs, ok := c.outputs[key]; ok {
+		return outputs
+	}
+	outputs := compute()
+	c.outputs[key] = outputs
+	return outputs
+}
+
+func (c *evalContext) cached(key string, compute func() []float64) []float64 {
+	if series, ok := c.cache[key]; ok {
+		return series
+	}
+	series := compute()
+	c.cache[key] = series
+	return series
+}
+
+func (c *evalContext) source(name string, field func(b Bar) float64) []float64 {
+	return c.cached(name, func() []float64 {
+		out := make([]float64, c.n)
+		for i, bar := range c.bars {
+			out[i] = field(bar)
+		}
+		return out
+	})
+}
+
+func (c *evalContext) constant(value float64) []float64 {
+	out := make([]float64, c.n)
+	for i := range out {
+		out[i] = value
+	}
+	return out
+}
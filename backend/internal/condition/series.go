@@ -0,0 +1,89 @@
+package condition
+
+// seriesFunction is a built-in that looks at the history of its arguments,
+// such as crossover(a, b) or rising(x, n). It evaluates to true/false.
+type seriesFunction struct {
+	usage    string
+	series   int  // Number of leading series arguments
+	length   bool // Whether a constant length follows the series arguments
+	evaluate func(args [][]float64, length int) []bool
+}
+
+var seriesFunctions = map[string]*seriesFunction{
+	// crossover is true on the bar where a moves from at or below b to above it
+	"crossover": {
+		usage:  "crossover(a, b)",
+		series: 2,
+		evaluate: func(args [][]float64, _ int) []bool {
+			return crosses(args[0], args[1])
+		},
+	},
+	// crossunder is true on the bar where a moves from at or above b to below it
+	"crossunder": {
+		usage:  "crossunder(a, b)",
+		series: 2,
+		evaluate: func(args [][]float64, _ int) []bool {
+			return crosses(args[1], args[0])
+		},
+	},
+	// rising is true when x is above each of its previous length values
+	"rising": {
+		usage:  "rising(source, length)",
+		series: 1,
+		length: true,
+		evaluate: func(args [][]float64, length int) []bool {
+			return trend(args[0], length, func(current, previous float64) bool { return current > previous })
+		},
+	},
+	// falling is true when x is below each of its previous length values
+	"falling": {
+		usage:  "falling(source, length)",
+		series: 1,
+		length: true,
+		evaluate: func(args [][]float64, length int) []bool {
+			return trend(args[0], length, func(current, previous float64) bool { return current < previous })
+		},
+	},
+}
+
+// crosses reports, for every bar, whether a crossed above b on that bar
+func crosses(a, b []float64) []bool {
+	out := make([]bool, len(a))
+	for i := 1; i < len(a); i++ {
+		out[i] = a[i] > b[i] && a[i-1] <= b[i-1]
+	}
+	return out
+}
+
+// trend reports, for every bar, whether the value compares true against each
+// of the previous length values
+func trend(x []float64, length int, compare func(current, previous float64) bool) []bool {
+	out := make([]bool, len(x))
+	for i := length; i < len(x); i++ {
+		out[i] = true
+		for k := 1; k <= length; k++ {
+			if !compare(x[i], x[i-k]) {
+				out[i] = false
+				break
+			}
+		}
+	}
+	return out
+}
+
+// shift moves a series back in time by offset bars, so that shift(x, 1)[i] is x[i-1]
+func shift(x []float64, offset int) []float64 {
+	out := nanSeries(len(x))
+	for i := offset; i < len(x); i++ {
+		out[i] = x[i-offset]
+	}
+	return out
+}
+
+func shiftBool(x []bool, offset int) []bool {
+	out := make([]bool, len(x))
+	for i := offset; i < len(x); i++ {
+		out[i] = x[i-offset]
+	}
+	return out
+}
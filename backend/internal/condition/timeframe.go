@@ -0,0 +1,119 @@
+package condition
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// intervals are the candle intervals a condition can refer to with @
+var intervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"3m":  3 * time.Minute,
+	"5m":  5 * time.Minute,
+	"10m": 10 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"2h":  2 * time.Hour,
+	"4h":  4 * time.Hour,
+	"D":   24 * time.Hour,
+	"W":   7 * 24 * time.Hour,
+}
+
+// NormalizeInterval returns the canonical form of an interval, such as "15m",
+// "1h" or "D", and reports whether the interval is supported
+func NormalizeInterval(interval string) (string, bool) {
+	interval = strings.ToLower(strings.TrimSpace(interval))
+	switch interval {
+	case "d", "1d":
+		interval = "D"
+	case "w", "1w":
+		interval = "W"
+	}
+	_, ok := intervals[interval]
+	return interval, ok
+}
+
+// IntervalDuration returns the length of a bar of the given interval
+func IntervalDuration(interval string) (time.Duration, error) {
+	normalized, ok := NormalizeInterval(interval)
+	if !ok {
+		return 0, fmt.Errorf("unsupported interval '%s'", interval)
+	}
+	return intervals[normalized], nil
+}
+
+// Input is the market data a program is evaluated on: the bars of the
+// condition's own interval, plus the bars of every other interval the
+// condition refers to with @ (see Program.Intervals).
+type Input struct {
+	Interval   string
+	Bars       []Bar
+	Timeframes map[string][]Bar
+}
+
+// timeframe returns the evaluation context for the bars of another interval
+func (c *evalContext) timeframe(interval string) *evalContext {
+	if interval == c.interval {
+		return c
+	}
+	if sub, ok := c.timeframes[interval]; ok {
+		return sub
+	}
+	sub := newEvalContext(c.input.Timeframes[interval])
+	sub.interval = interval
+	c.timeframes[interval] = sub
+	return sub
+}
+
+// align maps a series computed on another timeframe onto the bars of this
+// context. Each bar sees the last bar of the other timeframe that had closed
+// by the time this bar closed, so values never come from the future.
+func (c *evalContext) align(sub *evalContext, values []float64) []float64 {
+	if sub == c {
+		return values
+	}
+	out := nanSeries(c.n)
+	for i, j := range c.alignment(sub) {
+		if j >= 0 {
+			out[i] = values[j]
+		}
+	}
+	return out
+}
+
+func (c *evalContext) alignBool(sub *evalContext, values []bool) []bool {
+	if sub == c {
+		return values
+	}
+	out := make([]bool, c.n)
+	for i, j := range c.alignment(sub) {
+		if j >= 0 {
+			out[i] = values[j]
+		}
+	}
+	return out
+}
+
+// alignment returns, for every bar of this context, the index of the last
+// closed bar of sub, or -1 if none has closed yet
+func (c *evalContext) alignment(sub *evalContext) []int {
+	if index, ok := c.alignments[sub.interval]; ok {
+		return index
+	}
+
+	barLength := intervals[c.interval]
+	subLength := intervals[sub.interval]
+	index := make([]int, c.n)
+	j := -1
+	for i, bar := range c.bars {
+		closedAt := bar.Time.Add(barLength)
+		for j+1 < sub.n && !sub.bars[j+1].Time.Add(subLength).After(closedAt) {
+			j++
+		}
+		index[i] = j
+	}
+	c.alignments[sub.interval] = index
+	return index
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 	"trading-app/internal/models" // <--- ADD THIS LINE
 	_ "github.com/mattn/go-sqlite3"
@@ -116,6 +117,9 @@ func (db *DB) createTables() error {
 		user_id INTEGER NOT NULL,
 		strategy_id INTEGER,
 		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL DEFAULT '',
+		product TEXT NOT NULL DEFAULT '',
+		broker TEXT NOT NULL DEFAULT '',
 		action TEXT NOT NULL,
 		quantity INTEGER NOT NULL,
 		price REAL NOT NULL,
@@ -124,20 +128,307 @@ func (db *DB) createTables() error {
 		order_id TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		executed_at DATETIME,
+		ledger_applied INTEGER NOT NULL DEFAULT 0,
+		auto_order_id TEXT NOT NULL DEFAULT '',
+		broker_status TEXT NOT NULL DEFAULT '',
+		filled_action TEXT NOT NULL DEFAULT '',
+		filled_quantity INTEGER NOT NULL DEFAULT 0,
+		average_price REAL NOT NULL DEFAULT 0,
+		status_message TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME,
+		realized_pnl REAL NOT NULL DEFAULT 0,
 		FOREIGN KEY (user_id) REFERENCES users(id),
 		FOREIGN KEY (strategy_id) REFERENCES strategies(id)
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+	CREATE TABLE IF NOT EXISTS auto_orders (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		product TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		interval TEXT NOT NULL,
+		condition TEXT NOT NULL,
+		schedule_at TEXT NOT NULL DEFAULT '',
+		recurring BOOLEAN NOT NULL DEFAULT 0,
+		stop_loss TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		trailing_stop TEXT NOT NULL DEFAULT '',
+		trail_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'running',
+		state INTEGER NOT NULL DEFAULT 0,
+		condition_state BOOLEAN NOT NULL DEFAULT 0,
+		fire_count INTEGER NOT NULL DEFAULT 0,
+		last_fired_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS auto_order_fires (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		auto_order_id TEXT NOT NULL,
+		broker_order_id TEXT NOT NULL DEFAULT '',
+		trigger_values TEXT NOT NULL DEFAULT '{}',
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		fired_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (auto_order_id) REFERENCES auto_orders(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS candles (
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		interval TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		open REAL NOT NULL,
+		high REAL NOT NULL,
+		low REAL NOT NULL,
+		close REAL NOT NULL,
+		volume INTEGER NOT NULL DEFAULT 0,
+		oi INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (symbol, exchange, interval, timestamp)
+	);
+
+	CREATE TABLE IF NOT EXISTS broker_settings (
+		user_id INTEGER NOT NULL,
+		strategy_id INTEGER NOT NULL DEFAULT 0,
+		broker TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, strategy_id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS paper_accounts (
+		user_id INTEGER PRIMARY KEY,
+		cash REAL NOT NULL,
+		initial_cash REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS paper_positions (
+		user_id INTEGER NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		product TEXT NOT NULL,
+		quantity INTEGER NOT NULL DEFAULT 0,
+		average_price REAL NOT NULL DEFAULT 0,
+		realized_pnl REAL NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, symbol, exchange, product),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS paper_orders (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		strategy TEXT NOT NULL DEFAULT '',
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		action TEXT NOT NULL,
+		product TEXT NOT NULL,
+		price_type TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		price REAL NOT NULL DEFAULT 0,
+		trigger_price REAL NOT NULL DEFAULT 0,
+		filled_quantity INTEGER NOT NULL DEFAULT 0,
+		average_price REAL NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS open_positions (
+		user_id INTEGER NOT NULL,
+		broker TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		product TEXT NOT NULL,
+		quantity INTEGER NOT NULL DEFAULT 0,
+		entry_price REAL NOT NULL DEFAULT 0,
+		realized_pnl REAL NOT NULL DEFAULT 0,
+		broker_quantity INTEGER,
+		mismatch INTEGER NOT NULL DEFAULT 0,
+		reconciled_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, broker, symbol, exchange, product),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS position_lots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		broker TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		product TEXT NOT NULL,
+		trade_id INTEGER NOT NULL DEFAULT 0,
+		quantity INTEGER NOT NULL,
+		price REAL NOT NULL,
+		opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS brackets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		auto_order_id TEXT NOT NULL DEFAULT '',
+		broker TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		product TEXT NOT NULL,
+		action TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		interval TEXT NOT NULL DEFAULT '',
+		stop_loss_spec TEXT NOT NULL DEFAULT '',
+		target_spec TEXT NOT NULL DEFAULT '',
+		trailing_stop TEXT NOT NULL DEFAULT '',
+		trail_on TEXT NOT NULL DEFAULT '',
+		entry_trade_id INTEGER NOT NULL,
+		entry_price REAL NOT NULL DEFAULT 0,
+		stop_loss REAL NOT NULL DEFAULT 0,
+		target REAL NOT NULL DEFAULT 0,
+		atr REAL NOT NULL DEFAULT 0,
+		extreme_price REAL NOT NULL DEFAULT 0,
+		trailed_at DATETIME,
+		status TEXT NOT NULL DEFAULT 'pending',
+		exit_reason TEXT NOT NULL DEFAULT '',
+		exit_trade_id INTEGER,
+		exit_attempts INTEGER NOT NULL DEFAULT 0,
+		trigger_price REAL NOT NULL DEFAULT 0,
+		exit_price REAL NOT NULL DEFAULT 0,
+		realized_pnl REAL NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		closed_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (entry_trade_id) REFERENCES trades(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS risk_limits (
+		user_id INTEGER PRIMARY KEY,
+		max_order_value REAL NOT NULL DEFAULT 0,
+		max_symbol_quantity INTEGER NOT NULL DEFAULT 0,
+		max_open_positions INTEGER NOT NULL DEFAULT 0,
+		max_daily_loss REAL NOT NULL DEFAULT 0,
+		max_orders_per_minute INTEGER NOT NULL DEFAULT 0,
+		blocked_symbols TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS risk_rejections (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		action TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		price REAL NOT NULL DEFAULT 0,
+		rule TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS square_off_settings (
+		user_id INTEGER PRIMARY KEY,
+		square_off_time TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
 	CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token);
 	CREATE INDEX IF NOT EXISTS idx_chat_messages_user_id ON chat_messages(user_id);
 	CREATE INDEX IF NOT EXISTS idx_files_user_id ON files(user_id);
 	CREATE INDEX IF NOT EXISTS idx_strategies_user_id ON strategies(user_id);
 	CREATE INDEX IF NOT EXISTS idx_trades_user_id ON trades(user_id);
+	CREATE INDEX IF NOT EXISTS idx_trades_status ON trades(status);
+	CREATE INDEX IF NOT EXISTS idx_auto_orders_user_id ON auto_orders(user_id);
+	CREATE INDEX IF NOT EXISTS idx_auto_orders_status ON auto_orders(status);
+	CREATE INDEX IF NOT EXISTS idx_auto_order_fires_auto_order_id ON auto_order_fires(auto_order_id);
+	CREATE INDEX IF NOT EXISTS idx_paper_orders_user_id ON paper_orders(user_id, status);
+	CREATE INDEX IF NOT EXISTS idx_position_lots_position ON position_lots(user_id, broker, symbol, exchange, product);
+	CREATE INDEX IF NOT EXISTS idx_brackets_status ON brackets(status);
+	CREATE INDEX IF NOT EXISTS idx_brackets_user_id ON brackets(user_id);
+	CREATE INDEX IF NOT EXISTS idx_risk_rejections_user_id ON risk_rejections(user_id, created_at);
 	`
 
-	_, err := db.conn.Exec(schema)
-	return err
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+	return db.addColumns()
+}
+
+// addedColumns are columns added to tables after databases were created
+// with them, so that existing databases are brought up to date
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"trades", "exchange", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "product", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "broker", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "ledger_applied", "INTEGER NOT NULL DEFAULT 0"},
+	{"trades", "auto_order_id", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "broker_status", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "filled_quantity", "INTEGER NOT NULL DEFAULT 0"},
+	{"trades", "average_price", "REAL NOT NULL DEFAULT 0"},
+	{"trades", "status_message", "TEXT NOT NULL DEFAULT ''"},
+	{"trades", "updated_at", "DATETIME"},
+	{"auto_orders", "stop_loss", "TEXT NOT NULL DEFAULT ''"},
+	{"auto_orders", "target", "TEXT NOT NULL DEFAULT ''"},
+	{"auto_orders", "trailing_stop", "TEXT NOT NULL DEFAULT ''"},
+	{"auto_orders", "trail_on", "TEXT NOT NULL DEFAULT ''"},
+	{"auto_orders", "schedule_at", "TEXT NOT NULL DEFAULT ''"},
+	{"auto_orders", "recurring", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"brackets", "trailing_stop", "TEXT NOT NULL DEFAULT ''"},
+	{"brackets", "trail_on", "TEXT NOT NULL DEFAULT ''"},
+	{"brackets", "atr", "REAL NOT NULL DEFAULT 0"},
+	{"brackets", "extreme_price", "REAL NOT NULL DEFAULT 0"},
+	{"brackets", "trailed_at", "DATETIME"},
+	{"brackets", "exit_attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"trades", "realized_pnl", "REAL NOT NULL DEFAULT 0"},
+	{"trades", "filled_action", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addColumns adds the columns an existing database is missing
+func (db *DB) addColumns() error {
+	for _, added := range addedColumns {
+		var count int
+		if err := db.conn.QueryRow(
+			"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+			added.table, added.column,
+		).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", added.table, added.column, added.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", added.table, added.column, err)
+		}
+		log.Printf("DB: Added column %s.%s", added.table, added.column)
+	}
+	return nil
 }
 
 // User operations
@@ -387,10 +678,20 @@ func (db *DB) UpdateStrategyStatus(id int, status string) error {
 }
 
 // Trade operations
+const tradeColumns = "id, user_id, strategy_id, auto_order_id, symbol, exchange, product, broker, action, quantity, price, order_type, status, order_id, broker_status, filled_action, filled_quantity, average_price, status_message, created_at, updated_at, executed_at"
+
+func scanTrade(row rowScanner) (*models.Trade, error) {
+	trade := &models.Trade{}
+	var orderID sql.NullString
+	err := row.Scan(&trade.ID, &trade.UserID, &trade.StrategyID, &trade.AutoOrderID, &trade.Symbol, &trade.Exchange, &trade.Product, &trade.Broker, &trade.Action, &trade.Quantity, &trade.Price, &trade.OrderType, &trade.Status, &orderID, &trade.BrokerStatus, &trade.FilledAction, &trade.FilledQuantity, &trade.AveragePrice, &trade.StatusMessage, &trade.CreatedAt, &trade.UpdatedAt, &trade.ExecutedAt)
+	trade.OrderID = orderID.String
+	return trade, err
+}
+
 func (db *DB) CreateTrade(trade *models.Trade) (*models.Trade, error) {
 	result, err := db.conn.Exec(
-		"INSERT INTO trades (user_id, strategy_id, symbol, action, quantity, price, order_type, status, order_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		trade.UserID, trade.StrategyID, trade.Symbol, trade.Action, trade.Quantity, trade.Price, trade.OrderType, trade.Status, trade.OrderID,
+		"INSERT INTO trades (user_id, strategy_id, auto_order_id, symbol, exchange, product, broker, action, quantity, price, order_type, status, order_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		trade.UserID, trade.StrategyID, trade.AutoOrderID, trade.Symbol, trade.Exchange, trade.Product, trade.Broker, trade.Action, trade.Quantity, trade.Price, trade.OrderType, trade.Status, trade.OrderID,
 	)
 	if err != nil {
 		return nil, err
@@ -405,23 +706,15 @@ func (db *DB) CreateTrade(trade *models.Trade) (*models.Trade, error) {
 }
 
 func (db *DB) GetTradeByID(id int) (*models.Trade, error) {
-	trade := &models.Trade{}
-	err := db.conn.QueryRow(
-		"SELECT id, user_id, strategy_id, symbol, action, quantity, price, order_type, status, order_id, created_at, executed_at FROM trades WHERE id = ?",
-		id,
-	).Scan(&trade.ID, &trade.UserID, &trade.StrategyID, &trade.Symbol, &trade.Action, &trade.Quantity, &trade.Price, &trade.OrderType, &trade.Status, &trade.OrderID, &trade.CreatedAt, &trade.ExecutedAt)
-
+	trade, err := scanTrade(db.conn.QueryRow("SELECT "+tradeColumns+" FROM trades WHERE id = ?", id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return trade, err
 }
 
-func (db *DB) GetTradesByUserID(userID int, limit int) ([]*models.Trade, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, user_id, strategy_id, symbol, action, quantity, price, order_type, status, order_id, created_at, executed_at FROM trades WHERE user_id = ? ORDER BY created_at DESC LIMIT ?",
-		userID, limit,
-	)
+func (db *DB) queryTrades(query string, args ...interface{}) ([]*models.Trade, error) {
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -429,15 +722,79 @@ func (db *DB) GetTradesByUserID(userID int, limit int) ([]*models.Trade, error)
 
 	trades := []*models.Trade{}
 	for rows.Next() {
-		trade := &models.Trade{}
-		err := rows.Scan(&trade.ID, &trade.UserID, &trade.StrategyID, &trade.Symbol, &trade.Action, &trade.Quantity, &trade.Price, &trade.OrderType, &trade.Status, &trade.OrderID, &trade.CreatedAt, &trade.ExecutedAt)
+		trade, err := scanTrade(rows)
 		if err != nil {
 			return nil, err
 		}
 		trades = append(trades, trade)
 	}
 
-	return trades, nil
+	return trades, rows.Err()
+}
+
+func (db *DB) GetTradesByUserID(userID int, limit int) ([]*models.Trade, error) {
+	return db.queryTrades("SELECT "+tradeColumns+" FROM trades WHERE user_id = ? ORDER BY created_at DESC LIMIT ?", userID, limit)
+}
+
+// GetUnappliedTrades returns the executed trades that are not in the position ledger yet, oldest first
+func (db *DB) GetUnappliedTrades() ([]*models.Trade, error) {
+	return db.queryTrades("SELECT " + tradeColumns + " FROM trades WHERE status = 'executed' AND ledger_applied = 0 ORDER BY executed_at ASC, id ASC")
+}
+
+// MarkTradeApplied records that a trade cannot be applied to the position ledger, so it is not retried
+func (db *DB) MarkTradeApplied(id int) error {
+	_, err := db.conn.Exec("UPDATE trades SET ledger_applied = 1 WHERE id = ?", id)
+	return err
+}
+
+// GetOpenTrades returns the pending trades that have a broker order to track, oldest first
+func (db *DB) GetOpenTrades() ([]*models.Trade, error) {
+	return db.queryTrades("SELECT "+tradeColumns+" FROM trades WHERE status = ? AND order_id IS NOT NULL AND order_id != '' ORDER BY created_at ASC, id ASC", models.TradePending)
+}
+
+// GetUserTradeByOrderID returns the trade of a user placed with a broker order ID
+func (db *DB) GetUserTradeByOrderID(userID int, orderID string) (*models.Trade, error) {
+	trade, err := scanTrade(db.conn.QueryRow("SELECT "+tradeColumns+" FROM trades WHERE user_id = ? AND order_id = ? ORDER BY id DESC LIMIT 1", userID, orderID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return trade, err
+}
+
+// GetOpenTradesByUserID returns the pending trades of a user that have a broker order, oldest first
+func (db *DB) GetOpenTradesByUserID(userID int) ([]*models.Trade, error) {
+	return db.queryTrades("SELECT "+tradeColumns+" FROM trades WHERE user_id = ? AND status = ? AND order_id IS NOT NULL AND order_id != '' ORDER BY created_at ASC, id ASC", userID, models.TradePending)
+}
+
+// UpdateTradeTerms saves the quantity, price and order type of a modified trade
+func (db *DB) UpdateTradeTerms(trade *models.Trade) error {
+	_, err := db.conn.Exec(
+		"UPDATE trades SET quantity = ?, price = ?, order_type = ?, updated_at = ? WHERE id = ?",
+		trade.Quantity, trade.Price, trade.OrderType, trade.UpdatedAt, trade.ID,
+	)
+	return err
+}
+
+// UpdateTradeOrder saves the order status, fill and timestamps of a tracked trade
+func (db *DB) UpdateTradeOrder(trade *models.Trade) error {
+	_, err := db.conn.Exec(
+		"UPDATE trades SET status = ?, broker_status = ?, filled_action = ?, filled_quantity = ?, average_price = ?, status_message = ?, updated_at = ?, executed_at = ? WHERE id = ?",
+		trade.Status, trade.BrokerStatus, trade.FilledAction, trade.FilledQuantity, trade.AveragePrice, trade.StatusMessage, trade.UpdatedAt, trade.ExecutedAt, trade.ID,
+	)
+	return err
+}
+
+// SetTradeOrderID records the broker order ID of a trade saved before its order was placed
+func (db *DB) SetTradeOrderID(id int, orderID string) error {
+	_, err := db.conn.Exec("UPDATE trades SET order_id = ?, updated_at = ? WHERE id = ?", orderID, time.Now(), id)
+	return err
+}
+
+// GetUnplacedTradesByUserID returns the pending trades of a user created since
+// a time that have no broker order ID: their order may have been placed
+// without its ID being recorded. created_at is stored in UTC.
+func (db *DB) GetUnplacedTradesByUserID(userID int, since time.Time) ([]*models.Trade, error) {
+	return db.queryTrades("SELECT "+tradeColumns+" FROM trades WHERE user_id = ? AND status = ? AND (order_id IS NULL OR order_id = '') AND created_at >= ? ORDER BY created_at ASC, id ASC", userID, models.TradePending, since.UTC().Format("2006-01-02 15:04:05"))
 }
 
 func (db *DB) UpdateTradeStatus(id int, status, orderID string) error {
@@ -502,56 +859,808 @@ func (db *DB) GetBacktestResultsByStrategyID(strategyID int) ([]*models.Backtest
 	return results, nil
 }
 
-// Close closes the database connection
-func (db *DB) Close() error {
-	return db.conn.Close()
+// Auto order operations
+const autoOrderColumns = "id, user_id, symbol, exchange, product, quantity, action, interval, condition, schedule_at, recurring, stop_loss, target, trailing_stop, trail_on, status, state, condition_state, fire_count, last_fired_at, last_error, expires_at, created_at, updated_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// Cleanup old sessions
-func (db *DB) CleanupExpiredSessions() error {
-	_, err := db.conn.Exec("DELETE FROM sessions WHERE expires_at < datetime('now')")
+func scanAutoOrder(row rowScanner) (*models.AutoOrder, error) {
+	order := &models.AutoOrder{}
+	var lastFiredAt sql.NullTime
+	err := row.Scan(&order.ID, &order.UserID, &order.Symbol, &order.Exchange, &order.Product, &order.Quantity, &order.Action, &order.Interval, &order.Condition, &order.ScheduleAt, &order.Recurring, &order.StopLoss, &order.Target, &order.TrailingStop, &order.TrailOn, &order.Status, &order.State, &order.ConditionState, &order.FireCount, &lastFiredAt, &order.LastError, &order.ExpiresAt, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastFiredAt.Valid {
+		order.LastFiredAt = &lastFiredAt.Time
+	}
+	return order, nil
+}
+
+func (db *DB) CreateAutoOrder(order *models.AutoOrder) error {
+	_, err := db.conn.Exec(
+		"INSERT INTO auto_orders (id, user_id, symbol, exchange, product, quantity, action, interval, condition, schedule_at, recurring, stop_loss, target, trailing_stop, trail_on, status, state, condition_state, expires_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		order.ID, order.UserID, order.Symbol, order.Exchange, order.Product, order.Quantity, order.Action, order.Interval, order.Condition, order.ScheduleAt, order.Recurring, order.StopLoss, order.Target, order.TrailingStop, order.TrailOn, order.Status, order.State, order.ConditionState, order.ExpiresAt, order.CreatedAt, order.CreatedAt,
+	)
 	return err
 }
 
-// Initialize creates a default admin user if no users exist
-func (db *DB) Initialize(username, passwordHash string) error {
-	var count int
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+func (db *DB) GetAutoOrderByID(id string) (*models.AutoOrder, error) {
+	order, err := scanAutoOrder(db.conn.QueryRow("SELECT "+autoOrderColumns+" FROM auto_orders WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return order, err
+}
+
+func (db *DB) queryAutoOrders(query string, args ...interface{}) ([]*models.AutoOrder, error) {
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if count == 0 {
-		log.Println("Creating default admin user...")
-		_, err = db.CreateUser(username, passwordHash)
+	orders := []*models.AutoOrder{}
+	for rows.Next() {
+		order, err := scanAutoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (db *DB) GetAutoOrdersByUserID(userID int, limit int) ([]*models.AutoOrder, error) {
+	return db.queryAutoOrders("SELECT "+autoOrderColumns+" FROM auto_orders WHERE user_id = ? ORDER BY created_at DESC LIMIT ?", userID, limit)
+}
+
+// GetRunningAutoOrders returns every auto order still marked as running, across all users.
+// Expiry is checked by the caller so that expired orders can be reported before being closed.
+func (db *DB) GetRunningAutoOrders() ([]*models.AutoOrder, error) {
+	return db.queryAutoOrders("SELECT "+autoOrderColumns+" FROM auto_orders WHERE status = 'running' ORDER BY created_at ASC")
+}
+
+func (db *DB) UpdateAutoOrderStatus(id, status string) error {
+	_, err := db.conn.Exec(
+		"UPDATE auto_orders SET status = ?, updated_at = ? WHERE id = ?",
+		status, time.Now(), id,
+	)
+	return err
+}
+
+// UpdateAutoOrderState persists the evaluation state of a running auto order
+func (db *DB) UpdateAutoOrderState(order *models.AutoOrder) error {
+	order.StateMux.RLock()
+	defer order.StateMux.RUnlock()
+
+	_, err := db.conn.Exec(
+		"UPDATE auto_orders SET state = ?, condition_state = ?, fire_count = ?, last_fired_at = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		order.State, order.ConditionState, order.FireCount, order.LastFiredAt, order.LastError, time.Now(), order.ID,
+	)
+	return err
+}
+
+func (db *DB) CreateAutoOrderFire(fire *models.AutoOrderFire) error {
+	if fire.FiredAt.IsZero() {
+		fire.FiredAt = time.Now()
+	}
+	result, err := db.conn.Exec(
+		"INSERT INTO auto_order_fires (auto_order_id, broker_order_id, trigger_values, status, error, fired_at) VALUES (?, ?, ?, ?, ?, ?)",
+		fire.AutoOrderID, fire.BrokerOrderID, fire.TriggerValues, fire.Status, fire.Error, fire.FiredAt,
+	)
+	if err != nil {
 		return err
 	}
 
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	fire.ID = int(id)
 	return nil
 }
 
-// GetOpenPositionsByUserID retrieves all open positions for a given user ID
-func (db *DB) GetOpenPositionsByUserID(userID int) ([]*models.OpenPosition, error) {
-	// NOTE: This is a simplified mock implementation for development/testing
-	// In a real application, this would query a persistent store (e.g., PostgreSQL, OpenAlgo)
-	log.Printf("DB: Fetching open positions for UserID %d (using mock data)", userID)
-
-	// Since you are trading with TCS and RELIANCE in your examples, 
-	// we will use them as mock positions.
-	return []*models.OpenPosition{
-		{
-			UserID:   userID,
-			Symbol:   "TCS",
-			Exchange: "NSE",
-			Quantity: 10,
-			EntryPrice: 3800.0,
-		},
-		{
-			UserID:   userID,
-			Symbol:   "RELIANCE",
-			Exchange: "NSE",
-			Quantity: 5,
-			EntryPrice: 2800.0,
-		},
-	}, nil
-}
\ No newline at end of file
+func (db *DB) GetAutoOrderFires(autoOrderID string, limit int) ([]*models.AutoOrderFire, error) {
+	rows, err := db.conn.Query(
+		"SELECT id, auto_order_id, broker_order_id, trigger_values, status, error, fired_at FROM auto_order_fires WHERE auto_order_id = ? ORDER BY fired_at DESC LIMIT ?",
+		autoOrderID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fires := []*models.AutoOrderFire{}
+	for rows.Next() {
+		fire := &models.AutoOrderFire{}
+		err := rows.Scan(&fire.ID, &fire.AutoOrderID, &fire.BrokerOrderID, &fire.TriggerValues, &fire.Status, &fire.Error, &fire.FiredAt)
+		if err != nil {
+			return nil, err
+		}
+		fires = append(fires, fire)
+	}
+
+	return fires, rows.Err()
+}
+
+// Candle operations
+
+// SaveCandles inserts candles, replacing stored candles with the same timestamp
+func (db *DB) SaveCandles(candles []*models.Candle) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO candles (symbol, exchange, interval, timestamp, open, high, low, close, volume, oi) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range candles {
+		if _, err := stmt.Exec(c.Symbol, c.Exchange, c.Interval, c.Timestamp, c.Open, c.High, c.Low, c.Close, c.Volume, c.OI); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetCandles returns the stored candles of a series from the given timestamp on, oldest first
+func (db *DB) GetCandles(symbol, exchange, interval string, since int64) ([]*models.Candle, error) {
+	rows, err := db.conn.Query(
+		"SELECT symbol, exchange, interval, timestamp, open, high, low, close, volume, oi FROM candles WHERE symbol = ? AND exchange = ? AND interval = ? AND timestamp >= ? ORDER BY timestamp",
+		symbol, exchange, interval, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := []*models.Candle{}
+	for rows.Next() {
+		c := &models.Candle{}
+		err := rows.Scan(&c.Symbol, &c.Exchange, &c.Interval, &c.Timestamp, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.OI)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+
+	return candles, rows.Err()
+}
+
+// DeleteCandlesBefore removes the stored candles of a series older than the given timestamp
+func (db *DB) DeleteCandlesBefore(symbol, exchange, interval string, before int64) error {
+	_, err := db.conn.Exec(
+		"DELETE FROM candles WHERE symbol = ? AND exchange = ? AND interval = ? AND timestamp < ?",
+		symbol, exchange, interval, before,
+	)
+	return err
+}
+
+// Broker setting operations
+
+// GetBrokerSetting returns the broker selected by a user for a strategy, or for
+// all their orders when strategyID is 0. It returns nil if none was selected.
+func (db *DB) GetBrokerSetting(userID, strategyID int) (*models.BrokerSetting, error) {
+	setting := &models.BrokerSetting{}
+	err := db.conn.QueryRow(
+		"SELECT user_id, strategy_id, broker, updated_at FROM broker_settings WHERE user_id = ? AND strategy_id = ?",
+		userID, strategyID,
+	).Scan(&setting.UserID, &setting.StrategyID, &setting.Broker, &setting.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return setting, err
+}
+
+func (db *DB) GetBrokerSettingsByUserID(userID int) ([]*models.BrokerSetting, error) {
+	rows, err := db.conn.Query(
+		"SELECT user_id, strategy_id, broker, updated_at FROM broker_settings WHERE user_id = ? ORDER BY strategy_id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := []*models.BrokerSetting{}
+	for rows.Next() {
+		setting := &models.BrokerSetting{}
+		if err := rows.Scan(&setting.UserID, &setting.StrategyID, &setting.Broker, &setting.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+
+	return settings, rows.Err()
+}
+
+func (db *DB) SetBrokerSetting(setting *models.BrokerSetting) error {
+	setting.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(
+		"INSERT OR REPLACE INTO broker_settings (user_id, strategy_id, broker, updated_at) VALUES (?, ?, ?, ?)",
+		setting.UserID, setting.StrategyID, setting.Broker, setting.UpdatedAt,
+	)
+	return err
+}
+
+// Paper trading operations
+const paperOrderColumns = "id, user_id, strategy, symbol, exchange, action, product, price_type, quantity, price, trigger_price, filled_quantity, average_price, status, message, created_at, updated_at"
+
+func scanPaperOrder(row rowScanner) (*models.PaperOrder, error) {
+	order := &models.PaperOrder{}
+	err := row.Scan(&order.ID, &order.UserID, &order.Strategy, &order.Symbol, &order.Exchange, &order.Action, &order.Product, &order.PriceType, &order.Quantity, &order.Price, &order.TriggerPrice, &order.FilledQuantity, &order.AveragePrice, &order.Status, &order.Message, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetPaperAccount returns the paper trading account of a user, or nil if it has not been opened
+func (db *DB) GetPaperAccount(userID int) (*models.PaperAccount, error) {
+	account := &models.PaperAccount{}
+	err := db.conn.QueryRow(
+		"SELECT user_id, cash, initial_cash, created_at, updated_at FROM paper_accounts WHERE user_id = ?",
+		userID,
+	).Scan(&account.UserID, &account.Cash, &account.InitialCash, &account.CreatedAt, &account.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return account, err
+}
+
+func (db *DB) CreatePaperAccount(account *models.PaperAccount) error {
+	now := time.Now()
+	account.CreatedAt, account.UpdatedAt = now, now
+	_, err := db.conn.Exec(
+		"INSERT OR IGNORE INTO paper_accounts (user_id, cash, initial_cash, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		account.UserID, account.Cash, account.InitialCash, now, now,
+	)
+	return err
+}
+
+func (db *DB) GetPaperPositions(userID int) ([]*models.PaperPosition, error) {
+	rows, err := db.conn.Query(
+		"SELECT user_id, symbol, exchange, product, quantity, average_price, realized_pnl, updated_at FROM paper_positions WHERE user_id = ? ORDER BY symbol",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := []*models.PaperPosition{}
+	for rows.Next() {
+		pos := &models.PaperPosition{}
+		if err := rows.Scan(&pos.UserID, &pos.Symbol, &pos.Exchange, &pos.Product, &pos.Quantity, &pos.AveragePrice, &pos.RealizedPnL, &pos.UpdatedAt); err != nil {
+			return nil, err
+		}
+		positions = append(positions, pos)
+	}
+
+	return positions, rows.Err()
+}
+
+// GetPaperPosition returns a paper position, or nil if the account never traded it
+func (db *DB) GetPaperPosition(userID int, symbol, exchange, product string) (*models.PaperPosition, error) {
+	pos := &models.PaperPosition{}
+	err := db.conn.QueryRow(
+		"SELECT user_id, symbol, exchange, product, quantity, average_price, realized_pnl, updated_at FROM paper_positions WHERE user_id = ? AND symbol = ? AND exchange = ? AND product = ?",
+		userID, symbol, exchange, product,
+	).Scan(&pos.UserID, &pos.Symbol, &pos.Exchange, &pos.Product, &pos.Quantity, &pos.AveragePrice, &pos.RealizedPnL, &pos.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pos, err
+}
+
+func (db *DB) CreatePaperOrder(order *models.PaperOrder) error {
+	now := time.Now()
+	order.CreatedAt, order.UpdatedAt = now, now
+	_, err := db.conn.Exec(
+		"INSERT INTO paper_orders ("+paperOrderColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		order.ID, order.UserID, order.Strategy, order.Symbol, order.Exchange, order.Action, order.Product, order.PriceType, order.Quantity, order.Price, order.TriggerPrice, order.FilledQuantity, order.AveragePrice, order.Status, order.Message, now, now,
+	)
+	return err
+}
+
+// GetPaperOrder returns a paper order of a user, or nil if it does not exist
+func (db *DB) GetPaperOrder(userID int, id string) (*models.PaperOrder, error) {
+	order, err := scanPaperOrder(db.conn.QueryRow("SELECT "+paperOrderColumns+" FROM paper_orders WHERE user_id = ? AND id = ?", userID, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return order, err
+}
+
+func (db *DB) GetOpenPaperOrders(userID int) ([]*models.PaperOrder, error) {
+	rows, err := db.conn.Query("SELECT "+paperOrderColumns+" FROM paper_orders WHERE user_id = ? AND status = 'open' ORDER BY created_at ASC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*models.PaperOrder{}
+	for rows.Next() {
+		order, err := scanPaperOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+// UpdatePaperOrder saves the price, quantity and status of a paper order that did not fill
+func (db *DB) UpdatePaperOrder(order *models.PaperOrder) error {
+	order.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(
+		"UPDATE paper_orders SET price_type = ?, quantity = ?, price = ?, trigger_price = ?, status = ?, message = ?, updated_at = ? WHERE id = ?",
+		order.PriceType, order.Quantity, order.Price, order.TriggerPrice, order.Status, order.Message, order.UpdatedAt, order.ID,
+	)
+	return err
+}
+
+// RecordPaperFill saves a filled paper order together with the resulting
+// position and the cash it moved, so the ledger is never half updated
+func (db *DB) RecordPaperFill(order *models.PaperOrder, position *models.PaperPosition, cashChange float64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	order.UpdatedAt = now
+	position.UpdatedAt = now
+
+	if _, err := tx.Exec(
+		"UPDATE paper_orders SET price_type = ?, quantity = ?, price = ?, trigger_price = ?, filled_quantity = ?, average_price = ?, status = ?, message = ?, updated_at = ? WHERE id = ?",
+		order.PriceType, order.Quantity, order.Price, order.TriggerPrice, order.FilledQuantity, order.AveragePrice, order.Status, order.Message, now, order.ID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO paper_positions (user_id, symbol, exchange, product, quantity, average_price, realized_pnl, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		position.UserID, position.Symbol, position.Exchange, position.Product, position.Quantity, position.AveragePrice, position.RealizedPnL, now,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE paper_accounts SET cash = cash + ?, updated_at = ? WHERE user_id = ?",
+		cashChange, now, order.UserID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the database connection
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// Cleanup old sessions
+func (db *DB) CleanupExpiredSessions() error {
+	_, err := db.conn.Exec("DELETE FROM sessions WHERE expires_at < datetime('now')")
+	return err
+}
+
+// Initialize creates a default admin user if no users exist
+func (db *DB) Initialize(username, passwordHash string) error {
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		log.Println("Creating default admin user...")
+		_, err = db.CreateUser(username, passwordHash)
+		return err
+	}
+
+	return nil
+}
+
+// Position ledger operations
+const openPositionColumns = "user_id, broker, symbol, exchange, product, quantity, entry_price, realized_pnl, broker_quantity, mismatch, reconciled_at, created_at, updated_at"
+
+func scanOpenPosition(row rowScanner) (*models.OpenPosition, error) {
+	pos := &models.OpenPosition{}
+	var brokerQuantity sql.NullInt64
+	var reconciledAt sql.NullTime
+	err := row.Scan(&pos.UserID, &pos.Broker, &pos.Symbol, &pos.Exchange, &pos.Product, &pos.Quantity, &pos.EntryPrice, &pos.RealizedPnL, &brokerQuantity, &pos.Mismatch, &reconciledAt, &pos.CreatedAt, &pos.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if brokerQuantity.Valid {
+		quantity := int(brokerQuantity.Int64)
+		pos.BrokerQuantity = &quantity
+	}
+	if reconciledAt.Valid {
+		pos.ReconciledAt = &reconciledAt.Time
+	}
+	return pos, nil
+}
+
+func (db *DB) queryOpenPositions(query string, args ...interface{}) ([]*models.OpenPosition, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := []*models.OpenPosition{}
+	for rows.Next() {
+		pos, err := scanOpenPosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, pos)
+	}
+
+	return positions, rows.Err()
+}
+
+// GetOpenPositionsByUserID retrieves the ledger positions of a user that are still open
+func (db *DB) GetOpenPositionsByUserID(userID int) ([]*models.OpenPosition, error) {
+	return db.queryOpenPositions("SELECT "+openPositionColumns+" FROM open_positions WHERE user_id = ? AND quantity != 0 ORDER BY symbol, exchange, product", userID)
+}
+
+// GetPositionLedger retrieves every ledger position of a user, including
+// closed ones and those only the broker reported
+func (db *DB) GetPositionLedger(userID int) ([]*models.OpenPosition, error) {
+	return db.queryOpenPositions("SELECT "+openPositionColumns+" FROM open_positions WHERE user_id = ? ORDER BY symbol, exchange, product", userID)
+}
+
+// GetOpenPosition returns a ledger position, or nil if the user never held it
+func (db *DB) GetOpenPosition(userID int, broker, symbol, exchange, product string) (*models.OpenPosition, error) {
+	pos, err := scanOpenPosition(db.conn.QueryRow(
+		"SELECT "+openPositionColumns+" FROM open_positions WHERE user_id = ? AND broker = ? AND symbol = ? AND exchange = ? AND product = ?",
+		userID, broker, symbol, exchange, product,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pos, err
+}
+
+// GetPositionLots returns the open lots of a ledger position, oldest first
+func (db *DB) GetPositionLots(userID int, broker, symbol, exchange, product string) ([]*models.PositionLot, error) {
+	rows, err := db.conn.Query(
+		"SELECT id, user_id, broker, symbol, exchange, product, trade_id, quantity, price, opened_at FROM position_lots WHERE user_id = ? AND broker = ? AND symbol = ? AND exchange = ? AND product = ? ORDER BY opened_at ASC, id ASC",
+		userID, broker, symbol, exchange, product,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []*models.PositionLot{}
+	for rows.Next() {
+		lot := &models.PositionLot{}
+		if err := rows.Scan(&lot.ID, &lot.UserID, &lot.Broker, &lot.Symbol, &lot.Exchange, &lot.Product, &lot.TradeID, &lot.Quantity, &lot.Price, &lot.OpenedAt); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	return lots, rows.Err()
+}
+
+// RecordPositionFill saves a ledger position with its remaining open lots and
+// marks the trade that changed it as applied with the P&L it realized, in one
+// transaction
+func (db *DB) RecordPositionFill(position *models.OpenPosition, lots []*models.PositionLot, tradeID int, realized float64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	position.UpdatedAt = now
+	if position.CreatedAt.IsZero() {
+		position.CreatedAt = now
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM position_lots WHERE user_id = ? AND broker = ? AND symbol = ? AND exchange = ? AND product = ?",
+		position.UserID, position.Broker, position.Symbol, position.Exchange, position.Product,
+	); err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if _, err := tx.Exec(
+			"INSERT INTO position_lots (user_id, broker, symbol, exchange, product, trade_id, quantity, price, opened_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			position.UserID, position.Broker, position.Symbol, position.Exchange, position.Product, lot.TradeID, lot.Quantity, lot.Price, lot.OpenedAt,
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO open_positions ("+openPositionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		position.UserID, position.Broker, position.Symbol, position.Exchange, position.Product, position.Quantity, position.EntryPrice, position.RealizedPnL,
+		position.BrokerQuantity, position.Mismatch, position.ReconciledAt, position.CreatedAt, now,
+	); err != nil {
+		return err
+	}
+	if tradeID != 0 {
+		if _, err := tx.Exec("UPDATE trades SET ledger_applied = 1, realized_pnl = ? WHERE id = ?", realized, tradeID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SavePositionReconciliation records the quantity the broker reported for a
+// ledger position, creating the position if only the broker knows it
+func (db *DB) SavePositionReconciliation(position *models.OpenPosition) error {
+	now := time.Now()
+	position.ReconciledAt = &now
+	_, err := db.conn.Exec(
+		`INSERT INTO open_positions (user_id, broker, symbol, exchange, product, broker_quantity, mismatch, reconciled_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, broker, symbol, exchange, product)
+		DO UPDATE SET broker_quantity = excluded.broker_quantity, mismatch = excluded.mismatch, reconciled_at = excluded.reconciled_at`,
+		position.UserID, position.Broker, position.Symbol, position.Exchange, position.Product, position.BrokerQuantity, position.Mismatch, now, now, now,
+	)
+	return err
+}
+
+// GetLedgerUserIDs returns the users that have traded or hold ledger positions
+func (db *DB) GetLedgerUserIDs() ([]int, error) {
+	rows, err := db.conn.Query("SELECT user_id FROM open_positions UNION SELECT user_id FROM trades ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Bracket operations
+const bracketColumns = "id, user_id, auto_order_id, broker, symbol, exchange, product, action, quantity, interval, stop_loss_spec, target_spec, trailing_stop, trail_on, entry_trade_id, entry_price, stop_loss, target, atr, extreme_price, trailed_at, status, exit_reason, exit_trade_id, exit_attempts, trigger_price, exit_price, realized_pnl, last_error, created_at, updated_at, closed_at"
+
+func scanBracket(row rowScanner) (*models.Bracket, error) {
+	b := &models.Bracket{}
+	var exitTradeID sql.NullInt64
+	err := row.Scan(&b.ID, &b.UserID, &b.AutoOrderID, &b.Broker, &b.Symbol, &b.Exchange, &b.Product, &b.Action, &b.Quantity, &b.Interval, &b.StopLossSpec, &b.TargetSpec, &b.TrailingStop, &b.TrailOn, &b.EntryTradeID, &b.EntryPrice, &b.StopLoss, &b.Target, &b.ATR, &b.ExtremePrice, &b.TrailedAt, &b.Status, &b.ExitReason, &exitTradeID, &b.ExitAttempts, &b.TriggerPrice, &b.ExitPrice, &b.RealizedPnL, &b.LastError, &b.CreatedAt, &b.UpdatedAt, &b.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+	if exitTradeID.Valid {
+		id := int(exitTradeID.Int64)
+		b.ExitTradeID = &id
+	}
+	return b, nil
+}
+
+func (db *DB) CreateBracket(b *models.Bracket) error {
+	now := time.Now()
+	b.CreatedAt, b.UpdatedAt = now, now
+	result, err := db.conn.Exec(
+		"INSERT INTO brackets (user_id, auto_order_id, broker, symbol, exchange, product, action, quantity, interval, stop_loss_spec, target_spec, trailing_stop, trail_on, entry_trade_id, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		b.UserID, b.AutoOrderID, b.Broker, b.Symbol, b.Exchange, b.Product, b.Action, b.Quantity, b.Interval, b.StopLossSpec, b.TargetSpec, b.TrailingStop, b.TrailOn, b.EntryTradeID, b.Status, now, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	b.ID = int(id)
+	return nil
+}
+
+// GetBracket returns a bracket of a user, or nil if it does not exist
+func (db *DB) GetBracket(userID, id int) (*models.Bracket, error) {
+	b, err := scanBracket(db.conn.QueryRow("SELECT "+bracketColumns+" FROM brackets WHERE id = ? AND user_id = ?", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (db *DB) queryBrackets(query string, args ...interface{}) ([]*models.Bracket, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	brackets := []*models.Bracket{}
+	for rows.Next() {
+		b, err := scanBracket(rows)
+		if err != nil {
+			return nil, err
+		}
+		brackets = append(brackets, b)
+	}
+
+	return brackets, rows.Err()
+}
+
+// GetOpenBrackets returns the brackets that are waiting for their entry,
+// watching their legs or exiting, oldest first
+func (db *DB) GetOpenBrackets() ([]*models.Bracket, error) {
+	return db.queryBrackets("SELECT "+bracketColumns+" FROM brackets WHERE status IN (?, ?, ?) ORDER BY id ASC", models.BracketPending, models.BracketActive, models.BracketExiting)
+}
+
+// GetBracketsByUserID returns the latest brackets of a user, newest first
+func (db *DB) GetBracketsByUserID(userID, limit int) ([]*models.Bracket, error) {
+	return db.queryBrackets("SELECT "+bracketColumns+" FROM brackets WHERE user_id = ? ORDER BY id DESC LIMIT ?", userID, limit)
+}
+
+// GetBracketsByTradeID returns the brackets a trade is the entry or exit of
+func (db *DB) GetBracketsByTradeID(tradeID int) ([]*models.Bracket, error) {
+	return db.queryBrackets("SELECT "+bracketColumns+" FROM brackets WHERE entry_trade_id = ? OR exit_trade_id = ? ORDER BY id ASC", tradeID, tradeID)
+}
+
+// UpdateBracket saves the levels, status and exit of a bracket
+func (db *DB) UpdateBracket(b *models.Bracket) error {
+	b.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(
+		"UPDATE brackets SET quantity = ?, entry_price = ?, stop_loss = ?, target = ?, atr = ?, extreme_price = ?, trailed_at = ?, status = ?, exit_reason = ?, exit_trade_id = ?, exit_attempts = ?, trigger_price = ?, exit_price = ?, realized_pnl = ?, last_error = ?, updated_at = ?, closed_at = ? WHERE id = ?",
+		b.Quantity, b.EntryPrice, b.StopLoss, b.Target, b.ATR, b.ExtremePrice, b.TrailedAt, b.Status, b.ExitReason, b.ExitTradeID, b.ExitAttempts, b.TriggerPrice, b.ExitPrice, b.RealizedPnL, b.LastError, b.UpdatedAt, b.ClosedAt, b.ID,
+	)
+	return err
+}
+
+// Risk operations
+
+// GetRiskLimits returns the limits of a user, or nil if none were set
+func (db *DB) GetRiskLimits(userID int) (*models.RiskLimits, error) {
+	limits := &models.RiskLimits{UserID: userID}
+	var blocked string
+	err := db.conn.QueryRow(
+		"SELECT max_order_value, max_symbol_quantity, max_open_positions, max_daily_loss, max_orders_per_minute, blocked_symbols, updated_at FROM risk_limits WHERE user_id = ?",
+		userID,
+	).Scan(&limits.MaxOrderValue, &limits.MaxSymbolQuantity, &limits.MaxOpenPositions, &limits.MaxDailyLoss, &limits.MaxOrdersPerMinute, &blocked, &limits.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	limits.BlockedSymbols = []string{}
+	if blocked != "" {
+		limits.BlockedSymbols = strings.Split(blocked, ",")
+	}
+	return limits, nil
+}
+
+// SaveRiskLimits sets the limits of a user
+func (db *DB) SaveRiskLimits(limits *models.RiskLimits) error {
+	limits.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(
+		"INSERT OR REPLACE INTO risk_limits (user_id, max_order_value, max_symbol_quantity, max_open_positions, max_daily_loss, max_orders_per_minute, blocked_symbols, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		limits.UserID, limits.MaxOrderValue, limits.MaxSymbolQuantity, limits.MaxOpenPositions, limits.MaxDailyLoss, limits.MaxOrdersPerMinute, strings.Join(limits.BlockedSymbols, ","), limits.UpdatedAt,
+	)
+	return err
+}
+
+// GetRealizedPnLSince returns the P&L the ledger realized from a user's trades
+// executed since the given time
+func (db *DB) GetRealizedPnLSince(userID int, since time.Time) (float64, error) {
+	var realized float64
+	// Timestamps are stored with their zone offsets; julianday compares them in UTC
+	err := db.conn.QueryRow(
+		"SELECT COALESCE(SUM(realized_pnl), 0) FROM trades WHERE user_id = ? AND ledger_applied = 1 AND julianday(COALESCE(executed_at, updated_at, created_at)) >= julianday(?)",
+		userID, since.UTC().Format("2006-01-02 15:04:05"),
+	).Scan(&realized)
+	return realized, err
+}
+
+func (db *DB) CreateRiskRejection(rejection *models.RiskRejection) error {
+	rejection.CreatedAt = time.Now()
+	result, err := db.conn.Exec(
+		"INSERT INTO risk_rejections (user_id, source, symbol, exchange, action, quantity, price, rule, reason, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		rejection.UserID, rejection.Source, rejection.Symbol, rejection.Exchange, rejection.Action, rejection.Quantity, rejection.Price, rejection.Rule, rejection.Reason, rejection.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rejection.ID = int(id)
+	return nil
+}
+
+// GetRiskRejections returns the latest rejected orders of a user, newest first
+func (db *DB) GetRiskRejections(userID, limit int) ([]*models.RiskRejection, error) {
+	rows, err := db.conn.Query(
+		"SELECT id, user_id, source, symbol, exchange, action, quantity, price, rule, reason, created_at FROM risk_rejections WHERE user_id = ? ORDER BY id DESC LIMIT ?",
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rejections := []*models.RiskRejection{}
+	for rows.Next() {
+		r := &models.RiskRejection{}
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Source, &r.Symbol, &r.Exchange, &r.Action, &r.Quantity, &r.Price, &r.Rule, &r.Reason, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rejections = append(rejections, r)
+	}
+
+	return rejections, rows.Err()
+}
+
+// Square-off operations
+
+// GetSquareOffSetting returns the square-off setting of a user, or nil if none was set
+func (db *DB) GetSquareOffSetting(userID int) (*models.SquareOffSetting, error) {
+	setting := &models.SquareOffSetting{UserID: userID}
+	err := db.conn.QueryRow(
+		"SELECT square_off_time, enabled, updated_at FROM square_off_settings WHERE user_id = ?",
+		userID,
+	).Scan(&setting.Time, &setting.Enabled, &setting.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// SaveSquareOffSetting sets the square-off setting of a user
+func (db *DB) SaveSquareOffSetting(setting *models.SquareOffSetting) error {
+	setting.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(
+		"INSERT OR REPLACE INTO square_off_settings (user_id, square_off_time, enabled, updated_at) VALUES (?, ?, ?, ?)",
+		setting.UserID, setting.Time, setting.Enabled, setting.UpdatedAt,
+	)
+	return err
+}
+
+// Settings operations
+
+// GetSetting returns an application setting, or "" if it was never set
+func (db *DB) GetSetting(key string) (string, error) {
+	var value string
+	err := db.conn.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (db *DB) SetSetting(key, value string) error {
+	_, err := db.conn.Exec(
+		"INSERT OR REPLACE INTO settings (key, value, updated_at) VALUES (?, ?, ?)",
+		key, value, time.Now(),
+	)
+	return err
+}
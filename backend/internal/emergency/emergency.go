@@ -0,0 +1,444 @@
+// Package emergency takes a user out of the market in one step: it stops
+// their auto orders and brackets, withdraws their open broker orders and
+// squares off their open positions, either all of them or those in a scope,
+// such as the intraday ones.
+package emergency
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/autoorder"
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+	"trading-app/internal/orders"
+)
+
+// Strategy is the strategy name sent with the square-off orders of a panic
+const Strategy = "panic"
+
+// Results of the steps of a liquidation
+const (
+	ResultCancelled   = "cancelled"    // The auto order, bracket or broker order was stopped
+	ResultKept        = "kept"         // A market exit already on its way was left alone
+	ResultSquaredOff  = "squared_off"  // An exit order was placed for the position
+	ResultExitPending = "exit_pending" // Exits already on their way close the position
+	ResultFailed      = "failed"
+)
+
+// Outcome is what a liquidation did with one auto order, bracket, broker order or position
+type Outcome struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Result      string `json:"result"` // One of the Result constants
+	Detail      string `json:"detail,omitempty"`
+}
+
+// Report lists the outcome of every step of a liquidation
+type Report struct {
+	AutoOrders []Outcome `json:"auto_orders"`
+	Brackets   []Outcome `json:"brackets"`
+	Orders     []Outcome `json:"orders"`
+	Positions  []Outcome `json:"positions"`
+	Failures   int       `json:"failures"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// Scope selects what a liquidation takes out of the market
+type Scope struct {
+	// Name is sent as the strategy of the square-off orders and tags the logs
+	Name string
+	// Match selects by exchange and product; nil selects everything
+	Match func(exchange, product string) bool
+	// Keep leaves the selected auto orders it returns true for running
+	Keep func(order *models.AutoOrder) bool
+}
+
+func (s Scope) matches(exchange, product string) bool {
+	return s.Match == nil || s.Match(strings.ToUpper(exchange), strings.ToUpper(product))
+}
+
+// logf logs a message tagged with the name of the scope, as "PANIC: ..."
+func (s Scope) logf(format string, args ...interface{}) {
+	log.Printf(strings.ToUpper(strings.ReplaceAll(s.Name, "_", "-"))+": "+format, args...)
+}
+
+// Liquidator runs liquidations. A liquidation can be repeated safely: exits
+// already on their way are counted, never doubled, so a second one only
+// retries what the first one could not do.
+type Liquidator struct {
+	db       *database.DB
+	brokers  *broker.Selector
+	engine   *autoorder.Engine
+	orders   *orders.Tracker
+	brackets *bracket.Manager
+
+	// Liquidations run one at a time, so that concurrent calls do not both place exits
+	mu sync.Mutex
+}
+
+// NewLiquidator creates a Liquidator
+func NewLiquidator(db *database.DB, brokers *broker.Selector, engine *autoorder.Engine, tracker *orders.Tracker, brackets *bracket.Manager) *Liquidator {
+	return &Liquidator{
+		db:       db,
+		brokers:  brokers,
+		engine:   engine,
+		orders:   tracker,
+		brackets: brackets,
+	}
+}
+
+// Panic cancels every running auto order and open bracket of a user, cancels
+// their open broker orders except market exits already on their way, and
+// squares off the rest of their ledger positions at market. Square-offs only
+// reduce positions, so they bypass the risk checks and the kill switch.
+func (l *Liquidator) Panic(userID int) *Report {
+	log.Printf("PANIC: User %d pressed the panic button", userID)
+	report := l.Liquidate(userID, Scope{Name: Strategy})
+	log.Printf("PANIC: User %d: %d auto order(s), %d bracket(s), %d order(s), %d position(s), %d failure(s)",
+		userID, len(report.AutoOrders), len(report.Brackets), len(report.Orders), len(report.Positions), report.Failures)
+	return report
+}
+
+// Liquidate does what Panic does, for the auto orders, brackets, broker
+// orders and ledger positions of a user in scope only. Positions the brokers
+// hold other than the ledger has them are reported as failures to close with
+// the broker.
+func (l *Liquidator) Liquidate(userID int, scope Scope) *Report {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := &Report{AutoOrders: []Outcome{}, Brackets: []Outcome{}, Orders: []Outcome{}, Positions: []Outcome{}, StartedAt: time.Now()}
+
+	// The books are read before any exit is placed, as they fill before the ledger sees them
+	untracked := l.untracked(userID, scope)
+	l.cancelAutoOrders(userID, scope, report)
+	l.cancelBrackets(userID, scope, report)
+	exits := l.cancelOrders(userID, scope, report)
+	l.squareOff(userID, scope, exits, report)
+	report.Positions = append(report.Positions, untracked...)
+
+	for _, step := range [][]Outcome{report.AutoOrders, report.Brackets, report.Orders, report.Positions} {
+		for _, outcome := range step {
+			if outcome.Result == ResultFailed {
+				report.Failures++
+			}
+		}
+	}
+	return report
+}
+
+// cancelAutoOrders stops the running auto orders of a user
+func (l *Liquidator) cancelAutoOrders(userID int, scope Scope, report *Report) {
+	for _, order := range l.engine.List(userID) {
+		if !scope.matches(order.Exchange, order.Product) || (scope.Keep != nil && scope.Keep(order)) {
+			continue
+		}
+		outcome := Outcome{
+			ID:          order.ID,
+			Description: fmt.Sprintf("%s %d %s:%s", order.Action, order.Quantity, order.Exchange, order.Symbol),
+			Result:      ResultCancelled,
+		}
+		_, err := l.engine.Cancel(userID, order.ID)
+		switch {
+		case errors.Is(err, autoorder.ErrOrderNotFound):
+			// Finished since it was listed
+			continue
+		case err != nil:
+			outcome.Result, outcome.Detail = ResultFailed, err.Error()
+		}
+		report.AutoOrders = append(report.AutoOrders, outcome)
+	}
+}
+
+// cancelBrackets stops watching the open brackets of a user. Brackets that are
+// already exiting are left to finish their exit.
+func (l *Liquidator) cancelBrackets(userID int, scope Scope, report *Report) {
+	open, err := l.db.GetOpenBrackets()
+	if err != nil {
+		report.Brackets = append(report.Brackets, Outcome{Description: "open brackets", Result: ResultFailed, Detail: err.Error()})
+		return
+	}
+	for _, b := range open {
+		if b.UserID != userID || !scope.matches(b.Exchange, b.Product) {
+			continue
+		}
+		outcome := Outcome{
+			ID:          fmt.Sprint(b.ID),
+			Description: fmt.Sprintf("%s %d %s:%s", b.Action, b.Quantity, b.Exchange, b.Symbol),
+			Result:      ResultCancelled,
+		}
+		_, err := l.brackets.Cancel(userID, b.ID)
+		switch {
+		case errors.Is(err, bracket.ErrBracketNotFound):
+			// Closed since it was listed
+			continue
+		case errors.Is(err, bracket.ErrBracketExiting):
+			outcome.Result, outcome.Detail = ResultKept, "already exiting its position"
+		case err != nil:
+			outcome.Result, outcome.Detail = ResultFailed, err.Error()
+		}
+		report.Brackets = append(report.Brackets, outcome)
+	}
+}
+
+// cancelOrders cancels the open broker orders of a user, except market orders
+// that reduce one of their ledger positions: those are exits on their way
+// out, of a bracket or of an earlier panic, including exits whose order ID
+// was not recorded. It returns the quantity of those exits by position.
+func (l *Liquidator) cancelOrders(userID int, scope Scope, report *Report) map[string]int {
+	exits := make(map[string]int)
+	trades, err := l.orders.Open(userID)
+	if err != nil {
+		report.Orders = append(report.Orders, Outcome{Description: "open orders", Result: ResultFailed, Detail: err.Error()})
+		return exits
+	}
+	positions, err := l.db.GetOpenPositionsByUserID(userID)
+	if err != nil {
+		report.Orders = append(report.Orders, Outcome{Description: "open positions", Result: ResultFailed, Detail: err.Error()})
+		return exits
+	}
+	held := make(map[string]int)
+	for _, pos := range positions {
+		held[key(pos.Broker, pos.Symbol, pos.Exchange, pos.Product)] = pos.Quantity
+	}
+
+	// Exits placed without their order ID being recorded cannot be cancelled
+	// or tracked. They are counted as on their way, so that the position is
+	// not squared off twice, and reported so that the user checks them.
+	unplaced, err := l.db.GetUnplacedTradesByUserID(userID, time.Now().Add(-orders.MaxAge))
+	if err != nil {
+		report.Orders = append(report.Orders, Outcome{Description: "unrecorded orders", Result: ResultFailed, Detail: err.Error()})
+		return exits
+	}
+	for _, trade := range unplaced {
+		k := key(trade.Broker, trade.Symbol, trade.Exchange, trade.Product)
+		change := signed(trade.Action, trade.Quantity)
+		if !scope.matches(trade.Exchange, trade.Product) || !strings.EqualFold(trade.OrderType, "MARKET") || !reduces(held[k]+exits[k], change) {
+			continue
+		}
+		exits[k] += change
+		report.Orders = append(report.Orders, Outcome{
+			ID:          fmt.Sprintf("trade %d", trade.ID),
+			Description: fmt.Sprintf("%s %d %s:%s", strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol),
+			Result:      ResultFailed,
+			Detail:      "exit placed without its order ID being recorded; verify it with the broker",
+		})
+	}
+
+	for _, trade := range trades {
+		if !scope.matches(trade.Exchange, trade.Product) {
+			continue
+		}
+		outcome := Outcome{
+			ID:          trade.OrderID,
+			Description: fmt.Sprintf("%s %d %s:%s", strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol),
+			Result:      ResultCancelled,
+		}
+		k := key(trade.Broker, trade.Symbol, trade.Exchange, trade.Product)
+		change := signed(trade.Action, trade.Quantity)
+		if strings.EqualFold(trade.OrderType, "MARKET") && reduces(held[k]+exits[k], change) {
+			exits[k] += change
+			outcome.Result, outcome.Detail = ResultKept, "market exit on its way"
+			report.Orders = append(report.Orders, outcome)
+			continue
+		}
+
+		_, err := l.orders.Cancel(userID, trade.OrderID)
+		switch {
+		case errors.Is(err, orders.ErrOrderClosed):
+			// Filled or cancelled since it was listed
+			continue
+		case err != nil:
+			outcome.Result, outcome.Detail = ResultFailed, err.Error()
+		}
+		report.Orders = append(report.Orders, outcome)
+	}
+	return exits
+}
+
+// squareOff places market exits for what is left of the ledger positions of a
+// user once the exits on their way have filled
+func (l *Liquidator) squareOff(userID int, scope Scope, exits map[string]int, report *Report) {
+	positions, err := l.db.GetOpenPositionsByUserID(userID)
+	if err != nil {
+		report.Positions = append(report.Positions, Outcome{Description: "open positions", Result: ResultFailed, Detail: err.Error()})
+		return
+	}
+	for _, pos := range positions {
+		if !scope.matches(pos.Exchange, pos.Product) {
+			continue
+		}
+		outcome := Outcome{
+			ID:          fmt.Sprintf("%s:%s", pos.Exchange, pos.Symbol),
+			Description: fmt.Sprintf("%d %s:%s (%s) on %s", pos.Quantity, pos.Exchange, pos.Symbol, pos.Product, pos.Broker),
+		}
+		remaining := pos.Quantity + exits[key(pos.Broker, pos.Symbol, pos.Exchange, pos.Product)]
+		if remaining == 0 || (remaining > 0) != (pos.Quantity > 0) {
+			outcome.Result, outcome.Detail = ResultExitPending, "the exits on their way close it"
+		} else if trade, err := l.exit(userID, scope, pos, remaining); err != nil {
+			outcome.Result, outcome.Detail = ResultFailed, err.Error()
+		} else {
+			outcome.Result = ResultSquaredOff
+			outcome.Detail = fmt.Sprintf("exit order %s: %s %d at market", trade.OrderID, trade.Action, trade.Quantity)
+		}
+		report.Positions = append(report.Positions, outcome)
+	}
+}
+
+// untracked compares the position books of the brokers a user trades with to
+// their ledger. Square-offs follow the ledger, so a position in scope the
+// broker holds other than the ledger has it, such as one placed outside the
+// app, is not closed in full; it is returned as a failure to close it with
+// the broker.
+func (l *Liquidator) untracked(userID int, scope Scope) []Outcome {
+	outcomes := []Outcome{}
+	positions, err := l.db.GetOpenPositionsByUserID(userID)
+	if err != nil {
+		return append(outcomes, Outcome{Description: "open positions", Result: ResultFailed, Detail: err.Error()})
+	}
+	ledger := make(map[string]int)
+	names := make(map[string]bool)
+	for _, pos := range positions {
+		ledger[key(pos.Broker, pos.Symbol, pos.Exchange, pos.Product)] = pos.Quantity
+		names[pos.Broker] = true
+	}
+	if name, err := l.brokers.Resolve(userID, 0); err == nil {
+		names[name] = true
+	}
+
+	for name := range names {
+		b, err := l.brokers.ByName(userID, name)
+		var book map[broker.PositionKey]int
+		if err == nil {
+			book, err = broker.Book(b)
+		}
+		if err != nil {
+			outcomes = append(outcomes, Outcome{Description: "position book of " + name, Result: ResultFailed, Detail: err.Error()})
+			continue
+		}
+		for k, held := range book {
+			recorded := ledger[key(name, k.Symbol, k.Exchange, k.Product)]
+			if held == 0 || held == recorded || !scope.matches(k.Exchange, k.Product) {
+				continue
+			}
+			outcome := Outcome{
+				ID:          fmt.Sprintf("%s:%s", k.Exchange, k.Symbol),
+				Description: fmt.Sprintf("%d %s:%s (%s) on %s", held, k.Exchange, k.Symbol, k.Product, name),
+				Result:      ResultFailed,
+				Detail:      fmt.Sprintf("the broker holds %d but the ledger %d, so it is not squared off in full; close it with the broker", held, recorded),
+			}
+			if recorded == 0 {
+				outcome.Detail = "the broker holds a position the ledger does not know, so it is not squared off; close it with the broker"
+			}
+			outcomes = append(outcomes, outcome)
+		}
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].ID < outcomes[j].ID })
+	return outcomes
+}
+
+// exit places a market order that takes quantity off a position, never more
+// than the broker holds, and records it as a trade for the order tracker
+func (l *Liquidator) exit(userID int, scope Scope, pos *models.OpenPosition, quantity int) (*models.Trade, error) {
+	b, err := l.brokers.ByName(userID, pos.Broker)
+	if err != nil {
+		return nil, err
+	}
+	current, err := broker.Held(b, pos.Symbol, pos.Exchange, pos.Product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the position: %w", err)
+	}
+	if current == 0 || (current > 0) != (quantity > 0) {
+		return nil, fmt.Errorf("the broker holds %d, the position is already closed there", current)
+	}
+	// Never exit more than is held, nor flip the position
+	target := current - quantity
+	if (target > 0) != (current > 0) {
+		target = 0
+	}
+	action := "SELL"
+	if quantity < 0 {
+		action = "BUY"
+	}
+
+	orderReq := &openalgo.OpenAlgoSmartOrderRequest{
+		Strategy:     scope.Name,
+		Symbol:       pos.Symbol,
+		Exchange:     pos.Exchange,
+		Action:       action,
+		Pricetype:    "MARKET",
+		Product:      pos.Product,
+		Quantity:     abs(current - target),
+		PositionSize: target,
+	}
+	// The trade is saved before the order is placed, so that an exit placed
+	// without its order ID being recorded is still seen by the next liquidation
+	trade, err := l.db.CreateTrade(&models.Trade{
+		UserID:    userID,
+		Symbol:    pos.Symbol,
+		Exchange:  pos.Exchange,
+		Product:   pos.Product,
+		Broker:    b.Name(),
+		Action:    action,
+		Quantity:  orderReq.Quantity,
+		OrderType: "MARKET",
+		Status:    models.TradePending,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save the exit trade: %w", err)
+	}
+
+	response, err := b.PlaceSmartOrder(orderReq)
+	if err == nil && (response == nil || response.Data.OrderID == "") {
+		err = fmt.Errorf("the broker returned no order ID")
+	}
+	if err != nil {
+		now := time.Now()
+		trade.Status, trade.StatusMessage, trade.UpdatedAt = models.TradeFailed, err.Error(), &now
+		if saveErr := l.db.UpdateTradeOrder(trade); saveErr != nil {
+			scope.logf("Failed to mark exit trade %d as failed: %v", trade.ID, saveErr)
+		}
+		return nil, err
+	}
+
+	if err := l.db.SetTradeOrderID(trade.ID, response.Data.OrderID); err != nil {
+		return nil, fmt.Errorf("exit order %s placed but not recorded: %w", response.Data.OrderID, err)
+	}
+	trade.OrderID = response.Data.OrderID
+	scope.logf("User %d squared off %s:%s (%s) with %s %d, order %s", userID, pos.Exchange, pos.Symbol, pos.Product, action, trade.Quantity, trade.OrderID)
+	return trade, nil
+}
+
+// key identifies a ledger position
+func key(brokerName, symbol, exchange, product string) string {
+	return strings.ToUpper(strings.Join([]string{brokerName, exchange, symbol, product}, ":"))
+}
+
+// signed returns the quantity of an order, negative for sells
+func signed(action string, quantity int) int {
+	if strings.EqualFold(action, "SELL") {
+		return -quantity
+	}
+	return quantity
+}
+
+// reduces reports whether a change takes from a position without flipping it
+func reduces(position, change int) bool {
+	return position != 0 && (position > 0) != (change > 0) && abs(change) <= abs(position)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"trading-app/internal/autoorder"
+	"trading-app/internal/database"
+	"trading-app/pkg/utils"
+)
+
+type AutoOrderHandler struct {
+	db     *database.DB
+	engine *autoorder.Engine
+}
+
+func NewAutoOrderHandler(db *database.DB, engine *autoorder.Engine) *AutoOrderHandler {
+	return &AutoOrderHandler{
+		db:     db,
+		engine: engine,
+	}
+}
+
+// GetAutoOrders lists the running auto orders of the current user.
+// With ?id= it returns a single order including its fire history, and with
+// ?status=all it also returns finished orders.
+func (h *AutoOrderHandler) GetAutoOrders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		h.getAutoOrder(w, userID, strings.ToUpper(id))
+		return
+	}
+
+	if r.URL.Query().Get("status") == "all" {
+		limit := 50 // Default
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		stored, err := h.db.GetAutoOrdersByUserID(userID, limit)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve auto orders")
+			return
+		}
+
+		statuses := make([]autoorder.Status, 0, len(stored))
+		for _, order := range stored {
+			// Prefer the live order so running entries carry their last evaluation
+			if live, err := h.engine.Get(userID, order.ID); err == nil {
+				order = live
+			}
+			statuses = append(statuses, autoorder.StatusOf(order))
+		}
+		utils.SuccessResponse(w, "Auto orders retrieved", statuses)
+		return
+	}
+
+	orders := h.engine.List(userID)
+	statuses := make([]autoorder.Status, 0, len(orders))
+	for _, order := range orders {
+		statuses = append(statuses, autoorder.StatusOf(order))
+	}
+	utils.SuccessResponse(w, "Auto orders retrieved", statuses)
+}
+
+func (h *AutoOrderHandler) getAutoOrder(w http.ResponseWriter, userID int, id string) {
+	order, err := h.engine.Get(userID, id)
+	if err != nil {
+		// Not running; fall back to the stored record
+		order, err = h.db.GetAutoOrderByID(id)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve auto order")
+			return
+		}
+		if order == nil || order.UserID != userID {
+			utils.ErrorResponse(w, http.StatusNotFound, "Auto order not found")
+			return
+		}
+	}
+
+	status := autoorder.StatusOf(order)
+	fires, err := h.db.GetAutoOrderFires(order.ID, 50)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve auto order history")
+		return
+	}
+	status.Fires = fires
+
+	utils.SuccessResponse(w, "Auto order retrieved", status)
+}
+
+// CreateAutoOrder starts a new auto order from a structured JSON body
+func (h *AutoOrderHandler) CreateAutoOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req autoorder.Request
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if _, err := req.Validate(h.engine.Calendar()); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid auto order: "+err.Error())
+		return
+	}
+
+	order, err := h.engine.Submit(userID, req)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to start auto order: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(w, "Auto order started", autoorder.StatusOf(order))
+}
+
+// CancelAutoOrder cancels the auto order given by ?id=, or every running
+// auto order of the user with ?all=true
+func (h *AutoOrderHandler) CancelAutoOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	if r.URL.Query().Get("all") == "true" {
+		cancelled := h.engine.CancelAll(userID)
+		statuses := make([]autoorder.Status, 0, len(cancelled))
+		for _, order := range cancelled {
+			statuses = append(statuses, autoorder.StatusOf(order))
+		}
+		utils.SuccessResponse(w, "Auto orders cancelled", statuses)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Auto order ID is required")
+		return
+	}
+
+	order, err := h.engine.Cancel(userID, strings.ToUpper(id))
+	if err == autoorder.ErrOrderNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, "Auto order not found or not running")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to cancel auto order: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(w, "Auto order cancelled", autoorder.StatusOf(order))
+}
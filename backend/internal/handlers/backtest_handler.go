@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"trading-app/internal/database"
-	"trading-app/internal/openalgo"
+	"trading-app/internal/marketdata"
 	"trading-app/internal/strategy"
 	"trading-app/pkg/utils"
 )
@@ -15,11 +15,10 @@ type BacktestHandler struct {
 	backtester *strategy.Backtester
 }
 
-// FIX: Renamed the parameter type from openalgo.Client to openalgo.OpenAlgoClient
-func NewBacktestHandler(db *database.DB, openalgoClient *openalgo.OpenAlgoClient) *BacktestHandler {
+func NewBacktestHandler(db *database.DB, candles *marketdata.Store) *BacktestHandler {
 	return &BacktestHandler{
 		db:          db,
-		backtester: strategy.NewBacktester(db, openalgoClient),
+		backtester: strategy.NewBacktester(db, candles),
 	}
 }
 
@@ -30,6 +29,10 @@ type RunBacktestRequest struct {
 	InitialCapital float64 `json:"initial_capital"`
 	Symbol         string  `json:"symbol"`
 	Exchange       string  `json:"exchange"`
+	Interval       string  `json:"interval"`
+	EntryCondition string  `json:"entry_condition"`
+	ExitCondition  string  `json:"exit_condition"`
+	PositionSize   float64 `json:"position_size"`
 }
 
 // RunBacktest runs a backtest for a strategy
@@ -47,6 +50,13 @@ func (h *BacktestHandler) RunBacktest(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid parameters")
 		return
 	}
+	if req.Symbol == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Symbol is required")
+		return
+	}
+	if req.Exchange == "" {
+		req.Exchange = "NSE"
+	}
 
 	// Verify strategy ownership
 	strat, err := h.db.GetStrategyByID(req.StrategyID)
@@ -84,6 +94,10 @@ func (h *BacktestHandler) RunBacktest(w http.ResponseWriter, r *http.Request) {
 		InitialCapital: req.InitialCapital,
 		Symbol:         req.Symbol,
 		Exchange:       req.Exchange,
+		Interval:       req.Interval,
+		EntryCondition: req.EntryCondition,
+		ExitCondition:  req.ExitCondition,
+		PositionSize:   req.PositionSize,
 	}
 
 	result, err := h.backtester.RunBacktest(params)
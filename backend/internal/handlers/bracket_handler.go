@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"trading-app/internal/bracket"
+	"trading-app/internal/models"
+	"trading-app/pkg/utils"
+)
+
+// BracketHandler lists and cancels the stop-loss and target brackets of the user
+type BracketHandler struct {
+	brackets *bracket.Manager
+}
+
+func NewBracketHandler(brackets *bracket.Manager) *BracketHandler {
+	return &BracketHandler{brackets: brackets}
+}
+
+// GetBrackets lists the latest brackets of the current user, newest first
+func (h *BracketHandler) GetBrackets(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	brackets, err := h.brackets.List(userID, limit)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve brackets")
+		return
+	}
+	if brackets == nil {
+		brackets = []*models.Bracket{}
+	}
+	utils.SuccessResponse(w, "Brackets retrieved", brackets)
+}
+
+// CancelBracket stops watching the bracket given by ?id=. The position stays open.
+func (h *BracketHandler) CancelBracket(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid bracket ID")
+		return
+	}
+
+	b, err := h.brackets.Cancel(userID, id)
+	switch {
+	case errors.Is(err, bracket.ErrBracketNotFound):
+		utils.ErrorResponse(w, http.StatusNotFound, "Bracket not found")
+	case errors.Is(err, bracket.ErrBracketExiting):
+		utils.ErrorResponse(w, http.StatusConflict, "Bracket is already exiting its position")
+	case err != nil:
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+	default:
+		utils.SuccessResponse(w, "Bracket cancelled", b)
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/pkg/utils"
+)
+
+type BrokerHandler struct {
+	db      *database.DB
+	brokers *broker.Selector
+}
+
+func NewBrokerHandler(db *database.DB, brokers *broker.Selector) *BrokerHandler {
+	return &BrokerHandler{
+		db:      db,
+		brokers: brokers,
+	}
+}
+
+type SetBrokerRequest struct {
+	Broker     string `json:"broker"`      // "openalgo" or "paper"
+	StrategyID int    `json:"strategy_id"` // Optional; 0 selects the broker for all orders
+}
+
+// GetBroker returns the broker used for the user's orders, or for the
+// strategy given by the optional strategy_id query parameter
+func (h *BrokerHandler) GetBroker(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	strategyID := 0
+	if idStr := r.URL.Query().Get("strategy_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, "Invalid strategy ID")
+			return
+		}
+		strategyID = id
+	}
+
+	name, err := h.brokers.Resolve(userID, strategyID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to resolve broker")
+		return
+	}
+	settings, err := h.db.GetBrokerSettingsByUserID(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve broker settings")
+		return
+	}
+
+	utils.SuccessResponse(w, "Broker retrieved", map[string]interface{}{
+		"broker":    name,
+		"default":   h.brokers.Default(),
+		"available": []string{broker.OpenAlgo, broker.Paper},
+		"settings":  settings,
+	})
+}
+
+// SetBroker selects the broker for the user's orders or for one of their strategies
+func (h *BrokerHandler) SetBroker(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req SetBrokerRequest
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.StrategyID != 0 {
+		strategy, err := h.db.GetStrategyByID(req.StrategyID)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve strategy")
+			return
+		}
+		if strategy == nil || strategy.UserID != userID {
+			utils.ErrorResponse(w, http.StatusNotFound, "Strategy not found")
+			return
+		}
+	}
+
+	if err := h.brokers.Set(userID, req.StrategyID, req.Broker); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(w, "Broker updated", map[string]interface{}{
+		"broker":      req.Broker,
+		"strategy_id": req.StrategyID,
+	})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+	"trading-app/internal/orders"
+	"trading-app/internal/risk"
+	"trading-app/pkg/utils"
+)
+
+// OrderHandler manages the open broker orders of the user
+type OrderHandler struct {
+	db      *database.DB
+	tracker *orders.Tracker
+}
+
+func NewOrderHandler(db *database.DB, tracker *orders.Tracker) *OrderHandler {
+	return &OrderHandler{
+		db:      db,
+		tracker: tracker,
+	}
+}
+
+// GetOpenOrders lists the broker orders of the current user that are still pending
+func (h *OrderHandler) GetOpenOrders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	trades, err := h.tracker.Open(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve open orders")
+		return
+	}
+	if trades == nil {
+		trades = []*models.Trade{}
+	}
+	utils.SuccessResponse(w, "Open orders retrieved", trades)
+}
+
+// ModifyOrder changes the quantity, price or price type of an open order
+func (h *OrderHandler) ModifyOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req orders.Modification
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.OrderID == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Order ID is required")
+		return
+	}
+	if req.Quantity < 0 || req.Price < 0 || req.TriggerPrice < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Quantity and prices cannot be negative")
+		return
+	}
+
+	trade, err := h.tracker.Modify(userID, req)
+	if err != nil {
+		orderError(w, "modify", err)
+		return
+	}
+	utils.SuccessResponse(w, "Order modified", trade)
+}
+
+// CancelOrder cancels the order given by ?order_id=, or every open order of
+// the user with ?all=true
+func (h *OrderHandler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	if r.URL.Query().Get("all") == "true" {
+		cancelled, failed, err := h.tracker.CancelAll(userID)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.SuccessResponse(w, "Orders cancelled", map[string]interface{}{
+			"cancelled": cancelled,
+			"failed":    failed,
+		})
+		return
+	}
+
+	orderID := r.URL.Query().Get("order_id")
+	if orderID == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Order ID is required")
+		return
+	}
+
+	trade, err := h.tracker.Cancel(userID, orderID)
+	if err != nil {
+		orderError(w, "cancel", err)
+		return
+	}
+	utils.SuccessResponse(w, "Order cancelled", trade)
+}
+
+// orderError answers a failed modification or cancellation
+func orderError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, orders.ErrOrderNotFound):
+		utils.ErrorResponse(w, http.StatusNotFound, "Order not found")
+	case errors.Is(err, orders.ErrOrderClosed):
+		utils.ErrorResponse(w, http.StatusConflict, "Order is no longer open")
+	case errors.As(err, new(*risk.Rejection)):
+		utils.ErrorResponse(w, http.StatusForbidden, "Order "+err.Error())
+	default:
+		utils.ErrorResponse(w, http.StatusBadGateway, "Failed to "+action+" order: "+err.Error())
+	}
+}
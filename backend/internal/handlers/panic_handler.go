@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"trading-app/internal/emergency"
+	"trading-app/pkg/utils"
+)
+
+// PanicHandler takes the user out of the market in one request
+type PanicHandler struct {
+	liquidator *emergency.Liquidator
+}
+
+func NewPanicHandler(liquidator *emergency.Liquidator) *PanicHandler {
+	return &PanicHandler{liquidator: liquidator}
+}
+
+// Panic cancels the user's auto orders, brackets and open broker orders and
+// squares off their positions. It is safe to call repeatedly; the report
+// lists the outcome of every step.
+func (h *PanicHandler) Panic(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	report := h.liquidator.Panic(userID)
+	if report.Failures > 0 {
+		utils.SuccessResponse(w, fmt.Sprintf("Panic completed with %d failure(s); call it again to retry", report.Failures), report)
+		return
+	}
+	utils.SuccessResponse(w, "Panic completed", report)
+}
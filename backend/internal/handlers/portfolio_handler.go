@@ -3,51 +3,154 @@ package handlers
 import (
 	//"fmt" 
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
+	"trading-app/internal/condition"
 	"trading-app/internal/database"
+	"trading-app/internal/ledger"
+	"trading-app/internal/marketdata"
 	"trading-app/internal/models"
 	"trading-app/internal/openalgo"
+	"trading-app/internal/risk"
 	"trading-app/pkg/utils"
 )
 
 type PortfolioHandler struct {
 	db       *database.DB
-	openalgo *openalgo.OpenAlgoClient
+	brokers  *broker.Selector
+	candles  *marketdata.Store
+	ledger   *ledger.Ledger
+	brackets *bracket.Manager
+	risk     *risk.Engine
 }
 
-func NewPortfolioHandler(db *database.DB, openalgoClient *openalgo.OpenAlgoClient) *PortfolioHandler {
+func NewPortfolioHandler(db *database.DB, brokers *broker.Selector, candles *marketdata.Store, positions *ledger.Ledger, brackets *bracket.Manager, riskEngine *risk.Engine) *PortfolioHandler {
 	return &PortfolioHandler{
 		db:       db,
-		openalgo: openalgoClient,
+		brokers:  brokers,
+		candles:  candles,
+		ledger:   positions,
+		brackets: brackets,
+		risk:     riskEngine,
 	}
 }
 
-// GetPortfolio retrieves portfolio data from OpenAlgo (Kept as Not Implemented)
+// GetPortfolio combines the funds, positions and holdings of the user's broker
 func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request) {
-	utils.ErrorResponse(w, http.StatusNotImplemented, "GetPortfolio is not yet implemented after refactoring.")
+	userID := r.Context().Value("user_id").(int)
+
+	b, err := h.brokerFor(userID, nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	funds, err := b.Funds()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve funds: "+err.Error())
+		return
+	}
+	positions, err := livePositions(b)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve positions: "+err.Error())
+		return
+	}
+	holdings, err := liveHoldings(b)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve holdings: "+err.Error())
+		return
+	}
+
+	portfolio := models.Portfolio{
+		Cash:      float64(funds.AvailableCash),
+		TodayPnL:  float64(funds.M2MRealized + funds.M2MUnrealized),
+		TotalPnL:  float64(funds.M2MRealized),
+		Positions: append(positions, holdings...),
+	}
+	var invested float64
+	for _, pos := range portfolio.Positions {
+		portfolio.PositionsValue += pos.CurrentPrice * float64(pos.Quantity)
+		portfolio.TotalPnL += pos.PnL
+		invested += math.Abs(pos.AvgPrice * float64(pos.Quantity))
+	}
+	portfolio.TotalValue = portfolio.Cash + portfolio.PositionsValue
+	if invested > 0 {
+		portfolio.TotalPnLPercent = portfolio.TotalPnL / invested * 100
+	}
+
+	utils.SuccessResponse(w, "Portfolio retrieved", portfolio)
 }
 
-// GetPositions retrieves current positions (Kept as Not Implemented)
+// GetPositions retrieves the open positions of the user's broker at live prices
 func (h *PortfolioHandler) GetPositions(w http.ResponseWriter, r *http.Request) {
-	utils.ErrorResponse(w, http.StatusNotImplemented, "GetPositions is not yet implemented after refactoring.")
+	b, err := h.brokerFor(r.Context().Value("user_id").(int), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	positions, err := livePositions(b)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve positions: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(w, "Positions retrieved", positions)
 }
 
-// GetHoldings retrieves current holdings (Kept as Not Implemented)
+// GetHoldings retrieves the delivery holdings of the user's broker at live prices
 func (h *PortfolioHandler) GetHoldings(w http.ResponseWriter, r *http.Request) {
-	utils.ErrorResponse(w, http.StatusNotImplemented, "GetHoldings is not yet implemented after refactoring.")
+	b, err := h.brokerFor(r.Context().Value("user_id").(int), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	holdings, err := liveHoldings(b)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve holdings: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(w, "Holdings retrieved", holdings)
 }
 
-// PlaceOrder places a new order
+// PlaceOrder places a new order with the user's broker, or the broker of the
+// strategy given by the optional strategy_id query parameter
 func (h *PortfolioHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
 
-	var orderReq openalgo.OpenAlgoSmartOrderRequest
-	if err := utils.ParseJSON(r, &orderReq); err != nil {
+	var strategyID *int
+	if idStr := r.URL.Query().Get("strategy_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, "Invalid strategy ID")
+			return
+		}
+		strategy, err := h.db.GetStrategyByID(id)
+		if err != nil || strategy == nil || strategy.UserID != userID {
+			utils.ErrorResponse(w, http.StatusNotFound, "Strategy not found")
+			return
+		}
+		strategyID = &id
+	}
+
+	// An optional stop-loss, target and trailing stop turn the order into a bracket
+	var req struct {
+		openalgo.OpenAlgoSmartOrderRequest
+		bracket.Legs
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	orderReq := req.OpenAlgoSmartOrderRequest
 
 	// Validate order
 	if orderReq.Symbol == "" || orderReq.Exchange == "" || orderReq.Action == "" || orderReq.Quantity <= 0 {
@@ -55,8 +158,31 @@ func (h *PortfolioHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Place order via OpenAlgo
-	response, err := h.openalgo.PlaceOpenAlgoSmartOrder(&orderReq)
+	b, err := h.brokerFor(userID, strategyID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !req.Legs.IsZero() {
+		price := orderReq.Price
+		if price <= 0 {
+			if quote, err := b.Quote(orderReq.Symbol, orderReq.Exchange); err == nil {
+				price = quote.LTP
+			}
+		}
+		if err := req.Legs.Validate(orderReq.Action, price); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, "Invalid bracket: "+err.Error())
+			return
+		}
+	}
+
+	if err := h.risk.Check(userID, b, &orderReq, risk.SourceManual); err != nil {
+		utils.ErrorResponse(w, http.StatusForbidden, "Order "+err.Error())
+		return
+	}
+
+	response, err := b.PlaceSmartOrder(&orderReq)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to place order: "+err.Error())
 		return
@@ -64,14 +190,18 @@ func (h *PortfolioHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 
 	// Save trade to database
 	trade := &models.Trade{
-		UserID:    userID,
-		Symbol:    orderReq.Symbol,
-		Action:    orderReq.Action,
-		Quantity:  orderReq.Quantity,
-		Price:     orderReq.Price,
-		OrderType: orderReq.Pricetype,
-		Status:    "pending",
-		OrderID:   response.Data.OrderID,
+		UserID:     userID,
+		StrategyID: strategyID,
+		Symbol:     strings.ToUpper(orderReq.Symbol),
+		Exchange:   strings.ToUpper(orderReq.Exchange),
+		Product:    strings.ToUpper(orderReq.Product),
+		Broker:     b.Name(),
+		Action:     orderReq.Action,
+		Quantity:   orderReq.Quantity,
+		Price:      orderReq.Price,
+		OrderType:  orderReq.Pricetype,
+		Status:     "pending",
+		OrderID:    response.Data.OrderID,
 	}
 
 	savedTrade, err := h.db.CreateTrade(trade)
@@ -80,10 +210,20 @@ func (h *PortfolioHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.SuccessResponse(w, "Order placed successfully", map[string]interface{}{
+	result := map[string]interface{}{
 		"order": response,
 		"trade": savedTrade,
-	})
+	}
+	if savedTrade.OrderID != "" && !req.Legs.IsZero() {
+		bracketOrder, err := h.brackets.Attach(savedTrade, req.Legs)
+		if err != nil {
+			utils.SuccessResponse(w, "Order placed, but its exits could not be set: "+err.Error(), result)
+			return
+		}
+		result["bracket"] = bracketOrder
+	}
+
+	utils.SuccessResponse(w, "Order placed successfully", result)
 }
 
 // GetQuote retrieves market quote
@@ -96,7 +236,13 @@ func (h *PortfolioHandler) GetQuote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	quote, err := h.openalgo.FetchOpenAlgoQuote(strings.ToUpper(symbol), strings.ToUpper(exchange)) 
+	b, err := h.brokerFor(r.Context().Value("user_id").(int), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	quote, err := b.Quote(strings.ToUpper(symbol), strings.ToUpper(exchange))
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve quote: "+err.Error())
 		return
@@ -109,24 +255,26 @@ func (h *PortfolioHandler) GetQuote(w http.ResponseWriter, r *http.Request) {
 func (h *PortfolioHandler) HandlePortfolioValue(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
 	
-	// Get exchange from query parameter, default to NSE
-	exchange := r.URL.Query().Get("exchange")
-	if exchange == "" {
-		exchange = "NSE"
-	}
-	exchange = strings.ToUpper(exchange)
+	// Optional exchange filter
+	exchange := strings.ToUpper(r.URL.Query().Get("exchange"))
 
-	positions, err := h.db.GetOpenPositionsByUserID(userID)
+	positions, err := h.openPositions(userID, exchange)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve portfolio positions")
 		return
 	}
 
+	b, err := h.brokerFor(userID, nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	var totalPortfolioValue float64
 	for _, pos := range positions {
-		quote, err := h.openalgo.FetchOpenAlgoQuote(pos.Symbol, exchange)
+		quote, err := b.Quote(pos.Symbol, pos.Exchange)
 		if err != nil {
-			log.Printf("Warning: Failed to fetch quote for %s on %s: %v", pos.Symbol, exchange, err)
+			log.Printf("Warning: Failed to fetch quote for %s on %s: %v", pos.Symbol, pos.Exchange, err)
 			continue 
 		}
 		totalPortfolioValue += quote.LTP * float64(pos.Quantity)
@@ -144,18 +292,14 @@ func (h *PortfolioHandler) HandlePortfolioValue(w http.ResponseWriter, r *http.R
 // HandlePortfolioSignal checks if a condition is met for every symbol in the portfolio
 func (h *PortfolioHandler) HandlePortfolioSignal(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
-	condition := r.URL.Query().Get("pine_condition")
-	if condition == "" {
+	pineCondition := r.URL.Query().Get("pine_condition")
+	if pineCondition == "" {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Missing 'pine_condition' parameter")
 		return
 	}
 
-	// Get exchange and interval from query parameters
-	exchange := r.URL.Query().Get("exchange")
-	if exchange == "" {
-		exchange = "NSE"
-	}
-	exchange = strings.ToUpper(exchange)
+	// Optional exchange filter and interval from query parameters
+	exchange := strings.ToUpper(r.URL.Query().Get("exchange"))
 
 	interval := r.URL.Query().Get("interval")
 	if interval == "" {
@@ -169,7 +313,13 @@ func (h *PortfolioHandler) HandlePortfolioSignal(w http.ResponseWriter, r *http.
 		return
 	}
 
-	positions, err := h.db.GetOpenPositionsByUserID(userID)
+	program, err := condition.Compile(pineCondition)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid pine_condition: "+err.Error())
+		return
+	}
+
+	positions, err := h.openPositions(userID, exchange)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve portfolio positions")
 		return
@@ -184,9 +334,9 @@ func (h *PortfolioHandler) HandlePortfolioSignal(w http.ResponseWriter, r *http.
 
 	for _, pos := range positions {
 		symbol := pos.Symbol
-		isMet, _, err := h.openalgo.EvaluatePineCondition(interval, condition, strings.ToUpper(symbol), exchange)
+		isMet, _, err := h.candles.Evaluate(interval, program, strings.ToUpper(symbol), pos.Exchange)
 		if err != nil {
-			log.Printf("Signal evaluation failed for %s on %s (%s): %v", symbol, exchange, interval, err)
+			log.Printf("Signal evaluation failed for %s on %s (%s): %v", symbol, pos.Exchange, interval, err)
 			signalResults[symbol] = false 
 			continue
 		}
@@ -194,7 +344,7 @@ func (h *PortfolioHandler) HandlePortfolioSignal(w http.ResponseWriter, r *http.
 	}
 
 	result := map[string]interface{}{
-		"condition": condition,
+		"condition": pineCondition,
 		"exchange":  exchange,
 		"interval":  interval,
 		"results":   signalResults,
@@ -203,10 +353,44 @@ func (h *PortfolioHandler) HandlePortfolioSignal(w http.ResponseWriter, r *http.
 	utils.SuccessResponse(w, "Portfolio signal evaluation complete", result)
 }
 
+// GetLedger retrieves the position ledger built from the user's executed
+// trades, with the quantities last reported by the broker
+func (h *PortfolioHandler) GetLedger(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	positions, err := h.ledger.Positions(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve position ledger")
+		return
+	}
+
+	utils.SuccessResponse(w, "Position ledger retrieved", positions)
+}
+
+// ReconcilePositions checks the user's ledger against the broker's position book now
+func (h *PortfolioHandler) ReconcilePositions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	h.ledger.ApplyExecutedTrades()
+	mismatched, err := h.ledger.Reconcile(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadGateway, "Failed to reconcile positions: "+err.Error())
+		return
+	}
+
+	message := "Positions match the broker"
+	if len(mismatched) > 0 {
+		message = "Positions differ from the broker"
+	}
+	utils.SuccessResponse(w, message, map[string]interface{}{
+		"mismatches": mismatched,
+	})
+}
+
 // HandleSignalTest is the unprotected test route for /signal
 func (h *PortfolioHandler) HandleSignalTest(w http.ResponseWriter, r *http.Request) {
 	symbol := r.URL.Query().Get("symbol")
-	condition := r.URL.Query().Get("pine_condition")
+	pineCondition := r.URL.Query().Get("pine_condition")
 	exchange := r.URL.Query().Get("exchange")
 	interval := r.URL.Query().Get("interval")
 
@@ -221,7 +405,7 @@ func (h *PortfolioHandler) HandleSignalTest(w http.ResponseWriter, r *http.Reque
 	}
 	interval = strings.ToLower(interval)
 
-	if symbol == "" || condition == "" {
+	if symbol == "" || pineCondition == "" {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Symbol and pine_condition are required")
 		return
 	}
@@ -232,7 +416,13 @@ func (h *PortfolioHandler) HandleSignalTest(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	isConditionMet, indicatorValues, err := h.openalgo.EvaluatePineCondition(interval, condition, strings.ToUpper(symbol), exchange)
+	program, err := condition.Compile(pineCondition)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid pine_condition: "+err.Error())
+		return
+	}
+
+	isConditionMet, indicatorValues, err := h.candles.Evaluate(interval, program, strings.ToUpper(symbol), exchange)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Evaluation failed: "+err.Error())
 		return
@@ -241,11 +431,107 @@ func (h *PortfolioHandler) HandleSignalTest(w http.ResponseWriter, r *http.Reque
 	result := map[string]interface{}{
 		"condition_met":    isConditionMet,
 		"symbol":           symbol,
-		"condition":        condition,
+		"condition":        pineCondition,
 		"exchange":         exchange,
 		"interval":         interval,
 		"indicator_values": indicatorValues,
 	}
 
 	utils.SuccessResponse(w, "Signal evaluation complete", result)
-}
\ No newline at end of file
+}
+
+// livePositions maps the open entries of the position book to positions
+func livePositions(b broker.Broker) ([]models.Position, error) {
+	book, err := b.Positions()
+	if err != nil {
+		return nil, err
+	}
+
+	positions := []models.Position{}
+	for _, entry := range book {
+		if int(entry.Quantity) == 0 {
+			continue
+		}
+		positions = append(positions, livePosition(b, entry.Symbol, entry.Exchange, entry.Product, int(entry.Quantity), float64(entry.AveragePrice), float64(entry.LTP)))
+	}
+	return positions, nil
+}
+
+// liveHoldings maps the holdings of a broker to positions
+func liveHoldings(b broker.Broker) ([]models.Position, error) {
+	data, err := b.Holdings()
+	if err != nil {
+		return nil, err
+	}
+
+	holdings := []models.Position{}
+	for _, holding := range data.Holdings {
+		quantity := int(holding.Quantity)
+		if quantity == 0 {
+			continue
+		}
+		avgPrice, ltp := float64(holding.AveragePrice), float64(holding.LTP)
+		// Some brokers only report P&L, which gives the invested amount
+		if avgPrice == 0 && holding.PnLPercent != 0 {
+			avgPrice = float64(holding.PnL) / float64(holding.PnLPercent) * 100 / float64(quantity)
+		}
+		if ltp == 0 && avgPrice != 0 {
+			ltp = avgPrice + float64(holding.PnL)/float64(quantity)
+		}
+		holdings = append(holdings, livePosition(b, holding.Symbol, holding.Exchange, holding.Product, quantity, avgPrice, ltp))
+	}
+	return holdings, nil
+}
+
+// livePosition values a position at the live quote, or at the price the
+// broker reported when no quote is available
+func livePosition(b broker.Broker, symbol, exchange, product string, quantity int, avgPrice, ltp float64) models.Position {
+	if quote, err := b.Quote(symbol, exchange); err == nil {
+		ltp = quote.LTP
+	} else {
+		log.Printf("Warning: Failed to fetch quote for %s on %s: %v", symbol, exchange, err)
+	}
+	if avgPrice == 0 {
+		avgPrice = ltp
+	}
+
+	pos := models.Position{
+		Symbol:       symbol,
+		Exchange:     exchange,
+		Product:      product,
+		Quantity:     quantity,
+		AvgPrice:     avgPrice,
+		CurrentPrice: ltp,
+		PnL:          (ltp - avgPrice) * float64(quantity),
+		UpdatedAt:    time.Now(),
+	}
+	if avgPrice != 0 {
+		pos.PnLPercent = pos.PnL / math.Abs(avgPrice*float64(quantity)) * 100
+	}
+	return pos
+}
+
+// openPositions returns the open ledger positions of a user, optionally on one exchange
+func (h *PortfolioHandler) openPositions(userID int, exchange string) ([]*models.OpenPosition, error) {
+	positions, err := h.db.GetOpenPositionsByUserID(userID)
+	if err != nil || exchange == "" {
+		return positions, err
+	}
+
+	filtered := []*models.OpenPosition{}
+	for _, pos := range positions {
+		if pos.Exchange == exchange {
+			filtered = append(filtered, pos)
+		}
+	}
+	return filtered, nil
+}
+
+// brokerFor returns the broker of a user, or of one of their strategies
+func (h *PortfolioHandler) brokerFor(userID int, strategyID *int) (broker.Broker, error) {
+	id := 0
+	if strategyID != nil {
+		id = *strategyID
+	}
+	return h.brokers.For(userID, id)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"trading-app/internal/models"
+	"trading-app/internal/risk"
+	"trading-app/pkg/utils"
+)
+
+// RiskHandler manages the pre-trade risk limits of the user and the kill switch
+type RiskHandler struct {
+	risk *risk.Engine
+}
+
+func NewRiskHandler(riskEngine *risk.Engine) *RiskHandler {
+	return &RiskHandler{risk: riskEngine}
+}
+
+// GetLimits returns the risk limits that apply to the current user
+func (h *RiskHandler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	limits, err := h.risk.Limits(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve risk limits")
+		return
+	}
+	utils.SuccessResponse(w, "Risk limits retrieved", limits)
+}
+
+// UpdateLimits replaces the risk limits of the current user. A limit of 0 is not enforced.
+func (h *RiskHandler) UpdateLimits(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var limits models.RiskLimits
+	if err := utils.ParseJSON(r, &limits); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	limits.UserID = userID
+
+	if err := h.risk.SetLimits(&limits); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid risk limits: "+err.Error())
+		return
+	}
+	utils.SuccessResponse(w, "Risk limits updated", limits)
+}
+
+// GetRejections lists the latest orders of the current user the risk checks refused, newest first
+func (h *RiskHandler) GetRejections(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	rejections, err := h.risk.Rejections(userID, limit)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve rejections")
+		return
+	}
+	if rejections == nil {
+		rejections = []*models.RiskRejection{}
+	}
+	utils.SuccessResponse(w, "Rejections retrieved", rejections)
+}
+
+// GetKillSwitch returns the state of the kill switch
+func (h *RiskHandler) GetKillSwitch(w http.ResponseWriter, r *http.Request) {
+	utils.SuccessResponse(w, "Kill switch retrieved", h.risk.KillSwitch())
+}
+
+// SetKillSwitch engages or releases the kill switch for every user. Only admins may change it.
+func (h *RiskHandler) SetKillSwitch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req struct {
+		Engaged bool   `json:"engaged"`
+		Reason  string `json:"reason"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	state, err := h.risk.SetKillSwitch(userID, req.Engaged, req.Reason)
+	if errors.Is(err, risk.ErrNotAdmin) {
+		utils.ErrorResponse(w, http.StatusForbidden, "Only admins can change the kill switch")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if state.Engaged {
+		utils.SuccessResponse(w, "Kill switch engaged", state)
+		return
+	}
+	utils.SuccessResponse(w, "Kill switch released", state)
+}
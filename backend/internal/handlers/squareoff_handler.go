@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"trading-app/internal/models"
+	"trading-app/internal/squareoff"
+	"trading-app/pkg/utils"
+)
+
+// SquareOffHandler manages the daily time at which the intraday (MIS) auto
+// orders and positions of the user are closed
+type SquareOffHandler struct {
+	scheduler *squareoff.Scheduler
+}
+
+func NewSquareOffHandler(scheduler *squareoff.Scheduler) *SquareOffHandler {
+	return &SquareOffHandler{scheduler: scheduler}
+}
+
+// GetSetting returns the square-off setting that applies to the current user
+func (h *SquareOffHandler) GetSetting(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	setting, err := h.scheduler.Setting(userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve square-off setting")
+		return
+	}
+	utils.SuccessResponse(w, "Square-off setting retrieved", setting)
+}
+
+// UpdateSetting replaces the square-off setting of the current user. The time
+// is HH:MM in IST; an empty time keeps the current one.
+func (h *SquareOffHandler) UpdateSetting(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var setting models.SquareOffSetting
+	if err := utils.ParseJSON(r, &setting); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	setting.UserID = userID
+
+	if err := h.scheduler.SetSetting(&setting); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.SuccessResponse(w, "Square-off setting updated", setting)
+}
@@ -7,20 +7,21 @@ import (
 	"strconv"
 	"strings"
 
+	"trading-app/internal/condition"
 	"trading-app/internal/database"
-	"trading-app/internal/openalgo"
+	"trading-app/internal/marketdata"
 	"trading-app/pkg/utils"
 )
 
 type TradeHandler struct {
-	db       *database.DB
-	openalgo *openalgo.OpenAlgoClient
+	db      *database.DB
+	candles *marketdata.Store
 }
 
-func NewTradeHandler(db *database.DB, openalgoClient *openalgo.OpenAlgoClient) *TradeHandler {
+func NewTradeHandler(db *database.DB, candles *marketdata.Store) *TradeHandler {
 	return &TradeHandler{
-		db:       db,
-		openalgo: openalgoClient,
+		db:      db,
+		candles: candles,
 	}
 }
 
@@ -49,9 +50,9 @@ func (h *TradeHandler) GetTrades(w http.ResponseWriter, r *http.Request) {
 func (h *TradeHandler) HandleSignal(w http.ResponseWriter, r *http.Request) {
 	// Extract required parameters from URL query
 	symbol := r.URL.Query().Get("symbol")
-	condition := r.URL.Query().Get("pine_condition")
+	pineCondition := r.URL.Query().Get("pine_condition")
 
-	if symbol == "" || condition == "" {
+	if symbol == "" || pineCondition == "" {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Missing 'symbol' or 'pine_condition' parameters")
 		return
 	}
@@ -76,8 +77,14 @@ func (h *TradeHandler) HandleSignal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	program, err := condition.Compile(pineCondition)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid pine_condition: %v", err))
+		return
+	}
+
 	// Call the evaluation logic with interval
-	isConditionMet, indicatorValues, err := h.openalgo.EvaluatePineCondition(interval, condition, strings.ToUpper(symbol), exchange)
+	isConditionMet, indicatorValues, err := h.candles.Evaluate(interval, program, strings.ToUpper(symbol), exchange)
 	if err != nil {
 		log.Printf("Signal evaluation failed for %s on %s (%s): %v", symbol, exchange, interval, err)
 		utils.ErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Signal evaluation failed: %v", err.Error()))
@@ -89,10 +96,10 @@ func (h *TradeHandler) HandleSignal(w http.ResponseWriter, r *http.Request) {
 		"symbol":           symbol,
 		"exchange":         exchange,
 		"interval":         interval,
-		"condition":        condition,
+		"condition":        pineCondition,
 		"signal_met":       isConditionMet,
 		"indicator_values": indicatorValues,
-		"message":          fmt.Sprintf("Condition '%s' for %s on %s (%s) is %t", condition, symbol, exchange, interval, isConditionMet),
+		"message":          fmt.Sprintf("Condition '%s' for %s on %s (%s) is %t", pineCondition, symbol, exchange, interval, isConditionMet),
 	}
 
 	utils.SuccessResponse(w, "Signal evaluation complete", result)
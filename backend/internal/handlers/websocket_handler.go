@@ -7,8 +7,15 @@ import (
 	"github.com/gorilla/websocket"
 	"trading-app/internal/ai"
 	"trading-app/internal/auth"
+	"trading-app/internal/autoorder"
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
 	"trading-app/internal/database"
-	"trading-app/internal/email"
+	"trading-app/internal/emergency"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/orders"
+	"trading-app/internal/risk"
+	"trading-app/internal/squareoff"
 	wsocket "trading-app/internal/websocket"
 )
 
@@ -21,24 +28,32 @@ var upgrader = websocket.Upgrader{
 }
 
 type WebSocketHandler struct {
-	hub            *wsocket.Hub
-	db             *database.DB
-	aiClient       *ai.AIClient
-	openalgoURL    string
-	openalgoAPIKey string
-	emailService   *email.EmailService
-	emailRecipient string
+	hub        *wsocket.Hub
+	db         *database.DB
+	aiClient   *ai.AIClient
+	brokers    *broker.Selector
+	candles    *marketdata.Store
+	engine     *autoorder.Engine
+	orders     *orders.Tracker
+	brackets   *bracket.Manager
+	risk       *risk.Engine
+	liquidator *emergency.Liquidator
+	squareOff  *squareoff.Scheduler
 }
 
-func NewWebSocketHandler(hub *wsocket.Hub, db *database.DB, aiClient *ai.AIClient, openalgoURL string, openalgoAPIKey string, emailService *email.EmailService, emailRecipient string) *WebSocketHandler {
+func NewWebSocketHandler(hub *wsocket.Hub, db *database.DB, aiClient *ai.AIClient, brokers *broker.Selector, candles *marketdata.Store, engine *autoorder.Engine, tracker *orders.Tracker, brackets *bracket.Manager, riskEngine *risk.Engine, liquidator *emergency.Liquidator, squareOff *squareoff.Scheduler) *WebSocketHandler {
 	return &WebSocketHandler{
-		hub:            hub,
-		db:             db,
-		aiClient:       aiClient,
-		openalgoURL:    openalgoURL,
-		openalgoAPIKey: openalgoAPIKey,
-		emailService:   emailService,
-		emailRecipient: emailRecipient,
+		hub:        hub,
+		db:         db,
+		aiClient:   aiClient,
+		brokers:    brokers,
+		candles:    candles,
+		engine:     engine,
+		orders:     tracker,
+		brackets:   brackets,
+		risk:       riskEngine,
+		liquidator: liquidator,
+		squareOff:  squareOff,
 	}
 }
 
@@ -68,10 +83,14 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		userID,
 		h.db,
 		h.aiClient,
-		h.openalgoURL,
-		h.openalgoAPIKey,
-		h.emailService,
-		h.emailRecipient,
+		h.brokers,
+		h.candles,
+		h.engine,
+		h.orders,
+		h.brackets,
+		h.risk,
+		h.liquidator,
+		h.squareOff,
 	)
 
 	h.hub.Register <- client
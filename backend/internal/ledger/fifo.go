@@ -0,0 +1,57 @@
+package ledger
+
+import "trading-app/internal/models"
+
+// fill applies a signed quantity at a price to the open lots of a position,
+// which are all on the same side. Lots on the other side are closed oldest
+// first and whatever is left opens a new lot. It returns the remaining lots
+// and the P&L realized by the closed quantity.
+func fill(lots []*models.PositionLot, quantity int, price float64) ([]*models.PositionLot, float64) {
+	var realized float64
+	for quantity != 0 && len(lots) > 0 && (lots[0].Quantity > 0) != (quantity > 0) {
+		lot := lots[0]
+		side := sign(lot.Quantity)
+		closed := min(abs(quantity), abs(lot.Quantity)) * side
+
+		realized += (price - lot.Price) * float64(closed)
+		lot.Quantity -= closed
+		quantity += closed
+		if lot.Quantity == 0 {
+			lots = lots[1:]
+		}
+	}
+
+	if quantity != 0 {
+		lots = append(lots, &models.PositionLot{Quantity: quantity, Price: price})
+	}
+	return lots, realized
+}
+
+// average returns the net quantity of open lots and their average price
+func average(lots []*models.PositionLot) (int, float64) {
+	quantity, size := 0, 0
+	var cost float64
+	for _, lot := range lots {
+		quantity += lot.Quantity
+		size += abs(lot.Quantity)
+		cost += float64(abs(lot.Quantity)) * lot.Price
+	}
+	if size == 0 {
+		return 0, 0
+	}
+	return quantity, cost / float64(size)
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	}
+	return 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,99 @@
+package ledger
+
+import (
+	"testing"
+
+	"trading-app/internal/models"
+)
+
+// step is a fill: a signed quantity, negative for sells, at a price
+type step struct {
+	quantity int
+	price    float64
+}
+
+func TestFill(t *testing.T) {
+	tests := []struct {
+		name     string
+		fills    []step
+		lots     []step
+		realized float64
+		quantity int
+		average  float64
+	}{
+		{
+			name:     "buy, partial sell, buy, sell through zero",
+			fills:    []step{{10, 100}, {-4, 110}, {5, 120}, {-15, 130}},
+			lots:     []step{{-4, 130}},
+			realized: 40 + 180 + 50,
+			quantity: -4,
+			average:  130,
+		},
+		{
+			name:     "buys close first in, first out",
+			fills:    []step{{10, 100}, {10, 110}, {-15, 120}},
+			lots:     []step{{5, 110}},
+			realized: 200 + 50,
+			quantity: 5,
+			average:  110,
+		},
+		{
+			name:     "buys on the same side stay separate lots",
+			fills:    []step{{10, 100}, {10, 110}},
+			lots:     []step{{10, 100}, {10, 110}},
+			quantity: 20,
+			average:  105,
+		},
+		{
+			name:     "short covered at a profit then at a loss",
+			fills:    []step{{-10, 200}, {4, 190}, {6, 210}},
+			realized: 40 - 60,
+		},
+		{
+			name:     "short bought through zero",
+			fills:    []step{{-5, 100}, {8, 90}},
+			lots:     []step{{3, 90}},
+			realized: 50,
+			quantity: 3,
+			average:  90,
+		},
+		{
+			name:     "shorts close first in, first out",
+			fills:    []step{{-5, 100}, {-5, 120}, {7, 110}},
+			lots:     []step{{-3, 120}},
+			realized: -50 + 20,
+			quantity: -3,
+			average:  120,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lots := []*models.PositionLot{}
+			var realized float64
+			for _, f := range tt.fills {
+				var r float64
+				lots, r = fill(lots, f.quantity, f.price)
+				realized += r
+			}
+
+			got := make([]step, len(lots))
+			for i, lot := range lots {
+				got[i] = step{lot.Quantity, lot.Price}
+			}
+			if len(got) != len(tt.lots) {
+				t.Fatalf("lots = %v, want %v", got, tt.lots)
+			}
+			for i := range got {
+				if got[i] != tt.lots[i] {
+					t.Fatalf("lots = %v, want %v", got, tt.lots)
+				}
+			}
+			if realized != tt.realized {
+				t.Errorf("realized = %.2f, want %.2f", realized, tt.realized)
+			}
+			if quantity, average := average(lots); quantity != tt.quantity || average != tt.average {
+				t.Errorf("average = %d at %.2f, want %d at %.2f", quantity, average, tt.quantity, tt.average)
+			}
+		})
+	}
+}
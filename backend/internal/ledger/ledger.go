@@ -0,0 +1,279 @@
+// Package ledger keeps the open_positions of every user up to date from their
+// executed trades, and checks them against the position books of the brokers
+// they trade with.
+package ledger
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+)
+
+// Ledger maintains the position ledger. Positions are kept per broker,
+// exchange and product; open lots are closed first in, first out.
+type Ledger struct {
+	db      *database.DB
+	brokers *broker.Selector
+
+	// Serializes ledger updates
+	mu sync.Mutex
+	// Serializes passes over the executed trades, so none is applied twice
+	applying sync.Mutex
+}
+
+// NewLedger creates a position ledger
+func NewLedger(db *database.DB, brokers *broker.Selector) *Ledger {
+	return &Ledger{
+		db:      db,
+		brokers: brokers,
+	}
+}
+
+// Start applies executed trades and reconciles every user's positions with
+// their brokers now and then at the given interval
+func (l *Ledger) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			l.ApplyExecutedTrades()
+			l.ReconcileAll()
+			<-ticker.C
+		}
+	}()
+}
+
+// ApplyExecutedTrades adds the executed trades that are not in the ledger yet
+func (l *Ledger) ApplyExecutedTrades() {
+	l.applying.Lock()
+	defer l.applying.Unlock()
+
+	trades, err := l.db.GetUnappliedTrades()
+	if err != nil {
+		log.Printf("LEDGER: Failed to load executed trades: %v", err)
+		return
+	}
+	for _, trade := range trades {
+		quantity, price := trade.Quantity, trade.Price
+		if trade.FilledQuantity > 0 && trade.AveragePrice > 0 {
+			quantity, price = trade.FilledQuantity, trade.AveragePrice
+		}
+		if _, err := l.Apply(trade, quantity, price); err != nil {
+			log.Printf("LEDGER: Failed to apply trade %d: %v", trade.ID, err)
+		}
+	}
+}
+
+// Apply adds a fill of a trade to the user's position. Fills in the direction
+// of the position open a new lot; fills on the other side close the oldest
+// lots first and realize their P&L. The side is the one the broker filled,
+// when it reported one. A trade that can never be applied is marked so that
+// it is not retried.
+func (l *Ledger) Apply(trade *models.Trade, quantity int, price float64) (*models.OpenPosition, error) {
+	action := strings.ToUpper(trade.Action)
+	if trade.FilledAction != "" {
+		action = strings.ToUpper(trade.FilledAction)
+	}
+	if trade.Exchange == "" || quantity <= 0 || price <= 0 || (action != "BUY" && action != "SELL") {
+		if err := l.db.MarkTradeApplied(trade.ID); err != nil {
+			log.Printf("LEDGER: Failed to mark trade %d: %v", trade.ID, err)
+		}
+		return nil, fmt.Errorf("trade %d has no exchange, action, quantity or fill price", trade.ID)
+	}
+
+	brokerName := trade.Broker
+	if brokerName == "" {
+		strategyID := 0
+		if trade.StrategyID != nil {
+			strategyID = *trade.StrategyID
+		}
+		name, err := l.brokers.Resolve(trade.UserID, strategyID)
+		if err != nil {
+			return nil, err
+		}
+		brokerName = name
+	}
+	symbol := strings.ToUpper(trade.Symbol)
+	exchange := strings.ToUpper(trade.Exchange)
+	product := strings.ToUpper(trade.Product)
+	if product == "" {
+		product = "MIS"
+	}
+	if action == "SELL" {
+		quantity = -quantity
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	position, err := l.db.GetOpenPosition(trade.UserID, brokerName, symbol, exchange, product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load position: %w", err)
+	}
+	if position == nil {
+		position = &models.OpenPosition{UserID: trade.UserID, Broker: brokerName, Symbol: symbol, Exchange: exchange, Product: product}
+	}
+	lots, err := l.db.GetPositionLots(trade.UserID, brokerName, symbol, exchange, product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load position lots: %w", err)
+	}
+
+	opened := time.Now()
+	if trade.ExecutedAt != nil {
+		opened = *trade.ExecutedAt
+	}
+	lots, realized := fill(lots, quantity, price)
+	for _, lot := range lots {
+		if lot.ID == 0 {
+			lot.TradeID = trade.ID
+			lot.OpenedAt = opened
+		}
+	}
+	position.Quantity, position.EntryPrice = average(lots)
+	position.RealizedPnL += realized
+
+	if err := l.db.RecordPositionFill(position, lots, trade.ID, realized); err != nil {
+		return nil, fmt.Errorf("failed to save position: %w", err)
+	}
+	log.Printf("LEDGER: User %d %s %d %s:%s (%s) at %.2f on %s, position now %d at %.2f",
+		trade.UserID, action, abs(quantity), exchange, symbol, product, price, brokerName, position.Quantity, position.EntryPrice)
+	return position, nil
+}
+
+// Positions returns the ledger of a user, with open positions valued at the
+// live price
+func (l *Ledger) Positions(userID int) ([]*models.OpenPosition, error) {
+	positions, err := l.db.GetPositionLedger(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range positions {
+		if pos.Quantity == 0 {
+			continue
+		}
+		b, err := l.brokers.ByName(userID, pos.Broker)
+		if err != nil {
+			continue
+		}
+		quote, err := b.Quote(pos.Symbol, pos.Exchange)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch quote for %s on %s: %v", pos.Symbol, pos.Exchange, err)
+			continue
+		}
+		pos.LTP = quote.LTP
+		pos.UnrealizedPnL = (quote.LTP - pos.EntryPrice) * float64(pos.Quantity)
+	}
+	return positions, nil
+}
+
+// ReconcileAll reconciles the positions of every user who has traded
+func (l *Ledger) ReconcileAll() {
+	userIDs, err := l.db.GetLedgerUserIDs()
+	if err != nil {
+		log.Printf("LEDGER: Failed to load users: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		if _, err := l.Reconcile(userID); err != nil {
+			log.Printf("LEDGER: Failed to reconcile user %d: %v", userID, err)
+		}
+	}
+}
+
+// Reconcile compares the ledger of a user with the position books of the
+// brokers they trade with, records the broker quantities and flags the
+// positions whose quantities differ. It returns the mismatched positions.
+func (l *Ledger) Reconcile(userID int) ([]*models.OpenPosition, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ledger, err := l.db.GetPositionLedger(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ledger: %w", err)
+	}
+	byBroker := make(map[string][]*models.OpenPosition)
+	for _, pos := range ledger {
+		byBroker[pos.Broker] = append(byBroker[pos.Broker], pos)
+	}
+	if name, err := l.brokers.Resolve(userID, 0); err == nil {
+		if _, ok := byBroker[name]; !ok {
+			byBroker[name] = nil
+		}
+	}
+
+	mismatched := []*models.OpenPosition{}
+	failed := []string{}
+	for name, positions := range byBroker {
+		held, err := l.brokerQuantities(userID, name)
+		if err != nil {
+			log.Printf("LEDGER: Failed to read the %s position book of user %d: %v", name, userID, err)
+			failed = append(failed, name)
+			continue
+		}
+
+		for _, pos := range positions {
+			k := broker.PositionKey{Symbol: pos.Symbol, Exchange: pos.Exchange, Product: pos.Product}
+			l.record(pos, held[k])
+			delete(held, k)
+		}
+		// Positions the ledger does not know, such as orders placed outside the app
+		for k, quantity := range held {
+			if quantity == 0 {
+				continue
+			}
+			pos := &models.OpenPosition{UserID: userID, Broker: name, Symbol: k.Symbol, Exchange: k.Exchange, Product: k.Product}
+			l.record(pos, quantity)
+			positions = append(positions, pos)
+		}
+
+		for _, pos := range positions {
+			if pos.Mismatch {
+				mismatched = append(mismatched, pos)
+			}
+		}
+	}
+
+	sort.Slice(mismatched, func(i, j int) bool { return mismatched[i].Symbol < mismatched[j].Symbol })
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return mismatched, fmt.Errorf("failed to read the position book of %s", strings.Join(failed, ", "))
+	}
+	return mismatched, nil
+}
+
+// brokerQuantities returns the net quantities a broker holds for a user
+func (l *Ledger) brokerQuantities(userID int, name string) (map[broker.PositionKey]int, error) {
+	b, err := l.brokers.ByName(userID, name)
+	if err != nil {
+		return nil, err
+	}
+	return broker.Book(b)
+}
+
+// record saves the quantity a broker reported for a position and flags a
+// mismatch with the ledger quantity
+func (l *Ledger) record(pos *models.OpenPosition, brokerQuantity int) {
+	flagged := pos.Mismatch
+	pos.BrokerQuantity = &brokerQuantity
+	pos.Mismatch = brokerQuantity != pos.Quantity
+
+	switch {
+	case pos.Mismatch && !flagged:
+		log.Printf("LEDGER: Mismatch for user %d on %s %s:%s (%s): ledger %d, broker %d",
+			pos.UserID, pos.Broker, pos.Exchange, pos.Symbol, pos.Product, pos.Quantity, brokerQuantity)
+	case !pos.Mismatch && flagged:
+		log.Printf("LEDGER: Mismatch for user %d on %s %s:%s (%s) resolved at %d",
+			pos.UserID, pos.Broker, pos.Exchange, pos.Symbol, pos.Product, brokerQuantity)
+	}
+
+	if err := l.db.SavePositionReconciliation(pos); err != nil {
+		log.Printf("LEDGER: Failed to save reconciliation of %s:%s for user %d: %v", pos.Exchange, pos.Symbol, pos.UserID, err)
+	}
+}
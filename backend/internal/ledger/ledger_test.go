@@ -0,0 +1,175 @@
+package ledger
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+// quoteBroker quotes every symbol at the same price. Paper accounts only use
+// the live broker for quotes.
+type quoteBroker struct {
+	broker.Broker
+	ltp float64
+}
+
+func (b *quoteBroker) Quote(symbol, exchange string) (*openalgo.OpenAlgoQuoteData, error) {
+	return &openalgo.OpenAlgoQuoteData{LTP: b.ltp}, nil
+}
+
+// newTestLedger returns a ledger on a new database whose users trade on paper
+func newTestLedger(t *testing.T) (*Ledger, *database.DB, *broker.Selector) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "trading.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	brokers := broker.NewSelector(db, &quoteBroker{ltp: 100}, nil, broker.Paper, broker.PaperConfig{InitialCash: 1000000})
+	return NewLedger(db, brokers), db, brokers
+}
+
+func executed(action, symbol string, quantity int, price float64) *models.Trade {
+	return &models.Trade{UserID: 1, Symbol: symbol, Exchange: "NSE", Product: "MIS", Broker: broker.Paper, Action: action, Quantity: quantity, Price: price}
+}
+
+func TestApply(t *testing.T) {
+	l, db, _ := newTestLedger(t)
+
+	// Lots are reloaded from the database between fills
+	fills := []*models.Trade{
+		executed("BUY", "RELIANCE", 10, 100),
+		executed("SELL", "RELIANCE", 4, 110),
+		executed("BUY", "RELIANCE", 5, 120),
+		executed("SELL", "RELIANCE", 15, 130),
+	}
+	for _, trade := range fills {
+		if _, err := l.Apply(trade, trade.Quantity, trade.Price); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+
+	position, err := db.GetOpenPosition(1, broker.Paper, "RELIANCE", "NSE", "MIS")
+	if err != nil || position == nil {
+		t.Fatalf("GetOpenPosition = %v, %v", position, err)
+	}
+	if position.Quantity != -4 || position.EntryPrice != 130 || position.RealizedPnL != 270 {
+		t.Errorf("position = %d at %.2f with %.2f realized, want -4 at 130.00 with 270.00 realized",
+			position.Quantity, position.EntryPrice, position.RealizedPnL)
+	}
+	lots, err := db.GetPositionLots(1, broker.Paper, "RELIANCE", "NSE", "MIS")
+	if err != nil {
+		t.Fatalf("GetPositionLots failed: %v", err)
+	}
+	if len(lots) != 1 || lots[0].Quantity != -4 || lots[0].Price != 130 {
+		t.Errorf("lots = %+v, want one lot of -4 at 130", lots)
+	}
+
+	// A smart order to sell while short closed the position instead: the fill
+	// is booked on the side and for the quantity the broker reported
+	closing := executed("SELL", "RELIANCE", 2, 125)
+	closing.FilledAction = "BUY"
+	if position, err = l.Apply(closing, 4, 125); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if position.Quantity != 0 || position.RealizedPnL != 290 {
+		t.Errorf("position after the closing fill = %d with %.2f realized, want 0 with 290.00 realized", position.Quantity, position.RealizedPnL)
+	}
+
+	if _, err := l.Apply(executed("HOLD", "RELIANCE", 1, 100), 1, 100); err == nil {
+		t.Error("Apply of a trade with no valid action succeeded")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	l, db, brokers := newTestLedger(t)
+	paper := brokers.PaperAccount(1)
+
+	place := func(action, symbol string, quantity int) {
+		t.Helper()
+		_, err := paper.PlaceSmartOrder(&openalgo.OpenAlgoSmartOrderRequest{
+			Symbol: symbol, Exchange: "NSE", Action: action, Product: "MIS", Pricetype: "MARKET", Quantity: quantity,
+		})
+		if err != nil {
+			t.Fatalf("PlaceSmartOrder failed: %v", err)
+		}
+	}
+	apply := func(action, symbol string, quantity int) {
+		t.Helper()
+		if _, err := l.Apply(executed(action, symbol, quantity, 100), quantity, 100); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+
+	place("BUY", "RELIANCE", 10)
+	apply("BUY", "RELIANCE", 10)
+	place("SELL", "INFY", 5)
+	apply("SELL", "INFY", 5)
+	apply("BUY", "SBIN", 7) // Never reached the broker
+	place("BUY", "TCS", 3)  // Placed outside the app
+
+	mismatched, err := l.Reconcile(1)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	want := []struct {
+		symbol         string
+		quantity       int
+		brokerQuantity int
+	}{
+		{"SBIN", 7, 0},
+		{"TCS", 0, 3},
+	}
+	if len(mismatched) != len(want) {
+		t.Fatalf("Reconcile returned %d mismatches, want %d", len(mismatched), len(want))
+	}
+	for i, w := range want {
+		pos := mismatched[i]
+		if pos.Symbol != w.symbol || pos.Quantity != w.quantity || pos.BrokerQuantity == nil || *pos.BrokerQuantity != w.brokerQuantity {
+			t.Errorf("mismatch %d = %s ledger %d broker %v, want %s ledger %d broker %d",
+				i, pos.Symbol, pos.Quantity, pos.BrokerQuantity, w.symbol, w.quantity, w.brokerQuantity)
+		}
+	}
+
+	ledger, err := db.GetPositionLedger(1)
+	if err != nil {
+		t.Fatalf("GetPositionLedger failed: %v", err)
+	}
+	flagged := []string{}
+	for _, pos := range ledger {
+		if pos.BrokerQuantity == nil {
+			t.Errorf("%s was not reconciled", pos.Symbol)
+		}
+		if pos.Mismatch {
+			flagged = append(flagged, pos.Symbol)
+		}
+	}
+	if got := strings.Join(flagged, ","); got != "SBIN,TCS" {
+		t.Errorf("flagged positions = %s, want SBIN,TCS", got)
+	}
+
+	// The mismatches resolve once the ledger and the broker agree again
+	place("BUY", "SBIN", 7)
+	apply("BUY", "TCS", 3)
+	mismatched, err = l.Reconcile(1)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(mismatched) != 0 {
+		t.Errorf("Reconcile returned %d mismatches after resolving them, want none", len(mismatched))
+	}
+	ledger, err = db.GetPositionLedger(1)
+	if err != nil {
+		t.Fatalf("GetPositionLedger failed: %v", err)
+	}
+	for _, pos := range ledger {
+		if pos.Mismatch {
+			t.Errorf("%s is still flagged", pos.Symbol)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package marketdata
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"trading-app/internal/condition"
+	"trading-app/internal/openalgo"
+)
+
+// EvaluateCondition compiles and evaluates a Pine Script-like condition on the latest candles
+func (s *Store) EvaluateCondition(interval, pineCondition, symbol, exchange string) (bool, map[string]float64, error) {
+	program, err := condition.Compile(pineCondition)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid condition: %w", err)
+	}
+	return s.Evaluate(interval, program, symbol, exchange)
+}
+
+// Evaluate evaluates a compiled condition on the latest candles
+func (s *Store) Evaluate(interval string, program *condition.Program, symbol, exchange string) (bool, map[string]float64, error) {
+	log.Printf("Attempting to evaluate condition for %s on %s (%s): %s", symbol, exchange, interval, program.Source())
+
+	history := program.History(interval)
+	candles, err := s.Latest(symbol, exchange, interval, history[ownInterval(interval)])
+	if err != nil {
+		log.Printf("Error fetching history for %s: %v", symbol, err)
+		return false, nil, fmt.Errorf("failed to fetch required market data: %w", err)
+	}
+
+	if len(candles) == 0 {
+		log.Printf("No historical data found for %s on exchange %s in the specified range.", symbol, exchange)
+		return false, nil, fmt.Errorf("no historical data available to evaluate condition")
+	}
+
+	timeframes, err := s.Timeframes(symbol, exchange, interval, program.Intervals(), history)
+	if err != nil {
+		return false, nil, err
+	}
+
+	isConditionMet, indicatorValues, err := program.Eval(condition.Input{
+		Interval:   interval,
+		Bars:       openalgo.BarsFromCandles(candles),
+		Timeframes: timeframes,
+	})
+	if err != nil {
+		log.Printf("Error evaluating condition for %s: %v", symbol, err)
+		return false, nil, err
+	}
+
+	for name, value := range indicatorValues {
+		log.Printf("Calculated %s: %.2f", name, value)
+	}
+	log.Printf("Evaluation complete. Condition met: %t", isConditionMet)
+	return isConditionMet, indicatorValues, nil
+}
+
+// Latest returns at least the given number of the latest candles of a
+// series, if that much history exists
+func (s *Store) Latest(symbol, exchange, interval string, bars int) ([]openalgo.OpenAlgoCandle, error) {
+	bars = min(bars, maxCandles)
+	from := historyStart(s.calendar, exchange, interval, bars, time.Now())
+	log.Printf("Fetching %s history for %s on exchange %s: %d bars needed, from %s", interval, symbol, exchange, bars, from.Format("2006-01-02"))
+	return s.Candles(symbol, exchange, interval, from)
+}
+
+// Timeframes returns the latest bars of every interval a condition refers to,
+// other than its own, with as many bars as history (see Program.History) asks for
+func (s *Store) Timeframes(symbol, exchange, interval string, intervals []string, history map[string]int) (map[string][]condition.Bar, error) {
+	return s.timeframes(symbol, exchange, interval, intervals, func(other string) ([]openalgo.OpenAlgoCandle, error) {
+		return s.Latest(symbol, exchange, other, history[other])
+	})
+}
+
+// WarmHistory returns the candles of a series between two dates, preceded by
+// at least the given number of bars before the start, so that indicators
+// evaluated over them have settled by the start
+func (s *Store) WarmHistory(symbol, exchange, interval string, bars int, start, end time.Time) ([]openalgo.OpenAlgoCandle, error) {
+	return s.History(symbol, exchange, interval, historyStart(s.calendar, exchange, interval, bars, start), end)
+}
+
+// HistoryTimeframes returns the bars between two dates of every interval a
+// condition refers to, other than its own, each preceded by as many bars as
+// history (see Program.History) asks for
+func (s *Store) HistoryTimeframes(symbol, exchange, interval string, intervals []string, history map[string]int, start, end time.Time) (map[string][]condition.Bar, error) {
+	return s.timeframes(symbol, exchange, interval, intervals, func(other string) ([]openalgo.OpenAlgoCandle, error) {
+		return s.WarmHistory(symbol, exchange, other, history[other], start, end)
+	})
+}
+
+// ownInterval returns the canonical form of an interval where there is one
+func ownInterval(interval string) string {
+	if normalized, ok := condition.NormalizeInterval(interval); ok {
+		return normalized
+	}
+	return interval
+}
+
+func (s *Store) timeframes(symbol, exchange, interval string, intervals []string, fetch func(interval string) ([]openalgo.OpenAlgoCandle, error)) (map[string][]condition.Bar, error) {
+	own := ownInterval(interval)
+	timeframes := make(map[string][]condition.Bar)
+	for _, other := range intervals {
+		if _, fetched := timeframes[other]; fetched || other == own {
+			continue
+		}
+
+		candles, err := fetch(other)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s market data: %w", other, err)
+		}
+		timeframes[other] = openalgo.BarsFromCandles(candles)
+	}
+	return timeframes, nil
+}
@@ -0,0 +1,38 @@
+package marketdata
+
+import (
+	"math"
+	"time"
+
+	"trading-app/internal/calendar"
+	"trading-app/internal/condition"
+)
+
+// defaultLookbackDays is the history fetched for intervals whose bar length is unknown
+const defaultLookbackDays = 5
+
+// historyStart returns how far back to fetch so that at least the given
+// number of bars of an interval is available, counting only the days the
+// exchange trades on
+func historyStart(cal *calendar.Calendar, exchange, interval string, bars int, now time.Time) time.Time {
+	length, err := condition.IntervalDuration(interval)
+	if err != nil {
+		return now.AddDate(0, 0, -defaultLookbackDays)
+	}
+
+	var tradingDays int
+	switch {
+	case length >= 7*24*time.Hour:
+		tradingDays = bars * 5
+	case length >= 24*time.Hour:
+		tradingDays = bars
+	default:
+		perDay := int(math.Ceil(float64(cal.SessionMinutes(exchange)) / length.Minutes()))
+		tradingDays = (bars + perDay - 1) / perDay
+	}
+	// Today's session may have only just started, and a special session
+	// such as Muhurat trading counts as a day with only a few bars
+	tradingDays += 2
+
+	return cal.DaysBack(exchange, tradingDays, now)
+}
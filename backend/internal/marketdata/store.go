@@ -0,0 +1,329 @@
+// Package marketdata caches OpenAlgo candles so that the auto order engine,
+// the handlers and the backtester share one copy of each series instead of
+// downloading the full history on every evaluation.
+package marketdata
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/calendar"
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+const (
+	// refreshAfter is how long fetched candles are served without asking
+	// OpenAlgo for newer ones
+	refreshAfter = 5 * time.Second
+
+	// maxCandles is the rolling window kept in memory (and on disk) per series
+	maxCandles = 5000
+)
+
+// key identifies a candle series
+type key struct {
+	symbol   string
+	exchange string
+	interval string
+}
+
+func (k key) String() string {
+	return fmt.Sprintf("%s:%s@%s", k.exchange, k.symbol, k.interval)
+}
+
+// series is the cached window of one candle series, oldest candle first
+type series struct {
+	mu        sync.Mutex
+	loaded    bool      // Whether persisted candles have been read
+	from      time.Time // Start of the first day the window is complete from
+	full      bool      // Whether the window holds maxCandles, so older requests get what it holds
+	candles   []openalgo.OpenAlgoCandle
+	fetchedAt time.Time
+}
+
+// Source provides historical candles, such as an OpenAlgo client or any other broker
+type Source interface {
+	History(symbol, exchange, interval, startDate, endDate string) ([]openalgo.OpenAlgoCandle, error)
+}
+
+// Store is a candle cache keyed by symbol, exchange and interval. The first
+// request for a series downloads its history; later requests only fetch the
+// candles since the last one held. It is safe for concurrent use.
+type Store struct {
+	client   Source
+	db       *database.DB // Optional; nil keeps candles in memory only
+	calendar *calendar.Calendar
+
+	mu     sync.Mutex
+	series map[key]*series
+}
+
+// NewStore creates a candle store. If db is not nil, candles are also
+// persisted to SQLite so that the cache survives restarts. The calendar gives
+// the sessions that history is measured in.
+func NewStore(client Source, db *database.DB, cal *calendar.Calendar) *Store {
+	return &Store{
+		client:   client,
+		db:       db,
+		calendar: cal,
+		series:   make(map[key]*series),
+	}
+}
+
+// Calendar returns the exchange calendar of the store
+func (s *Store) Calendar() *calendar.Calendar {
+	return s.calendar
+}
+
+func newKey(symbol, exchange, interval string) key {
+	return key{
+		symbol:   strings.ToUpper(strings.TrimSpace(symbol)),
+		exchange: strings.ToUpper(strings.TrimSpace(exchange)),
+		interval: ownInterval(interval),
+	}
+}
+
+func (s *Store) get(k key) *series {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.series[k]
+	if !ok {
+		cached = &series{}
+		s.series[k] = cached
+	}
+	return cached
+}
+
+// Candles returns the candles of a series from the day of from up to now,
+// fetching only what the cache does not hold yet
+func (s *Store) Candles(symbol, exchange, interval string, from time.Time) ([]openalgo.OpenAlgoCandle, error) {
+	k := newKey(symbol, exchange, interval)
+	cached := s.get(k)
+	from = startOfDay(from)
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if !cached.loaded {
+		s.load(k, cached, from)
+	}
+
+	switch {
+	case len(cached.candles) == 0 || (from.Before(cached.from) && !cached.full):
+		if err := s.fetchAll(k, cached, from); err != nil {
+			return nil, err
+		}
+	case time.Since(cached.fetchedAt) >= refreshAfter:
+		if err := s.fetchLatest(k, cached); err != nil {
+			return nil, err
+		}
+	}
+
+	return since(cached.candles, from), nil
+}
+
+// History returns the candles of a series between two dates. It is served
+// from the cache when the cached window covers the range; older history is
+// fetched directly without being cached.
+func (s *Store) History(symbol, exchange, interval string, start, end time.Time) ([]openalgo.OpenAlgoCandle, error) {
+	k := newKey(symbol, exchange, interval)
+	cached := s.get(k)
+	start = startOfDay(start)
+	end = startOfDay(end).AddDate(0, 0, 1)
+
+	cached.mu.Lock()
+	covered := len(cached.candles) > 0 && !start.Before(cached.from) && cached.fetchedAt.After(end)
+	var candles []openalgo.OpenAlgoCandle
+	if covered {
+		candles = between(cached.candles, start, end)
+	}
+	cached.mu.Unlock()
+
+	if covered {
+		return candles, nil
+	}
+	return s.client.History(k.symbol, k.exchange, interval, start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"))
+}
+
+// LastPrice returns the close of the most recent candle held for a symbol in
+// any interval, without fetching anything. It reports false if none is held.
+func (s *Store) LastPrice(symbol, exchange string) (float64, time.Time, bool) {
+	k := newKey(symbol, exchange, "")
+	s.mu.Lock()
+	held := []*series{}
+	for other, cached := range s.series {
+		if other.symbol == k.symbol && other.exchange == k.exchange {
+			held = append(held, cached)
+		}
+	}
+	s.mu.Unlock()
+
+	var price float64
+	var at int64
+	for _, cached := range held {
+		cached.mu.Lock()
+		if n := len(cached.candles); n > 0 && cached.candles[n-1].Timestamp > at {
+			price, at = cached.candles[n-1].Close, cached.candles[n-1].Timestamp
+		}
+		cached.mu.Unlock()
+	}
+	return price, time.Unix(at, 0), at > 0
+}
+
+// load reads the persisted candles of a series into memory
+func (s *Store) load(k key, cached *series, from time.Time) {
+	cached.loaded = true
+	if s.db == nil {
+		return
+	}
+
+	stored, err := s.db.GetCandles(k.symbol, k.exchange, k.interval, from.Unix())
+	if err != nil {
+		log.Printf("CANDLES: Failed to load stored candles for %s: %v", k, err)
+		return
+	}
+	if len(stored) == 0 {
+		return
+	}
+
+	candles := make([]openalgo.OpenAlgoCandle, len(stored))
+	for i, c := range stored {
+		candles[i] = openalgo.OpenAlgoCandle{
+			Timestamp: c.Timestamp,
+			Open:      c.Open,
+			High:      c.High,
+			Low:       c.Low,
+			Close:     c.Close,
+			Volume:    c.Volume,
+			OI:        c.OI,
+		}
+	}
+	cached.candles = candles
+	// Stored candles are only known to be complete from the first one on
+	cached.from = startOfDay(time.Unix(candles[0].Timestamp, 0))
+	log.Printf("CANDLES: Loaded %d stored candles for %s", len(candles), k)
+}
+
+// fetchAll replaces the cached window with the full history from a day on
+func (s *Store) fetchAll(k key, cached *series, from time.Time) error {
+	now := time.Now()
+	log.Printf("CANDLES: Fetching %s history from %s", k, from.Format("2006-01-02"))
+	candles, err := s.client.History(k.symbol, k.exchange, k.interval, from.Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	sortCandles(candles)
+	cached.from = from
+	cached.fetchedAt = now
+	cached.candles = merge(since(cached.candles, from), candles)
+	s.trim(k, cached)
+	s.persist(k, candles)
+	return nil
+}
+
+// fetchLatest fetches the candles from the day of the last cached one on,
+// replacing the last candle, which may still have been forming
+func (s *Store) fetchLatest(k key, cached *series) error {
+	now := time.Now()
+	last := time.Unix(cached.candles[len(cached.candles)-1].Timestamp, 0)
+	candles, err := s.client.History(k.symbol, k.exchange, k.interval, last.Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	sortCandles(candles)
+	cached.fetchedAt = now
+	cached.candles = merge(cached.candles, candles)
+	s.trim(k, cached)
+	s.persist(k, candles)
+	return nil
+}
+
+// trim drops the oldest candles beyond the rolling window
+func (s *Store) trim(k key, cached *series) {
+	excess := len(cached.candles) - maxCandles
+	if excess <= 0 {
+		return
+	}
+
+	cached.candles = append([]openalgo.OpenAlgoCandle(nil), cached.candles[excess:]...)
+	first := time.Unix(cached.candles[0].Timestamp, 0)
+	// The first day may now be partial, so the window is complete from the next one
+	cached.from = startOfDay(first).AddDate(0, 0, 1)
+	cached.full = true
+
+	if s.db != nil {
+		if err := s.db.DeleteCandlesBefore(k.symbol, k.exchange, k.interval, cached.candles[0].Timestamp); err != nil {
+			log.Printf("CANDLES: Failed to delete old candles for %s: %v", k, err)
+		}
+	}
+}
+
+// persist stores fetched candles when persistence is enabled
+func (s *Store) persist(k key, candles []openalgo.OpenAlgoCandle) {
+	if s.db == nil || len(candles) == 0 {
+		return
+	}
+
+	rows := make([]*models.Candle, len(candles))
+	for i, c := range candles {
+		rows[i] = &models.Candle{
+			Symbol:    k.symbol,
+			Exchange:  k.exchange,
+			Interval:  k.interval,
+			Timestamp: c.Timestamp,
+			Open:      c.Open,
+			High:      c.High,
+			Low:       c.Low,
+			Close:     c.Close,
+			Volume:    c.Volume,
+			OI:        c.OI,
+		}
+	}
+	if err := s.db.SaveCandles(rows); err != nil {
+		log.Printf("CANDLES: Failed to store candles for %s: %v", k, err)
+	}
+}
+
+// merge combines cached candles with newer ones. Fetched candles win over
+// cached candles from the same time on, so a candle that was still forming
+// when it was cached is replaced.
+func merge(cached, fetched []openalgo.OpenAlgoCandle) []openalgo.OpenAlgoCandle {
+	if len(fetched) == 0 {
+		return cached
+	}
+	cut := sort.Search(len(cached), func(i int) bool {
+		return cached[i].Timestamp >= fetched[0].Timestamp
+	})
+	merged := make([]openalgo.OpenAlgoCandle, 0, cut+len(fetched))
+	merged = append(merged, cached[:cut]...)
+	return append(merged, fetched...)
+}
+
+// since returns a copy of the candles from a time on
+func since(candles []openalgo.OpenAlgoCandle, from time.Time) []openalgo.OpenAlgoCandle {
+	return between(candles, from, time.Unix(1<<62, 0))
+}
+
+// between returns a copy of the candles in [start, end)
+func between(candles []openalgo.OpenAlgoCandle, start, end time.Time) []openalgo.OpenAlgoCandle {
+	i := sort.Search(len(candles), func(i int) bool { return candles[i].Timestamp >= start.Unix() })
+	j := sort.Search(len(candles), func(i int) bool { return candles[i].Timestamp >= end.Unix() })
+	return append([]openalgo.OpenAlgoCandle{}, candles[i:j]...)
+}
+
+func sortCandles(candles []openalgo.OpenAlgoCandle) {
+	sort.SliceStable(candles, func(i, j int) bool { return candles[i].Timestamp < candles[j].Timestamp })
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -77,26 +77,49 @@ type BacktestResult struct {
 	CreatedAt      time.Time `json:"created_at"`
 }
 
+// Trade statuses. A pending trade is tracked with the broker until it
+// reaches one of the others.
+const (
+	TradePending    = "pending"
+	TradeExecuted   = "executed"
+	TradeRejected   = "rejected"
+	TradeCancelled  = "cancelled"
+	TradeFailed     = "failed"
+	TradeUnresolved = "unresolved" // The broker never reported a final status
+)
+
 // Trade represents a trade execution
 type Trade struct {
-	ID         int        `json:"id"`
-	UserID     int        `json:"user_id"`
-	StrategyID *int       `json:"strategy_id,omitempty"`
-	Symbol     string     `json:"symbol"`
-	Action     string     `json:"action"` // "BUY", "SELL"
-	Quantity   int        `json:"quantity"`
-	Price      float64    `json:"price"`
-	OrderType  string     `json:"order_type"` // "MARKET", "LIMIT"
-	Status     string     `json:"status"`     // "pending", "executed", "failed"
-	OrderID    string     `json:"order_id,omitempty"`
-	CreatedAt  time.Time  `json:"created_at"`
-	ExecutedAt *time.Time `json:"executed_at,omitempty"`
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	StrategyID     *int       `json:"strategy_id,omitempty"`
+	AutoOrderID    string     `json:"auto_order_id,omitempty"` // Auto order that placed the trade
+	Symbol         string     `json:"symbol"`
+	Exchange       string     `json:"exchange"`
+	Product        string     `json:"product"`
+	Broker         string     `json:"broker"` // Broker the order was placed with
+	Action         string     `json:"action"` // "BUY", "SELL"
+	Quantity       int        `json:"quantity"`
+	Price          float64    `json:"price"`
+	OrderType      string     `json:"order_type"` // "MARKET", "LIMIT"
+	Status         string     `json:"status"`     // One of the Trade statuses
+	OrderID        string     `json:"order_id,omitempty"`
+	BrokerStatus   string     `json:"broker_status,omitempty"` // Last order status reported by the broker
+	FilledAction   string     `json:"filled_action,omitempty"` // Side the broker filled; a smart order closing a position fills the other side
+	FilledQuantity int        `json:"filled_quantity"`
+	AveragePrice   float64    `json:"average_price"` // Fill price
+	StatusMessage  string     `json:"status_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"` // Last status change
+	ExecutedAt     *time.Time `json:"executed_at,omitempty"`
 }
 
 // Position represents an open position
 type Position struct {
 	Symbol       string    `json:"symbol"`
-	Quantity     int       `json:"quantity"`
+	Exchange     string    `json:"exchange"`
+	Product      string    `json:"product"`
+	Quantity     int       `json:"quantity"` // Negative when short
 	AvgPrice     float64   `json:"avg_price"`
 	CurrentPrice float64   `json:"current_price"`
 	PnL          float64   `json:"pnl"`
@@ -115,14 +138,38 @@ type Portfolio struct {
 	Positions       []Position `json:"positions"`
 }
 
-// OpenPosition represents a currently held position in the portfolio
+// OpenPosition is a position of the open_positions ledger, built from the
+// user's executed trades and checked against the broker's position book
 type OpenPosition struct {
-	UserID     int       `json:"user_id"`
-	Symbol     string    `json:"symbol"`
-	Exchange   string    `json:"exchange"`
-	Quantity   int       `json:"quantity"`
-	EntryPrice float64   `json:"entry_price"`
-	CreatedAt  time.Time `json:"created_at"`
+	UserID         int        `json:"user_id"`
+	Broker         string     `json:"broker"`
+	Symbol         string     `json:"symbol"`
+	Exchange       string     `json:"exchange"`
+	Product        string     `json:"product"`
+	Quantity       int        `json:"quantity"`    // Negative when short
+	EntryPrice     float64    `json:"entry_price"` // FIFO average price of the open lots
+	RealizedPnL    float64    `json:"realized_pnl"`
+	UnrealizedPnL  float64    `json:"unrealized_pnl"` // At LTP; not stored
+	LTP            float64    `json:"ltp,omitempty"`  // Not stored
+	BrokerQuantity *int       `json:"broker_quantity,omitempty"`
+	Mismatch       bool       `json:"mismatch"` // The broker reported a different quantity
+	ReconciledAt   *time.Time `json:"reconciled_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// PositionLot is an open lot of a ledger position. Exits close the oldest lots first.
+type PositionLot struct {
+	ID       int       `json:"id"`
+	UserID   int       `json:"user_id"`
+	Broker   string    `json:"broker"`
+	Symbol   string    `json:"symbol"`
+	Exchange string    `json:"exchange"`
+	Product  string    `json:"product"`
+	TradeID  int       `json:"trade_id"`
+	Quantity int       `json:"quantity"` // Negative for short lots
+	Price    float64   `json:"price"`
+	OpenedAt time.Time `json:"opened_at"`
 }
 
 // OrderState represents the current state of an auto order
@@ -135,26 +182,253 @@ const (
 	StateCompleted
 	StateFailed
 	StateExpired
+	StateCancelled
+	StateMarketClosed // Waiting for the exchange to open
+	StateScheduled    // Waiting for the time of a scheduled order
 )
 
+// String returns a human-readable name for the order state
+func (s OrderState) String() string {
+	switch s {
+	case StateMonitoring:
+		return "monitoring"
+	case StateEvaluating:
+		return "evaluating"
+	case StateExecuting:
+		return "executing"
+	case StateCompleted:
+		return "completed"
+	case StateFailed:
+		return "failed"
+	case StateExpired:
+		return "expired"
+	case StateCancelled:
+		return "cancelled"
+	case StateMarketClosed:
+		return "market_closed"
+	case StateScheduled:
+		return "scheduled"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the order state by name in JSON responses
+func (s OrderState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 // AutoOrder represents a running background conditional order
 type AutoOrder struct {
-	ID        string    `json:"id"`        // Unique ID for tracking/cancellation
+	ID           string    `json:"id"` // Unique ID for tracking/cancellation
+	UserID       int       `json:"user_id"`
+	Symbol       string    `json:"symbol"`
+	Exchange     string    `json:"exchange"`
+	Product      string    `json:"product"` // MIS, NRML, CNC
+	Quantity     int       `json:"quantity"`
+	Action       string    `json:"action"`
+	Interval     string    `json:"interval"`
+	Condition    string    `json:"condition"`             // Empty for scheduled orders
+	ScheduleAt   string    `json:"schedule_at,omitempty"` // Time of day a scheduled order fires at instead of on a condition, as "09:20" IST
+	Recurring    bool      `json:"recurring,omitempty"`   // Scheduled orders that fire on every trading day until they expire
+	StopLoss     string    `json:"stop_loss,omitempty"`   // Bracket of every fill, as "1450", "2%" or "1.5atr"
+	Target       string    `json:"target,omitempty"`
+	TrailingStop string    `json:"trailing_stop,omitempty"` // Trailing stop of every fill, as "20" points, "1%" or "2atr"
+	TrailOn      string    `json:"trail_on,omitempty"`      // tick or interval
+	Status       string    `json:"status"`                  // e.g., "running", "executed", "cancelled"
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // Defines when monitoring stops
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Fire tracking, persisted so a restart does not re-fire on an old edge
+	FireCount   int        `json:"fire_count"`
+	LastFiredAt *time.Time `json:"last_fired_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	// Result of the most recent evaluation, kept in memory only
+	LastValues      map[string]float64 `json:"last_values,omitempty"`
+	LastResult      bool               `json:"last_result"`
+	LastEvaluatedAt *time.Time         `json:"last_evaluated_at,omitempty"`
+	NextRunAt       *time.Time         `json:"next_run_at,omitempty"` // Of scheduled orders
+
+	// State management fields
+	State          OrderState   `json:"state"`
+	ConditionState bool         `json:"condition_state"` // Tracks the last known state of the condition (true/false)
+	StateMux       sync.RWMutex `json:"-"`
+	CleanupOnce    sync.Once    `json:"-"`
+}
+
+// AutoOrderFire records a single trigger of an auto order
+type AutoOrderFire struct {
+	ID            int       `json:"id"`
+	AutoOrderID   string    `json:"auto_order_id"`
+	BrokerOrderID string    `json:"broker_order_id,omitempty"`
+	TriggerValues string    `json:"trigger_values"` // JSON string of indicator values at trigger time
+	Status        string    `json:"status"`         // "placed", "failed"
+	Error         string    `json:"error,omitempty"`
+	FiredAt       time.Time `json:"fired_at"`
+}
+
+// Candle is a stored OHLCV bar of a symbol at a given interval
+type Candle struct {
+	Symbol    string  `json:"symbol"`
+	Exchange  string  `json:"exchange"`
+	Interval  string  `json:"interval"`
+	Timestamp int64   `json:"timestamp"` // Unix timestamp of the bar start
+	Open      float64 `json:"open"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Close     float64 `json:"close"`
+	Volume    int64   `json:"volume"`
+	OI        int64   `json:"oi"`
+}
+
+// BrokerSetting selects the broker that places a user's orders. A setting
+// with a strategy ID applies to that strategy only and overrides the user's.
+type BrokerSetting struct {
+	UserID     int       `json:"user_id"`
+	StrategyID int       `json:"strategy_id"` // 0 for the user's default
+	Broker     string    `json:"broker"`      // "openalgo" or "paper"
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PaperAccount is the cash ledger of a user's paper trading account
+type PaperAccount struct {
+	UserID      int       `json:"user_id"`
+	Cash        float64   `json:"cash"`
+	InitialCash float64   `json:"initial_cash"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PaperPosition is a net position held in a paper trading account
+type PaperPosition struct {
+	UserID       int       `json:"user_id"`
+	Symbol       string    `json:"symbol"`
+	Exchange     string    `json:"exchange"`
+	Product      string    `json:"product"`
+	Quantity     int       `json:"quantity"` // Negative when short
+	AveragePrice float64   `json:"average_price"`
+	RealizedPnL  float64   `json:"realized_pnl"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PaperOrder is an order placed with the paper broker
+type PaperOrder struct {
+	ID             string    `json:"id"`
+	UserID         int       `json:"user_id"`
+	Strategy       string    `json:"strategy"`
+	Symbol         string    `json:"symbol"`
+	Exchange       string    `json:"exchange"`
+	Action         string    `json:"action"`
+	Product        string    `json:"product"`
+	PriceType      string    `json:"price_type"` // MARKET, LIMIT, SL, SL-M
+	Quantity       int       `json:"quantity"`
+	Price          float64   `json:"price"`
+	TriggerPrice   float64   `json:"trigger_price"`
+	FilledQuantity int       `json:"filled_quantity"`
+	AveragePrice   float64   `json:"average_price"`
+	Status         string    `json:"status"` // "open", "complete", "cancelled", "rejected"
+	Message        string    `json:"message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Bracket statuses. A bracket waits for its entry order to fill, then
+// watches its stop-loss and target until one of them closes the position.
+const (
+	BracketPending   = "pending"   // Waiting for the entry order to fill
+	BracketActive    = "active"    // Watching the stop-loss and target
+	BracketExiting   = "exiting"   // A leg triggered and its exit order is working
+	BracketClosed    = "closed"    // A leg filled and the other one was cancelled
+	BracketCancelled = "cancelled" // The entry did not fill, or the bracket was cancelled
+)
+
+// Bracket exit reasons
+const (
+	ExitStopLoss     = "stop_loss"
+	ExitTarget       = "target"
+	ExitTrailingStop = "trailing_stop"
+)
+
+// Bracket is a stop-loss and target attached to an entry order and managed by
+// the server: when the price reaches one leg, the position is closed with a
+// market order and the other leg is cancelled (one-cancels-other).
+type Bracket struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	AutoOrderID  string     `json:"auto_order_id,omitempty"`
+	Broker       string     `json:"broker"`
+	Symbol       string     `json:"symbol"`
+	Exchange     string     `json:"exchange"`
+	Product      string     `json:"product"`
+	Action       string     `json:"action"` // Action of the entry order
+	Quantity     int        `json:"quantity"`
+	Interval     string     `json:"interval"`       // Candles of the ATR of ATR based levels
+	StopLossSpec string     `json:"stop_loss_spec"` // As requested: "1450", "2%" or "1.5atr"
+	TargetSpec   string     `json:"target_spec"`
+	TrailingStop string     `json:"trailing_stop,omitempty"` // As requested: "20" points, "1%" or "2atr"
+	TrailOn      string     `json:"trail_on,omitempty"`      // tick or interval
+	EntryTradeID int        `json:"entry_trade_id"`
+	EntryPrice   float64    `json:"entry_price"`
+	StopLoss     float64    `json:"stop_loss"` // Price levels, set once the entry fills
+	Target       float64    `json:"target"`
+	ATR          float64    `json:"atr,omitempty"`           // At the entry, for ATR based levels
+	ExtremePrice float64    `json:"extreme_price,omitempty"` // Highest price since a long entry, lowest since a short one
+	TrailedAt    *time.Time `json:"trailed_at,omitempty"`    // Start of the last candle an interval trailing stop followed
+	Status       string     `json:"status"`
+	ExitReason   string     `json:"exit_reason,omitempty"`
+	ExitTradeID  *int       `json:"exit_trade_id,omitempty"`
+	ExitAttempts int        `json:"exit_attempts,omitempty"` // Exits that could not be placed or did not fill
+	TriggerPrice float64    `json:"trigger_price,omitempty"` // Price that triggered the exit
+	ExitPrice    float64    `json:"exit_price,omitempty"`
+	RealizedPnL  float64    `json:"realized_pnl"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+}
+
+// RiskLimits are the pre-trade limits of a user. A zero limit is not enforced.
+type RiskLimits struct {
+	UserID             int       `json:"user_id"`
+	MaxOrderValue      float64   `json:"max_order_value"`       // Quantity times price of a single order
+	MaxSymbolQuantity  int       `json:"max_symbol_quantity"`   // Absolute position in one symbol after an order
+	MaxOpenPositions   int       `json:"max_open_positions"`    // Symbols with an open position
+	MaxDailyLoss       float64   `json:"max_daily_loss"`        // Realized loss since midnight IST, as a positive amount
+	MaxOrdersPerMinute int       `json:"max_orders_per_minute"` // Orders let through in the last minute
+	BlockedSymbols     []string  `json:"blocked_symbols"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// RiskRejection is an order the risk checks refused
+type RiskRejection struct {
+	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
+	Source    string    `json:"source"` // manual, auto_order, ...
 	Symbol    string    `json:"symbol"`
 	Exchange  string    `json:"exchange"`
-	Product   string    `json:"product"`   // MIS, NRML, CNC
-	Quantity  int       `json:"quantity"`
 	Action    string    `json:"action"`
-	Interval  string    `json:"interval"`
-	Condition string    `json:"condition"`
-	Status    string    `json:"status"`    // e.g., "running", "executed", "cancelled"
+	Quantity  int       `json:"quantity"`
+	Price     float64   `json:"price"`
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason"`
 	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"` // Defines when monitoring stops
-	
-	// State management fields
-	State          OrderState
-	ConditionState bool // Tracks the last known state of the condition (true/false)
-	StateMux       sync.RWMutex
-	CleanupOnce    sync.Once
-}
\ No newline at end of file
+}
+
+// KillSwitch blocks every new order of every user while it is engaged
+type KillSwitch struct {
+	Engaged   bool      `json:"engaged"`
+	Reason    string    `json:"reason,omitempty"`
+	By        string    `json:"by,omitempty"` // Username of whoever last changed it
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SquareOffSetting is when the intraday (MIS) auto orders and positions of a
+// user are closed on each trading day
+type SquareOffSetting struct {
+	UserID    int       `json:"user_id"`
+	Time      string    `json:"time"` // "15:15", IST
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
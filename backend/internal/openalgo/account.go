@@ -0,0 +1,283 @@
+package openalgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Number is a numeric field that OpenAlgo sends either as a JSON number or as a string
+type Number float64
+
+// UnmarshalJSON accepts 12.5, "12.5" and empty values
+func (n *Number) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*n = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", string(data))
+	}
+	*n = Number(value)
+	return nil
+}
+
+type OpenAlgoModifyOrderRequest struct {
+	Apikey            string  `json:"apikey"`
+	Strategy          string  `json:"strategy"`
+	Symbol            string  `json:"symbol"`
+	Exchange          string  `json:"exchange"`
+	OrderID           string  `json:"orderid"`
+	Action            string  `json:"action"`
+	Product           string  `json:"product"`
+	Pricetype         string  `json:"pricetype"`
+	Price             float64 `json:"price"`
+	Quantity          int     `json:"quantity"`
+	DisclosedQuantity int     `json:"disclosed_quantity"`
+	TriggerPrice      float64 `json:"trigger_price"`
+}
+
+type OpenAlgoCancelOrderRequest struct {
+	Apikey   string `json:"apikey"`
+	Strategy string `json:"strategy"`
+	OrderID  string `json:"orderid"`
+}
+
+type OpenAlgoCancelAllOrdersRequest struct {
+	Apikey   string `json:"apikey"`
+	Strategy string `json:"strategy"`
+}
+
+// OpenAlgoOrderResponse is the reply to order modifications and cancellations
+type OpenAlgoOrderResponse struct {
+	Status  string `json:"status"`
+	OrderID string `json:"orderid"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// OpenAlgoFailedCancellation is an order that cancelallorder could not cancel
+type OpenAlgoFailedCancellation struct {
+	OrderID string `json:"orderid"`
+	Message string `json:"message,omitempty"`
+}
+
+// OpenAlgoCancelAllOrdersResponse lists the orders cancelled by cancelallorder
+type OpenAlgoCancelAllOrdersResponse struct {
+	Status              string                       `json:"status"`
+	Message             string                       `json:"message,omitempty"`
+	CanceledOrders      []string                     `json:"canceled_orders"`
+	FailedCancellations []OpenAlgoFailedCancellation `json:"failed_cancellations"`
+	Error               string                       `json:"error,omitempty"`
+}
+
+type OpenAlgoAccountRequest struct {
+	Apikey string `json:"apikey"`
+}
+
+// OpenAlgoPosition is an entry of the broker position book
+type OpenAlgoPosition struct {
+	Symbol       string `json:"symbol"`
+	Exchange     string `json:"exchange"`
+	Product      string `json:"product"`
+	Quantity     Number `json:"quantity"`
+	AveragePrice Number `json:"average_price"`
+	LTP          Number `json:"ltp,omitempty"`
+	PnL          Number `json:"pnl,omitempty"`
+}
+
+type OpenAlgoPositionBookResponse struct {
+	Status string             `json:"status"`
+	Data   []OpenAlgoPosition `json:"data"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// OpenAlgoHolding is a delivery holding of the demat account. Brokers that
+// do not report the average price and LTP leave them zero.
+type OpenAlgoHolding struct {
+	Symbol       string `json:"symbol"`
+	Exchange     string `json:"exchange"`
+	Product      string `json:"product"`
+	Quantity     Number `json:"quantity"`
+	AveragePrice Number `json:"average_price,omitempty"`
+	LTP          Number `json:"ltp,omitempty"`
+	PnL          Number `json:"pnl"`
+	PnLPercent   Number `json:"pnlpercent"`
+}
+
+type OpenAlgoHoldingsStatistics struct {
+	TotalHoldingValue  Number `json:"totalholdingvalue"`
+	TotalInvValue      Number `json:"totalinvvalue"`
+	TotalProfitAndLoss Number `json:"totalprofitandloss"`
+	TotalPnLPercentage Number `json:"totalpnlpercentage"`
+}
+
+type OpenAlgoHoldingsData struct {
+	Holdings   []OpenAlgoHolding          `json:"holdings"`
+	Statistics OpenAlgoHoldingsStatistics `json:"statistics"`
+}
+
+type OpenAlgoHoldingsResponse struct {
+	Status string               `json:"status"`
+	Data   OpenAlgoHoldingsData `json:"data"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// OpenAlgoFunds is the cash and margin summary of the trading account
+type OpenAlgoFunds struct {
+	AvailableCash  Number `json:"availablecash"`
+	Collateral     Number `json:"collateral"`
+	M2MRealized    Number `json:"m2mrealized"`
+	M2MUnrealized  Number `json:"m2munrealized"`
+	UtilisedDebits Number `json:"utiliseddebits"`
+}
+
+type OpenAlgoFundsResponse struct {
+	Status string        `json:"status"`
+	Data   OpenAlgoFunds `json:"data"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// --- METHOD: ModifyOrder changes the price, quantity or type of an open order ---
+func (oa *OpenAlgoClient) ModifyOrder(orderReq *OpenAlgoModifyOrderRequest) (*OpenAlgoOrderResponse, error) {
+	orderReq.Apikey = oa.APIKey
+	var response OpenAlgoOrderResponse
+	if err := oa.post("/api/v1/modifyorder", "modify order", orderReq, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// --- METHOD: CancelOrder cancels an open order ---
+func (oa *OpenAlgoClient) CancelOrder(orderID, strategy string) (*OpenAlgoOrderResponse, error) {
+	requestBody := OpenAlgoCancelOrderRequest{
+		Apikey:   oa.APIKey,
+		Strategy: strategy,
+		OrderID:  orderID,
+	}
+	var response OpenAlgoOrderResponse
+	if err := oa.post("/api/v1/cancelorder", "cancel order", requestBody, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// --- METHOD: CancelAllOrders cancels every open order of the account ---
+func (oa *OpenAlgoClient) CancelAllOrders(strategy string) (*OpenAlgoCancelAllOrdersResponse, error) {
+	requestBody := OpenAlgoCancelAllOrdersRequest{
+		Apikey:   oa.APIKey,
+		Strategy: strategy,
+	}
+	var response OpenAlgoCancelAllOrdersResponse
+	if err := oa.post("/api/v1/cancelallorder", "cancel all orders", requestBody, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// --- METHOD: Positions fetches the position book of the account ---
+func (oa *OpenAlgoClient) Positions() ([]OpenAlgoPosition, error) {
+	var response OpenAlgoPositionBookResponse
+	if err := oa.post("/api/v1/positionbook", "position book", OpenAlgoAccountRequest{Apikey: oa.APIKey}, &response); err != nil {
+		return nil, err
+	}
+	if response.Data == nil {
+		return []OpenAlgoPosition{}, nil
+	}
+	return response.Data, nil
+}
+
+// --- METHOD: Holdings fetches the delivery holdings of the account ---
+func (oa *OpenAlgoClient) Holdings() (*OpenAlgoHoldingsData, error) {
+	var response OpenAlgoHoldingsResponse
+	if err := oa.post("/api/v1/holdings", "holdings", OpenAlgoAccountRequest{Apikey: oa.APIKey}, &response); err != nil {
+		return nil, err
+	}
+	if response.Data.Holdings == nil {
+		response.Data.Holdings = []OpenAlgoHolding{}
+	}
+	return &response.Data, nil
+}
+
+// --- METHOD: Funds fetches the available cash and margins of the account ---
+func (oa *OpenAlgoClient) Funds() (*OpenAlgoFunds, error) {
+	var response OpenAlgoFundsResponse
+	if err := oa.post("/api/v1/funds", "funds", OpenAlgoAccountRequest{Apikey: oa.APIKey}, &response); err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// apiStatus is the part of every OpenAlgo response that reports success or failure
+type apiStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s apiStatus) errorMessage() string {
+	if s.Error != "" {
+		return s.Error
+	}
+	if s.Message != "" {
+		return s.Message
+	}
+	return "api reported status: " + s.Status
+}
+
+// post sends a request to an OpenAlgo endpoint and decodes a successful response into out.
+// The name describes the call in errors.
+func (oa *OpenAlgoClient) post(path, name string, requestBody, out interface{}) error {
+	if oa.APIKey == "" {
+		return fmt.Errorf("OpenAlgo API key not configured")
+	}
+
+	endpoint := oa.BaseURL + path
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", name, err)
+	}
+
+	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("http post failed for %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response body: %w", name, err)
+	}
+
+	var status apiStatus
+	decodeErr := json.Unmarshal(bodyBytes, &status)
+	if resp.StatusCode != http.StatusOK {
+		if decodeErr == nil && (status.Error != "" || status.Message != "") {
+			return fmt.Errorf("api request failed with status %d: %s", resp.StatusCode, status.errorMessage())
+		}
+		if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "html") {
+			if resp.StatusCode == http.StatusNotFound {
+				return fmt.Errorf("api endpoint not found (status %d): %s - Check OpenAlgo setup", resp.StatusCode, endpoint)
+			}
+			return fmt.Errorf("api request failed with status %d: received HTML page (potential endpoint issue)", resp.StatusCode)
+		}
+		return fmt.Errorf("api request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode %s response: %w. Body: %s", name, decodeErr, string(bodyBytes))
+	}
+	if status.Status != "success" {
+		return fmt.Errorf("%s api error: %s", name, status.errorMessage())
+	}
+
+	if err := json.Unmarshal(bodyBytes, out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w. Body: %s", name, err, string(bodyBytes))
+	}
+	return nil
+}
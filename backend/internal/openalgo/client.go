@@ -7,13 +7,10 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/Knetic/govaluate"
-	"github.com/markcheno/go-talib"
+	"trading-app/internal/condition"
 )
 
 // --- OpenAlgoClient struct to hold config and methods ---
@@ -33,6 +30,11 @@ func NewOpenAlgoClient(baseURL, apiKey string) *OpenAlgoClient {
 	}
 }
 
+// Name identifies the broker behind the client
+func (oa *OpenAlgoClient) Name() string {
+	return "openalgo"
+}
+
 // --- Structs for OpenAlgo API Calls (Quotes, Orders, History) ---
 type OpenAlgoQuoteRequest struct {
 	Apikey   string `json:"apikey"`
@@ -132,8 +134,8 @@ type OpenAlgoOrderStatusResponse struct {
 	Error  string                  `json:"error,omitempty"`
 }
 
-// --- METHOD: FetchOpenAlgoQuote fetches live quote data from OpenAlgo ---
-func (oa *OpenAlgoClient) FetchOpenAlgoQuote(symbol, exchange string) (*OpenAlgoQuoteData, error) {
+// --- METHOD: Quote fetches live quote data from OpenAlgo ---
+func (oa *OpenAlgoClient) Quote(symbol, exchange string) (*OpenAlgoQuoteData, error) {
 	if oa.APIKey == "" {
 		return nil, fmt.Errorf("OpenAlgo API key not configured")
 	}
@@ -193,8 +195,8 @@ func (oa *OpenAlgoClient) FetchOpenAlgoQuote(symbol, exchange string) (*OpenAlgo
 	return &quoteResponse.Data, nil
 }
 
-// --- METHOD: PlaceOpenAlgoSmartOrder places a SMART order via OpenAlgo /api/v1/placesmartorder ---
-func (oa *OpenAlgoClient) PlaceOpenAlgoSmartOrder(orderReq *OpenAlgoSmartOrderRequest) (*OpenAlgoSmartOrderResponse, error) {
+// --- METHOD: PlaceSmartOrder places a SMART order via OpenAlgo /api/v1/placesmartorder ---
+func (oa *OpenAlgoClient) PlaceSmartOrder(orderReq *OpenAlgoSmartOrderRequest) (*OpenAlgoSmartOrderResponse, error) {
 	if oa.APIKey == "" {
 		return nil, fmt.Errorf("OpenAlgo API key not configured")
 	}
@@ -248,9 +250,9 @@ func (oa *OpenAlgoClient) PlaceOpenAlgoSmartOrder(orderReq *OpenAlgoSmartOrderRe
 
 	// Check status from the raw map
 	status, _ := rawResponse["status"].(string)
+	message, _ := rawResponse["message"].(string)
+	errorMsg, _ := rawResponse["error"].(string)
 	if status != "success" {
-		message, _ := rawResponse["message"].(string)
-		errorMsg, _ := rawResponse["error"].(string)
 		errMsg := message
 		if errMsg == "" {
 			errMsg = errorMsg
@@ -276,16 +278,16 @@ func (oa *OpenAlgoClient) PlaceOpenAlgoSmartOrder(orderReq *OpenAlgoSmartOrderRe
 	// Construct the final response object
 	orderResponse := &OpenAlgoSmartOrderResponse{
 		Status:  status,
-		Message: rawResponse["message"].(string),
+		Message: message,
 		Data:    OpenAlgoSmartOrderData{OrderID: orderID},
-		Error:   rawResponse["error"].(string),
+		Error:   errorMsg,
 	}
 
 	return orderResponse, nil
 }
 
-// --- METHOD: FetchOrderStatus fetches the status of a specific order ---
-func (oa *OpenAlgoClient) FetchOrderStatus(orderID, strategy string) (*OpenAlgoOrderStatusData, error) {
+// --- METHOD: OrderStatus fetches the status of a specific order ---
+func (oa *OpenAlgoClient) OrderStatus(orderID, strategy string) (*OpenAlgoOrderStatusData, error) {
 	if oa.APIKey == "" {
 		return nil, fmt.Errorf("OpenAlgo API key not configured")
 	}
@@ -339,8 +341,8 @@ func (oa *OpenAlgoClient) FetchOrderStatus(orderID, strategy string) (*OpenAlgoO
 	return &statusResponse.Data, nil
 }
 
-// METHOD: fetchOpenAlgoHistory fetches historical candle data
-func (oa *OpenAlgoClient) FetchOpenAlgoHistory(symbol, exchange, interval, startDate, endDate string) ([]OpenAlgoCandle, error) {
+// --- METHOD: History fetches historical candle data ---
+func (oa *OpenAlgoClient) History(symbol, exchange, interval, startDate, endDate string) ([]OpenAlgoCandle, error) {
 	if oa.APIKey == "" {
 		return nil, fmt.Errorf("OpenAlgo API key not configured")
 	}
@@ -408,172 +410,19 @@ func (oa *OpenAlgoClient) FetchOpenAlgoHistory(symbol, exchange, interval, start
 	return historyResponse.Data, nil
 }
 
-// --- NEW METHOD: CalculateIndicatorValue calculates the latest value for a given indicator and period. ---
-func (oa *OpenAlgoClient) CalculateIndicatorValue(indicatorName string, period int, closePrices []float64) (float64, error) {
-	requiredLength := period + 1
-
-	if len(closePrices) < requiredLength {
-		return 0, fmt.Errorf("not enough history data to calculate %s(%d) (need at least %d, got %d)", indicatorName, period, requiredLength, len(closePrices))
-	}
-
-	switch strings.ToUpper(indicatorName) {
-	case "RSI":
-		rsiResults := talib.Rsi(closePrices, period)
-		return rsiResults[len(rsiResults)-1], nil
-	case "EMA":
-		emaResults := talib.Ema(closePrices, period)
-		return emaResults[len(emaResults)-1], nil
-	case "SMA":
-		smaResults := talib.Sma(closePrices, period)
-		return smaResults[len(smaResults)-1], nil
-	case "MACD":
-		_, _, macdResults := talib.Macd(closePrices, 12, 26, 9)
-		return macdResults[len(macdResults)-1], nil
-	case "ROC":
-		rocResults := talib.Roc(closePrices, period)
-		return rocResults[len(rocResults)-1], nil
-	case "LRS":
-		lrsResults := talib.LinearRegSlope(closePrices, period)
-		return lrsResults[len(lrsResults)-1], nil
-	default:
-		return 0, fmt.Errorf("unsupported indicator: %s", indicatorName)
-	}
-}
-
-// --- METHOD: EvaluatePineCondition evaluates Pine Script-like conditions ---
-func (oa *OpenAlgoClient) EvaluatePineCondition(interval, condition, symbol, exchange string) (bool, map[string]float64, error) {
-	log.Printf("Attempting to evaluate condition for %s on %s (%s): %s", symbol, exchange, interval, condition)
-
-	endDate := time.Now().Format("2006-01-02")
-	startDate := time.Now().AddDate(0, 0, -5).Format("2006-01-02")
-
-	log.Printf("Fetching %s history for %s (%s to %s) on exchange %s", interval, symbol, startDate, endDate, exchange)
-
-	candles, err := oa.FetchOpenAlgoHistory(symbol, exchange, interval, startDate, endDate)
-	if err != nil {
-		log.Printf("Error fetching history for %s: %v", symbol, err)
-		return false, nil, fmt.Errorf("failed to fetch required market data: %w", err)
-	}
-
-	if len(candles) == 0 {
-		log.Printf("No historical data found for %s on exchange %s in the specified range.", symbol, exchange)
-		return false, nil, fmt.Errorf("no historical data available to evaluate condition")
-	}
-
-	log.Printf("Successfully fetched %d candles for %s on exchange %s", len(candles), symbol, exchange)
-
-	closePrices := make([]float64, len(candles))
+// BarsFromCandles converts OpenAlgo candles into bars for condition evaluation
+func BarsFromCandles(candles []OpenAlgoCandle) []condition.Bar {
+	bars := make([]condition.Bar, len(candles))
 	for i, candle := range candles {
-		closePrices[i] = candle.Close
-	}
-	log.Printf("Data extracted. Ready for indicator calculation using %d points.", len(closePrices))
-
-	reWithPeriod := regexp.MustCompile(`([A-Za-z]+)(\d+)`)
-	matchesWithPeriod := reWithPeriod.FindAllStringSubmatch(condition, -1)
-
-	parameters := make(map[string]interface{})
-
-	if len(closePrices) > 0 {
-		parameters["CLOSE"] = closePrices[len(closePrices)-1]
-		parameters["close"] = closePrices[len(closePrices)-1]
-	}
-
-	var indicatorName, periodStr, varName string
-
-	reFunctionStyle := regexp.MustCompile(`(?i)(sma|ema|rsi)\s*\(\s*close\s*,\s*(\d+)\s*\)`)
-	functionMatches := reFunctionStyle.FindAllStringSubmatch(condition, -1)
-
-	for _, match := range functionMatches {
-		funcName := strings.ToUpper(match[1])
-		periodStr := match[2]
-
-		period, periodErr := strconv.Atoi(periodStr)
-		if periodErr != nil {
-			log.Printf("Error converting period '%s' to int: %v", periodStr, periodErr)
-			continue
-		}
-
-		indicatorValue, calcErr := oa.CalculateIndicatorValue(funcName, period, closePrices)
-		if calcErr != nil {
-			log.Printf("Error calculating indicator %s(%d): %v", funcName, period, calcErr)
-			return false, nil, calcErr
-		}
-
-		oldFunc := match[0]
-		condition = strings.ReplaceAll(condition, oldFunc, fmt.Sprintf("%.6f", indicatorValue))
-
-		varName = fmt.Sprintf("%s%d", funcName, period)
-		parameters[varName] = float64(indicatorValue)
-		log.Printf("Calculated %s: %.2f", varName, indicatorValue)
-	}
-
-	for _, match := range matchesWithPeriod {
-		indicatorName = match[1]
-		periodStr = match[2]
-		varName = match[0]
-
-		period, periodErr := strconv.Atoi(periodStr)
-		if periodErr != nil {
-			log.Printf("Error converting period '%s' to int: %v", periodStr, periodErr)
-			return false, nil, fmt.Errorf("invalid period specified for indicator %s", indicatorName)
-		}
-
-		indicatorValue, calcErr := oa.CalculateIndicatorValue(indicatorName, period, closePrices)
-		if calcErr != nil {
-			log.Printf("Error calculating indicator %s: %v", varName, calcErr)
-			return false, nil, calcErr
-		}
-
-		parameters[varName] = float64(indicatorValue)
-		log.Printf("Calculated %s: %.2f", varName, indicatorValue)
-	}
-
-	if strings.Contains(strings.ToUpper(condition), "MACD") {
-		macdValue, macdErr := oa.CalculateIndicatorValue("MACD", 12, closePrices)
-		if macdErr != nil {
-			log.Printf("Error calculating standalone MACD: %v", macdErr)
-			return false, nil, macdErr
+		bars[i] = condition.Bar{
+			Time:   time.Unix(candle.Timestamp, 0),
+			Open:   candle.Open,
+			High:   candle.High,
+			Low:    candle.Low,
+			Close:  candle.Close,
+			Volume: float64(candle.Volume),
+			OI:     float64(candle.OI),
 		}
-
-		parameters["MACD"] = float64(macdValue)
-		log.Printf("Calculated MACD: %.2f", macdValue)
 	}
-
-	reNoPeriod := regexp.MustCompile(`(RSI|EMA|SMA)\s`)
-	if reNoPeriod.MatchString(condition) {
-		log.Printf("Parsing error: Condition '%s' contains indicator without period.", condition)
-		return false, nil, fmt.Errorf("invalid indicator syntax. Did you forget the period? (e.g., use RSI14 instead of RSI)")
-	}
-
-	if len(parameters) == 1 && !strings.Contains(strings.ToUpper(condition), "MACD") {
-		log.Printf("Warning: No recognized indicators found in condition: %s. Assuming literal evaluation.", condition)
-	}
-
-	expression, err := govaluate.NewEvaluableExpression(condition)
-	if err != nil {
-		log.Printf("Error parsing condition '%s': %v", condition, err)
-		return false, nil, fmt.Errorf("invalid Pine Script condition syntax: %w", err)
-	}
-
-	result, err := expression.Evaluate(parameters)
-	if err != nil {
-		log.Printf("Error evaluating condition: %v", err)
-		return false, nil, fmt.Errorf("error during condition evaluation. Check your indicator names and syntax. Details: %v", err)
-	}
-
-	isConditionMet, ok := result.(bool)
-	if !ok {
-		log.Printf("Evaluation result not a boolean: %v (Type: %T)", result, result)
-		return false, nil, fmt.Errorf("condition must evaluate to TRUE or FALSE (got type %T). Did you forget a comparison operator (>, <, ==, etc.)?", result)
-	}
-
-	indicatorValues := make(map[string]float64)
-	for name, value := range parameters {
-		if floatVal, ok := value.(float64); ok {
-			indicatorValues[name] = floatVal
-		}
-	}
-
-	log.Printf("Evaluation complete. Condition met: %t", isConditionMet)
-	return isConditionMet, indicatorValues, nil
+	return bars
 }
@@ -0,0 +1,196 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+	"trading-app/internal/risk"
+)
+
+// ErrOrderNotFound is returned when a broker order is not one of the user's trades
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrOrderClosed is returned when an order was already filled, rejected or cancelled
+var ErrOrderClosed = errors.New("order is no longer open")
+
+// Modification changes an open order. Zero values keep the current quantity,
+// price and price type.
+type Modification struct {
+	OrderID      string  `json:"order_id"`
+	Quantity     int     `json:"quantity"`
+	Price        float64 `json:"price"`
+	PriceType    string  `json:"pricetype"`
+	TriggerPrice float64 `json:"trigger_price"`
+}
+
+// Failure is an order that could not be cancelled
+type Failure struct {
+	OrderID string `json:"order_id"`
+	Symbol  string `json:"symbol"`
+	Error   string `json:"error"`
+}
+
+// Open returns the broker orders of a user that are still pending
+func (t *Tracker) Open(userID int) ([]*models.Trade, error) {
+	return t.db.GetOpenTradesByUserID(userID)
+}
+
+// Modify changes the quantity, price or type of one of the user's open orders
+func (t *Tracker) Modify(userID int, m Modification) (*models.Trade, error) {
+	trade, b, err := t.owned(userID, m.OrderID)
+	if err != nil {
+		return trade, err
+	}
+
+	priceType := strings.ToUpper(strings.TrimSpace(m.PriceType))
+	if priceType == "" {
+		priceType = strings.ToUpper(trade.OrderType)
+	}
+	if priceType == "" {
+		priceType = "MARKET"
+	}
+	quantity := m.Quantity
+	if quantity <= 0 {
+		quantity = trade.Quantity
+	}
+	price := m.Price
+	if priceType == "MARKET" {
+		price = 0
+	} else if price <= 0 {
+		price = trade.Price
+	}
+	if priceType != "MARKET" && price <= 0 {
+		return trade, fmt.Errorf("a %s order needs a price", priceType)
+	}
+
+	req := &openalgo.OpenAlgoModifyOrderRequest{
+		Strategy:     Strategy,
+		Symbol:       trade.Symbol,
+		Exchange:     trade.Exchange,
+		OrderID:      trade.OrderID,
+		Action:       strings.ToUpper(trade.Action),
+		Product:      trade.Product,
+		Pricetype:    priceType,
+		Price:        price,
+		Quantity:     quantity,
+		TriggerPrice: m.TriggerPrice,
+	}
+	// Only lowering the quantity takes no new risk
+	if quantity > trade.Quantity || price != trade.Price || priceType != strings.ToUpper(trade.OrderType) {
+		if err := t.checkRisk(userID, b, trade, quantity, price, priceType); err != nil {
+			return trade, err
+		}
+	}
+	if _, err := b.ModifyOrder(req); err != nil {
+		return trade, err
+	}
+
+	now := time.Now()
+	trade.Quantity, trade.Price, trade.OrderType, trade.UpdatedAt = quantity, price, priceType, &now
+	if err := t.db.UpdateTradeTerms(trade); err != nil {
+		log.Printf("ORDERS: Failed to save modified trade %d: %v", trade.ID, err)
+	}
+	log.Printf("ORDERS: User %d modified order %s to %d at %.2f (%s)", userID, trade.OrderID, quantity, price, priceType)
+
+	t.refresh(trade)
+	return trade, nil
+}
+
+// checkRisk passes a modified order through the risk checks as a new order
+// for its full quantity, traded from the user's ledger position
+func (t *Tracker) checkRisk(userID int, b broker.Broker, trade *models.Trade, quantity int, price float64, priceType string) error {
+	product := strings.ToUpper(trade.Product)
+	if product == "" {
+		product = "MIS"
+	}
+	position, err := t.db.GetOpenPosition(userID, b.Name(), strings.ToUpper(trade.Symbol), strings.ToUpper(trade.Exchange), product)
+	if err != nil {
+		return fmt.Errorf("failed to load the position: %w", err)
+	}
+	current := 0
+	if position != nil {
+		current = position.Quantity
+	}
+	target := current + quantity
+	if strings.EqualFold(trade.Action, "SELL") {
+		target = current - quantity
+	}
+
+	return t.risk.Check(userID, b, &openalgo.OpenAlgoSmartOrderRequest{
+		Strategy:     Strategy,
+		Symbol:       trade.Symbol,
+		Exchange:     trade.Exchange,
+		Action:       strings.ToUpper(trade.Action),
+		Product:      product,
+		Pricetype:    priceType,
+		Price:        price,
+		Quantity:     quantity,
+		PositionSize: target,
+	}, risk.SourceManual)
+}
+
+// Cancel withdraws one of the user's open orders
+func (t *Tracker) Cancel(userID int, orderID string) (*models.Trade, error) {
+	trade, b, err := t.owned(userID, orderID)
+	if err != nil {
+		return trade, err
+	}
+	if _, err := b.CancelOrder(trade.OrderID, Strategy); err != nil {
+		return trade, err
+	}
+	log.Printf("ORDERS: User %d cancelled order %s", userID, trade.OrderID)
+
+	t.refresh(trade)
+	return trade, nil
+}
+
+// CancelAll withdraws every open order of a user. Orders are cancelled one
+// by one: OpenAlgo's cancelallorder would also cancel the orders of other
+// users sharing the account, and those placed outside the app.
+func (t *Tracker) CancelAll(userID int) ([]*models.Trade, []Failure, error) {
+	trades, err := t.db.GetOpenTradesByUserID(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load open orders: %w", err)
+	}
+
+	cancelled := []*models.Trade{}
+	failed := []Failure{}
+	for _, trade := range trades {
+		updated, err := t.Cancel(userID, trade.OrderID)
+		if err != nil {
+			failed = append(failed, Failure{OrderID: trade.OrderID, Symbol: trade.Symbol, Error: err.Error()})
+			continue
+		}
+		cancelled = append(cancelled, updated)
+	}
+	return cancelled, failed, nil
+}
+
+// owned returns an open trade of the user and the broker it was placed with
+func (t *Tracker) owned(userID int, orderID string) (*models.Trade, broker.Broker, error) {
+	orderID = strings.TrimSpace(orderID)
+	if orderID == "" {
+		return nil, nil, ErrOrderNotFound
+	}
+	trade, err := t.db.GetUserTradeByOrderID(userID, orderID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load order: %w", err)
+	}
+	if trade == nil {
+		return nil, nil, ErrOrderNotFound
+	}
+	if trade.Status != models.TradePending {
+		return trade, nil, ErrOrderClosed
+	}
+	b, err := t.broker(trade)
+	if err != nil {
+		return trade, nil, err
+	}
+	return trade, b, nil
+}
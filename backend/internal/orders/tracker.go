@@ -0,0 +1,292 @@
+// Package orders follows the broker orders behind the trades table until
+// they are filled, rejected or cancelled.
+package orders
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/ledger"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+	"trading-app/internal/risk"
+)
+
+// Strategy is the strategy name sent with order status requests
+const Strategy = "trading_app"
+
+// MaxAge is how long an order is tracked before it is marked unresolved
+const MaxAge = 3 * 24 * time.Hour
+
+// ist is the time zone of the order timestamps reported by OpenAlgo
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+// Notifier delivers a message to every open session of a user.
+// It is implemented by websocket.Hub.
+type Notifier interface {
+	SendToUser(userID int, message []byte)
+}
+
+// message mirrors the websocket message envelope understood by the frontend
+type message struct {
+	Type    string      `json:"type"`
+	Content string      `json:"content,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Tracker polls the brokers for the status of every pending trade and
+// records fills, rejections and cancellations as they happen.
+type Tracker struct {
+	db       *database.DB
+	brokers  *broker.Selector
+	ledger   *ledger.Ledger
+	risk     *risk.Engine
+	notifier Notifier
+
+	mu         sync.Mutex
+	listeners  []func(*models.Trade)
+	lastErrors map[int]string
+
+	// Serializes status checks, so a change is published once
+	checking sync.Mutex
+}
+
+// NewTracker creates an order tracker. Executed trades are added to the
+// position ledger straight away. Modified orders pass the risk checks again.
+func NewTracker(db *database.DB, brokers *broker.Selector, positions *ledger.Ledger, riskEngine *risk.Engine, notifier Notifier) *Tracker {
+	return &Tracker{
+		db:         db,
+		brokers:    brokers,
+		ledger:     positions,
+		risk:       riskEngine,
+		notifier:   notifier,
+		lastErrors: make(map[int]string),
+	}
+}
+
+// Subscribe registers a function called with every trade whose status changed
+func (t *Tracker) Subscribe(listener func(*models.Trade)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+// Start checks the pending trades now and then at the given interval
+func (t *Tracker) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			t.Check()
+			<-ticker.C
+		}
+	}()
+}
+
+// Check asks the brokers for the status of every pending trade and saves the
+// ones that changed
+func (t *Tracker) Check() {
+	t.checking.Lock()
+	defer t.checking.Unlock()
+
+	trades, err := t.db.GetOpenTrades()
+	if err != nil {
+		log.Printf("ORDERS: Failed to load pending trades: %v", err)
+		return
+	}
+
+	executed := false
+	for _, trade := range trades {
+		if !t.check(trade) {
+			continue
+		}
+		if trade.Status == models.TradeExecuted {
+			executed = true
+		}
+	}
+	if executed {
+		t.ledger.ApplyExecutedTrades()
+	}
+}
+
+// check updates one trade from its broker order. It reports whether the
+// trade changed.
+func (t *Tracker) check(trade *models.Trade) bool {
+	previous := *trade
+	status, err := t.orderStatus(trade)
+	now := time.Now()
+
+	if err != nil {
+		if now.Sub(trade.CreatedAt) < MaxAge {
+			t.logError(trade, err)
+			return false
+		}
+		t.clearError(trade)
+		trade.Status = models.TradeUnresolved
+		trade.StatusMessage = fmt.Sprintf("No final status from the broker after %s: %v", MaxAge, err)
+	} else {
+		t.clearError(trade)
+		apply(trade, status, now)
+		if trade.Status == models.TradePending && now.Sub(trade.CreatedAt) >= MaxAge {
+			trade.Status = models.TradeUnresolved
+			trade.StatusMessage = fmt.Sprintf("Still %s at the broker after %s", trade.BrokerStatus, MaxAge)
+		}
+	}
+
+	if trade.Status == previous.Status && trade.BrokerStatus == previous.BrokerStatus &&
+		trade.FilledAction == previous.FilledAction && trade.FilledQuantity == previous.FilledQuantity && trade.AveragePrice == previous.AveragePrice {
+		return false
+	}
+
+	trade.UpdatedAt = &now
+	if err := t.db.UpdateTradeOrder(trade); err != nil {
+		log.Printf("ORDERS: Failed to save trade %d: %v", trade.ID, err)
+		return false
+	}
+	log.Printf("ORDERS: Trade %d (order %s, %s %d %s:%s) is %s (broker status '%s')",
+		trade.ID, trade.OrderID, trade.Action, trade.Quantity, trade.Exchange, trade.Symbol, trade.Status, trade.BrokerStatus)
+
+	t.publish(trade, previous.Status)
+	return true
+}
+
+// refresh checks a single trade right away, after it was changed by the user
+func (t *Tracker) refresh(trade *models.Trade) {
+	t.checking.Lock()
+	defer t.checking.Unlock()
+
+	if t.check(trade) && trade.Status == models.TradeExecuted {
+		t.ledger.ApplyExecutedTrades()
+	}
+}
+
+// orderStatus fetches the status of a trade's order from the broker it was placed with
+func (t *Tracker) orderStatus(trade *models.Trade) (*openalgo.OpenAlgoOrderStatusData, error) {
+	b, err := t.broker(trade)
+	if err != nil {
+		return nil, err
+	}
+	return b.OrderStatus(trade.OrderID, Strategy)
+}
+
+// broker returns the account a trade was placed with
+func (t *Tracker) broker(trade *models.Trade) (broker.Broker, error) {
+	if trade.Broker != "" {
+		return t.brokers.ByName(trade.UserID, trade.Broker)
+	}
+	strategyID := 0
+	if trade.StrategyID != nil {
+		strategyID = *trade.StrategyID
+	}
+	return t.brokers.For(trade.UserID, strategyID)
+}
+
+// apply copies a broker order status onto a trade. The side and quantity
+// are the ones the broker filled: a smart order sent while a position is
+// open can close it instead of adding to it.
+func apply(trade *models.Trade, status *openalgo.OpenAlgoOrderStatusData, now time.Time) {
+	trade.BrokerStatus = strings.ToLower(status.OrderStatus)
+
+	switch trade.BrokerStatus {
+	case "complete", "completed", "filled", "executed":
+		trade.Status = models.TradeExecuted
+		if action := strings.ToUpper(strings.TrimSpace(status.Action)); action == "BUY" || action == "SELL" {
+			trade.FilledAction = action
+		}
+		trade.FilledQuantity = trade.Quantity
+		if quantity, err := strconv.Atoi(strings.TrimSpace(status.Quantity)); err == nil && quantity > 0 {
+			trade.FilledQuantity = quantity
+		}
+		trade.AveragePrice = status.AveragePrice
+		if trade.AveragePrice <= 0 {
+			trade.AveragePrice = status.Price
+		}
+		if trade.AveragePrice <= 0 {
+			trade.AveragePrice = trade.Price
+		}
+		executedAt := now
+		if at, err := time.ParseInLocation("02-Jan-2006 15:04:05", status.Timestamp, ist); err == nil {
+			executedAt = at
+		}
+		trade.ExecutedAt = &executedAt
+	case "rejected":
+		trade.Status = models.TradeRejected
+	case "cancelled", "canceled":
+		trade.Status = models.TradeCancelled
+	}
+}
+
+// publish tells the user and the listeners that a trade changed. Terminal
+// changes of trades placed by hand are also reported in the chat; auto
+// orders report their own.
+func (t *Tracker) publish(trade *models.Trade, previous string) {
+	if msgBytes, err := json.Marshal(message{Type: "order", Data: trade}); err == nil {
+		t.notifier.SendToUser(trade.UserID, msgBytes)
+	} else {
+		log.Printf("ORDERS: Failed to marshal order event for trade %d: %v", trade.ID, err)
+	}
+
+	if trade.AutoOrderID == "" && trade.Status != previous && trade.Status != models.TradePending {
+		chatMsg := message{
+			Type:    "chat",
+			Content: Describe(trade),
+			Data: map[string]interface{}{
+				"role":       "system",
+				"created_at": time.Now(),
+			},
+		}
+		if msgBytes, err := json.Marshal(chatMsg); err == nil {
+			t.notifier.SendToUser(trade.UserID, msgBytes)
+		}
+	}
+
+	t.mu.Lock()
+	listeners := append([]func(*models.Trade){}, t.listeners...)
+	t.mu.Unlock()
+	for _, listener := range listeners {
+		listener(trade)
+	}
+}
+
+// Describe renders the status of a trade's order as a chat message
+func Describe(trade *models.Trade) string {
+	order := fmt.Sprintf("%s %d %s:%s (order **%s**, %s)", strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol, trade.OrderID, trade.Broker)
+	switch trade.Status {
+	case models.TradeExecuted:
+		if trade.FilledAction != "" && !strings.EqualFold(trade.FilledAction, trade.Action) {
+			return fmt.Sprintf("✅ **Order Filled**: %s — filled as %s %d at %.2f, closing the open position.", order, trade.FilledAction, trade.FilledQuantity, trade.AveragePrice)
+		}
+		return fmt.Sprintf("✅ **Order Filled**: %s — %d filled at %.2f.", order, trade.FilledQuantity, trade.AveragePrice)
+	case models.TradeRejected:
+		return fmt.Sprintf("❌ **Order Rejected**: %s.", order)
+	case models.TradeCancelled:
+		return fmt.Sprintf("🚫 **Order Cancelled**: %s.", order)
+	case models.TradeUnresolved:
+		return fmt.Sprintf("⚠️ **Order Status Unresolved**: %s. %s. Please verify it with the broker.", order, trade.StatusMessage)
+	}
+	return fmt.Sprintf("ℹ️ **Order %s**: %s.", strings.ToUpper(trade.BrokerStatus), order)
+}
+
+// logError logs a failed status check, once until the error changes
+func (t *Tracker) logError(trade *models.Trade, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastErrors[trade.ID] == err.Error() {
+		return
+	}
+	t.lastErrors[trade.ID] = err.Error()
+	log.Printf("ORDERS: Failed to check order %s of trade %d: %v", trade.OrderID, trade.ID, err)
+}
+
+func (t *Tracker) clearError(trade *models.Trade) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.lastErrors, trade.ID)
+}
@@ -0,0 +1,359 @@
+// Package risk checks orders against the limits of their user before they are
+// sent to a broker. Every path that places an order on a user's behalf must
+// pass it, except the exits of brackets, which only ever reduce a position.
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/broker"
+	"trading-app/internal/database"
+	"trading-app/internal/models"
+	"trading-app/internal/openalgo"
+)
+
+// Order sources, recorded with rejections
+const (
+	SourceManual    = "manual"
+	SourceAutoOrder = "auto_order"
+)
+
+// Rules an order can be rejected by
+const (
+	RuleKillSwitch       = "kill_switch"
+	RuleBlockedSymbol    = "blocked_symbol"
+	RuleOrderValue       = "max_order_value"
+	RuleSymbolQuantity   = "max_symbol_quantity"
+	RuleOpenPositions    = "max_open_positions"
+	RuleDailyLoss        = "max_daily_loss"
+	RuleOrdersPerMinute  = "max_orders_per_minute"
+	RuleUnavailable      = "unavailable"
+	killSwitchSettingKey = "kill_switch"
+)
+
+// ErrNotAdmin is returned when a user who is not an admin changes the kill switch
+var ErrNotAdmin = errors.New("only admins can change the kill switch")
+
+// ist is the time zone the trading day starts in
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+// Rejection is the error of an order the risk checks refused
+type Rejection struct {
+	Rule   string
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return "blocked by risk checks: " + r.Reason
+}
+
+// Engine checks orders against the kill switch and the limits of their user.
+// Users without limits of their own get the default limits.
+type Engine struct {
+	db       *database.DB
+	defaults models.RiskLimits
+	admins   map[string]bool
+
+	mu         sync.Mutex
+	killSwitch models.KillSwitch
+	// Times of the orders each user was let through in the last minute
+	recent map[int][]time.Time
+}
+
+// NewEngine creates a risk engine. admins are the usernames allowed to
+// engage and release the kill switch, which is restored from the database.
+func NewEngine(db *database.DB, defaults models.RiskLimits, admins []string) *Engine {
+	e := &Engine{
+		db:       db,
+		defaults: defaults,
+		admins:   make(map[string]bool),
+		recent:   make(map[int][]time.Time),
+	}
+	e.defaults.BlockedSymbols = normalizeSymbols(defaults.BlockedSymbols)
+	for _, admin := range admins {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			e.admins[admin] = true
+		}
+	}
+
+	if value, err := db.GetSetting(killSwitchSettingKey); err != nil {
+		log.Printf("RISK: Failed to load the kill switch: %v", err)
+	} else if value != "" {
+		if err := json.Unmarshal([]byte(value), &e.killSwitch); err != nil {
+			log.Printf("RISK: Failed to read the kill switch: %v", err)
+		}
+	}
+	if e.killSwitch.Engaged {
+		log.Printf("RISK: The kill switch is engaged (%s)", e.killSwitch.Reason)
+	}
+	return e
+}
+
+// Limits returns the limits that apply to a user
+func (e *Engine) Limits(userID int) (*models.RiskLimits, error) {
+	limits, err := e.db.GetRiskLimits(userID)
+	if err != nil {
+		return nil, err
+	}
+	if limits == nil {
+		defaults := e.defaults
+		defaults.UserID = userID
+		defaults.BlockedSymbols = append([]string{}, e.defaults.BlockedSymbols...)
+		return &defaults, nil
+	}
+	return limits, nil
+}
+
+// SetLimits replaces the limits of a user
+func (e *Engine) SetLimits(limits *models.RiskLimits) error {
+	if limits.MaxOrderValue < 0 || limits.MaxSymbolQuantity < 0 || limits.MaxOpenPositions < 0 || limits.MaxDailyLoss < 0 || limits.MaxOrdersPerMinute < 0 {
+		return fmt.Errorf("limits cannot be negative (use 0 for no limit)")
+	}
+	limits.BlockedSymbols = normalizeSymbols(limits.BlockedSymbols)
+	if err := e.db.SaveRiskLimits(limits); err != nil {
+		return err
+	}
+	log.Printf("RISK: User %d set limits: order value %.2f, symbol quantity %d, open positions %d, daily loss %.2f, orders per minute %d, blocked %v",
+		limits.UserID, limits.MaxOrderValue, limits.MaxSymbolQuantity, limits.MaxOpenPositions, limits.MaxDailyLoss, limits.MaxOrdersPerMinute, limits.BlockedSymbols)
+	return nil
+}
+
+// KillSwitch returns the state of the kill switch
+func (e *Engine) KillSwitch() models.KillSwitch {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.killSwitch
+}
+
+// SetKillSwitch engages or releases the kill switch for every user. Only
+// admins may change it. It is persisted, so it survives restarts.
+func (e *Engine) SetKillSwitch(userID int, engaged bool, reason string) (models.KillSwitch, error) {
+	user, err := e.db.GetUserByID(userID)
+	if err != nil || user == nil || !e.admins[user.Username] {
+		return e.KillSwitch(), ErrNotAdmin
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := models.KillSwitch{Engaged: engaged, Reason: strings.TrimSpace(reason), By: user.Username, UpdatedAt: time.Now()}
+	value, err := json.Marshal(state)
+	if err != nil {
+		return e.killSwitch, err
+	}
+	if err := e.db.SetSetting(killSwitchSettingKey, string(value)); err != nil {
+		return e.killSwitch, fmt.Errorf("failed to save the kill switch: %w", err)
+	}
+	e.killSwitch = state
+	if engaged {
+		log.Printf("RISK: Kill switch ENGAGED by %s: %s", user.Username, state.Reason)
+	} else {
+		log.Printf("RISK: Kill switch released by %s", user.Username)
+	}
+	return state, nil
+}
+
+// Check lets an order through or returns the *Rejection that explains why
+// not. Smart orders are checked by the change they make to the user's ledger
+// position, as the broker will trade it. Orders that only reduce a position
+// are only held back by the kill switch and the order rate. Rejections are
+// logged and recorded.
+func (e *Engine) Check(userID int, b broker.Broker, req *openalgo.OpenAlgoSmartOrderRequest, source string) error {
+	if rejection := e.check(userID, b, req); rejection != nil {
+		e.reject(userID, req, source, rejection)
+		return rejection
+	}
+	return nil
+}
+
+func (e *Engine) check(userID int, b broker.Broker, req *openalgo.OpenAlgoSmartOrderRequest) *Rejection {
+	if ks := e.KillSwitch(); ks.Engaged {
+		reason := "the kill switch is engaged, no new orders are accepted"
+		if ks.Reason != "" {
+			reason += " (" + ks.Reason + ")"
+		}
+		return &Rejection{Rule: RuleKillSwitch, Reason: reason}
+	}
+
+	limits, err := e.Limits(userID)
+	if err != nil {
+		return &Rejection{Rule: RuleUnavailable, Reason: fmt.Sprintf("the risk limits could not be loaded: %v", err)}
+	}
+
+	symbol := strings.ToUpper(strings.TrimSpace(req.Symbol))
+	exchange := strings.ToUpper(strings.TrimSpace(req.Exchange))
+	product := strings.ToUpper(strings.TrimSpace(req.Product))
+	if product == "" {
+		product = "MIS"
+	}
+	position, err := e.db.GetOpenPosition(userID, b.Name(), symbol, exchange, product)
+	if err != nil {
+		return &Rejection{Rule: RuleUnavailable, Reason: fmt.Sprintf("the position in %s could not be loaded: %v", symbol, err)}
+	}
+	current := 0
+	if position != nil {
+		current = position.Quantity
+	}
+	change := positionChange(req, current)
+	after := current + change
+
+	// Reductions get a position out of risk; they are never held back by the position limits
+	if change != 0 && !reduces(current, after) {
+		if rejection := e.checkExposure(userID, b, limits, req, symbol, exchange, current, change, after); rejection != nil {
+			return rejection
+		}
+	}
+
+	if limits.MaxOrdersPerMinute > 0 && !e.allowRate(userID, limits.MaxOrdersPerMinute) {
+		return &Rejection{Rule: RuleOrdersPerMinute, Reason: fmt.Sprintf("%d orders were already placed in the last minute, the limit is %d", limits.MaxOrdersPerMinute, limits.MaxOrdersPerMinute)}
+	}
+	return nil
+}
+
+// checkExposure checks an order that opens or adds to a position
+func (e *Engine) checkExposure(userID int, b broker.Broker, limits *models.RiskLimits, req *openalgo.OpenAlgoSmartOrderRequest, symbol, exchange string, current, change, after int) *Rejection {
+	for _, blocked := range limits.BlockedSymbols {
+		if blocked == symbol || blocked == exchange+":"+symbol {
+			return &Rejection{Rule: RuleBlockedSymbol, Reason: fmt.Sprintf("%s is on the blocklist", symbol)}
+		}
+	}
+
+	if limits.MaxSymbolQuantity > 0 && abs(after) > limits.MaxSymbolQuantity {
+		return &Rejection{Rule: RuleSymbolQuantity, Reason: fmt.Sprintf("the position in %s would be %d, above the limit of %d", symbol, abs(after), limits.MaxSymbolQuantity)}
+	}
+
+	if limits.MaxOrderValue > 0 {
+		price := req.Price
+		if price <= 0 {
+			quote, err := b.Quote(symbol, exchange)
+			if err != nil || quote.LTP <= 0 {
+				return &Rejection{Rule: RuleOrderValue, Reason: fmt.Sprintf("the value of the order could not be checked, as %s has no price", symbol)}
+			}
+			price = quote.LTP
+		}
+		if value := price * float64(abs(change)); value > limits.MaxOrderValue {
+			return &Rejection{Rule: RuleOrderValue, Reason: fmt.Sprintf("the order value %.2f is above the limit of %.2f", value, limits.MaxOrderValue)}
+		}
+	}
+
+	if limits.MaxOpenPositions > 0 && current == 0 {
+		open, err := e.db.GetOpenPositionsByUserID(userID)
+		if err != nil {
+			return &Rejection{Rule: RuleUnavailable, Reason: fmt.Sprintf("the open positions could not be loaded: %v", err)}
+		}
+		if len(open) >= limits.MaxOpenPositions {
+			return &Rejection{Rule: RuleOpenPositions, Reason: fmt.Sprintf("%d positions are open, the limit is %d", len(open), limits.MaxOpenPositions)}
+		}
+	}
+
+	if limits.MaxDailyLoss > 0 {
+		realized, err := e.db.GetRealizedPnLSince(userID, startOfDay(time.Now()))
+		if err != nil {
+			return &Rejection{Rule: RuleUnavailable, Reason: fmt.Sprintf("today's P&L could not be loaded: %v", err)}
+		}
+		if -realized >= limits.MaxDailyLoss {
+			return &Rejection{Rule: RuleDailyLoss, Reason: fmt.Sprintf("today's realized loss of %.2f reached the limit of %.2f; only exits are accepted until tomorrow", -realized, limits.MaxDailyLoss)}
+		}
+	}
+	return nil
+}
+
+// allowRate records an order of a user unless the user already placed the
+// maximum number of orders in the last minute
+func (e *Engine) allowRate(userID, max int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Minute)
+	recent := e.recent[userID][:0]
+	for _, at := range e.recent[userID] {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= max {
+		e.recent[userID] = recent
+		return false
+	}
+	e.recent[userID] = append(recent, time.Now())
+	return true
+}
+
+// reject logs and records a refused order
+func (e *Engine) reject(userID int, req *openalgo.OpenAlgoSmartOrderRequest, source string, rejection *Rejection) {
+	log.Printf("RISK: Rejected %s order of user %d (%s %d %s:%s): %s",
+		source, userID, strings.ToUpper(req.Action), req.Quantity, strings.ToUpper(req.Exchange), strings.ToUpper(req.Symbol), rejection.Reason)
+	if err := e.db.CreateRiskRejection(&models.RiskRejection{
+		UserID:   userID,
+		Source:   source,
+		Symbol:   strings.ToUpper(req.Symbol),
+		Exchange: strings.ToUpper(req.Exchange),
+		Action:   strings.ToUpper(req.Action),
+		Quantity: req.Quantity,
+		Price:    req.Price,
+		Rule:     rejection.Rule,
+		Reason:   rejection.Reason,
+	}); err != nil {
+		log.Printf("RISK: Failed to record rejection: %v", err)
+	}
+}
+
+// Rejections returns the latest orders of a user the risk checks refused
+func (e *Engine) Rejections(userID, limit int) ([]*models.RiskRejection, error) {
+	return e.db.GetRiskRejections(userID, limit)
+}
+
+// positionChange returns the signed quantity a smart order trades from the
+// current position: the difference to its position size, or its quantity
+// when both the position size and the position are flat
+func positionChange(req *openalgo.OpenAlgoSmartOrderRequest, current int) int {
+	if req.PositionSize == 0 && current == 0 {
+		if strings.EqualFold(req.Action, "SELL") {
+			return -req.Quantity
+		}
+		return req.Quantity
+	}
+	return req.PositionSize - current
+}
+
+// reduces reports whether going from one position to another only closes
+// part or all of it
+func reduces(current, after int) bool {
+	if after == 0 {
+		return true
+	}
+	return (current > 0) == (after > 0) && abs(after) < abs(current)
+}
+
+// startOfDay returns midnight IST of the day of t
+func startOfDay(t time.Time) time.Time {
+	t = t.In(ist)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ist)
+}
+
+// normalizeSymbols uppercases a symbol list and drops blanks and duplicates
+func normalizeSymbols(symbols []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol != "" && !seen[symbol] {
+			seen[symbol] = true
+			normalized = append(normalized, symbol)
+		}
+	}
+	return normalized
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
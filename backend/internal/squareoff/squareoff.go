@@ -0,0 +1,314 @@
+// Package squareoff closes the intraday (MIS) auto orders and positions of
+// every user at their square-off time, before the broker squares them off
+// itself and charges for it.
+package squareoff
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-app/internal/autoorder"
+	"trading-app/internal/calendar"
+	"trading-app/internal/database"
+	"trading-app/internal/email"
+	"trading-app/internal/emergency"
+	"trading-app/internal/models"
+)
+
+const (
+	// Product is the product whose positions are squared off
+	Product = "MIS"
+	// Strategy is the strategy name sent with square-off orders
+	Strategy = "square_off"
+	// DefaultTime is the square-off time of users who set none, in IST
+	DefaultTime = "15:15"
+	// closeBuffer is how long before the session closes positions are squared
+	// off when the time of the user falls outside the session or later, as on
+	// days with a special session
+	closeBuffer = 5 * time.Minute
+)
+
+// Notifier delivers a message to every open session of a user.
+// It is implemented by websocket.Hub.
+type Notifier interface {
+	SendToUser(userID int, message []byte)
+}
+
+// message mirrors the websocket message envelope understood by the frontend
+type message struct {
+	Type    string      `json:"type"`
+	Content string      `json:"content,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Scheduler squares off the intraday positions of each user once their
+// square-off time has passed, until the session of the exchange closes. Every
+// check repeats the square-off, so auto orders that fire late and exits that
+// failed are caught by the next one. Scheduled orders whose next run is in a
+// later session keep running.
+type Scheduler struct {
+	db             *database.DB
+	calendar       *calendar.Calendar
+	liquidator     *emergency.Liquidator
+	notifier       Notifier
+	emailService   *email.EmailService
+	emailRecipient string
+	defaults       models.SquareOffSetting
+
+	mu sync.Mutex
+	// Day of the last failure reported to each user, so that retries are reported once
+	failedOn map[int]string
+}
+
+// NewScheduler creates a square-off scheduler. defaultTime is the time of
+// users who set none, as "15:15" IST; "off" disables their square-off.
+func NewScheduler(db *database.DB, cal *calendar.Calendar, liquidator *emergency.Liquidator, notifier Notifier, emailService *email.EmailService, emailRecipient, defaultTime string) *Scheduler {
+	s := &Scheduler{
+		db:             db,
+		calendar:       cal,
+		liquidator:     liquidator,
+		notifier:       notifier,
+		emailService:   emailService,
+		emailRecipient: emailRecipient,
+		defaults:       models.SquareOffSetting{Time: DefaultTime, Enabled: true},
+		failedOn:       make(map[int]string),
+	}
+	if strings.EqualFold(strings.TrimSpace(defaultTime), "off") {
+		s.defaults.Enabled = false
+	} else if t, err := ParseTime(defaultTime); err != nil {
+		log.Printf("SQUARE-OFF: %v, using %s", err, DefaultTime)
+	} else {
+		s.defaults.Time = t
+	}
+	return s
+}
+
+// ParseTime checks a time of day such as "15:15" and returns it as HH:MM
+func ParseTime(text string) (string, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return "", fmt.Errorf("invalid square-off time %q (use HH:MM, IST)", text)
+	}
+	return t.Format("15:04"), nil
+}
+
+// Setting returns the square-off setting that applies to a user
+func (s *Scheduler) Setting(userID int) (*models.SquareOffSetting, error) {
+	setting, err := s.db.GetSquareOffSetting(userID)
+	if err != nil {
+		return nil, err
+	}
+	if setting == nil {
+		defaults := s.defaults
+		defaults.UserID = userID
+		return &defaults, nil
+	}
+	return setting, nil
+}
+
+// SetSetting replaces the square-off setting of a user. An empty time keeps
+// the current one.
+func (s *Scheduler) SetSetting(setting *models.SquareOffSetting) error {
+	if strings.TrimSpace(setting.Time) == "" {
+		current, err := s.Setting(setting.UserID)
+		if err != nil {
+			return err
+		}
+		setting.Time = current.Time
+	}
+	t, err := ParseTime(setting.Time)
+	if err != nil {
+		return err
+	}
+	setting.Time = t
+	if err := s.db.SaveSquareOffSetting(setting); err != nil {
+		return err
+	}
+	log.Printf("SQUARE-OFF: User %d set square-off to %s (enabled: %t)", setting.UserID, setting.Time, setting.Enabled)
+	return nil
+}
+
+// At returns when the intraday positions of an exchange are squared off in a
+// session, for a square-off time of day
+func At(session calendar.Session, squareOffTime string) time.Time {
+	close := session.Close.Add(-closeBuffer)
+	if close.Before(session.Open) {
+		close = session.Open
+	}
+	t, err := time.Parse("15:04", squareOffTime)
+	if err != nil {
+		return close
+	}
+	open := session.Open.In(calendar.IST)
+	at := time.Date(open.Year(), open.Month(), open.Day(), t.Hour(), t.Minute(), 0, 0, calendar.IST)
+	if at.Before(session.Open) || at.After(close) {
+		return close
+	}
+	return at
+}
+
+// Start checks the users every interval
+func (s *Scheduler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.Check()
+			<-ticker.C
+		}
+	}()
+}
+
+// Check squares off the intraday positions of the users whose square-off
+// time has passed on an exchange that is still in session
+func (s *Scheduler) Check() {
+	users, err := s.users()
+	if err != nil {
+		log.Printf("SQUARE-OFF: Failed to load users: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, userID := range users {
+		setting, err := s.Setting(userID)
+		if err != nil {
+			log.Printf("SQUARE-OFF: Failed to load the setting of user %d: %v", userID, err)
+			continue
+		}
+		if !setting.Enabled {
+			continue
+		}
+		report := s.liquidator.Liquidate(userID, emergency.Scope{
+			Name: Strategy,
+			Match: func(exchange, product string) bool {
+				return product == Product && s.due(exchange, setting.Time, now)
+			},
+			Keep: func(order *models.AutoOrder) bool {
+				return s.laterSession(order, now)
+			},
+		})
+		s.report(userID, setting, report, now)
+	}
+}
+
+// due reports whether the square-off time of an exchange has passed while it is in session
+func (s *Scheduler) due(exchange, squareOffTime string, now time.Time) bool {
+	session, ok := s.calendar.Session(exchange, now)
+	if !ok || !session.Contains(now) {
+		return false
+	}
+	return !now.Before(At(session, squareOffTime))
+}
+
+// laterSession reports whether a scheduled order only runs again after the
+// session of its exchange closes, so it cannot open a position before then
+func (s *Scheduler) laterSession(order *models.AutoOrder, now time.Time) bool {
+	status := autoorder.StatusOf(order)
+	if status.ScheduleAt == "" || status.NextRunAt == nil {
+		return false
+	}
+	session, ok := s.calendar.Session(order.Exchange, now)
+	return ok && !status.NextRunAt.Before(session.Close)
+}
+
+// users returns the users that may hold intraday auto orders or positions
+func (s *Scheduler) users() ([]int, error) {
+	ids, err := s.db.GetLedgerUserIDs()
+	if err != nil {
+		return nil, err
+	}
+	running, err := s.db.GetRunningAutoOrders()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, order := range running {
+		if !seen[order.UserID] {
+			seen[order.UserID] = true
+			ids = append(ids, order.UserID)
+		}
+	}
+	return ids, nil
+}
+
+// report tells the user what a square-off did, in chat and by email. Checks
+// that did nothing are not reported, and failures only once a day, as they
+// are retried on every check.
+func (s *Scheduler) report(userID int, setting *models.SquareOffSetting, report *emergency.Report, now time.Time) {
+	acted := false
+	for _, step := range [][]emergency.Outcome{report.AutoOrders, report.Brackets, report.Orders, report.Positions} {
+		for _, outcome := range step {
+			if outcome.Result == emergency.ResultCancelled || outcome.Result == emergency.ResultSquaredOff {
+				acted = true
+			}
+		}
+	}
+	today := now.In(calendar.IST).Format(time.DateOnly)
+	s.mu.Lock()
+	newFailure := report.Failures > 0 && s.failedOn[userID] != today
+	if report.Failures > 0 {
+		s.failedOn[userID] = today
+	}
+	s.mu.Unlock()
+	if !acted && !newFailure {
+		return
+	}
+
+	log.Printf("SQUARE-OFF: User %d: %d auto order(s), %d bracket(s), %d order(s), %d position(s), %d failure(s)",
+		userID, len(report.AutoOrders), len(report.Brackets), len(report.Orders), len(report.Positions), report.Failures)
+	body := summary(report)
+	if report.Failures > 0 {
+		body += fmt.Sprintf("\n%d step(s) failed. They are retried until the market closes; see /open_orders.", report.Failures)
+	}
+
+	chatMsg := message{
+		Type:    "chat",
+		Content: fmt.Sprintf("⏰ **MIS square-off** (%s IST)\n\n%s", setting.Time, body),
+		Data: map[string]interface{}{
+			"role":       "system",
+			"created_at": now,
+		},
+	}
+	if msgBytes, err := json.Marshal(chatMsg); err == nil {
+		s.notifier.SendToUser(userID, msgBytes)
+	}
+	if msgBytes, err := json.Marshal(message{Type: "square_off", Data: report}); err == nil {
+		s.notifier.SendToUser(userID, msgBytes)
+	} else {
+		log.Printf("SQUARE-OFF: Failed to marshal the report of user %d: %v", userID, err)
+	}
+	s.emailService.SendEmail(s.emailRecipient, "MIS Square-off", fmt.Sprintf("MIS square-off of user %d at %s IST:\n\n%s", userID, setting.Time, body))
+}
+
+// summary lists the outcomes of a square-off, one per line
+func summary(report *emergency.Report) string {
+	var s strings.Builder
+	for _, step := range []struct {
+		title    string
+		outcomes []emergency.Outcome
+	}{
+		{"Auto-orders", report.AutoOrders},
+		{"Brackets", report.Brackets},
+		{"Broker orders", report.Orders},
+		{"Positions", report.Positions},
+	} {
+		if len(step.outcomes) == 0 {
+			continue
+		}
+		s.WriteString(step.title + ":\n")
+		for _, outcome := range step.outcomes {
+			s.WriteString(fmt.Sprintf("- %s %s: %s", outcome.ID, outcome.Description, strings.ReplaceAll(outcome.Result, "_", " ")))
+			if outcome.Detail != "" {
+				s.WriteString(" (" + outcome.Detail + ")")
+			}
+			s.WriteString("\n")
+		}
+	}
+	return s.String()
+}
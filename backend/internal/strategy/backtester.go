@@ -4,53 +4,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
+	"trading-app/internal/calendar"
+	"trading-app/internal/condition"
 	"trading-app/internal/database"
+	"trading-app/internal/marketdata"
 	"trading-app/internal/models"
 	"trading-app/internal/openalgo"
 )
 
 // Backtester runs backtests on trading strategies
 type Backtester struct {
-	db *database.DB
-	openalgo *openalgo.OpenAlgoClient // CORRECTED: Changed 'Client' to 'OpenAlgoClient'
+	db      *database.DB
+	candles *marketdata.Store
 }
 
 // NewBacktester creates a new backtester
-func NewBacktester(db *database.DB, openalgoClient *openalgo.OpenAlgoClient) *Backtester { // CORRECTED: Changed 'Client' to 'OpenAlgoClient'
+func NewBacktester(db *database.DB, candles *marketdata.Store) *Backtester {
 	return &Backtester{
-		db: db,
-		openalgo: openalgoClient,
+		db:      db,
+		candles: candles,
 	}
 }
 
 // BacktestParams represents backtest parameters
 type BacktestParams struct {
-	StrategyID int `json:"strategy_id"`
-	StartDate time.Time `json:"start_date"`
-	EndDate time.Time `json:"end_date"`
-	InitialCapital float64 `json:"initial_capital"`
-	Symbol string `json:"symbol"`
-	Exchange string `json:"exchange"`
+	StrategyID     int       `json:"strategy_id"`
+	StartDate      time.Time `json:"start_date"`
+	EndDate        time.Time `json:"end_date"`
+	InitialCapital float64   `json:"initial_capital"`
+	Symbol         string    `json:"symbol"`
+	Exchange       string    `json:"exchange"`
+	Interval       string    `json:"interval"`        // 5m, 15m, 1h or D (default)
+	EntryCondition string    `json:"entry_condition"` // Defaults to the entry condition in the strategy code
+	ExitCondition  string    `json:"exit_condition"`  // Defaults to the exit condition in the strategy code
+	PositionSize   float64   `json:"position_size"`   // Fraction of equity committed per trade (default 0.2)
 }
 
 // BacktestTrade represents a trade in the backtest
 type BacktestTrade struct {
 	Timestamp time.Time `json:"timestamp"`
-	Action string `json:"action"`
-	Price float64 `json:"price"`
-	Quantity int `json:"quantity"`
-	PnL float64 `json:"pnl"`
+	Action    string    `json:"action"`
+	Price     float64   `json:"price"`
+	Quantity  int       `json:"quantity"`
+	PnL       float64   `json:"pnl"`
 }
 
 // BacktestMetrics contains detailed backtest metrics
 type BacktestMetrics struct {
-	Trades []BacktestTrade `json:"trades"`
-	EquityCurve []float64 `json:"equity_curve"`
-	DrawdownCurve []float64 `json:"drawdown_curve"`
+	Symbol         string          `json:"symbol"`
+	Exchange       string          `json:"exchange"`
+	Interval       string          `json:"interval"`
+	EntryCondition string          `json:"entry_condition"`
+	ExitCondition  string          `json:"exit_condition"`
+	Trades         []BacktestTrade `json:"trades"`
+	Timestamps     []time.Time     `json:"timestamps"`
+	EquityCurve    []float64       `json:"equity_curve"`
+	DrawdownCurve  []float64       `json:"drawdown_curve"`
 }
 
+const (
+	defaultBacktestInterval = "D"
+	defaultPositionSize     = 0.2 // Use 20% of capital per trade
+)
+
 // RunBacktest executes a backtest
 func (b *Backtester) RunBacktest(params BacktestParams) (*models.BacktestResult, error) {
 	// Get strategy
@@ -62,22 +82,110 @@ func (b *Backtester) RunBacktest(params BacktestParams) (*models.BacktestResult,
 		return nil, fmt.Errorf("strategy not found")
 	}
 
-	// For this MVP, we'll create a simplified backtest
-	// In production, you would:
-	// 1. Fetch historical data from OpenAlgo/broker
-	// 2. Parse and execute the strategy code
-	// 3. Simulate trades based on strategy signals
+	if params.Symbol == "" || params.Exchange == "" {
+		return nil, fmt.Errorf("symbol and exchange are required")
+	}
+	if !params.EndDate.After(params.StartDate) {
+		return nil, fmt.Errorf("end date must be after start date")
+	}
+	if params.Interval == "" {
+		params.Interval = defaultBacktestInterval
+	}
+	if params.PositionSize <= 0 || params.PositionSize > 1 {
+		params.PositionSize = defaultPositionSize
+	}
+
+	// Conditions given with the request take precedence over those in the strategy code
+	entryCondition, exitCondition := ParseStrategyConditions(strategy.Code)
+	if params.EntryCondition != "" {
+		entryCondition = params.EntryCondition
+	}
+	if params.ExitCondition != "" {
+		exitCondition = params.ExitCondition
+	}
+	if entryCondition == "" || exitCondition == "" {
+		return nil, fmt.Errorf("strategy needs an entry and an exit condition (add 'entry: <condition>' and 'exit: <condition>' lines to the strategy code)")
+	}
+	params.EntryCondition = entryCondition
+	params.ExitCondition = exitCondition
+
+	entryProgram, err := condition.Compile(params.EntryCondition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entry condition: %w", err)
+	}
+	exitProgram, err := condition.Compile(params.ExitCondition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exit condition: %w", err)
+	}
+
+	// The candles start early enough for the indicators of both conditions to
+	// have settled by the start date
+	history := entryProgram.History(params.Interval)
+	for interval, bars := range exitProgram.History(params.Interval) {
+		history[interval] = max(history[interval], bars)
+	}
+	own := params.Interval
+	if normalized, ok := condition.NormalizeInterval(own); ok {
+		own = normalized
+	}
+	candles, err := b.candles.WarmHistory(
+		strings.ToUpper(params.Symbol),
+		strings.ToUpper(params.Exchange),
+		params.Interval,
+		history[own],
+		params.StartDate,
+		params.EndDate,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch historical data: %w", err)
+	}
+	// Bars outside the sessions of the exchange, such as stray ticks on holidays, are never traded on
+	candles = inSession(b.candles.Calendar(), params.Exchange, params.Interval, candles)
+	// Trading starts with the first bar of the start date on the exchange
+	startDay := params.StartDate.Format("2006-01-02")
+	first := sort.Search(len(candles), func(i int) bool {
+		return time.Unix(candles[i].Timestamp, 0).In(calendar.IST).Format("2006-01-02") >= startDay
+	})
+	if first == len(candles) {
+		return nil, fmt.Errorf("no historical data for %s on %s between %s and %s", params.Symbol, params.Exchange, params.StartDate.Format("2006-01-02"), params.EndDate.Format("2006-01-02"))
+	}
+
+	// Fetch the other timeframes referenced by either condition, with their own warm-up
+	timeframes, err := b.candles.HistoryTimeframes(
+		strings.ToUpper(params.Symbol),
+		strings.ToUpper(params.Exchange),
+		params.Interval,
+		append(entryProgram.Intervals(), exitProgram.Intervals()...),
+		history,
+		params.StartDate,
+		params.EndDate,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	// Simplified simulation
-	trades, metrics := b.simulateStrategy(params)
+	trades, metrics, err := b.simulateStrategy(params, entryProgram, exitProgram, condition.Input{
+		Interval:   params.Interval,
+		Bars:       openalgo.BarsFromCandles(candles),
+		Timeframes: timeframes,
+	}, candles, first)
+	if err != nil {
+		return nil, err
+	}
 
 	// Calculate metrics
-	totalReturn := metrics.EquityCurve[len(metrics.EquityCurve)-1] - params.InitialCapital
+	finalCapital := metrics.EquityCurve[len(metrics.EquityCurve)-1]
+	totalReturn := finalCapital - params.InitialCapital
 	returnPercent := (totalReturn / params.InitialCapital) * 100
 
+	closedTrades := 0
 	winningTrades := 0
 	losingTrades := 0
 	for _, trade := range trades {
+		if trade.Action != "SELL" {
+			continue
+		}
+		closedTrades++
 		if trade.PnL > 0 {
 			winningTrades++
 		} else if trade.PnL < 0 {
@@ -86,7 +194,7 @@ func (b *Backtester) RunBacktest(params BacktestParams) (*models.BacktestResult,
 	}
 
 	maxDrawdown := b.calculateMaxDrawdown(metrics.DrawdownCurve)
-	sharpeRatio := b.calculateSharpeRatio(metrics.EquityCurve, params.InitialCapital)
+	sharpeRatio := b.calculateSharpeRatio(metrics.EquityCurve, periodsPerYear(params.Interval, b.candles.Calendar().SessionMinutes(params.Exchange)))
 
 	// Serialize metrics
 	metricsJSON, err := json.Marshal(metrics)
@@ -96,18 +204,18 @@ func (b *Backtester) RunBacktest(params BacktestParams) (*models.BacktestResult,
 
 	// Create backtest result
 	result := &models.BacktestResult{
-		StrategyID: params.StrategyID,
-		StartDate: params.StartDate,
-		EndDate: params.EndDate,
+		StrategyID:     params.StrategyID,
+		StartDate:      params.StartDate,
+		EndDate:        params.EndDate,
 		InitialCapital: params.InitialCapital,
-		FinalCapital: metrics.EquityCurve[len(metrics.EquityCurve)-1],
-		TotalReturn: returnPercent,
-		TotalTrades: len(trades),
-		WinningTrades: winningTrades,
-		LosingTrades: losingTrades,
-		MaxDrawdown: maxDrawdown,
-		SharpeRatio: sharpeRatio,
-		ResultData: string(metricsJSON),
+		FinalCapital:   finalCapital,
+		TotalReturn:    returnPercent,
+		TotalTrades:    closedTrades,
+		WinningTrades:  winningTrades,
+		LosingTrades:   losingTrades,
+		MaxDrawdown:    maxDrawdown,
+		SharpeRatio:    sharpeRatio,
+		ResultData:     string(metricsJSON),
 	}
 
 	// Save to database
@@ -119,95 +227,119 @@ func (b *Backtester) RunBacktest(params BacktestParams) (*models.BacktestResult,
 	return savedResult, nil
 }
 
-// simulateStrategy simulates strategy execution (simplified)
-func (b *Backtester) simulateStrategy(params BacktestParams) ([]BacktestTrade, BacktestMetrics) {
-	// This is a simplified simulation
-	// In production, you would fetch real historical data and execute strategy logic
+// simulateStrategy replays the candles from first on, bar by bar, as a
+// long-only strategy. The candles before first only warm up the indicators.
+// Conditions are evaluated on the close of each bar using only the bars seen
+// so far, and the resulting order is filled at the open of the next bar.
+func (b *Backtester) simulateStrategy(params BacktestParams, entryProgram, exitProgram *condition.Program, input condition.Input, candles []openalgo.OpenAlgoCandle, first int) ([]BacktestTrade, BacktestMetrics, error) {
+	// Bars without enough history for the indicators never signal
+	entrySignals, err := entryProgram.EvalSeries(input)
+	if err != nil {
+		return nil, BacktestMetrics{}, fmt.Errorf("failed to evaluate entry condition: %w", err)
+	}
+	exitSignals, err := exitProgram.EvalSeries(input)
+	if err != nil {
+		return nil, BacktestMetrics{}, fmt.Errorf("failed to evaluate exit condition: %w", err)
+	}
 
 	trades := []BacktestTrade{}
+	timestamps := []time.Time{params.StartDate}
 	equityCurve := []float64{params.InitialCapital}
 	drawdownCurve := []float64{0}
 
-	currentCapital := params.InitialCapital
+	cash := params.InitialCapital
 	position := 0
 	entryPrice := 0.0
-
-	// Simulate 50 days of trading
-	days := int(params.EndDate.Sub(params.StartDate).Hours() / 24)
-	if days > 100 {
-		days = 100 // Limit for demo
-	}
-
-	// Generate random trades for demo
-	// In production, this would be based on actual strategy signals
-	for i := 0; i < days; i++ {
-		timestamp := params.StartDate.Add(time.Duration(i) * 24 * time.Hour)
-
-		// Simulate price movement (random walk)
-		price := 100.0 + float64(i)*0.5 + (float64(i%10) - 5)
-
-		// Simple strategy: buy if no position, sell if in position
-		if i%5 == 0 {
-			if position == 0 {
-				// Buy
-				quantity := int(currentCapital * 0.2 / price) // Use 20% of capital
-				if quantity > 0 {
-					position = quantity
-					entryPrice = price
-					currentCapital -= float64(quantity) * price
-
-					trades = append(trades, BacktestTrade{
-						Timestamp: timestamp,
-						Action: "BUY",
-						Price: price,
-						Quantity: quantity,
-						PnL: 0,
-					})
-				}
-			} else {
-				// Sell
-				pnl := (price - entryPrice) * float64(position)
-				currentCapital += float64(position) * price
+	peakEquity := params.InitialCapital
+	pendingAction := ""
+
+	for i := first; i < len(candles); i++ {
+		candle := candles[i]
+		timestamp := time.Unix(candle.Timestamp, 0)
+
+		// Fill the order signalled on the previous bar at this bar's open
+		switch pendingAction {
+		case "BUY":
+			equity := cash + float64(position)*candle.Open
+			quantity := int(equity * params.PositionSize / candle.Open)
+			if quantity > 0 && float64(quantity)*candle.Open <= cash {
+				position = quantity
+				entryPrice = candle.Open
+				cash -= float64(quantity) * candle.Open
 
 				trades = append(trades, BacktestTrade{
 					Timestamp: timestamp,
-					Action: "SELL",
-					Price: price,
-					Quantity: position,
-					PnL: pnl,
+					Action:    "BUY",
+					Price:     candle.Open,
+					Quantity:  quantity,
+					PnL:       0,
 				})
-
-				position = 0
-				entryPrice = 0
 			}
+		case "SELL":
+			pnl := (candle.Open - entryPrice) * float64(position)
+			cash += float64(position) * candle.Open
+
+			trades = append(trades, BacktestTrade{
+				Timestamp: timestamp,
+				Action:    "SELL",
+				Price:     candle.Open,
+				Quantity:  position,
+				PnL:       pnl,
+			})
+
+			position = 0
+			entryPrice = 0
 		}
-
-		// Calculate current equity
-		equity := currentCapital
-		if position > 0 {
-			equity += float64(position) * price
+		pendingAction = ""
+
+		// Signals on the last bar cannot be filled within the range
+		if i < len(candles)-1 {
+			if position == 0 && entrySignals[i] {
+				pendingAction = "BUY"
+			} else if position > 0 && exitSignals[i] {
+				pendingAction = "SELL"
+			}
 		}
 
+		// Calculate current equity
+		equity := cash + float64(position)*candle.Close
+		timestamps = append(timestamps, timestamp)
 		equityCurve = append(equityCurve, equity)
 
 		// Calculate drawdown
-		maxEquity := equityCurve[0]
-		for _, e := range equityCurve {
-			if e > maxEquity {
-				maxEquity = e
-			}
+		if equity > peakEquity {
+			peakEquity = equity
 		}
-		drawdown := ((maxEquity - equity) / maxEquity) * 100
+		drawdown := ((peakEquity - equity) / peakEquity) * 100
 		drawdownCurve = append(drawdownCurve, drawdown)
 	}
 
+	// Close any open position at the final close so that P&L is realized
+	if position > 0 {
+		last := candles[len(candles)-1]
+		pnl := (last.Close - entryPrice) * float64(position)
+		trades = append(trades, BacktestTrade{
+			Timestamp: time.Unix(last.Timestamp, 0),
+			Action:    "SELL",
+			Price:     last.Close,
+			Quantity:  position,
+			PnL:       pnl,
+		})
+	}
+
 	metrics := BacktestMetrics{
-		Trades: trades,
-		EquityCurve: equityCurve,
-		DrawdownCurve: drawdownCurve,
+		Symbol:         params.Symbol,
+		Exchange:       params.Exchange,
+		Interval:       params.Interval,
+		EntryCondition: params.EntryCondition,
+		ExitCondition:  params.ExitCondition,
+		Trades:         trades,
+		Timestamps:     timestamps,
+		EquityCurve:    equityCurve,
+		DrawdownCurve:  drawdownCurve,
 	}
 
-	return trades, metrics
+	return trades, metrics, nil
 }
 
 // calculateMaxDrawdown calculates maximum drawdown
@@ -221,17 +353,17 @@ func (b *Backtester) calculateMaxDrawdown(drawdownCurve []float64) float64 {
 	return maxDD
 }
 
-// calculateSharpeRatio calculates Sharpe ratio
-func (b *Backtester) calculateSharpeRatio(equityCurve []float64, initialCapital float64) float64 {
+// calculateSharpeRatio calculates the annualized Sharpe ratio of per-bar returns
+func (b *Backtester) calculateSharpeRatio(equityCurve []float64, periodsPerYear float64) float64 {
 	if len(equityCurve) < 2 {
 		return 0
 	}
 
-	// Calculate daily returns
+	// Calculate per-bar returns
 	returns := []float64{}
 	for i := 1; i < len(equityCurve); i++ {
-		dailyReturn := (equityCurve[i] - equityCurve[i-1]) / equityCurve[i-1]
-		returns = append(returns, dailyReturn)
+		barReturn := (equityCurve[i] - equityCurve[i-1]) / equityCurve[i-1]
+		returns = append(returns, barReturn)
 	}
 
 	// Calculate mean return
@@ -254,7 +386,37 @@ func (b *Backtester) calculateSharpeRatio(equityCurve []float64, initialCapital
 	}
 
 	// Sharpe ratio (assuming risk-free rate = 0)
-	sharpeRatio := meanReturn / stdDev * math.Sqrt(252) // Annualized
+	sharpeRatio := meanReturn / stdDev * math.Sqrt(periodsPerYear) // Annualized
 
 	return sharpeRatio
 }
+
+// periodsPerYear returns the number of bars of an interval in a trading year
+// of 252 sessions of the given length
+func periodsPerYear(interval string, sessionMinutes int) float64 {
+	const sessions = 252
+	length, err := condition.IntervalDuration(interval)
+	if err != nil || length >= 24*time.Hour {
+		return sessions
+	}
+	return sessions * float64(sessionMinutes) / length.Minutes()
+}
+
+// inSession drops the candles that lie outside the sessions of an exchange:
+// intraday candles that open while it is closed, and daily candles of days it
+// does not trade. Longer candles are kept.
+func inSession(cal *calendar.Calendar, exchange, interval string, candles []openalgo.OpenAlgoCandle) []openalgo.OpenAlgoCandle {
+	length, err := condition.IntervalDuration(interval)
+	if err != nil || length > 24*time.Hour {
+		return candles
+	}
+	kept := make([]openalgo.OpenAlgoCandle, 0, len(candles))
+	for _, candle := range candles {
+		start := time.Unix(candle.Timestamp, 0)
+		session, ok := cal.Session(exchange, start)
+		if ok && (length == 24*time.Hour || session.Contains(start)) {
+			kept = append(kept, candle)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,59 @@
+package strategy
+
+import (
+	"strings"
+)
+
+// Prefixes recognized for entry and exit conditions in strategy code. Both the
+// plain "entry: ..." form and Pine-style assignments are accepted.
+var (
+	entryPrefixes = []string{"entry", "longcondition", "buycondition", "entrycondition"}
+	exitPrefixes  = []string{"exit", "exitcondition", "sellcondition", "shortcondition"}
+)
+
+// ParseStrategyConditions extracts the entry and exit conditions from strategy
+// code, for example:
+//
+//	entry: RSI14 < 30
+//	exit: RSI14 > 70
+//
+// Either value is empty if the code does not define it.
+func ParseStrategyConditions(code string) (entry, exit string) {
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sep := strings.IndexAny(line, ":=")
+		if sep <= 0 {
+			continue
+		}
+		// Skip comparisons such as "a == b" or "a >= b" on their own
+		if line[sep] == '=' && sep+1 < len(line) && line[sep+1] == '=' {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(line[:sep]))
+		value := strings.Trim(strings.TrimSpace(line[sep+1:]), "\"")
+		if value == "" {
+			continue
+		}
+
+		if entry == "" && hasName(entryPrefixes, name) {
+			entry = value
+		} else if exit == "" && hasName(exitPrefixes, name) {
+			exit = value
+		}
+	}
+	return entry, exit
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
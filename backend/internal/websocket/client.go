@@ -2,20 +2,25 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"trading-app/internal/ai"
+	"trading-app/internal/autoorder"
+	"trading-app/internal/bracket"
+	"trading-app/internal/broker"
 	"trading-app/internal/database"
-	"trading-app/internal/email"
+	"trading-app/internal/emergency"
 	"trading-app/internal/models"
-	"trading-app/internal/openalgo"
+	"trading-app/internal/marketdata"
+	"trading-app/internal/orders"
+	"trading-app/internal/risk"
+	"trading-app/internal/squareoff"
 )
 
 const (
@@ -26,18 +31,20 @@ const (
 )
 
 type Client struct {
-	hub            *Hub
-	conn           *websocket.Conn
-	send           chan []byte
-	userID         int
-	db             *database.DB
-	ai             *ai.AIClient
-	oaClient       *openalgo.OpenAlgoClient
-	autoOrders     map[string]*models.AutoOrder
-	orderMux       sync.Mutex
-	cancellation   map[string]chan struct{}
-	emailService   *email.EmailService
-	emailRecipient string
+	hub        *Hub
+	conn       *websocket.Conn
+	send       chan []byte
+	userID     int
+	db         *database.DB
+	ai         *ai.AIClient
+	brokers    *broker.Selector
+	candles    *marketdata.Store
+	engine     *autoorder.Engine
+	orders     *orders.Tracker
+	brackets   *bracket.Manager
+	risk       *risk.Engine
+	liquidator *emergency.Liquidator
+	squareOff  *squareoff.Scheduler
 }
 
 type Message struct {
@@ -47,51 +54,25 @@ type Message struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID int, db *database.DB, aiClient *ai.AIClient, baseURL string, apiKey string, emailService *email.EmailService, emailRecipient string) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID int, db *database.DB, aiClient *ai.AIClient, brokers *broker.Selector, candles *marketdata.Store, engine *autoorder.Engine, tracker *orders.Tracker, brackets *bracket.Manager, riskEngine *risk.Engine, liquidator *emergency.Liquidator, squareOff *squareoff.Scheduler) *Client {
 	return &Client{
-		hub:            hub,
-		conn:           conn,
-		send:           make(chan []byte, 256),
-		userID:         userID,
-		db:             db,
-		ai:             aiClient,
-		oaClient:       openalgo.NewOpenAlgoClient(baseURL, apiKey),
-		autoOrders:     make(map[string]*models.AutoOrder),
-		cancellation:   make(map[string]chan struct{}),
-		emailService:   emailService,
-		emailRecipient: emailRecipient,
+		hub:        hub,
+		conn:       conn,
+		send:       make(chan []byte, 256),
+		userID:     userID,
+		db:         db,
+		ai:         aiClient,
+		brokers:    brokers,
+		candles:    candles,
+		engine:     engine,
+		orders:     tracker,
+		brackets:   brackets,
+		risk:       riskEngine,
+		liquidator: liquidator,
+		squareOff:  squareOff,
 	}
 }
 
-func (c *Client) StartAutoOrderMonitoring(symbol, exchange, product, interval, condition, action string, quantity int, expiresAt time.Time) (string, error) {
-	orderID := fmt.Sprintf("SO-%d", time.Now().Unix()%100000)
-	cancelChan := make(chan struct{})
-
-	order := &models.AutoOrder{
-		ID:        orderID,
-		UserID:    c.userID,
-		Symbol:    symbol,
-		Exchange:  exchange,
-		Product:   product,
-		Quantity:  quantity,
-		Action:    action,
-		Interval:  interval,
-		Condition: condition,
-		Status:    "running",
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
-	}
-
-	c.orderMux.Lock()
-	c.autoOrders[orderID] = order
-	c.cancellation[orderID] = cancelChan
-	c.orderMux.Unlock()
-
-	go c.monitorAndPlaceOrder(order)
-
-	return orderID, nil
-}
-
 func (c *Client) sendError(errMsg string) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -110,288 +91,232 @@ func (c *Client) sendError(errMsg string) {
 	}
 }
 
-func (c *Client) monitorAndPlaceOrder(order *models.AutoOrder) {
+func (c *Client) sendSystemMessage(content string) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("🚨 PANIC in monitorAndPlaceOrder for %s: %v", order.Symbol, r)
-			c.sendError(fmt.Sprintf("❌ Auto-Order %s crashed: %v.", order.ID, r))
-			c.emailService.SendEmail(c.emailRecipient, "Auto-Order Process crashed", fmt.Sprintf("Auto-Order %s crashed: %v", order.ID, r))
-			if time.Now().Before(order.ExpiresAt) {
-				c.sendSystemMessage(fmt.Sprintf(" restarting monitoring for order %s.", order.ID))
-				go c.monitorAndPlaceOrder(order)
-			} else {
-				c.sendSystemMessage(fmt.Sprintf(" order %s has expired and will not be restarted.", order.ID))
-				c.removeAutoOrder(order.ID)
-			}
+			log.Printf("Recovered in sendSystemMessage: %v", r)
 		}
 	}()
-
-	log.Printf("AUTO-ORDER: Monitoring started for %s on %s. Interval: %s. Condition: %s",
-		order.Symbol, order.Exchange, order.Interval, order.Condition)
-
-	c.orderMux.Lock()
-	cancelChan, ok := c.cancellation[order.ID]
-	c.orderMux.Unlock()
-
-	if !ok {
-		log.Printf("AUTO-ORDER ERROR: Could not find cancellation channel for order %s. Stopping.", order.ID)
-		return
+	msg := Message{
+		Type:    "chat",
+		Content: content,
+		Data: map[string]interface{}{
+			"role":       "system",
+			"created_at": time.Now(),
+		},
 	}
+	msgBytes, _ := json.Marshal(msg)
+	c.send <- msgBytes
+}
 
-	checkDelay, _ := ParseIntervalDuration(order.Interval)
-	if checkDelay < 5*time.Second {
-		checkDelay = 5 * time.Second
+// formatAutoOrderStatus renders a running auto order for the /status_orders command
+func formatAutoOrderStatus(order *models.AutoOrder) string {
+	order.StateMux.RLock()
+	defer order.StateMux.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("**%s**: %s %d %s on %s (%s)\n", order.ID, order.Action, order.Quantity, order.Symbol, order.Exchange, order.Product))
+	if order.ScheduleAt != "" {
+		b.WriteString(fmt.Sprintf("- **Schedule**: %s\n", formatSchedule(order.ScheduleAt, order.Recurring)))
+		if order.NextRunAt != nil {
+			b.WriteString(fmt.Sprintf("- **Next Run**: %s\n", order.NextRunAt.Format("Mon 02 Jan 15:04 MST")))
+		}
+	} else {
+		b.WriteString(fmt.Sprintf("- **Condition**: `%s` (%s)\n", order.Condition, order.Interval))
 	}
-	ticker := time.NewTicker(checkDelay)
-	defer ticker.Stop()
-
-	expiryDuration := time.Until(order.ExpiresAt)
-	if expiryDuration <= 0 {
-		c.sendSystemMessage(fmt.Sprintf("⚠️ Auto-Order %s already expired. Stopping.", order.ID))
-		return
+	if order.StopLoss != "" || order.Target != "" || order.TrailingStop != "" {
+		b.WriteString(fmt.Sprintf("- **Bracket**: %s\n", formatLegs(order)))
 	}
-	if expiryDuration > 30*24*time.Hour {
-		expiryDuration = 30 * 24 * time.Hour
+	b.WriteString(fmt.Sprintf("- **State**: %s\n", order.State))
+	if order.LastEvaluatedAt != nil {
+		b.WriteString(fmt.Sprintf("- **Last Check**: %s, condition %t\n", order.LastEvaluatedAt.Format("15:04:05"), order.LastResult))
 	}
-	expiryTimer := time.NewTimer(expiryDuration)
-	defer expiryTimer.Stop()
-
-	defer func() {
-		c.removeAutoOrder(order.ID)
-		log.Printf("AUTO-ORDER: Monitoring for %s (ID: %s) stopped and cleaned up.", order.Symbol, order.ID)
-	}()
-
-	for {
-		select {
-		case <-cancelChan:
-			c.sendSystemMessage(fmt.Sprintf("❌ Auto-Order %s for %s was CANCELLED.", order.ID, order.Symbol))
-			return
-		case <-expiryTimer.C:
-			c.sendSystemMessage(fmt.Sprintf("🕒 Auto-Order %s for %s has EXPIRED. Monitoring stopped.", order.ID, order.Symbol))
-			return
-		case <-ticker.C:
-			if time.Now().After(order.ExpiresAt) {
-				c.sendSystemMessage(fmt.Sprintf("🕒 Auto-Order %s for %s has EXPIRED. Monitoring stopped.", order.ID, order.Symbol))
-				return
-			}
-
-			isMet, valuesMap, err := c.oaClient.EvaluatePineCondition(order.Interval, order.Condition, order.Symbol, order.Exchange)
-			if err != nil {
-				log.Printf("AUTO-ORDER: Evaluation error for %s: %v", order.ID, err)
-				continue
-			}
-
-			// State transition logic: only fire when the condition *becomes* true
-			if isMet && !order.ConditionState {
-				order.StateMux.Lock()
-				order.ConditionState = true
-				order.StateMux.Unlock()
-
-				var indicatorSummary strings.Builder
-				for name, value := range valuesMap {
-					if math.IsNaN(value) || math.IsInf(value, 0) {
-						indicatorSummary.WriteString(fmt.Sprintf(" **%s**: N/A |", name))
-					} else {
-						indicatorSummary.WriteString(fmt.Sprintf(" **%s**: %.2f |", name, value))
-					}
-				}
-
-				orderReq := &openalgo.OpenAlgoSmartOrderRequest{
-					Strategy:  "auto_chat",
-					Symbol:    order.Symbol,
-					Exchange:  order.Exchange,
-					Action:    order.Action,
-					Pricetype: "MARKET",
-					Product:   order.Product,
-					Quantity:  order.Quantity,
-				}
-
-				log.Printf("AUTO-ORDER: Condition met for %s. Placing order.", order.ID)
-				orderResponse, err := c.oaClient.PlaceOpenAlgoSmartOrder(orderReq)
-
-				if err != nil {
-					// On failure, cancel the auto-order immediately
-					errMsg := fmt.Sprintf("❌ Auto-Order %s FAILED to place order: %v. The auto-order has been CANCELLED.", order.ID, err)
-					c.sendError(errMsg)
-					c.emailService.SendEmail(c.emailRecipient, "Auto-Order CANCELLED Due to Failure", errMsg)
-					// Use a goroutine to not block the current loop
-					go func() {
-						c.orderMux.Lock()
-						if ch, ok := c.cancellation[order.ID]; ok {
-							select {
-							case <-ch: // Already closed
-							default:
-								close(ch)
-							}
-						}
-						c.orderMux.Unlock()
-					}()
-					return // Stop the monitoring loop
-				} else {
-					// Safely access the broker ID from the already parsed response
-					brokerID := ""
-					if orderResponse != nil && orderResponse.Data.OrderID != "" {
-						brokerID = orderResponse.Data.OrderID
-					}
-
-					if brokerID == "" {
-						log.Printf("CRITICAL: Broker Order ID is empty for auto-order %s. Status polling will fail.", order.ID)
-						// Even if brokerID is empty, we must send a success message so the user knows the trigger fired.
-						// The user will see that the Broker ID is missing.
-					}
-
-					c.sendSystemMessage(fmt.Sprintf("✅ **AUTO ORDER EXECUTED** for %s on %s!\n\n### Trigger Values:\n%s\n**Broker ID**: %s\n\nMonitoring continues.",
-						order.Symbol, order.Exchange, indicatorSummary.String(), brokerID))
-					c.emailService.SendEmail(c.emailRecipient, "Auto-Order Executed", fmt.Sprintf("Auto-Order %s executed for %s on %s.", order.ID, order.Symbol, order.Exchange))
-
-					// Only start polling if we have a valid broker ID
-					if brokerID != "" {
-						go c.pollOrderStatus(order.ID, brokerID)
-					}
-				}
-			} else if !isMet && order.ConditionState {
-				// Condition is no longer met, reset the state so it can fire again
-				log.Printf("AUTO-ORDER: Condition for %s is no longer met. Resetting state.", order.ID)
-				order.StateMux.Lock()
-				order.ConditionState = false
-				order.StateMux.Unlock()
-			}
-		}
+	if len(order.LastValues) > 0 {
+		b.WriteString(fmt.Sprintf("- **Last Values**:%s\n", autoorder.FormatValues(order.LastValues)))
 	}
+	if order.LastError != "" {
+		b.WriteString(fmt.Sprintf("- **Last Error**: %s\n", order.LastError))
+	}
+	fired := fmt.Sprintf("%d time(s)", order.FireCount)
+	if order.LastFiredAt != nil {
+		fired += fmt.Sprintf(", last at %s", order.LastFiredAt.Format("02 Jan 15:04:05"))
+	}
+	b.WriteString(fmt.Sprintf("- **Fired**: %s\n", fired))
+	b.WriteString(fmt.Sprintf("- **Expires**: %s\n", formatTimeToExpiry(order.ExpiresAt)))
+	return b.String()
 }
 
-func (c *Client) pollOrderStatus(autoOrderID, brokerOrderID string) {
-	const maxRetries = 5
-	const retryInterval = 15 * time.Second
+// formatSchedule renders when a scheduled order runs
+func formatSchedule(at string, recurring bool) string {
+	if recurring {
+		return at + " IST on every trading day"
+	}
+	return at + " IST, once"
+}
 
-	for i := 0; i < maxRetries; i++ {
-		time.Sleep(retryInterval)
+// formatBracket renders a bracket for the /brackets command
+func formatBracket(b *models.Bracket) string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("**%d**: %s %d %s:%s (%s) — %s\n", b.ID, b.Action, b.Quantity, b.Exchange, b.Symbol, b.Product, b.Status))
+	if b.EntryPrice > 0 {
+		s.WriteString(fmt.Sprintf("- **Entry**: %.2f\n", b.EntryPrice))
+	}
+	stopLoss, target := orDash(b.StopLossSpec), orDash(b.TargetSpec)
+	if b.StopLoss > 0 {
+		stopLoss = fmt.Sprintf("%.2f", b.StopLoss)
+	}
+	if b.Target > 0 {
+		target = fmt.Sprintf("%.2f", b.Target)
+	}
+	s.WriteString(fmt.Sprintf("- **Stop-loss**: %s, **Target**: %s\n", stopLoss, target))
+	if b.TrailingStop != "" {
+		s.WriteString(fmt.Sprintf("- **Trailing**: by %s on every %s, extreme %.2f\n", b.TrailingStop, b.TrailOn, b.ExtremePrice))
+	}
+	if b.Status == models.BracketClosed {
+		s.WriteString(fmt.Sprintf("- **Exit**: %s at %.2f, P&L %.2f\n", strings.ReplaceAll(b.ExitReason, "_", "-"), b.ExitPrice, b.RealizedPnL))
+	}
+	if b.LastError != "" {
+		s.WriteString(fmt.Sprintf("- **Note**: %s\n", b.LastError))
+	}
+	return s.String()
+}
 
-		c.orderMux.Lock()
-		autoOrder, exists := c.autoOrders[autoOrderID]
-		c.orderMux.Unlock()
-		if !exists {
-			log.Printf("Order status polling for %s stopped as the auto-order no longer exists.", autoOrderID)
-			return
+// formatRiskLimits renders the risk limits of a user for the /risk command
+func formatRiskLimits(limits *models.RiskLimits) string {
+	limit := func(value float64) string {
+		if value <= 0 {
+			return "none"
 		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	blocked := "none"
+	if len(limits.BlockedSymbols) > 0 {
+		blocked = strings.Join(limits.BlockedSymbols, ", ")
+	}
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("- **Max order value**: %s\n", limit(limits.MaxOrderValue)))
+	s.WriteString(fmt.Sprintf("- **Max quantity per symbol**: %s\n", limit(float64(limits.MaxSymbolQuantity))))
+	s.WriteString(fmt.Sprintf("- **Max open positions**: %s\n", limit(float64(limits.MaxOpenPositions))))
+	s.WriteString(fmt.Sprintf("- **Max daily loss**: %s\n", limit(limits.MaxDailyLoss)))
+	s.WriteString(fmt.Sprintf("- **Max orders per minute**: %s\n", limit(float64(limits.MaxOrdersPerMinute))))
+	s.WriteString(fmt.Sprintf("- **Blocked symbols**: %s\n", blocked))
+	return s.String()
+}
 
-		status, err := c.oaClient.FetchOrderStatus(brokerOrderID, "auto_chat")
-		if err != nil {
-			log.Printf("Error fetching order status for %s: %v", brokerOrderID, err)
+// formatPanicReport renders the outcome of the /panic command
+func formatPanicReport(report *emergency.Report) string {
+	icons := map[string]string{
+		emergency.ResultCancelled:   "✅",
+		emergency.ResultKept:        "⏳",
+		emergency.ResultSquaredOff:  "✅",
+		emergency.ResultExitPending: "⏳",
+		emergency.ResultFailed:      "❌",
+	}
+	var s strings.Builder
+	s.WriteString("🚨 **PANIC**\n")
+	for _, step := range []struct {
+		title    string
+		outcomes []emergency.Outcome
+	}{
+		{"Auto-orders", report.AutoOrders},
+		{"Brackets", report.Brackets},
+		{"Broker orders", report.Orders},
+		{"Positions", report.Positions},
+	} {
+		s.WriteString(fmt.Sprintf("\n**%s**\n", step.title))
+		if len(step.outcomes) == 0 {
+			s.WriteString("- none\n")
 			continue
 		}
-
-		log.Printf("Order %s status for %s (%s): %s", brokerOrderID, autoOrder.Symbol, autoOrder.Action, status.OrderStatus)
-
-		switch strings.ToLower(status.OrderStatus) {
-		case "complete":
-			return
-		case "rejected", "cancelled":
-			failureMsg := fmt.Sprintf(
-				"⚠️ **Order Failure Notice** ⚠️\n\nYour auto-order for **%s** (%s) with broker ID **%s** was **%s**.",
-				autoOrder.Symbol, autoOrder.Action, brokerOrderID, strings.ToUpper(status.OrderStatus),
-			)
-			c.sendSystemMessage(failureMsg)
-			c.emailService.SendEmail(
-				c.emailRecipient,
-				fmt.Sprintf("Auto-Order %s for %s was %s", autoOrder.ID, autoOrder.Symbol, strings.ToUpper(status.OrderStatus)),
-				failureMsg,
-			)
-			return
+		for _, outcome := range step.outcomes {
+			s.WriteString(fmt.Sprintf("- %s **%s** %s: %s", icons[outcome.Result], orDash(outcome.ID), outcome.Description, strings.ReplaceAll(outcome.Result, "_", " ")))
+			if outcome.Detail != "" {
+				s.WriteString(" (" + outcome.Detail + ")")
+			}
+			s.WriteString("\n")
 		}
 	}
-
-	c.orderMux.Lock()
-	autoOrder, exists := c.autoOrders[autoOrderID]
-	c.orderMux.Unlock()
-	if !exists {
-		return
+	if report.Failures > 0 {
+		s.WriteString(fmt.Sprintf("\n⚠️ %d step(s) failed. Run `/panic` again to retry them.", report.Failures))
+	} else {
+		s.WriteString("\nEverything is stopped. Running `/panic` again is safe.")
 	}
-	unresolvedMsg := fmt.Sprintf(
-		"⚠️ **Order Status Unresolved** ⚠️\n\nYour auto-order for **%s** (%s) with broker ID **%s** could not be confirmed as 'complete' after several checks. Please verify its status manually.",
-		autoOrder.Symbol, autoOrder.Action, brokerOrderID,
-	)
-	c.sendSystemMessage(unresolvedMsg)
-	c.emailService.SendEmail(
-		c.emailRecipient,
-		fmt.Sprintf("Auto-Order %s for %s - Status Unresolved", autoOrder.ID, autoOrder.Symbol),
-		unresolvedMsg,
-	)
+	return s.String()
 }
 
-func (c *Client) removeAutoOrder(orderID string) {
-	c.orderMux.Lock()
-	order, exists := c.autoOrders[orderID]
-	if !exists {
-		c.orderMux.Unlock()
-		return
-	}
-
-	order.CleanupOnce.Do(func() {
-		log.Printf("AUTO-ORDER: Cleaning up order %s", orderID)
-		delete(c.autoOrders, orderID)
-		if ch, ok := c.cancellation[orderID]; ok {
-			select {
-			case <-ch:
-			default:
-				close(ch)
-			}
-			delete(c.cancellation, orderID)
+// splitLegs separates the sl=, tp=, trail= and trail_on= tokens of a command from its condition
+func splitLegs(tokens []string) (string, bracket.Legs) {
+	var legs bracket.Legs
+	rest := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		name, value, _ := strings.Cut(token, "=")
+		switch strings.ToLower(name) {
+		case "sl":
+			legs.StopLoss = value
+		case "tp":
+			legs.Target = value
+		case "trail":
+			legs.TrailingStop = value
+		case "trail_on":
+			legs.TrailOn = value
+		default:
+			rest = append(rest, token)
 		}
-	})
-	c.orderMux.Unlock()
+	}
+	return strings.Join(rest, " "), legs
 }
 
-func (c *Client) sendSystemMessage(content string) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Recovered in sendSystemMessage: %v", r)
-		}
-	}()
-	msg := Message{
-		Type:    "chat",
-		Content: content,
-		Data: map[string]interface{}{
-			"role":       "system",
-			"created_at": time.Now(),
-		},
+// formatLegs renders the exits of an auto order
+func formatLegs(order *models.AutoOrder) string {
+	legs := fmt.Sprintf("stop-loss %s, target %s", orDash(order.StopLoss), orDash(order.Target))
+	if order.TrailingStop != "" {
+		legs += fmt.Sprintf(", trailing stop %s (%s)", order.TrailingStop, order.TrailOn)
 	}
-	msgBytes, _ := json.Marshal(msg)
-	c.send <- msgBytes
+	return legs
 }
 
-func ParseIntervalDuration(interval string) (time.Duration, error) {
-	switch strings.ToLower(interval) {
-	case "5m":
-		return 5 * time.Minute, nil
-	case "15m":
-		return 15 * time.Minute, nil
-	case "1h":
-		return time.Hour, nil
-	default:
-		d, err := time.ParseDuration(interval)
-		if err != nil {
-			return 0, fmt.Errorf("invalid or unsupported interval format: %s", interval)
-		}
-		return d, nil
+func orDash(s string) string {
+	if s == "" {
+		return "—"
 	}
+	return s
 }
 
-func parseValidity(validityStr string) (time.Time, error) {
-	if strings.ToLower(validityStr) == "forever" {
-		return time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+// formatOpenOrder renders a pending broker order for the /open_orders command
+func formatOpenOrder(trade *models.Trade) string {
+	price := "MARKET"
+	if trade.OrderType != "" && strings.ToUpper(trade.OrderType) != "MARKET" {
+		price = fmt.Sprintf("%s @ %.2f", strings.ToUpper(trade.OrderType), trade.Price)
 	}
-
-	duration, err := time.ParseDuration(validityStr)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid duration format")
+	status := trade.BrokerStatus
+	if status == "" {
+		status = trade.Status
 	}
+	return fmt.Sprintf("- **%s**: %s %d %s:%s %s (%s, %s)\n",
+		trade.OrderID, strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol, price, trade.Broker, status)
+}
 
-	if duration > 30*24*time.Hour {
-		return time.Time{}, fmt.Errorf("maximum validity period is 30 days")
+// orderErrorMessage explains why a broker order could not be changed
+func orderErrorMessage(action, orderID string, err error) string {
+	switch err {
+	case orders.ErrOrderNotFound:
+		return fmt.Sprintf("No order found with ID %s. Use `/open_orders` to list your open orders.", orderID)
+	case orders.ErrOrderClosed:
+		return fmt.Sprintf("Order %s is no longer open and cannot be %s.", orderID, action)
 	}
+	return fmt.Sprintf("❌ Order %s could not be %s: %v", orderID, action, err)
+}
 
-	return time.Now().Add(duration), nil
+// formatTimeToExpiry describes how long an auto order has left to run
+func formatTimeToExpiry(expiresAt time.Time) string {
+	if expiresAt.Year() >= 9999 {
+		return "never (forever)"
+	}
+	remaining := time.Until(expiresAt).Round(time.Second)
+	if remaining <= 0 {
+		return "now"
+	}
+	return fmt.Sprintf("in %s (at %s)", remaining, expiresAt.Format("02 Jan 15:04 MST"))
 }
 
 func (c *Client) ReadPump() {
@@ -531,54 +456,331 @@ func (c *Client) handleTradingCommand(command string) {
 			// ... (existing implementation)
 		case "/buy_smart_auto", "/sell_smart_auto":
 			if len(parts) < 8 {
-				responseContent = "Usage: `/buy_smart_auto <SYMBOL> <QTY> <EXCHANGE> <PRODUCT> <INTERVAL> <VALIDITY> <CONDITION...>`"
+				responseContent = "Usage: `/buy_smart_auto <SYMBOL> <QTY> <EXCHANGE> <PRODUCT> <INTERVAL> <VALIDITY> <CONDITION...> [sl=<LEVEL>] [tp=<LEVEL>] [trail=<DISTANCE>] [trail_on=tick|interval]`\n\nLevels are a price (`1450`), a percentage (`2%`) or an ATR multiple (`1.5atr`). A trailing stop trails by points (`20`), a percentage (`1%`) or an ATR multiple (`2atr`). The validity is a duration (`2h`), `close` for the end of the trading session, or `forever`."
 				break
 			}
 			action := "BUY"
 			if cmd == "/sell_smart_auto" {
 				action = "SELL"
 			}
-			symbol := strings.ToUpper(parts[1])
-			quantityStr := parts[2]
-			exchange := strings.ToUpper(parts[3])
-			product := strings.ToUpper(parts[4])
-			interval := strings.ToLower(parts[5])
-			validityStr := strings.ToLower(parts[6])
-			condition := strings.Join(parts[7:], " ")
-			condition = strings.Trim(condition, "\"")
-			if product != "MIS" && product != "NRML" && product != "CNC" {
-				responseContent = fmt.Sprintf("Invalid product type: %s. Use MIS, NRML, or CNC.", product)
+			quantity, err := strconv.Atoi(parts[2])
+			if err != nil {
+				responseContent = "Invalid quantity."
+				break
+			}
+			condition, legs := splitLegs(parts[7:])
+			req := autoorder.Request{
+				Symbol:       parts[1],
+				Quantity:     quantity,
+				Exchange:     parts[3],
+				Product:      parts[4],
+				Interval:     parts[5],
+				Validity:     parts[6],
+				Condition:    condition,
+				Action:       action,
+				StopLoss:     legs.StopLoss,
+				Target:       legs.Target,
+				TrailingStop: legs.TrailingStop,
+				TrailOn:      legs.TrailOn,
+			}
+			if _, err := req.Validate(c.engine.Calendar()); err != nil {
+				responseContent = fmt.Sprintf("Invalid auto order: %v.", err)
 				break
 			}
-			quantity, err := strconv.Atoi(quantityStr)
-			if err != nil || quantity <= 0 {
+			_, initialValues, _ := c.candles.EvaluateCondition(req.Interval, req.Condition, req.Symbol, req.Exchange)
+			order, err := c.engine.Submit(c.userID, req)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to start auto order: %v", err)
+			} else {
+				expiryDisplay := "Running Indefinitely"
+				if req.Validity != "forever" {
+					expiryDisplay = fmt.Sprintf("Expires at %s", order.ExpiresAt.Format("15:04:05 MST"))
+				}
+				responseContent = fmt.Sprintf("✅ **Auto Order Monitoring Started!**\n\n### Initial Values:\n%s\n- **ID**: %s\n- **Action**: %s\n- **Symbol**: %s on %s\n- **Interval**: %s\n- **Condition**: `%s`\n- **Validity**: %s",
+					autoorder.FormatValues(initialValues), order.ID, order.Action, order.Symbol, order.Exchange, order.Interval, order.Condition, expiryDisplay)
+				if order.StopLoss != "" || order.Target != "" || order.TrailingStop != "" {
+					responseContent += "\n- **Bracket**: " + formatLegs(order)
+				}
+			}
+		case "/schedule_buy", "/schedule_sell":
+			if len(parts) < 6 {
+				responseContent = "Usage: `/schedule_buy <SYMBOL> <QTY> <EXCHANGE> <PRODUCT> <HH:MM> [daily [VALIDITY]] [sl=<LEVEL>] [tp=<LEVEL>] [trail=<DISTANCE>] [trail_on=tick|interval]`\n\nThe order is placed at market at HH:MM IST on the next trading day whose session includes that time, or on every trading day with `daily`, until its validity (`forever` by default, or a duration such as `720h`) ends. Stop-loss, target and trailing stop are given as for `/buy_smart_auto`."
+				break
+			}
+			action := "BUY"
+			if cmd == "/schedule_sell" {
+				action = "SELL"
+			}
+			quantity, err := strconv.Atoi(parts[2])
+			if err != nil {
 				responseContent = "Invalid quantity."
 				break
 			}
-			if interval != "5m" && interval != "15m" && interval != "1h" {
-				responseContent = fmt.Sprintf("Unsupported interval: %s.", interval)
+			rest, legs := splitLegs(parts[6:])
+			req := autoorder.Request{
+				Symbol:       parts[1],
+				Quantity:     quantity,
+				Exchange:     parts[3],
+				Product:      parts[4],
+				ScheduleAt:   parts[5],
+				Action:       action,
+				StopLoss:     legs.StopLoss,
+				Target:       legs.Target,
+				TrailingStop: legs.TrailingStop,
+				TrailOn:      legs.TrailOn,
+			}
+			if fields := strings.Fields(rest); len(fields) > 0 {
+				if !strings.EqualFold(fields[0], "daily") || len(fields) > 2 {
+					responseContent = fmt.Sprintf("Invalid schedule %q: only `daily` and a validity may follow the time.", rest)
+					break
+				}
+				req.Recurring = true
+				if len(fields) == 2 {
+					req.Validity = fields[1]
+				}
+			}
+			order, err := c.engine.Submit(c.userID, req)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to schedule the order: %v", err)
+				break
+			}
+			firstRun := "-"
+			if run, ok := autoorder.NextRun(c.engine.Calendar(), order.Exchange, order.ScheduleAt, order.CreatedAt); ok {
+				firstRun = run.Format("Mon 02 Jan 15:04 MST")
+			}
+			responseContent = fmt.Sprintf("✅ **Order Scheduled!**\n\n- **ID**: %s\n- **Action**: %s %d\n- **Symbol**: %s on %s (%s)\n- **Schedule**: %s\n- **First Run**: %s\n- **Expires**: %s",
+				order.ID, order.Action, order.Quantity, order.Symbol, order.Exchange, order.Product, formatSchedule(order.ScheduleAt, order.Recurring), firstRun, formatTimeToExpiry(order.ExpiresAt))
+			if order.StopLoss != "" || order.Target != "" || order.TrailingStop != "" {
+				responseContent += "\n- **Bracket**: " + formatLegs(order)
+			}
+		case "/status_orders":
+			orders := c.engine.List(c.userID)
+			if len(orders) == 0 {
+				responseContent = "You have no active auto-orders."
+				break
+			}
+			var status strings.Builder
+			status.WriteString(fmt.Sprintf("📋 **Active Auto-Orders (%d)**\n", len(orders)))
+			for _, order := range orders {
+				status.WriteString("\n")
+				status.WriteString(formatAutoOrderStatus(order))
+			}
+			responseContent = status.String()
+		case "/cancel_order":
+			if len(parts) < 2 {
+				responseContent = "Usage: `/cancel_order <ORDER_ID>`"
+				break
+			}
+			orderID := strings.ToUpper(parts[1])
+			order, err := c.engine.Cancel(c.userID, orderID)
+			if err == autoorder.ErrOrderNotFound {
+				responseContent = fmt.Sprintf("No active auto-order found with ID %s. Use `/status_orders` to list your orders.", orderID)
 				break
 			}
-			expiresAt, err := parseValidity(validityStr)
 			if err != nil {
-				responseContent = fmt.Sprintf("Invalid validity: %v.", err)
+				responseContent = fmt.Sprintf("❌ Failed to cancel auto-order %s: %v", orderID, err)
+				break
+			}
+			responseContent = fmt.Sprintf("✅ Auto-Order **%s** (%s %s on %s) has been cancelled.", order.ID, order.Action, order.Symbol, order.Exchange)
+		case "/cancel_all_orders":
+			cancelled := c.engine.CancelAll(c.userID)
+			if len(cancelled) == 0 {
+				responseContent = "You have no active auto-orders to cancel."
 				break
 			}
-			_, initialValues, _ := c.oaClient.EvaluatePineCondition(interval, condition, symbol, exchange)
-			var indicatorSummary strings.Builder
-			for name, value := range initialValues {
-				indicatorSummary.WriteString(fmt.Sprintf(" **%s**: %.2f |", name, value))
+			var summary strings.Builder
+			summary.WriteString(fmt.Sprintf("✅ Cancelled %d auto-order(s):\n", len(cancelled)))
+			for _, order := range cancelled {
+				summary.WriteString(fmt.Sprintf("- **%s**: %s %s on %s\n", order.ID, order.Action, order.Symbol, order.Exchange))
 			}
-			orderID, err := c.StartAutoOrderMonitoring(symbol, exchange, product, interval, condition, action, quantity, expiresAt)
+			responseContent = summary.String()
+		case "/open_orders":
+			open, err := c.orders.Open(c.userID)
 			if err != nil {
-				responseContent = fmt.Sprintf("❌ Failed to start auto order: %v", err)
-			} else {
-				expiryDisplay := "Running Indefinitely"
-				if validityStr != "forever" {
-					expiryDisplay = fmt.Sprintf("Expires at %s", expiresAt.Format("15:04:05 MST"))
+				responseContent = fmt.Sprintf("❌ Failed to load your open orders: %v", err)
+				break
+			}
+			if len(open) == 0 {
+				responseContent = "You have no open broker orders."
+				break
+			}
+			var list strings.Builder
+			list.WriteString(fmt.Sprintf("📋 **Open Orders (%d)**\n", len(open)))
+			for _, trade := range open {
+				list.WriteString(formatOpenOrder(trade))
+			}
+			responseContent = list.String()
+		case "/modify_order":
+			if len(parts) < 3 {
+				responseContent = "Usage: `/modify_order <ORDER_ID> <QTY> [PRICE] [PRICETYPE]` (a QTY or PRICE of 0 keeps the current one)"
+				break
+			}
+			quantity, err := strconv.Atoi(parts[2])
+			if err != nil || quantity < 0 {
+				responseContent = "Invalid quantity."
+				break
+			}
+			modification := orders.Modification{OrderID: parts[1], Quantity: quantity}
+			if len(parts) > 3 {
+				price, err := strconv.ParseFloat(parts[3], 64)
+				if err != nil || price < 0 {
+					responseContent = "Invalid price."
+					break
 				}
-				responseContent = fmt.Sprintf("✅ **Auto Order Monitoring Started!**\n\n### Initial Values:\n%s\n- **ID**: %s\n- **Action**: %s\n- **Symbol**: %s on %s\n- **Interval**: %s\n- **Condition**: `%s`\n- **Validity**: %s",
-					indicatorSummary.String(), orderID, action, symbol, exchange, interval, condition, expiryDisplay)
+				modification.Price = price
+			}
+			if len(parts) > 4 {
+				modification.PriceType = parts[4]
+			}
+			trade, err := c.orders.Modify(c.userID, modification)
+			if err != nil {
+				responseContent = orderErrorMessage("modified", parts[1], err)
+				break
+			}
+			responseContent = "✏️ **Order Modified**\n" + formatOpenOrder(trade)
+		case "/cancel_broker_order":
+			if len(parts) < 2 {
+				responseContent = "Usage: `/cancel_broker_order <ORDER_ID>`"
+				break
+			}
+			trade, err := c.orders.Cancel(c.userID, parts[1])
+			if err != nil {
+				responseContent = orderErrorMessage("cancelled", parts[1], err)
+				break
+			}
+			responseContent = fmt.Sprintf("✅ Order **%s** (%s %d %s:%s) has been cancelled.", trade.OrderID, strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol)
+		case "/cancel_all_broker_orders":
+			cancelled, failed, err := c.orders.CancelAll(c.userID)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to cancel your open orders: %v", err)
+				break
+			}
+			if len(cancelled) == 0 && len(failed) == 0 {
+				responseContent = "You have no open broker orders to cancel."
+				break
+			}
+			var summary strings.Builder
+			summary.WriteString(fmt.Sprintf("✅ Cancelled %d order(s):\n", len(cancelled)))
+			for _, trade := range cancelled {
+				summary.WriteString(fmt.Sprintf("- **%s**: %s %d %s:%s\n", trade.OrderID, strings.ToUpper(trade.Action), trade.Quantity, trade.Exchange, trade.Symbol))
+			}
+			if len(failed) > 0 {
+				summary.WriteString(fmt.Sprintf("\n❌ Failed to cancel %d order(s):\n", len(failed)))
+				for _, failure := range failed {
+					summary.WriteString(fmt.Sprintf("- **%s** (%s): %s\n", failure.OrderID, failure.Symbol, failure.Error))
+				}
+			}
+			responseContent = summary.String()
+		case "/brackets":
+			brackets, err := c.brackets.List(c.userID, 10)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to load your brackets: %v", err)
+				break
+			}
+			if len(brackets) == 0 {
+				responseContent = "You have no brackets."
+				break
+			}
+			var list strings.Builder
+			list.WriteString(fmt.Sprintf("🛡️ **Brackets (%d latest)**\n", len(brackets)))
+			for _, b := range brackets {
+				list.WriteString("\n")
+				list.WriteString(formatBracket(b))
+			}
+			responseContent = list.String()
+		case "/cancel_bracket":
+			if len(parts) < 2 {
+				responseContent = "Usage: `/cancel_bracket <BRACKET_ID>`"
+				break
+			}
+			id, err := strconv.Atoi(parts[1])
+			if err != nil {
+				responseContent = "Invalid bracket ID."
+				break
+			}
+			b, err := c.brackets.Cancel(c.userID, id)
+			switch {
+			case errors.Is(err, bracket.ErrBracketNotFound):
+				responseContent = fmt.Sprintf("No open bracket found with ID %d. Use `/brackets` to list your brackets.", id)
+			case errors.Is(err, bracket.ErrBracketExiting):
+				responseContent = fmt.Sprintf("Bracket %d is already exiting its position and can no longer be cancelled.", id)
+			case err != nil:
+				responseContent = fmt.Sprintf("❌ Failed to cancel bracket %d: %v", id, err)
+			default:
+				responseContent = fmt.Sprintf("✅ Bracket %d cancelled. The stop-loss and target of %s %d %s:%s are no longer watched; the position stays open.",
+					b.ID, b.Action, b.Quantity, b.Exchange, b.Symbol)
+			}
+		case "/risk":
+			limits, err := c.risk.Limits(c.userID)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to load your risk limits: %v", err)
+				break
+			}
+			var summary strings.Builder
+			if ks := c.risk.KillSwitch(); ks.Engaged {
+				summary.WriteString(fmt.Sprintf("🛑 **Kill switch ENGAGED** by %s: %s. No new orders are accepted.\n\n", ks.By, orDash(ks.Reason)))
+			}
+			summary.WriteString("🧯 **Risk Limits**\n")
+			summary.WriteString(formatRiskLimits(limits))
+			if rejections, err := c.risk.Rejections(c.userID, 5); err == nil && len(rejections) > 0 {
+				summary.WriteString("\n**Latest rejections**\n")
+				for _, rejection := range rejections {
+					summary.WriteString(fmt.Sprintf("- %s: %s %d %s:%s (%s) — %s\n", rejection.CreatedAt.Format("02 Jan 15:04"),
+						rejection.Action, rejection.Quantity, rejection.Exchange, rejection.Symbol, rejection.Source, rejection.Reason))
+				}
+			}
+			responseContent = summary.String()
+		case "/kill_switch":
+			mode := ""
+			if len(parts) > 1 {
+				mode = strings.ToLower(parts[1])
+			}
+			if mode != "on" && mode != "off" {
+				ks := c.risk.KillSwitch()
+				state := "released"
+				if ks.Engaged {
+					state = "ENGAGED"
+				}
+				responseContent = fmt.Sprintf("The kill switch is **%s**. Usage: `/kill_switch on|off [REASON]`", state)
+				break
+			}
+			ks, err := c.risk.SetKillSwitch(c.userID, mode == "on", strings.Join(parts[2:], " "))
+			switch {
+			case errors.Is(err, risk.ErrNotAdmin):
+				responseContent = "❌ Only admins can change the kill switch."
+			case err != nil:
+				responseContent = fmt.Sprintf("❌ Failed to change the kill switch: %v", err)
+			case ks.Engaged:
+				responseContent = fmt.Sprintf("🛑 **Kill switch ENGAGED**: %s. No new orders are accepted from any user until it is released with `/kill_switch off`.", orDash(ks.Reason))
+			default:
+				responseContent = "✅ **Kill switch released**. New orders are accepted again."
+			}
+		case "/panic":
+			responseContent = formatPanicReport(c.liquidator.Panic(c.userID))
+		case "/square_off":
+			setting, err := c.squareOff.Setting(c.userID)
+			if err != nil {
+				responseContent = fmt.Sprintf("❌ Failed to load your square-off time: %v", err)
+				break
+			}
+			if len(parts) > 1 {
+				switch strings.ToLower(parts[1]) {
+				case "off":
+					setting.Enabled = false
+				case "on":
+					setting.Enabled = true
+				default:
+					setting.Time, setting.Enabled = parts[1], true
+				}
+				if err := c.squareOff.SetSetting(setting); err != nil {
+					responseContent = fmt.Sprintf("❌ %v", err)
+					break
+				}
+			}
+			if setting.Enabled {
+				responseContent = fmt.Sprintf("⏰ Intraday (MIS) auto-orders are cancelled and MIS positions squared off at market at **%s IST** on every trading day, or shortly before the close of a shorter session. Usage: `/square_off HH:MM|on|off`", setting.Time)
+			} else {
+				responseContent = fmt.Sprintf("⏰ The MIS square-off is **off**; the broker squares off intraday positions itself. Turn it on with `/square_off on` (%s IST) or `/square_off HH:MM`.", setting.Time)
 			}
 		// ... (rest of the switch statement)
 		}